@@ -0,0 +1,118 @@
+// Package directory provides a team.Authenticator verifying user passwords against a
+// directory service (eg. an LDAP server), with optional group membership requirements.
+//
+// The directory itself is an interface, which applications implement on top of their
+// directory client (an LDAP simple bind, and a search of the groups of the user), and
+// of which this package provides a local, in-memory implementation (Local), useful for
+// tests and small deployments.
+//
+// Teamclients authenticate with a "<user>:<password>" token, which they send instead
+// of a teamserver API token.
+package directory
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/reeflective/team"
+)
+
+var (
+	// ErrInvalidCredentials is returned by directories when the password of a user is wrong.
+	ErrInvalidCredentials = errors.New("invalid directory credentials")
+
+	// ErrNotMember is returned when the user is not a member of any of the required groups.
+	ErrNotMember = errors.New("user is not a member of the required groups")
+)
+
+// Directory is a directory of users, against which passwords can be verified.
+type Directory interface {
+	// Bind verifies the password of the named user, like an LDAP simple bind.
+	// It returns a (wrapped) team.ErrUnknownCredentials if the user is not in
+	// the directory, and ErrInvalidCredentials if the password is wrong.
+	Bind(name, password string) error
+
+	// Groups returns the names of the groups of which the user is a member.
+	Groups(name string) ([]string, error)
+}
+
+// Authenticator verifies "<user>:<password>" tokens against a directory. It implements
+// team.Authenticator: other tokens, and users who are not in the directory, are not its
+// own (team.ErrUnknownCredentials), and other authenticators can be consulted for them.
+type Authenticator struct {
+	dir    Directory
+	groups []string
+}
+
+// Option is an option for the directory authenticator.
+type Option func(auth *Authenticator)
+
+// WithGroups requires users to be a member of at least one of the given groups.
+func WithGroups(groups ...string) Option {
+	return func(auth *Authenticator) {
+		auth.groups = append(auth.groups, groups...)
+	}
+}
+
+// New returns an authenticator verifying user passwords against the directory.
+func New(dir Directory, opts ...Option) *Authenticator {
+	auth := &Authenticator{dir: dir}
+
+	for _, opt := range opts {
+		opt(auth)
+	}
+
+	return auth
+}
+
+// Authenticate implements team.Authenticator.
+func (auth *Authenticator) Authenticate(creds team.Credentials) (*team.User, error) {
+	name, password, found := strings.Cut(creds.Token, ":")
+	if !found || name == "" {
+		return nil, team.ErrUnknownCredentials
+	}
+
+	if err := auth.dir.Bind(name, password); err != nil {
+		return nil, err
+	}
+
+	if len(auth.groups) == 0 {
+		return &team.User{Name: name}, nil
+	}
+
+	groups, err := auth.dir.Groups(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups of user %s: %w", name, err)
+	}
+
+	for _, group := range groups {
+		if slices.Contains(auth.groups, group) {
+			return &team.User{Name: name}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w (%s)", ErrNotMember, name)
+}
+
+// compile-time guarantee that the authenticator can be used by teamservers.
+var _ team.Authenticator = (*Authenticator)(nil)
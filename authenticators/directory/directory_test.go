@@ -0,0 +1,68 @@
+package directory
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/reeflective/team"
+)
+
+// TestAuthenticate checks password and group verification against a local directory.
+func TestAuthenticate(t *testing.T) {
+	entries, _ := json.Marshal([]Entry{
+		NewEntry("alice", "s3cret", "operators"),
+		NewEntry("bob", "hunter2", "interns"),
+	})
+
+	path := filepath.Join(t.TempDir(), "directory.json")
+	if err := os.WriteFile(path, entries, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := LoadLocal(path)
+	if err != nil {
+		t.Fatalf("LoadLocal: %v", err)
+	}
+
+	auth := New(dir, WithGroups("operators", "admins"))
+
+	user, err := auth.Authenticate(team.Credentials{Token: "alice:s3cret"})
+	if err != nil || user.Name != "alice" {
+		t.Fatalf("valid password refused: %v", err)
+	}
+
+	if _, err := auth.Authenticate(team.Credentials{Token: "alice:wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("wrong password should be refused, got %v", err)
+	}
+
+	if _, err := auth.Authenticate(team.Credentials{Token: "bob:hunter2"}); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("user outside of the required groups should be refused, got %v", err)
+	}
+
+	for _, token := range []string{"carol:password", "0123456789abcdef"} {
+		if _, err := auth.Authenticate(team.Credentials{Token: token}); !errors.Is(err, team.ErrUnknownCredentials) {
+			t.Fatalf("foreign credentials should be unknown, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package directory
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/reeflective/team"
+)
+
+// Entry is a user of a local directory.
+type Entry struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+
+	// PasswordHash is the hex-encoded SHA-256 digest of the user password.
+	PasswordHash string `json:"password_sha256"`
+}
+
+// NewEntry returns a local directory entry for a user and its password.
+func NewEntry(name, password string, groups ...string) Entry {
+	digest := sha256.Sum256([]byte(password))
+
+	return Entry{
+		Name:         name,
+		Groups:       groups,
+		PasswordHash: hex.EncodeToString(digest[:]),
+	}
+}
+
+// Local is an in-memory directory, standing in for a directory service.
+// It is safe for concurrent use.
+type Local struct {
+	entries map[string]Entry
+	mutex   sync.RWMutex
+}
+
+// NewLocal returns a local directory with the given entries.
+func NewLocal(entries ...Entry) *Local {
+	dir := &Local{entries: make(map[string]Entry)}
+
+	for _, entry := range entries {
+		dir.Add(entry)
+	}
+
+	return dir
+}
+
+// LoadLocal reads a local directory from a JSON file containing a list of entries.
+func LoadLocal(path string) (*Local, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse directory: %w", err)
+	}
+
+	return NewLocal(entries...), nil
+}
+
+// Add adds (or replaces) an entry to the directory.
+func (dir *Local) Add(entry Entry) {
+	dir.mutex.Lock()
+	defer dir.mutex.Unlock()
+
+	dir.entries[entry.Name] = entry
+}
+
+// Remove removes a user from the directory.
+func (dir *Local) Remove(name string) {
+	dir.mutex.Lock()
+	defer dir.mutex.Unlock()
+
+	delete(dir.entries, name)
+}
+
+// Bind implements Directory.
+func (dir *Local) Bind(name, password string) error {
+	dir.mutex.RLock()
+	entry, found := dir.entries[name]
+	dir.mutex.RUnlock()
+
+	if !found {
+		return fmt.Errorf("%w: no user %s in directory", team.ErrUnknownCredentials, name)
+	}
+
+	digest := sha256.Sum256([]byte(password))
+	expected, err := hex.DecodeString(entry.PasswordHash)
+
+	if err != nil || subtle.ConstantTimeCompare(digest[:], expected) != 1 {
+		return fmt.Errorf("%w (%s)", ErrInvalidCredentials, name)
+	}
+
+	return nil
+}
+
+// Groups implements Directory.
+func (dir *Local) Groups(name string) ([]string, error) {
+	dir.mutex.RLock()
+	defer dir.mutex.RUnlock()
+
+	entry, found := dir.entries[name]
+	if !found {
+		return nil, fmt.Errorf("%w: no user %s in directory", team.ErrUnknownCredentials, name)
+	}
+
+	return append([]string{}, entry.Groups...), nil
+}
+
+// compile-time guarantee that the local directory is a Directory.
+var _ Directory = (*Local)(nil)
@@ -0,0 +1,287 @@
+// Package jwt provides a team.Authenticator verifying signed JSON Web Tokens (RFC 7519)
+// against the public keys of a local JSON Web Key Set file, so that teamserver users
+// can authenticate with tokens issued by an external identity provider.
+//
+// Tokens must be signed (JWS compact serialization) with one of the RS256, RS384, RS512,
+// PS256, PS384, PS512, ES256, ES384, ES512 or EdDSA algorithms, and have an expiry time.
+// The name of the authenticated user is the subject of the token, by default.
+package jwt
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256" // Hash functions of the algorithms.
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/reeflective/team"
+)
+
+// ErrInvalidToken is returned (wrapped) for tokens that are recognized as JWTs signed
+// with a key of the set, but which are invalid: bad signature, expired, wrong issuer...
+var ErrInvalidToken = errors.New("invalid JWT")
+
+// algorithms are the supported signature algorithms, and their hash function.
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+	"EdDSA": 0, // Ed25519 signs the content itself.
+}
+
+// curveSizes are the sizes of the curves used by the ECDSA algorithms.
+var curveSizes = map[crypto.Hash]int{crypto.SHA256: 256, crypto.SHA384: 384, crypto.SHA512: 521}
+
+// Authenticator verifies JSON Web Tokens. It implements team.Authenticator:
+// tokens which are not JWTs, or are signed with a key that is not in its set,
+// are not its own (team.ErrUnknownCredentials), and other authenticators can
+// be consulted for them.
+type Authenticator struct {
+	keys      *KeySet
+	issuer    string
+	audience  string
+	userClaim string
+	leeway    time.Duration
+	now       func() time.Time
+}
+
+// Option is an option for the JWT authenticator.
+type Option func(auth *Authenticator)
+
+// WithIssuer requires tokens to be issued by the given issuer ("iss" claim).
+func WithIssuer(issuer string) Option {
+	return func(auth *Authenticator) {
+		auth.issuer = issuer
+	}
+}
+
+// WithAudience requires tokens to be intended for the given audience ("aud" claim).
+func WithAudience(audience string) Option {
+	return func(auth *Authenticator) {
+		auth.audience = audience
+	}
+}
+
+// WithUserClaim sets the (string) claim used as the user name, instead of "sub".
+func WithUserClaim(claim string) Option {
+	return func(auth *Authenticator) {
+		auth.userClaim = claim
+	}
+}
+
+// WithLeeway sets the tolerated clock skew when checking the token validity times.
+func WithLeeway(leeway time.Duration) Option {
+	return func(auth *Authenticator) {
+		auth.leeway = leeway
+	}
+}
+
+// New returns a JWT authenticator verifying tokens with the keys of the set.
+func New(keys *KeySet, opts ...Option) *Authenticator {
+	auth := &Authenticator{
+		keys:      keys,
+		userClaim: "sub",
+		now:       time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(auth)
+	}
+
+	return auth
+}
+
+// NewFromFile returns a JWT authenticator verifying tokens with the keys of a JWKS file.
+func NewFromFile(path string, opts ...Option) (*Authenticator, error) {
+	keys, err := LoadKeySet(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(keys, opts...), nil
+}
+
+// Authenticate implements team.Authenticator.
+func (auth *Authenticator) Authenticate(creds team.Credentials) (*team.User, error) {
+	parts := strings.Split(creds.Token, ".")
+	if len(parts) != 3 {
+		return nil, team.ErrUnknownCredentials
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg == "" {
+		return nil, team.ErrUnknownCredentials
+	}
+
+	key, found := auth.keys.key(header.Kid)
+	if !found {
+		return nil, fmt.Errorf("%w: no key %q in key set", team.ErrUnknownCredentials, header.Kid)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if err = verify(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	claims := make(map[string]any)
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if err = auth.validate(claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	name, ok := claims[auth.userClaim].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%w: no user name claim %q", ErrInvalidToken, auth.userClaim)
+	}
+
+	return &team.User{Name: name}, nil
+}
+
+// validate checks the registered claims of the token.
+func (auth *Authenticator) validate(claims map[string]any) error {
+	now := auth.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no expiry time")
+	}
+
+	if now.After(time.Unix(int64(exp), 0).Add(auth.leeway)) {
+		return errors.New("token is expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(auth.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+
+	if auth.issuer != "" && claims["iss"] != auth.issuer {
+		return fmt.Errorf("token issuer is not %q", auth.issuer)
+	}
+
+	if auth.audience == "" {
+		return nil
+	}
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == auth.audience {
+			return nil
+		}
+	case []any:
+		for _, value := range aud {
+			if value == auth.audience {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("token audience is not %q", auth.audience)
+}
+
+// verify checks the signature of the signed content with the key, using the algorithm
+// of the token header, which must correspond to the type of the key.
+func verify(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	hash, supported := algorithms[alg]
+	if !supported {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	if hash != 0 {
+		digest := hash.New()
+		digest.Write(signed)
+		signed = digest.Sum(nil)
+	}
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, signed, signature)
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, signed, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+
+		// Each algorithm uses a single curve (ES512 uses P-521).
+		if alg[:2] != "ES" || pub.Curve.Params().BitSize != curveSizes[hash] {
+			break
+		}
+
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(pub, signed, r, s) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			break
+		}
+
+		if !ed25519.Verify(pub, signed, signature) {
+			return errors.New("invalid signature")
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("algorithm %q cannot be used with the key", alg)
+}
+
+func decodeSegment(segment string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
+
+// compile-time guarantee that the authenticator can be used by teamservers.
+var _ team.Authenticator = (*Authenticator)(nil)
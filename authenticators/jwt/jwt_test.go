@@ -0,0 +1,127 @@
+package jwt
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/reeflective/team"
+)
+
+var b64 = base64.RawURLEncoding
+
+// sign returns a compact JWS of the claims, signed with an ECDSA P-256 or Ed25519 key.
+func sign(t *testing.T, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+
+	alg := "EdDSA"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+
+	var signature []byte
+
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("ecdsa.Sign: %v", err)
+		}
+
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	}
+
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+// TestAuthenticate checks that tokens signed with a key of the JWKS file authenticate
+// their subject, that invalid ones are refused, and that foreign ones are not its own.
+func TestAuthenticate(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": %q, "y": %q},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": %q}
+	]}`, b64.EncodeToString(ecKey.X.Bytes()), b64.EncodeToString(ecKey.Y.Bytes()), b64.EncodeToString(edPub))
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, []byte(jwks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewFromFile(path, WithIssuer("idp"), WithAudience("teamserver"))
+	if err != nil {
+		t.Fatalf("NewFromFile: %v", err)
+	}
+
+	claims := func(exp time.Duration) map[string]any {
+		return map[string]any{"sub": "alice", "iss": "idp", "aud": []string{"teamserver"}, "exp": time.Now().Add(exp).Unix()}
+	}
+
+	for _, token := range []string{sign(t, "ec", ecKey, claims(time.Hour)), sign(t, "ed", edKey, claims(time.Hour))} {
+		user, err := auth.Authenticate(team.Credentials{Token: token})
+		if err != nil || user.Name != "alice" {
+			t.Fatalf("valid token refused: %v", err)
+		}
+	}
+
+	if _, err := auth.Authenticate(team.Credentials{Token: sign(t, "ec", ecKey, claims(-time.Hour))}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expired token should be refused, got %v", err)
+	}
+
+	if _, err := auth.Authenticate(team.Credentials{Token: sign(t, "ec", otherKey, claims(time.Hour))}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token signed with another key should be refused, got %v", err)
+	}
+
+	wrongIssuer := claims(time.Hour)
+	wrongIssuer["iss"] = "other"
+
+	if _, err := auth.Authenticate(team.Credentials{Token: sign(t, "ed", edKey, wrongIssuer)}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("token of another issuer should be refused, got %v", err)
+	}
+
+	for _, token := range []string{"0123456789abcdef", sign(t, "unknown", otherKey, claims(time.Hour))} {
+		if _, err := auth.Authenticate(team.Credentials{Token: token}); !errors.Is(err, team.ErrUnknownCredentials) {
+			t.Fatalf("foreign token should be unknown, got %v", err)
+		}
+	}
+}
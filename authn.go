@@ -0,0 +1,101 @@
+package team
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// ErrUnknownCredentials is returned (possibly wrapped) by an Authenticator which does
+// not recognize the credentials it is given, for instance because the token is not in
+// the format it expects, or because it has no such identity. In a chain (Authenticators),
+// the next authenticator is then consulted.
+//
+// Any other error is a definitive refusal: the credentials are recognized, but invalid.
+var ErrUnknownCredentials = errors.New("unknown credentials")
+
+// Credentials are the authentication materials presented by a teamclient.
+type Credentials struct {
+	// Token is the raw bearer token sent by the client: a teamserver API token,
+	// or any other secret understood by an Authenticator (eg. a signed JWT).
+	Token string
+
+	// Certificate is the Mutual TLS client certificate of the connection the
+	// credentials were presented over, or nil for other connections.
+	Certificate *x509.Certificate
+
+	// RemoteAddr is the network address of the client, if known.
+	RemoteAddr string
+}
+
+// Authenticator verifies the credentials of a teamclient against an identity source,
+// and returns the user they belong to. The teamserver uses its own token store by
+// default, and can be given any number of additional authenticators for external
+// identity sources (see team/server.WithAuthenticators).
+//
+// Like the teamserver, an Authenticator only proves WHO a caller is: the returned
+// user carries no authorization data (see Authorizer).
+type Authenticator interface {
+	// Authenticate returns the user to which the credentials belong. It returns
+	// an ErrUnknownCredentials error if the credentials are not its own, and any
+	// other error if they are, but are invalid (expired, wrong signature, etc).
+	Authenticate(creds Credentials) (*User, error)
+}
+
+// AuthenticatorFunc is a function used as an Authenticator.
+type AuthenticatorFunc func(creds Credentials) (*User, error)
+
+// Authenticate implements Authenticator by calling the function itself.
+func (f AuthenticatorFunc) Authenticate(creds Credentials) (*User, error) {
+	return f(creds)
+}
+
+// Authenticators is a chain of authenticators, consulted in order: the first one to
+// either authenticate or refuse the credentials has the final word, while those that
+// do not know them (ErrUnknownCredentials) fall back to the next one.
+//
+// If no authenticator recognizes the credentials, the error of the last one is returned.
+type Authenticators []Authenticator
+
+// Authenticate implements Authenticator with the chain.
+func (chain Authenticators) Authenticate(creds Credentials) (*User, error) {
+	err := ErrUnknownCredentials
+
+	for _, auth := range chain {
+		if auth == nil {
+			continue
+		}
+
+		var user *User
+
+		user, err = auth.Authenticate(creds)
+		if errors.Is(err, ErrUnknownCredentials) {
+			continue
+		}
+
+		if err == nil && (user == nil || user.Name == "") {
+			err = errors.New("authenticator returned no user")
+		}
+
+		return user, err
+	}
+
+	return nil, err
+}
@@ -21,6 +21,7 @@ package client
 import (
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/reeflective/team"
 	"github.com/reeflective/team/internal/assets"
@@ -96,6 +97,25 @@ type Dialer interface {
 	Close() error
 }
 
+// TokenRefresher is an optional interface for Dialers able to ask their teamserver
+// for a new API token, in exchange of the one they are currently authenticated with.
+// Implementations must use the new token for all their subsequent requests.
+// See the Client.RefreshToken() method.
+type TokenRefresher interface {
+	// RefreshToken returns the new token and its expiry time (zero if it never expires).
+	RefreshToken() (token string, expiresAt time.Time, err error)
+}
+
+// CertificateRenewer is an optional interface for Dialers able to ask their teamserver
+// for a new client certificate, issued by its current users CA (eg. during a CA rotation).
+// See the Client.RenewCertificate() method.
+type CertificateRenewer interface {
+	// RenewCertificate sends the PEM-encoded certificate request to the teamserver, and
+	// returns the certificate and users CA it issued (without token, private key, host
+	// and port).
+	RenewCertificate(csrPEM []byte) (*Config, error)
+}
+
 // New is the required constructor of a new application teamclient.
 // Parameters:
 //   - The name of the application using the teamclient.
@@ -164,6 +184,8 @@ func (tc *Client) Connect(options ...Options) (err error) {
 			return
 		}
 
+		tc.warnCertificateExpiry(tc.Config())
+
 		// Initialize the dialer with our client.
 		err = tc.dialer.Init(tc)
 		if err != nil {
@@ -270,6 +292,69 @@ func (tc *Client) VersionServer() (ver team.Version, err error) {
 	return version, nil
 }
 
+// RefreshToken asks the teamserver for a new API token in exchange of the current one,
+// and saves it in the teamclient configuration file (see Client.SaveConfig()), returning
+// its expiry time (zero if it never expires). The previous token keeps working during
+// a grace period set by the teamserver, so other clients using it can still connect.
+//
+// The client must be connected, and its dialer must implement client.TokenRefresher,
+// otherwise an ErrNoTokenRefresh error is returned.
+func (tc *Client) RefreshToken() (time.Time, error) {
+	refresher, ok := tc.dialer.(TokenRefresher)
+	if !ok {
+		return time.Time{}, ErrNoTokenRefresh
+	}
+
+	token, expiresAt, err := refresher.RefreshToken()
+	if err != nil {
+		return time.Time{}, tc.errorf("%w: %w", ErrClient, err)
+	}
+
+	tc.mutex.Lock()
+	config := *tc.Config()
+	config.Token = token
+	tc.opts.config = &config
+	tc.mutex.Unlock()
+
+	return expiresAt, tc.SaveConfig(&config)
+}
+
+// RenewCertificate asks the teamserver for a new client certificate issued by its current
+// users CA, over a new private key generated by the teamclient, and saves them with the CA
+// in the teamclient configuration file (see Client.SaveConfig()). This is needed after the
+// teamserver rotates its users CA, before the end of the rotation window, after which the
+// previous certificate is not trusted anymore. The previous certificate is revoked: the
+// client must reconnect (Disconnect/Connect) to use the new one.
+//
+// The client must be connected, and its dialer must implement client.CertificateRenewer,
+// otherwise an ErrNoCertificateRenewal error is returned.
+func (tc *Client) RenewCertificate() error {
+	renewer, ok := tc.dialer.(CertificateRenewer)
+	if !ok {
+		return ErrNoCertificateRenewal
+	}
+
+	csrPEM, keyPEM, err := newCertificateRequest()
+	if err != nil {
+		return tc.errorf("%w: %w", ErrConfig, err)
+	}
+
+	renewed, err := renewer.RenewCertificate(csrPEM)
+	if err != nil {
+		return tc.errorf("%w: %w", ErrClient, err)
+	}
+
+	tc.mutex.Lock()
+	config := *tc.Config()
+	config.CACertificate = renewed.CACertificate
+	config.Certificate = renewed.Certificate
+	config.PrivateKey = string(keyPEM)
+	tc.opts.config = &config
+	tc.mutex.Unlock()
+
+	return tc.SaveConfig(&config)
+}
+
 // Name returns the name of the client application.
 func (tc *Client) Name() string {
 	return tc.name
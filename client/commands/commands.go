@@ -97,8 +97,11 @@ func clientCommands(cli *client.Client) *cobra.Command {
 Import a connection config an administrator gave you, then query the server:
 
   import   save a *.teamclient.cfg into your client configs directory
+  enroll   get a config from the teamserver with an invitation code
   users    list the team's users and their online status
   version  show client and server build versions
+  refresh  swap your API token for a new one, and save it in your config
+  renew    get a new certificate (after a CA rotation), and save it in your config
 
 Commands connect automatically using your imported config. If you have several and
 none is marked default, you'll be prompted to choose one.`, cli.Name()),
@@ -173,6 +176,50 @@ the time since each was last seen.`,
 
 	teamCmd.AddCommand(usersCmd)
 
+	refreshCmd := &cobra.Command{
+		Use:   "refresh",
+		Short: "Get a new API token from the teamserver, and save it in the client config",
+		Long: `Connect to the teamserver and swap your API token for a new one, which is saved
+in your client configuration file. Use it before your current token expires: the old
+token keeps working for a short grace period, so that other clients using the same
+config can still connect.`,
+		Example: `  teamclient refresh`,
+		RunE:    refreshCmd(cli),
+	}
+
+	teamCmd.AddCommand(refreshCmd)
+
+	renewCmd := &cobra.Command{
+		Use:   "renew",
+		Short: "Get a new client certificate from the teamserver, and save it in the client config",
+		Long: `Connect to the teamserver and get a new client certificate, issued by its current
+users CA over a new private key, which are saved in your client configuration file with
+the CA. Use it when the teamserver administrator rotates the users CA, before the end
+of the rotation window: your previous certificate is then not trusted anymore.`,
+		Example: `  teamclient renew`,
+		RunE:    renewCmd(cli),
+	}
+
+	teamCmd.AddCommand(renewCmd)
+
+	enrollCmd := &cobra.Command{
+		Use:   "enroll",
+		Short: "Enroll with an invitation code, and save the new client config",
+		Long: `Enroll with an invitation code given by the teamserver administrator: the teamclient
+generates its own private key and sends a certificate request to the teamserver, which
+returns a signed client certificate and an API token. The resulting config is saved in
+your client configs directory. The code can only be used once, before it expires.`,
+		Example: `  teamclient enroll <code> --host teamserver.example.com --port 31337`,
+		Args:    cobra.ExactArgs(1),
+		RunE:    enrollCmd(cli),
+	}
+
+	enrollCmd.Flags().StringP("host", "l", "", "teamserver host")
+	enrollCmd.Flags().Uint16P("port", "p", 31337, "teamserver port")
+	enrollCmd.MarkFlagRequired("host")
+
+	teamCmd.AddCommand(enrollCmd)
+
 	return teamCmd
 }
 
@@ -0,0 +1,53 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/client"
+	"github.com/reeflective/team/internal/command"
+)
+
+func renewCmd(cli *client.Client) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				cli.SetLogLevel(int(slog.LevelError) - logLevel*4)
+			}
+		}
+
+		if err := cli.Connect(); err != nil {
+			return err
+		}
+
+		if err := cli.RenewCertificate(); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Failed to renew certificate: %s\n", err)
+			return nil
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Renewed client certificate for %s@%s\n", cli.Config().User, cli.Config().Host)
+
+		return nil
+	}
+}
@@ -29,6 +29,7 @@ import (
 	osuser "os/user"
 	"path/filepath"
 	"sort"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
 
@@ -39,6 +40,9 @@ import (
 
 const (
 	fileWriteModePerm = 0o600
+
+	// certExpiryWarning is how long before its expiry the client certificate is reported.
+	certExpiryWarning = 30 * 24 * time.Hour
 )
 
 // Config is a JSON client connection configuration.
@@ -46,7 +50,7 @@ const (
 // allowed to connect to it, and cryptographic material to secure and
 // authenticate the client-server connection (using Mutual TLS).
 type Config struct {
-	User          string `json:"user"` // This value is actually ignored for the most part (cert CN is used instead)
+	User          string `json:"user"` // Must match the certificate CN, to which the token is bound.
 	Host          string `json:"host"`
 	Port          int    `json:"port"`
 	Token         string `json:"token"`
@@ -55,6 +59,40 @@ type Config struct {
 	Certificate   string `json:"certificate"`
 }
 
+// CertificateExpiry returns the expiry time of the client certificate of the configuration.
+func (c *Config) CertificateExpiry() (time.Time, error) {
+	cert, err := certs.ParseCertificatePEM([]byte(c.Certificate))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Cannot parse client certificate: %w", err)
+	}
+
+	return cert.NotAfter, nil
+}
+
+// warnCertificateExpiry logs a warning if the client certificate of the configuration
+// expires soon, or has expired: the teamserver would refuse the connection afterwards.
+func (tc *Client) warnCertificateExpiry(config *Config) {
+	if config == nil {
+		return
+	}
+
+	expiry, err := config.CertificateExpiry()
+	if err != nil {
+		return
+	}
+
+	remaining := time.Until(expiry)
+
+	switch {
+	case remaining <= 0:
+		tc.log().Warn(fmt.Sprintf("Client certificate of %s@%s has expired on %s: ask for a new config",
+			config.User, config.Host, expiry.Format(time.RFC1123)))
+	case remaining <= certExpiryWarning:
+		tc.log().Warn(fmt.Sprintf("Client certificate of %s@%s expires in %d days, on %s: renew it while connected",
+			config.User, config.Host, int(remaining.Hours()/24), expiry.Format(time.RFC1123)))
+	}
+}
+
 func (tc *Client) initConfig() (err error) {
 	cfg := tc.opts.config
 
@@ -252,12 +290,18 @@ func (tc *Client) Config() *Config {
 
 // NewTLSConfigFrom generates a working client TLS configuration prepared for Mutual TLS.
 // It requires the three credential materials presents in any user remote teamserver config.
+// With the client.WithHostVerification() option, the server certificate must also be valid
+// for the host of the current remote teamserver configuration.
 func (tc *Client) NewTLSConfigFrom(caCert string, cert string, key string) (*tls.Config, error) {
 	certPEM, err := tls.X509KeyPair([]byte(cert), []byte(key))
 	if err != nil {
 		return nil, fmt.Errorf("Cannot parse client certificate: %w", err)
 	}
 
+	if tc.opts.verifyHost {
+		return tc.newHostTLSConfig(caCert, certPEM)
+	}
+
 	// Load CA cert
 	caCertPool := x509.NewCertPool()
 	caCertPool.AppendCertsFromPEM([]byte(caCert))
@@ -275,6 +319,32 @@ func (tc *Client) NewTLSConfigFrom(caCert string, cert string, key string) (*tls
 	return tlsConfig, nil
 }
 
+// newHostTLSConfig returns a client TLS configuration verifying
+// the server certificate for the host of the current configuration.
+func (tc *Client) newHostTLSConfig(caCert string, cert tls.Certificate) (*tls.Config, error) {
+	var host string
+	if config := tc.Config(); config != nil {
+		host = config.Host
+	}
+
+	if host == "" {
+		return nil, fmt.Errorf("No teamserver host to verify the server certificate for")
+	}
+
+	caCertPool := x509.NewCertPool()
+	caCertPool.AppendCertsFromPEM([]byte(caCert))
+
+	// The standard verification builds the chain from the intermediates sent by the
+	// server (eg. the cross-signed certificate of a rotated CA) up to the users CA.
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caCertPool,
+		ServerName:   host,
+	}
+
+	return tlsConfig, nil
+}
+
 func getPromptForConfigs(configs map[string]*Config) []*survey.Question {
 	keys := []string{}
 	for k := range configs {
@@ -0,0 +1,143 @@
+package client
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/reeflective/team/internal/certs"
+)
+
+// Enroller is an optional interface for Dialers able to enroll a teamclient with an
+// invitation code, over a one-off connection that is not Mutual TLS authenticated.
+// See the Client.Enroll() method.
+type Enroller interface {
+	// Enroll sends the invitation code and PEM-encoded certificate request to the
+	// teamserver at addr, and returns the credentials it issued (without private key,
+	// host and port). It must connect with the provided TLS configuration.
+	Enroll(addr string, tlsConfig *tls.Config, code string, csrPEM []byte) (*Config, error)
+}
+
+// Enroll obtains a new remote teamserver configuration with an invitation code created
+// by the teamserver administrator. The teamclient generates its private key and sends a
+// certificate request: its private key thus never leaves it, unlike when the teamserver
+// creates the user configuration file.
+//
+// The new configuration is saved in the teamclient configs directory, and used by the
+// client when it connects. The dialer must implement client.Enroller, otherwise an
+// ErrNoEnrollment error is returned.
+func (tc *Client) Enroll(host string, port int, code string) (*Config, error) {
+	enroller, ok := tc.dialer.(Enroller)
+	if !ok {
+		return nil, ErrNoEnrollment
+	}
+
+	tlsConfig, err := tc.NewEnrollmentTLSConfig(code)
+	if err != nil {
+		return nil, tc.errorf("%w: %w", ErrConfig, err)
+	}
+
+	csrPEM, keyPEM, err := newCertificateRequest()
+	if err != nil {
+		return nil, tc.errorf("%w: %w", ErrConfig, err)
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	config, err := enroller.Enroll(addr, tlsConfig, code, csrPEM)
+	if err != nil {
+		return nil, tc.errorf("%w: %w", ErrClient, err)
+	}
+
+	config.Host = host
+	config.Port = port
+	config.PrivateKey = string(keyPEM)
+
+	tc.mutex.Lock()
+	tc.opts.config = config
+	tc.mutex.Unlock()
+
+	return config, tc.SaveConfig(config)
+}
+
+// NewEnrollmentTLSConfig generates a client TLS configuration for enrolling with an
+// invitation code. The teamclient has no client certificate nor users CA yet: the
+// server certificate is instead verified against the CA sent along it, which must
+// match the fingerprint contained in the invitation code.
+func (tc *Client) NewEnrollmentTLSConfig(code string) (*tls.Config, error) {
+	_, fingerprint, found := strings.Cut(code, ".")
+	if !found || fingerprint == "" {
+		return nil, errors.New("invalid invitation code (no CA fingerprint)")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         certs.EnrollmentServerName,
+		InsecureSkipVerify: true, // Verified against the fingerprinted CA below.
+		MinVersion:         tls.VersionTLS13,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, rawCA := range rawCerts[1:] {
+				if !strings.HasPrefix(certs.Fingerprint(rawCA), fingerprint) {
+					continue
+				}
+
+				caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rawCA})
+
+				return certs.RootOnlyVerifyCertificate(string(caCert), rawCerts)
+			}
+
+			return fmt.Errorf("no teamserver CA matching the invitation code fingerprint")
+		},
+	}
+
+	return tlsConfig, nil
+}
+
+// newCertificateRequest generates a private key, and a certificate request for it.
+// Both are PEM-encoded. The teamserver sets the subject of the certificate itself.
+func newCertificateRequest() (csrPEM, keyPEM []byte, err error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return csrPEM, keyPEM, nil
+}
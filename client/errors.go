@@ -26,6 +26,18 @@ var (
 	// to do it. Make sure that your team/client.Client has been given one.
 	ErrNoTeamclient = errors.New("this teamclient has no client implementation")
 
+	// ErrNoTokenRefresh indicates that the teamclient dialer cannot ask the
+	// teamserver for a new API token: it does not implement client.TokenRefresher.
+	ErrNoTokenRefresh = errors.New("this teamclient dialer cannot refresh its token")
+
+	// ErrNoCertificateRenewal indicates that the teamclient dialer cannot ask the teamserver
+	// for a new client certificate: it does not implement client.CertificateRenewer.
+	ErrNoCertificateRenewal = errors.New("this teamclient dialer cannot renew its certificate")
+
+	// ErrNoEnrollment indicates that the teamclient dialer cannot enroll
+	// with an invitation code: it does not implement client.Enroller.
+	ErrNoEnrollment = errors.New("this teamclient dialer cannot enroll with an invitation code")
+
 	// ErrConfig is an error related to the teamclient connection configuration.
 	ErrConfig = errors.New("client config error")
 
@@ -47,6 +47,7 @@ type opts struct {
 	logFile      string
 	inMemory     bool
 	noDisconnect bool
+	verifyHost   bool
 	config       *Config
 	logger       slog.Handler
 	consoleStyle func(*log.ConsoleOptions)
@@ -265,3 +266,17 @@ func WithNoDisconnect() Options {
 		opts.noDisconnect = true
 	}
 }
+
+// WithHostVerification makes the teamclient verify that the teamserver certificate is
+// valid for the host of its remote configuration (the Config.Host), in addition to being
+// issued by its users CA, as standard TLS clients do. The teamserver certificate is valid
+// for its daemon and listeners hosts, and the server names of its configuration: use this
+// option when the configurations of its users are for one of those.
+//
+// This option can be used multiple times, either when using
+// team/client.New() or when using the teamclient.Connect() method.
+func WithHostVerification() Options {
+	return func(opts *opts) {
+		opts.verifyHost = true
+	}
+}
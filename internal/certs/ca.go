@@ -19,15 +19,18 @@ package certs
 */
 
 import (
-	"crypto/ecdsa"
+	"bytes"
+	"crypto"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
 	"path/filepath"
 
 	"github.com/reeflective/team/internal/assets"
-	"github.com/reeflective/team/log"
 )
 
 // -----------------------
@@ -39,7 +42,7 @@ const (
 )
 
 // GetUsersCA returns the certificate authority for teamserver users.
-func (c *Manager) GetUsersCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+func (c *Manager) GetUsersCA() (*x509.Certificate, crypto.Signer, error) {
 	return c.getCA(userCA)
 }
 
@@ -49,31 +52,120 @@ func (c *Manager) GetUsersCAPEM() ([]byte, []byte, error) {
 }
 
 // SaveUsersCA saves a user certificate authority (may contain several users).
-func (c *Manager) SaveUsersCA(cert, key []byte) {
-	c.saveCA(userCA, cert, key)
+// The CA is saved as is: use ImportUsersCA to check it, or to import a CA chain.
+func (c *Manager) SaveUsersCA(cert, key []byte) error {
+	if err := c.saveCA(userCA, cert, key); err != nil {
+		return err
+	}
+
+	// A CA saved without chain is a root.
+	err := c.fs.Remove(c.caFilePath(userCA, chainCAFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
 }
 
-// generateCA - Creates a new CA cert for a given type, or die trying.
-func (c *Manager) generateCA(caType string, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
-	storageDir := c.getCertDir()
+// generateCA - Creates a new CA cert for a given type, if it does not exist yet, and loads it.
+func (c *Manager) generateCA(caType string, commonName string) (*x509.Certificate, crypto.Signer, error) {
+	certFilePath := filepath.Join(c.getCertDir(), fmt.Sprintf("%s_%s-ca-cert.%s", c.appName, caType, certFileExt))
 
-	certFilePath := filepath.Join(storageDir, fmt.Sprintf("%s_%s-ca-cert.%s", c.appName, caType, certFileExt))
-	if _, err := os.Stat(certFilePath); os.IsNotExist(err) {
+	if _, err := c.fs.Stat(certFilePath); os.IsNotExist(err) {
 		c.log.Info(fmt.Sprintf("Generating certificate authority for '%s'", caType))
-		cert, key := c.GenerateECCCertificate(caType, commonName, true, false)
-		c.saveCA(caType, cert, key)
+
+		cert, key, _, err := c.GenerateCertificate(caType, commonName, true, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate CA: %w", err)
+		}
+
+		if err := c.saveCA(caType, cert, key); err != nil {
+			return nil, nil, err
+		}
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA: %w", err)
 	}
 
+	// Without its passphrase, the CA is loaded but locked.
 	cert, key, err := c.getCA(caType)
+	if errors.Is(err, ErrCAKeyLocked) || errors.Is(err, ErrPassphrase) {
+		c.log.Warn(fmt.Sprintf("Certificate authority for '%s' is locked: %s", caType, err))
+		c.passphrase = nil
+
+		return cert, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	// Keys stored in cleartext are encrypted as soon as we have a passphrase.
+	certPEM, keyPEM, err := c.getCAPEM(caType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	if len(c.passphrase) > 0 && !IsEncryptedPEM(keyPEM) {
+		c.log.Info(fmt.Sprintf("Encrypting private key of certificate authority for '%s'", caType))
+
+		if err := c.saveCA(caType, certPEM, keyPEM); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return cert, key, nil
+}
+
+// reissueCA reissues a CA certificate created without the cRLSign key usage (by older
+// teamservers), with it. The new certificate has the same subject, key and validity, so
+// that the certificates issued by the CA, and teamclients trusting the former certificate,
+// are not affected. Only our self-signed CAs can be reissued: CAs of an external PKI must
+// be imported again, with this key usage.
+func (c *Manager) reissueCA(caType string, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, error) {
+	if chain, err := c.caChain(caType); err != nil {
+		return nil, err
+	} else if len(chain) > 0 {
+		return nil, fmt.Errorf("%w without the cRLSign key usage: import a CA with it", ErrExternalCA)
+	}
+
+	c.log.Warn(fmt.Sprintf("Reissuing certificate authority for '%s' with the cRLSign key usage", caType))
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), serialNumberLen)
+	serialNumber, _ := rand.Int(rand.Reader, serialNumberLimit)
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		RawSubject:            caCert.RawSubject,
+		SubjectKeyId:          caCert.SubjectKeyId,
+		NotBefore:             caCert.NotBefore,
+		NotAfter:              caCert.NotAfter,
+		KeyUsage:              caCert.KeyUsage | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           caCert.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            caCert.MaxPathLen,
+		MaxPathLenZero:        caCert.MaxPathLenZero,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, caCert.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reissue CA: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(derBytes)
 	if err != nil {
-		log.Fatal(c.log, fmt.Sprintf("Failed to load CA: %s", err))
+		return nil, fmt.Errorf("failed to parse reissued CA: %w", err)
 	}
 
-	return cert, key
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+
+	if err := c.fs.WriteFile(c.caFilePath(caType, "ca-cert"), certPEM, assets.FileReadPerm); err != nil {
+		return nil, fmt.Errorf("failed to save reissued CA: %w", err)
+	}
+
+	return cert, nil
 }
 
 // getCA - Get the current CA certificate.
-func (c *Manager) getCA(caType string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+func (c *Manager) getCA(caType string) (*x509.Certificate, crypto.Signer, error) {
 	certPEM, keyPEM, err := c.getCAPEM(caType)
 	if err != nil {
 		return nil, nil, err
@@ -82,7 +174,7 @@ func (c *Manager) getCA(caType string) (*x509.Certificate, *ecdsa.PrivateKey, er
 	certBlock, _ := pem.Decode(certPEM)
 	if certBlock == nil {
 		c.log.Error("Failed to parse certificate PEM")
-		return nil, nil, err
+		return nil, nil, errors.New("failed to parse CA certificate PEM")
 	}
 
 	cert, err := x509.ParseCertificate(certBlock.Bytes)
@@ -91,26 +183,51 @@ func (c *Manager) getCA(caType string) (*x509.Certificate, *ecdsa.PrivateKey, er
 		return nil, nil, err
 	}
 
-	keyBlock, _ := pem.Decode(keyPEM)
-	if keyBlock == nil {
-		c.log.Error("Failed to parse certificate PEM")
-		return nil, nil, err
+	key, err := c.caPrivateKey(caType, keyPEM)
+	if err != nil {
+		return cert, nil, err
+	}
+
+	return cert, key, nil
+}
+
+// caPrivateKey parses the private key of a CA, decrypting it with the manager passphrase if needed.
+// Decrypted keys are kept in memory, since deriving the encryption key is (purposely) slow.
+func (c *Manager) caPrivateKey(caType string, keyPEM []byte) (crypto.Signer, error) {
+	if !IsEncryptedPEM(keyPEM) {
+		return parsePrivateKeyPEM(keyPEM)
+	}
+
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+
+	if cached, found := c.caKeys[caType]; found && bytes.Equal(cached.stored, keyPEM) {
+		return cached.key, nil
 	}
 
-	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if len(c.passphrase) == 0 {
+		return nil, ErrCAKeyLocked
+	}
+
+	decrypted, err := DecryptPrivateKeyPEM(keyPEM, c.passphrase)
 	if err != nil {
-		c.log.Error(err.Error())
-		return nil, nil, err
+		return nil, err
 	}
 
-	return cert, key, nil
+	key, err := parsePrivateKeyPEM(decrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	c.caKeys[caType] = decryptedKey{stored: keyPEM, key: key}
+
+	return key, nil
 }
 
 // getCAPEM - Get PEM encoded CA cert/key.
 func (c *Manager) getCAPEM(caType string) ([]byte, []byte, error) {
 	caType = filepath.Base(caType)
 	caCertPath := filepath.Join(c.getCertDir(), fmt.Sprintf("%s_%s-ca-cert.%s", c.appName, caType, certFileExt))
-	caKeyPath := filepath.Join(c.getCertDir(), fmt.Sprintf("%s_%s-ca-key.%s", c.appName, caType, certFileExt))
 
 	certPEM, err := c.fs.ReadFile(caCertPath)
 	if err != nil {
@@ -118,7 +235,7 @@ func (c *Manager) getCAPEM(caType string) ([]byte, []byte, error) {
 		return nil, nil, err
 	}
 
-	keyPEM, err := c.fs.ReadFile(caKeyPath)
+	keyPEM, err := c.SecretStore().Get(caKeySecret(caType))
 	if err != nil {
 		c.log.Error(err.Error())
 		return nil, nil, err
@@ -127,24 +244,34 @@ func (c *Manager) getCAPEM(caType string) ([]byte, []byte, error) {
 	return certPEM, keyPEM, nil
 }
 
-// saveCA - Save the certificate and the key to the filesystem
-// doesn't return an error because errors are fatal. If we can't generate CAs,
-// then we can't secure communication and we should die a horrible death.
-func (c *Manager) saveCA(caType string, cert []byte, key []byte) {
-	storageDir := c.getCertDir()
+// saveCA - Save the certificate to the filesystem, and the key (encrypted with the passphrase
+// if any) to the secret store.
+func (c *Manager) saveCA(caType string, cert []byte, key []byte) error {
+	storageDir, err := c.makeCertDir()
+	if err != nil {
+		return err
+	}
 
 	// CAs get written to the filesystem since we control the names and makes them
 	// easier to move around/backup
 	certFilePath := filepath.Join(storageDir, fmt.Sprintf("%s_%s-ca-cert.%s", c.appName, caType, certFileExt))
-	keyFilePath := filepath.Join(storageDir, fmt.Sprintf("%s_%s-ca-key.%s", c.appName, caType, certFileExt))
 
-	err := c.fs.WriteFile(certFilePath, cert, assets.FileReadPerm)
+	// The key is stored encrypted if we have a passphrase.
+	if len(c.passphrase) > 0 && !IsEncryptedPEM(key) {
+		if key, err = EncryptPrivateKeyPEM(key, c.passphrase); err != nil {
+			return fmt.Errorf("failed to encrypt CA private key: %w", err)
+		}
+	}
+
+	err = c.SecretStore().Put(caKeySecret(caType), key)
 	if err != nil {
-		log.Fatal(c.log, fmt.Sprintf("Failed write certificate data to %s, %s", certFilePath, err))
+		return fmt.Errorf("failed to write CA private key to the secret store: %w", err)
 	}
 
-	err = c.fs.WriteFile(keyFilePath, key, assets.FileReadPerm)
+	err = c.fs.WriteFile(certFilePath, cert, assets.FileReadPerm)
 	if err != nil {
-		log.Fatal(c.log, fmt.Sprintf("Failed write certificate data to %s: %s", keyFilePath, err))
+		return fmt.Errorf("failed to write certificate data to %s: %w", certFilePath, err)
 	}
+
+	return nil
 }
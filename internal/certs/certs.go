@@ -20,32 +20,33 @@ package certs
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
-	"crypto/elliptic"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/binary"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"log/slog"
 	"math/big"
-	insecureRand "math/rand"
 	"net"
 	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
 
 	"github.com/reeflective/team/internal/assets"
 	"github.com/reeflective/team/internal/db"
-	"github.com/reeflective/team/log"
 )
 
 const (
-	// ECCKey - Namespace for ECC keys.
+	// ECCKey - Namespace for ECC keys. Users and server certificates are stored
+	// in it whatever their key algorithm, which is recorded with their profile.
 	ECCKey = "ecc"
 
 	// RSAKey - Namespace for RSA keys.
@@ -69,26 +70,57 @@ type Manager struct {
 	log      *slog.Logger
 	database *gorm.DB
 	fs       *assets.FS
+	profiles Profiles
+	secrets  SecretStore
+
+	// Passphrase of the encrypted CA private keys, and the keys decrypted with it.
+	passphrase []byte
+	caKeys     map[string]decryptedKey
+	keysMutex  sync.Mutex
 }
 
 // NewManager initializes and returns a certificate manager for a given teamserver.
 // The returned manager will have ensured that all certificate authorities are initialized
 // and working, or will create them if needed.
-// Any critical error happening at initialization time will log a fatal event to
-// the provided logger and exit the program: the certificate infrastructure must
-// work for the teamserver to operate securely.
-func NewManager(filesystem *assets.FS, db *gorm.DB, logger *slog.Logger, appName, appDir string) *Manager {
+// Certificates are issued according to the given profiles (validated with Profiles.Validate),
+// and to the default profile of their purpose when none is given for their CA and purpose.
+//
+// With a passphrase, CA private keys are stored encrypted with it, and the ones stored in
+// cleartext are encrypted. Without, or with an incorrect one, encrypted CA keys are locked
+// until Manager.UnlockUsersCA() is called: certificates cannot be issued in the meantime.
+//
+// All private keys are kept in the given secret store, or in files of the application
+// directory if nil. Keys stored by older versions (CA key files and certificate keys in
+// the database) are moved into it.
+//
+// Any error happening at initialization time (eg. failing to write the CA) is returned:
+// the certificate infrastructure must work for the teamserver to operate securely.
+func NewManager(filesystem *assets.FS, db *gorm.DB, logger *slog.Logger, appName, appDir string, profiles Profiles, passphrase []byte, secrets SecretStore) (*Manager, error) {
+	if secrets == nil {
+		secrets = NewFileSecretStore(filesystem, filepath.Join(appDir, "secrets"))
+	}
+
 	certs := &Manager{
-		appName:  appName,
-		appDir:   appDir,
-		log:      logger,
-		database: db,
-		fs:       filesystem,
+		appName:    appName,
+		appDir:     appDir,
+		log:        logger,
+		database:   db,
+		fs:         filesystem,
+		profiles:   profiles,
+		secrets:    secrets,
+		passphrase: passphrase,
+		caKeys:     make(map[string]decryptedKey),
+	}
+
+	if err := certs.moveLegacySecrets(); err != nil {
+		return nil, fmt.Errorf("failed to move private keys to the secret store: %w", err)
 	}
 
-	certs.generateCA(userCA, "teamusers")
+	if _, _, err := certs.generateCA(userCA, "teamusers"); err != nil {
+		return nil, err
+	}
 
-	return certs
+	return certs, nil
 }
 
 func (c *Manager) db() *gorm.DB {
@@ -130,7 +162,13 @@ func (c *Manager) GetCertificate(caType string, keyType string, commonName strin
 		return nil, nil, result.Error
 	}
 
-	return []byte(certModel.CertificatePEM), []byte(certModel.PrivateKeyPEM), nil
+	// Certificates signed for a request have no private key.
+	keyPEM, err := c.SecretStore().Get(certificateKeySecret(caType, keyType, commonName))
+	if err != nil && !errors.Is(err, ErrSecretNotFound) {
+		return nil, nil, err
+	}
+
+	return []byte(certModel.CertificatePEM), keyPEM, nil
 }
 
 // RemoveCertificate - Remove a certificate from the cert store.
@@ -146,65 +184,151 @@ func (c *Manager) RemoveCertificate(caType string, keyType string, commonName st
 		KeyType:    keyType,
 		CommonName: commonName,
 	}).Delete(&db.Certificate{}).Error
+	if err != nil {
+		return err
+	}
 
-	return err
+	return c.SecretStore().Delete(certificateKeySecret(caType, keyType, commonName))
 }
 
 // --------------------------------
 //  Generic Certificate Functions
 // --------------------------------
 
-// GenerateECCCertificate - Generate a TLS certificate with the given parameters
-// We choose some reasonable defaults like Curve, Key Size, ValidFor, etc.
+// GenerateCertificate - Generate a TLS certificate as specified by the profile of its CA type
+// and purpose (authority, client or server authentication). Returns the certificate, the key
+// (PEM Encoded) and the profile with which they were issued.
+func (c *Manager) GenerateCertificate(caType string, commonName string, isCA bool, isClient bool) ([]byte, []byte, Profile, error) {
+	profile := c.profiles.get(caType, profilePurpose(isCA, isClient))
+
+	cert, key, err := c.generateProfileCertificate(caType, commonName, isCA, isClient, profile)
+
+	return cert, key, profile, err
+}
+
+// GenerateECCCertificate - Generate a TLS certificate with an ECDSA key, the curve and other
+// properties being those of the profile of its CA type and purpose, if it uses ECDSA keys.
 // Returns two strings `cert` and `key` (PEM Encoded).
-func (c *Manager) GenerateECCCertificate(caType string, commonName string, isCA bool, isClient bool) ([]byte, []byte) {
-	c.log.Info(fmt.Sprintf("Generating TLS certificate (ECC) for '%s' ...", commonName))
+func (c *Manager) GenerateECCCertificate(caType string, commonName string, isCA bool, isClient bool) ([]byte, []byte, error) {
+	profile := c.profiles.get(caType, profilePurpose(isCA, isClient))
 
-	var privateKey interface{}
-	var err error
+	if profile.Algorithm != KeyECDSA {
+		profile.Algorithm, profile.Curve = KeyECDSA, ""
+		profile, _ = profile.Validate(profilePurpose(isCA, isClient))
+	}
+
+	return c.generateProfileCertificate(caType, commonName, isCA, isClient, profile)
+}
 
-	// Generate private key
-	curves := []elliptic.Curve{elliptic.P521(), elliptic.P384(), elliptic.P256()}
-	curve := curves[randomInt(len(curves))]
+// GenerateRSACertificate - Generates an RSA Certificate, the key size and other properties
+// being those of the profile of its CA type and purpose, if it uses RSA keys.
+func (c *Manager) GenerateRSACertificate(caType string, commonName string, isCA bool, isClient bool) ([]byte, []byte, error) {
+	profile := c.profiles.get(caType, profilePurpose(isCA, isClient))
 
-	privateKey, err = ecdsa.GenerateKey(curve, rand.Reader)
+	if profile.Algorithm != KeyRSA {
+		profile.Algorithm, profile.RSABits = KeyRSA, 0
+		profile, _ = profile.Validate(profilePurpose(isCA, isClient))
+	}
+
+	return c.generateProfileCertificate(caType, commonName, isCA, isClient, profile)
+}
+
+func (c *Manager) generateProfileCertificate(caType, commonName string, isCA, isClient bool, profile Profile, names ...string) ([]byte, []byte, error) {
+	c.log.Info(fmt.Sprintf("Generating TLS certificate (%s) for '%s' ...", profile.Algorithm, commonName))
+
+	privateKey, err := profile.generateKey()
 	if err != nil {
-		log.Fatal(c.log, fmt.Sprintf("Failed to generate private key: %s", err))
+		return nil, nil, fmt.Errorf("failed to generate private key: %w", err)
 	}
 
 	subject := pkix.Name{
 		CommonName: commonName,
 	}
 
-	return c.generateCertificate(caType, subject, isCA, isClient, privateKey)
+	return c.generateCertificate(caType, subject, isCA, isClient, privateKey, profile, names...)
 }
 
-// GenerateRSACertificate - Generates an RSA Certificate.
-func (c *Manager) GenerateRSACertificate(caType string, commonName string, isCA bool, isClient bool) ([]byte, []byte) {
-	c.log.Debug(fmt.Sprintf("Generating TLS certificate (RSA) for '%s' ...", commonName))
+func (c *Manager) generateCertificate(caType string, subject pkix.Name, isCA bool, isClient bool, privateKey crypto.Signer, profile Profile, names ...string) ([]byte, []byte, error) {
+	template := c.newCertificateTemplate(subject, isCA, isClient, profile, names...)
 
-	var privateKey interface{}
-	var err error
+	// Sign certificate or self-sign if CA
+	var certErr error
+	var derBytes []byte
+
+	if isCA {
+		c.log.Debug("Certificate is an AUTHORITY")
+
+		template.IsCA = true
+		derBytes, certErr = x509.CreateCertificate(rand.Reader, template, template, privateKey.Public(), privateKey)
+	} else {
+		caCert, caKey, err := c.getCA(caType) // Sign the new certificate with our CA
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid ca type (%s): %w", caType, err)
+		}
+		derBytes, certErr = x509.CreateCertificate(rand.Reader, template, caCert, privateKey.Public(), caKey)
+	}
 
-	// Generate private key
-	privateKey, err = rsa.GenerateKey(rand.Reader, rsaKeySize())
+	if certErr != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", certErr)
+	}
+
+	keyBlock, err := c.pemBlockForKey(privateKey)
 	if err != nil {
-		log.Fatal(c.log, fmt.Sprintf("Failed to generate private key: %s", err))
+		return nil, nil, err
 	}
 
-	subject := pkix.Name{
-		CommonName: commonName,
+	// Encode certificate and key
+	certOut := bytes.NewBuffer([]byte{})
+	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+
+	keyOut := bytes.NewBuffer([]byte{})
+	pem.Encode(keyOut, keyBlock)
+
+	return certOut.Bytes(), keyOut.Bytes(), nil
+}
+
+// SignCertificateRequest - Sign a PEM-encoded certificate request with a given CA, and return
+// the PEM-encoded certificate. Only the public key of the request is used: the subject of the
+// certificate is always the common name given, and its other properties are ours.
+func (c *Manager) SignCertificateRequest(caType string, commonName string, csrPEM []byte, isClient bool) ([]byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("failed to parse certificate request PEM")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+
+	caCert, caKey, err := c.getCA(caType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca type (%s): %w", caType, err)
+	}
+
+	c.log.Info(fmt.Sprintf("Signing certificate request for '%s' ...", commonName))
+
+	profile := c.profiles.get(caType, profilePurpose(false, isClient))
+	template := c.newCertificateTemplate(pkix.Name{CommonName: commonName}, false, isClient, profile)
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	if err != nil {
+		return nil, err
 	}
 
-	return c.generateCertificate(caType, subject, isCA, isClient, privateKey)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), nil
 }
 
-func (c *Manager) generateCertificate(caType string, subject pkix.Name, isCA bool, isClient bool, privateKey interface{}) ([]byte, []byte) {
-	// Valid times, subtract random days from .Now()
-	notBefore := time.Now()
-	days := randomInt(daysInYear) * -1 // Within -1 year
-	notBefore = notBefore.AddDate(0, 0, days)
-	notAfter := notBefore.Add(randomValidFor())
+// newCertificateTemplate returns the template of a new certificate, with the validity
+// times and key usages of its profile. Server certificates authenticate their common
+// name, and the other names given (host names or IP addresses), as subject alt names.
+func (c *Manager) newCertificateTemplate(subject pkix.Name, isCA bool, isClient bool, profile Profile, names ...string) *x509.Certificate {
+	notBefore := time.Now().Add(-profile.Backdate)
+	notAfter := notBefore.Add(profile.Validity)
 	c.log.Debug(fmt.Sprintf("Valid from %v to %v", notBefore, notAfter))
 
 	// Serial number
@@ -212,32 +336,13 @@ func (c *Manager) generateCertificate(caType string, subject pkix.Name, isCA boo
 	serialNumber, _ := rand.Int(rand.Reader, serialNumberLimit)
 	c.log.Debug(fmt.Sprintf("Serial Number: %d", serialNumber))
 
-	keyUsage := x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
-	var extKeyUsage []x509.ExtKeyUsage
+	keyUsage := profile.keyUsage
+	extKeyUsage := profile.extKeyUsage
 
-	switch {
-	case isCA:
-		c.log.Debug("Authority certificate")
-
-		keyUsage = x509.KeyUsageCertSign | x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
-		extKeyUsage = []x509.ExtKeyUsage{
-			x509.ExtKeyUsageServerAuth,
-			x509.ExtKeyUsageClientAuth,
-		}
-	case isClient:
-		c.log.Debug("Client authentication certificate")
-
-		extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
-	default:
-		c.log.Debug("Server authentication certificate")
-
-		extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
-	}
-
-	c.log.Debug(fmt.Sprintf("ExtKeyUsage = %v", extKeyUsage))
+	c.log.Debug(fmt.Sprintf("KeyUsage = %v, ExtKeyUsage = %v", keyUsage, extKeyUsage))
 
 	// Certificate template
-	template := x509.Certificate{
+	template := &x509.Certificate{
 		SerialNumber:          serialNumber,
 		Subject:               subject,
 		NotBefore:             notBefore,
@@ -249,134 +354,215 @@ func (c *Manager) generateCertificate(caType string, subject pkix.Name, isCA boo
 
 	if !isClient {
 		// Host or IP address
-		if ip := net.ParseIP(subject.CommonName); ip != nil {
-			c.log.Debug(fmt.Sprintf("Certificate authenticates IP address: %v", ip))
-			template.IPAddresses = append(template.IPAddresses, ip)
-		} else {
-			c.log.Debug(fmt.Sprintf("Certificate authenticates host: %v", subject.CommonName))
-			template.DNSNames = append(template.DNSNames, subject.CommonName)
+		for _, name := range append([]string{subject.CommonName}, names...) {
+			if ip := net.ParseIP(name); ip != nil && !slices.ContainsFunc(template.IPAddresses, ip.Equal) {
+				c.log.Debug(fmt.Sprintf("Certificate authenticates IP address: %v", ip))
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else if ip == nil && name != "" && !slices.Contains(template.DNSNames, name) {
+				c.log.Debug(fmt.Sprintf("Certificate authenticates host: %v", name))
+				template.DNSNames = append(template.DNSNames, name)
+			}
 		}
 	} else {
 		c.log.Debug(fmt.Sprintf("Client certificate authenticates CN: %v", subject.CommonName))
 	}
 
-	// Sign certificate or self-sign if CA
-	var certErr error
-	var derBytes []byte
+	return template
+}
 
-	if isCA {
-		c.log.Debug("Certificate is an AUTHORITY")
+func (c *Manager) saveCertificate(caType, keyType, commonName string, cert, key []byte, profile Profile) error {
+	if keyType != ECCKey && keyType != RSAKey {
+		return fmt.Errorf("Invalid key type '%s'", keyType)
+	}
 
-		template.IsCA = true
-		template.KeyUsage |= x509.KeyUsageCertSign
-		derBytes, certErr = x509.CreateCertificate(rand.Reader, &template, &template, publicKey(privateKey), privateKey)
-	} else {
-		caCert, caKey, err := c.getCA(caType) // Sign the new certificate with our CA
-		if err != nil {
-			log.Fatal(c.log, fmt.Sprintf("Invalid ca type (%s): %s", caType, err))
+	c.log.Info(fmt.Sprintf("Saving certificate for cn = '%s'", commonName))
+
+	if len(key) > 0 {
+		if err := c.SecretStore().Put(certificateKeySecret(caType, keyType, commonName), key); err != nil {
+			return err
 		}
-		derBytes, certErr = x509.CreateCertificate(rand.Reader, &template, caCert, publicKey(privateKey), caKey)
 	}
 
-	if certErr != nil {
-		// We maybe don't want this to be fatal, but it should basically never happen afaik
-		log.Fatal(c.log, fmt.Sprintf("Failed to create certificate: %s", certErr))
+	result := c.db().Create(newCertificateModel(caType, keyType, commonName, cert, profile))
+
+	return result.Error
+}
+
+// replaceCertificate saves a certificate (and its private key, if any) in place of the current
+// one with the same name, if any. The previous certificate is only deleted once the new one is
+// saved, in the same database transaction, which also runs the update function, if any, with
+// the previous certificate (nil without one). If the transaction fails, the previous private key
+// is restored: nothing is changed. On success, the previous key is deleted if the new certificate
+// has none (eg. signed for a request).
+func (c *Manager) replaceCertificate(caType, keyType, commonName string, cert, key []byte, profile Profile,
+	update func(tx *gorm.DB, previous *db.Certificate) error,
+) error {
+	if keyType != ECCKey && keyType != RSAKey {
+		return fmt.Errorf("Invalid key type '%s'", keyType)
 	}
 
-	// Encode certificate and key
-	certOut := bytes.NewBuffer([]byte{})
-	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	c.log.Info(fmt.Sprintf("Replacing certificate for cn = '%s'", commonName))
 
-	keyOut := bytes.NewBuffer([]byte{})
-	pem.Encode(keyOut, c.pemBlockForKey(privateKey))
+	secretName := certificateKeySecret(caType, keyType, commonName)
+
+	previousKey, err := c.SecretStore().Get(secretName)
+	if err != nil && !errors.Is(err, ErrSecretNotFound) {
+		return err
+	}
+
+	if len(key) > 0 {
+		if err := c.SecretStore().Put(secretName, key); err != nil {
+			return err
+		}
+	}
+
+	err = c.db().Transaction(func(tx *gorm.DB) error {
+		previous := &db.Certificate{}
+
+		result := tx.Where(&db.Certificate{
+			CAType:     caType,
+			KeyType:    keyType,
+			CommonName: commonName,
+		}).Limit(1).Find(previous)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			previous = nil
+		} else if err := tx.Delete(previous).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(newCertificateModel(caType, keyType, commonName, cert, profile)).Error; err != nil {
+			return err
+		}
+
+		if update == nil {
+			return nil
+		}
+
+		return update(tx, previous)
+	})
 
-	return certOut.Bytes(), keyOut.Bytes()
+	switch {
+	case err != nil && len(key) > 0:
+		c.restoreSecret(secretName, previousKey)
+	case err == nil && len(key) == 0 && previousKey != nil:
+		if err := c.SecretStore().Delete(secretName); err != nil {
+			c.log.Warn(fmt.Sprintf("Failed to delete the private key of the replaced certificate cn = '%s': %s", commonName, err))
+		}
+	}
+
+	return err
 }
 
-func (c *Manager) saveCertificate(caType string, keyType string, commonName string, cert []byte, key []byte) error {
-	if keyType != ECCKey && keyType != RSAKey {
-		return fmt.Errorf("Invalid key type '%s'", keyType)
+// restoreSecret puts back a secret replaced before a failed operation, or deletes it if there was none.
+func (c *Manager) restoreSecret(name string, secret []byte) {
+	var err error
+
+	if secret == nil {
+		err = c.SecretStore().Delete(name)
+	} else {
+		err = c.SecretStore().Put(name, secret)
 	}
 
-	c.log.Info(fmt.Sprintf("Saving certificate for cn = '%s'", commonName))
+	if err != nil {
+		c.log.Error(fmt.Sprintf("Failed to restore secret %s: %s", name, err))
+	}
+}
 
+// newCertificateModel returns the database model of a certificate issued with a profile.
+func newCertificateModel(caType, keyType, commonName string, cert []byte, profile Profile) *db.Certificate {
 	certModel := &db.Certificate{
 		CommonName:     commonName,
 		CAType:         caType,
 		KeyType:        keyType,
 		CertificatePEM: string(cert),
-		PrivateKeyPEM:  string(key),
 	}
 
-	result := c.db().Create(&certModel)
+	if profile.Algorithm != "" {
+		certModel.Profile = profile.String()
+	}
 
-	return result.Error
+	return certModel
 }
 
-// getCertDir returns the directory (and makes it if needed) for writing certificate backups.
+// getCertDir returns the directory of the certificate files (CA certificates and chains).
 func (c *Manager) getCertDir() string {
-	rootDir := c.appDir
-	certDir := filepath.Join(rootDir, "certs")
+	return filepath.Join(c.appDir, "certs")
+}
+
+// makeCertDir returns the directory of the certificate files, making it if needed.
+func (c *Manager) makeCertDir() (string, error) {
+	certDir := c.getCertDir()
 
 	err := c.fs.MkdirAll(certDir, assets.DirPerm)
 	if err != nil {
-		log.Fatal(c.log, fmt.Sprintf("Failed to create cert dir: %s", err))
+		return "", fmt.Errorf("failed to create cert dir: %w", err)
 	}
 
-	return certDir
+	return certDir, nil
 }
 
-func (c *Manager) pemBlockForKey(priv interface{}) *pem.Block {
+// privateKeyPEM returns a private key PEM-encoded, like the keys generated by the manager.
+func (c *Manager) privateKeyPEM(key crypto.Signer) ([]byte, error) {
+	block, err := c.pemBlockForKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+func (c *Manager) pemBlockForKey(priv crypto.Signer) (*pem.Block, error) {
 	switch key := priv.(type) {
 	case *rsa.PrivateKey:
 		data := x509.MarshalPKCS1PrivateKey(key)
-		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: data}
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: data}, nil
 	case *ecdsa.PrivateKey:
 		data, err := x509.MarshalECPrivateKey(key)
 		if err != nil {
-			log.Fatal(c.log, fmt.Sprintf("Unable to marshal ECDSA private key: %v", err))
+			return nil, fmt.Errorf("unable to marshal ECDSA private key: %w", err)
 		}
 
-		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: data}
-	default:
-		return nil
-	}
-}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: data}, nil
+	case ed25519.PrivateKey:
+		data, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal Ed25519 private key: %w", err)
+		}
 
-func publicKey(priv interface{}) interface{} {
-	switch k := priv.(type) {
-	case *rsa.PrivateKey:
-		return &k.PublicKey
-	case *ecdsa.PrivateKey:
-		return &k.PublicKey
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: data}, nil
 	default:
-		return nil
+		return nil, fmt.Errorf("unsupported private key type %T", priv)
 	}
 }
 
-func randomInt(max int) int {
-	intLen := 4
-	buf := make([]byte, intLen)
-	rand.Read(buf)
-	i := binary.LittleEndian.Uint32(buf)
-
-	return int(i) % max
-}
+// parsePrivateKeyPEM parses a PEM-encoded private key, either in PKCS #8 form,
+// or in the RSA (PKCS #1) and EC (SEC 1) forms of keys generated by the manager.
+func parsePrivateKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse private key PEM")
+	}
 
-func randomValidFor() time.Duration {
-	validFor := validForYears * (daysInYear * hoursInDay * time.Hour)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
 
-	switch insecureRand.Intn(2) {
-	case 0:
-		validFor = (validForYears - 1) * (daysInYear * hoursInDay * time.Hour)
-	case 1:
-		validFor = validForYears * (daysInYear * hoursInDay * time.Hour)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
 	}
 
-	return validFor
-}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
 
-func rsaKeySize() int {
-	rsaKeySizes := []int{4096, 2048}
-	return rsaKeySizes[randomInt(len(rsaKeySizes))]
+	return signer, nil
 }
@@ -20,8 +20,15 @@ package certs
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"io"
 	"log/slog"
 	"testing"
@@ -38,6 +45,13 @@ import (
 func newTestManager(t *testing.T) *Manager {
 	t.Helper()
 
+	return newTestManagerWithProfiles(t, nil)
+}
+
+// newTestManagerWithProfiles is newTestManager, with certificate profiles.
+func newTestManagerWithProfiles(t *testing.T, profiles Profiles) *Manager {
+	t.Helper()
+
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
 	dbConfig := &db.Config{
@@ -55,7 +69,12 @@ func newTestManager(t *testing.T) *Manager {
 
 	fs := assets.NewFileSystem(true)
 
-	return NewManager(fs, database, logger, "testapp", "/app")
+	certs, err := NewManager(fs, database, logger, "testapp", "/app", profiles, nil, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	return certs
 }
 
 // TestNewManagerInitializesCA verifies that constructing a manager creates a
@@ -94,12 +113,15 @@ func TestECCCertificateRoundTrip(t *testing.T) {
 	certs := newTestManager(t)
 
 	cn := "roundtrip.example.com"
-	cert, key := certs.GenerateECCCertificate(userCA, cn, false, false)
+	cert, key, err := certs.GenerateECCCertificate(userCA, cn, false, false)
+	if err != nil {
+		t.Fatalf("GenerateECCCertificate: %v", err)
+	}
 	if len(cert) == 0 || len(key) == 0 {
 		t.Fatal("GenerateECCCertificate returned empty material")
 	}
 
-	if err := certs.saveCertificate(userCA, ECCKey, cn, cert, key); err != nil {
+	if err := certs.saveCertificate(userCA, ECCKey, cn, cert, key, Profile{}); err != nil {
 		t.Fatalf("saveCertificate: %v", err)
 	}
 
@@ -126,7 +148,10 @@ func TestRSACertificateRoundTrip(t *testing.T) {
 	certs := newTestManager(t)
 
 	cn := "rsa.example.com"
-	cert, key := certs.GenerateRSACertificate(userCA, cn, false, false)
+	cert, key, err := certs.GenerateRSACertificate(userCA, cn, false, false)
+	if err != nil {
+		t.Fatalf("GenerateRSACertificate: %v", err)
+	}
 	if len(cert) == 0 || len(key) == 0 {
 		t.Fatal("GenerateRSACertificate returned empty material")
 	}
@@ -135,7 +160,7 @@ func TestRSACertificateRoundTrip(t *testing.T) {
 		t.Fatal("RSA private key PEM block is malformed")
 	}
 
-	if err := certs.saveCertificate(userCA, RSAKey, cn, cert, key); err != nil {
+	if err := certs.saveCertificate(userCA, RSAKey, cn, cert, key, Profile{}); err != nil {
 		t.Fatalf("saveCertificate: %v", err)
 	}
 
@@ -173,7 +198,7 @@ func TestInvalidKeyTypeRejected(t *testing.T) {
 	if err := certs.RemoveCertificate(userCA, "dsa", "x"); err == nil {
 		t.Fatal("RemoveCertificate accepted an invalid key type")
 	}
-	if err := certs.saveCertificate(userCA, "dsa", "x", nil, nil); err == nil {
+	if err := certs.saveCertificate(userCA, "dsa", "x", nil, nil, Profile{}); err == nil {
 		t.Fatal("saveCertificate accepted an invalid key type")
 	}
 }
@@ -288,7 +313,10 @@ func TestRootOnlyVerifyCertificate(t *testing.T) {
 	// Go's default EKU (server-auth), so we verify with server certificates.
 	//
 	// A leaf signed by our CA must verify.
-	leafPEM, _ := certs.GenerateECCCertificate(userCA, "localhost", false, false)
+	leafPEM, _, err := certs.GenerateECCCertificate(userCA, "localhost", false, false)
+	if err != nil {
+		t.Fatalf("GenerateECCCertificate: %v", err)
+	}
 	leafBlock, _ := pem.Decode(leafPEM)
 	if leafBlock == nil {
 		t.Fatal("failed to decode leaf certificate PEM")
@@ -299,7 +327,10 @@ func TestRootOnlyVerifyCertificate(t *testing.T) {
 
 	// A leaf signed by a DIFFERENT CA must be rejected.
 	other := newTestManagerWithApp(t, "otherapp", "/other")
-	foreignPEM, _ := other.GenerateECCCertificate(userCA, "localhost", false, false)
+	foreignPEM, _, err := other.GenerateECCCertificate(userCA, "localhost", false, false)
+	if err != nil {
+		t.Fatalf("GenerateECCCertificate: %v", err)
+	}
 	foreignBlock, _ := pem.Decode(foreignPEM)
 	if foreignBlock == nil {
 		t.Fatal("failed to decode foreign certificate PEM")
@@ -336,5 +367,175 @@ func newTestManagerWithApp(t *testing.T, appName, appDir string) *Manager {
 		t.Fatalf("failed to create in-memory database: %v", err)
 	}
 
-	return NewManager(assets.NewFileSystem(true), database, logger, appName, appDir)
+	certs, err := NewManager(assets.NewFileSystem(true), database, logger, appName, appDir, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	return certs
+}
+
+// TestUserClientSignCredentialCSR checks that a certificate request is signed by the
+// user CA for the given user, over the requested key, and that non-ECDSA keys are refused.
+func TestUserClientSignCredentialCSR(t *testing.T) {
+	certs := newTestManager(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	leafPEM, err := certs.UserClientSignCredentialCSR("carol", "laptop", csrPEM)
+	if err != nil {
+		t.Fatalf("UserClientSignCredentialCSR: %v", err)
+	}
+
+	leaf, err := ParseCertificatePEM(leafPEM)
+	if err != nil {
+		t.Fatalf("ParseCertificatePEM: %v", err)
+	}
+
+	if leaf.Subject.CommonName != "carol" || !key.PublicKey.Equal(leaf.PublicKey) {
+		t.Fatalf("certificate not issued to carol over the requested key: %s", leaf.Subject.CommonName)
+	}
+
+	caCert, _, _ := certs.GetUsersCA()
+	if err := leaf.CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("certificate not signed by the user CA: %v", err)
+	}
+
+	if stored, _, err := certs.UserClientGetCredentialCertificate("carol", "laptop"); err != nil || !bytes.Equal(stored, leafPEM) {
+		t.Fatalf("signed certificate not saved (%v)", err)
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, rsaKey)
+
+	if _, err := certs.UserClientSignCredentialCSR("carol", "desktop",
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: rsaDER})); err == nil {
+		t.Fatal("RSA certificate request should be refused by an ECDSA client profile")
+	}
+}
+
+// TestUserClientSignCredentialCSRProfile checks that certificate requests are signed
+// for keys of the client profile algorithm, whichever it is, and only for those.
+func TestUserClientSignCredentialCSRProfile(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	request := func(key crypto.Signer) []byte {
+		csrDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+	}
+
+	for _, test := range []struct {
+		profile  Profile
+		accepted crypto.Signer
+		refused  []crypto.Signer
+	}{
+		{Profile{Algorithm: KeyEd25519}, edKey, []crypto.Signer{ecKey, rsaKey}},
+		{Profile{Algorithm: KeyRSA, RSABits: 2048}, rsaKey, []crypto.Signer{ecKey, edKey}},
+		{Profile{Algorithm: KeyRSA, RSABits: 3072}, nil, []crypto.Signer{rsaKey}},
+	} {
+		certs := newTestManagerWithProfiles(t, Profiles{PurposeClient: test.profile})
+
+		if test.accepted != nil {
+			leafPEM, err := certs.UserClientSignCredentialCSR("carol", "laptop", request(test.accepted))
+			if err != nil {
+				t.Fatalf("%s profile: UserClientSignCredentialCSR: %v", test.profile.Algorithm, err)
+			}
+
+			leaf, _ := ParseCertificatePEM(leafPEM)
+			if leaf == nil || !test.accepted.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(leaf.PublicKey) {
+				t.Fatalf("%s profile: certificate not issued over the requested key", test.profile.Algorithm)
+			}
+		}
+
+		for _, key := range test.refused {
+			if _, err := certs.UserClientSignCredentialCSR("carol", "desktop", request(key)); err == nil {
+				t.Fatalf("%s profile: %T certificate request should be refused", test.profile.Algorithm, key)
+			}
+		}
+	}
+}
+
+// TestUserClientRenewCredentialCSR checks that a credential keeps its certificate, unrevoked,
+// when its renewal fails, and that the previous one is revoked when it succeeds.
+func TestUserClientRenewCredentialCSR(t *testing.T) {
+	certs := newTestManager(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	currentPEM, err := certs.UserClientSignCredentialCSR("carol", "laptop", csrPEM)
+	if err != nil {
+		t.Fatalf("UserClientSignCredentialCSR: %v", err)
+	}
+
+	current, _ := ParseCertificatePEM(currentPEM)
+	errRenew := errors.New("renewal failure")
+
+	_, err = certs.UserClientRenewCredentialCSR("carol", "laptop", csrPEM, func(*gorm.DB, []byte) error { return errRenew })
+	if !errors.Is(err, errRenew) {
+		t.Fatalf("renewal should fail with the error of its update, got %v", err)
+	}
+
+	if stored, _, err := certs.UserClientGetCredentialCertificate("carol", "laptop"); err != nil || !bytes.Equal(stored, currentPEM) {
+		t.Fatalf("certificate should be kept after a failed renewal (%v)", err)
+	}
+
+	if revoked, err := certs.IsRevoked(current.SerialNumber); err != nil || revoked {
+		t.Fatalf("certificate should not be revoked by a failed renewal (%v)", err)
+	}
+
+	renewedPEM, err := certs.UserClientRenewCredentialCSR("carol", "laptop", csrPEM, func(*gorm.DB, []byte) error { return nil })
+	if err != nil {
+		t.Fatalf("UserClientRenewCredentialCSR: %v", err)
+	}
+
+	if stored, _, err := certs.UserClientGetCredentialCertificate("carol", "laptop"); err != nil || !bytes.Equal(stored, renewedPEM) {
+		t.Fatalf("renewed certificate not saved (%v)", err)
+	}
+
+	if revoked, err := certs.IsRevoked(current.SerialNumber); err != nil || !revoked {
+		t.Fatalf("previous certificate should be revoked by the renewal (%v)", err)
+	}
+}
+
+// TestUserServerCertificateNames checks that the server certificate is issued for the
+// names given, and that VerifyCertificateHost only accepts it for one of them.
+func TestUserServerCertificateNames(t *testing.T) {
+	certs := newTestManager(t)
+
+	caPEM, _, err := certs.GetUsersCAPEM()
+	if err != nil {
+		t.Fatalf("GetUsersCAPEM: %v", err)
+	}
+
+	certPEM, _, err := certs.UserServerGenerateCertificate("team.example.com", "10.0.0.1", "team.example.com", "")
+	if err != nil {
+		t.Fatalf("UserServerGenerateCertificate: %v", err)
+	}
+
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatalf("ParseCertificatePEM: %v", err)
+	}
+
+	if len(cert.DNSNames) != 2 || len(cert.IPAddresses) != 1 {
+		t.Fatalf("unexpected server certificate names: %v %v", cert.DNSNames, cert.IPAddresses)
+	}
+
+	for _, host := range []string{userCertHostname, "team.example.com", "10.0.0.1"} {
+		if err := VerifyCertificateHost(string(caPEM), host, [][]byte{cert.Raw}); err != nil {
+			t.Fatalf("VerifyCertificateHost(%s): %v", host, err)
+		}
+	}
+
+	for _, host := range []string{"other.example.com", "10.0.0.2", ""} {
+		if err := VerifyCertificateHost(string(caPEM), host, [][]byte{cert.Raw}); err == nil {
+			t.Fatalf("VerifyCertificateHost should refuse host %q", host)
+		}
+	}
 }
@@ -0,0 +1,231 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/reeflective/team/internal/assets"
+)
+
+// -----------------------
+//  CA CHAIN
+// -----------------------
+
+// ErrExternalCA - Returned when rotating a CA issued by an external PKI, which must be imported instead.
+var ErrExternalCA = errors.New("the CA is issued by an external PKI")
+
+// chainCAFile holds the issuers of a CA issued by an external PKI, up to its root.
+const chainCAFile = "ca-chain"
+
+// ImportUsersCA replaces the users CA with a PEM-encoded CA certificate and its private key.
+// The CA certificate can be followed by its issuers, up to a self-signed root: the users CA
+// is then an intermediate of an external PKI, and certificates issued by the teamserver are
+// sent along this chain, verified up to the root. Any CA rotation in progress is retired.
+// An encrypted private key is decrypted with the passphrase, or with the one of the manager
+// if empty, and stored like the current one: encrypted with the manager passphrase, if any.
+func (c *Manager) ImportUsersCA(chainPEM, keyPEM, passphrase []byte) error {
+	return c.importCA(userCA, chainPEM, keyPEM, passphrase)
+}
+
+// UsersCAChain returns the issuers of the users CA, the last one being the root, if the users
+// CA is an intermediate of an external PKI (see Manager.ImportUsersCA). It returns no certificate
+// if the users CA is self-signed.
+func (c *Manager) UsersCAChain() ([]*x509.Certificate, error) {
+	return c.caChain(userCA)
+}
+
+// UsersCAChainPEM returns the PEM-encoded users CA certificate, followed by its issuers if any.
+func (c *Manager) UsersCAChainPEM() ([]byte, error) {
+	caPEM, _, err := c.getCAPEM(userCA)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := c.caChain(userCA)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(caPEM, encodeCertificates(chain)...), nil
+}
+
+// UsersCertificateChainPEM returns a PEM-encoded certificate issued by the users CA, followed
+// by the CA certificates needed to verify it up to the root: the users CA and its issuers but
+// the root, if the users CA is an intermediate. Otherwise the certificate is returned as is.
+func (c *Manager) UsersCertificateChainPEM(certPEM []byte) ([]byte, error) {
+	chain, err := c.caChain(userCA)
+	if err != nil || len(chain) == 0 {
+		return certPEM, err
+	}
+
+	caPEM, _, err := c.getCAPEM(userCA)
+	if err != nil {
+		return nil, err
+	}
+
+	chainPEM := append(bytes.Clone(certPEM), caPEM...)
+
+	return append(chainPEM, encodeCertificates(chain[:len(chain)-1])...), nil
+}
+
+// importCA checks and saves a CA certificate and key, and the chain of its issuers, if any.
+func (c *Manager) importCA(caType string, chainPEM, keyPEM, passphrase []byte) error {
+	// Otherwise the new key would be stored in cleartext.
+	if _, _, err := c.getCA(caType); errors.Is(err, ErrCAKeyLocked) {
+		return err
+	}
+
+	chain, err := ParseCertificatesPEM(chainPEM)
+	if err != nil {
+		return err
+	}
+
+	if len(passphrase) == 0 {
+		passphrase = c.passphrase
+	}
+
+	if keyPEM, err = DecryptPrivateKeyPEM(keyPEM, passphrase); err != nil {
+		return err
+	}
+
+	caCert, issuers := chain[0], chain[1:]
+
+	if !caCert.IsCA {
+		return fmt.Errorf("certificate '%s' is not a CA", caCert.Subject.CommonName)
+	}
+
+	key, err := parsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return err
+	}
+
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(caCert.PublicKey) {
+		return fmt.Errorf("private key does not match CA certificate '%s'", caCert.Subject.CommonName)
+	}
+
+	// Without issuers, the CA must be a root: there would be nothing to verify it with.
+	root := caCert
+	if len(issuers) > 0 {
+		root = issuers[len(issuers)-1]
+	}
+
+	if err := root.CheckSignatureFrom(root); err != nil {
+		return fmt.Errorf("chain must end with a self-signed root, '%s' is not: %w", root.Subject.CommonName, err)
+	}
+
+	if len(issuers) > 0 {
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+
+		intermediates := x509.NewCertPool()
+		for _, issuer := range issuers[:len(issuers)-1] {
+			intermediates.AddCert(issuer)
+		}
+
+		_, err := caCert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("CA certificate does not chain up to root '%s': %w", root.Subject.CommonName, err)
+		}
+	}
+
+	if err := c.retireCA(caType); err != nil {
+		return fmt.Errorf("failed to retire CA rotation: %w", err)
+	}
+
+	if err := c.saveCA(caType, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), keyPEM); err != nil {
+		return err
+	}
+
+	chainPath := c.caFilePath(caType, chainCAFile)
+
+	if len(issuers) == 0 {
+		err = c.fs.Remove(chainPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove CA chain: %w", err)
+		}
+	} else if err = c.fs.WriteFile(chainPath, encodeCertificates(issuers), assets.FileReadPerm); err != nil {
+		return fmt.Errorf("failed to save CA chain: %w", err)
+	}
+
+	c.log.Info(fmt.Sprintf("Imported certificate authority '%s' for '%s' (%d issuers)", caCert.Subject.CommonName, caType, len(issuers)))
+
+	return nil
+}
+
+// caChain returns the issuers of a CA, if any.
+func (c *Manager) caChain(caType string) ([]*x509.Certificate, error) {
+	chainPEM, err := c.fs.ReadFile(c.caFilePath(caType, chainCAFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	chain, err := ParseCertificatesPEM(chainPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA chain: %w", err)
+	}
+
+	return chain, nil
+}
+
+// ParseCertificatesPEM parses all certificates of a PEM bundle, in order.
+func ParseCertificatesPEM(certsPEM []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+
+	for block, rest := pem.Decode(certsPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate found in PEM data")
+	}
+
+	return chain, nil
+}
+
+func encodeCertificates(chain []*x509.Certificate) []byte {
+	var certsPEM []byte
+
+	for _, cert := range chain {
+		certsPEM = append(certsPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return certsPEM
+}
@@ -0,0 +1,162 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newTestPKI returns the PEM-encoded certificates of a root CA and of an intermediate
+// CA signed by it, and the PEM-encoded private key of the intermediate.
+func newTestPKI(t *testing.T) (rootPEM, intermediatePEM, keyPEM []byte) {
+	t.Helper()
+
+	newCA := func(name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		if err != nil {
+			t.Fatalf("CreateCertificate: %v", err)
+		}
+
+		cert, _ := x509.ParseCertificate(der)
+
+		return cert, key
+	}
+
+	root, rootKey := newCA("Org Root", nil, nil)
+	intermediate, key := newCA("Org Teamserver", root, rootKey)
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediate.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// TestImportUsersCAChain checks that an intermediate CA can be imported with its chain,
+// that the certificates it issues are verified up to the root with the chain sent along
+// them, and that invalid chains are refused.
+func TestImportUsersCAChain(t *testing.T) {
+	certs := newTestManager(t)
+	rootPEM, intermediatePEM, keyPEM := newTestPKI(t)
+
+	clientPEM, _, err := certs.GenerateECCCertificate(userCA, "leaf", false, true)
+	if err != nil {
+		t.Fatalf("GenerateECCCertificate: %v", err)
+	}
+	_, otherKeyPEM, err := certs.GenerateECCCertificate(userCA, "other", true, false)
+	if err != nil {
+		t.Fatalf("GenerateECCCertificate: %v", err)
+	}
+
+	invalid := map[string][2][]byte{
+		"no root":      {intermediatePEM, keyPEM},
+		"not a CA":     {append(clientPEM, rootPEM...), keyPEM},
+		"key mismatch": {append(intermediatePEM, rootPEM...), otherKeyPEM},
+		"no chain":     {rootPEM, keyPEM},
+	}
+
+	for name, pair := range invalid {
+		if err := certs.ImportUsersCA(pair[0], pair[1], nil); err == nil {
+			t.Errorf("invalid CA (%s) should be refused", name)
+		}
+	}
+
+	if err := certs.ImportUsersCA(append(intermediatePEM, rootPEM...), keyPEM, nil); err != nil {
+		t.Fatalf("ImportUsersCA: %v", err)
+	}
+
+	chain, err := certs.UsersCAChain()
+	if err != nil || len(chain) != 1 || chain[0].Subject.CommonName != "Org Root" {
+		t.Fatalf("users CA chain should contain the root, got %v (%v)", chain, err)
+	}
+
+	caChainPEM, err := certs.UsersCAChainPEM()
+	if err != nil {
+		t.Fatalf("UsersCAChainPEM: %v", err)
+	}
+
+	if caChain, _ := ParseCertificatesPEM(caChainPEM); len(caChain) != 2 || caChain[0].Subject.CommonName != "Org Teamserver" {
+		t.Fatalf("users CA chain PEM should start with the intermediate, got %d certificates", len(caChain))
+	}
+
+	leafPEM, _, err := certs.GenerateECCCertificate(userCA, "localhost", false, false)
+	if err != nil {
+		t.Fatalf("GenerateECCCertificate: %v", err)
+	}
+
+	leafChainPEM, err := certs.UsersCertificateChainPEM(leafPEM)
+	if err != nil {
+		t.Fatalf("UsersCertificateChainPEM: %v", err)
+	}
+
+	leafChain, _ := ParseCertificatesPEM(leafChainPEM)
+	if len(leafChain) != 2 {
+		t.Fatalf("certificate should be sent along the intermediate only, got %d certificates", len(leafChain))
+	}
+
+	if err := RootOnlyVerifyCertificate(string(rootPEM), [][]byte{leafChain[0].Raw}); err == nil {
+		t.Fatal("certificate should not verify against the root without the intermediate")
+	}
+
+	if err := RootOnlyVerifyCertificate(string(rootPEM), [][]byte{leafChain[0].Raw, leafChain[1].Raw}); err != nil {
+		t.Fatalf("certificate should verify up to the root: %v", err)
+	}
+
+	if err := certs.RotateUsersCA(time.Hour); !errors.Is(err, ErrExternalCA) {
+		t.Fatalf("rotating an intermediate should fail with ErrExternalCA, got %v", err)
+	}
+
+	// Saving a bare CA makes it a root again.
+	caPEM, caKeyPEM, err := certs.GenerateECCCertificate(userCA, "other", true, false)
+	if err != nil {
+		t.Fatalf("GenerateECCCertificate: %v", err)
+	}
+	if err := certs.SaveUsersCA(caPEM, caKeyPEM); err != nil {
+		t.Fatalf("SaveUsersCA: %v", err)
+	}
+
+	if chain, err := certs.UsersCAChain(); err != nil || len(chain) != 0 {
+		t.Fatalf("bare CA should have no chain, got %v (%v)", chain, err)
+	}
+}
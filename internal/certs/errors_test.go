@@ -0,0 +1,166 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/reeflective/team/internal/assets"
+	"github.com/reeflective/team/internal/db"
+)
+
+// errDiskFailure is returned by failingFs once it fails.
+var errDiskFailure = errors.New("injected disk failure")
+
+// failingFs is an in-memory filesystem whose writes fail after failing is set.
+type failingFs struct {
+	afero.Fs
+	failing bool
+}
+
+func (f *failingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if f.failing && flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+		return nil, errDiskFailure
+	}
+
+	return f.Fs.OpenFile(name, flag, perm)
+}
+
+func (f *failingFs) MkdirAll(path string, perm os.FileMode) error {
+	if f.failing {
+		return errDiskFailure
+	}
+
+	return f.Fs.MkdirAll(path, perm)
+}
+
+// TestManagerFilesystemErrors checks that filesystem failures are returned as errors by
+// the manager, from its creation to the certificates it issues, instead of exiting.
+func TestManagerFilesystemErrors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.NewClient(&db.Config{
+		Dialect:      db.Sqlite,
+		Database:     db.SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create in-memory database: %v", err)
+	}
+
+	disk := &failingFs{Fs: afero.NewMemMapFs(), failing: true}
+	fs := &assets.FS{Fs: disk}
+
+	if _, err := NewManager(fs, database, logger, "testapp", "/app", nil, nil, nil); !errors.Is(err, errDiskFailure) {
+		t.Fatalf("NewManager should fail to write the CA, got %v", err)
+	}
+
+	disk.failing = false
+
+	certs, err := NewManager(fs, database, logger, "testapp", "/app", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	disk.failing = true
+
+	if _, _, err := certs.UserClientGenerateCertificate("alice"); !errors.Is(err, errDiskFailure) {
+		t.Fatalf("UserClientGenerateCertificate should fail to write its key, got %v", err)
+	}
+
+	if _, _, err := certs.UserServerGenerateCertificate("localhost"); !errors.Is(err, errDiskFailure) {
+		t.Fatalf("UserServerGenerateCertificate should fail to write its key, got %v", err)
+	}
+
+	if err := certs.RotateUsersCA(time.Hour); !errors.Is(err, errDiskFailure) {
+		t.Fatalf("RotateUsersCA should fail to write the new CA, got %v", err)
+	}
+
+	// The manager keeps working once the disk is back.
+	disk.failing = false
+
+	if _, _, err := certs.UserClientGenerateCertificate("alice"); err != nil {
+		t.Fatalf("UserClientGenerateCertificate after recovery: %v", err)
+	}
+}
+
+// TestUserServerCertificateKept checks that the server certificate and its key are
+// kept when a new one cannot be saved, instead of being removed beforehand.
+func TestUserServerCertificateKept(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.NewClient(&db.Config{
+		Dialect:      db.Sqlite,
+		Database:     db.SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create in-memory database: %v", err)
+	}
+
+	disk := &failingFs{Fs: afero.NewMemMapFs()}
+
+	certs, err := NewManager(&assets.FS{Fs: disk}, database, logger, "testapp", "/app", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	certPEM, keyPEM, err := certs.UserServerGenerateCertificate("localhost")
+	if err != nil {
+		t.Fatalf("UserServerGenerateCertificate: %v", err)
+	}
+
+	disk.failing = true
+
+	if _, _, err := certs.UserServerGenerateCertificate("localhost"); !errors.Is(err, errDiskFailure) {
+		t.Fatalf("UserServerGenerateCertificate should fail to write its key, got %v", err)
+	}
+
+	disk.failing = false
+
+	current, currentKey, err := certs.UserServerGetCertificate()
+	if err != nil {
+		t.Fatalf("server certificate removed by a failed replacement: %v", err)
+	}
+
+	if string(current) != string(certPEM) || string(currentKey) != string(keyPEM) {
+		t.Fatal("server certificate or key changed by a failed replacement")
+	}
+
+	newCert, newKey, err := certs.UserServerGenerateCertificate("localhost")
+	if err != nil {
+		t.Fatalf("UserServerGenerateCertificate after recovery: %v", err)
+	}
+
+	current, currentKey, err = certs.UserServerGetCertificate()
+	if err != nil || string(current) != string(newCert) || string(currentKey) != string(newKey) {
+		t.Fatalf("server certificate not replaced: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// -----------------------
+//  INVENTORY
+// -----------------------
+
+// Certificate is a certificate issued by the manager, with the information it is stored with.
+type Certificate struct {
+	*x509.Certificate
+	CAType  string    // CA that issued the certificate (eg. "user").
+	Purpose string    // PurposeCA, PurposeServer or PurposeClient.
+	User    string    // User owning the certificate, for client certificates.
+	Label   string    // Credential of the user, empty for its default one.
+	Profile string    // JSON description of the profile it was issued with, if known.
+	Stored  time.Time // Time at which the certificate was stored.
+}
+
+// ListCertificates returns the users CA and all certificates it issued
+// that are stored by the manager (revoked ones are not), CA first.
+func (c *Manager) ListCertificates() ([]Certificate, error) {
+	caCert, _, err := c.GetUsersCA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users CA: %w", err)
+	}
+
+	inventory := []Certificate{{Certificate: caCert, CAType: userCA, Purpose: PurposeCA}}
+
+	certModels := []*db.Certificate{}
+	if err := c.db().Where(&db.Certificate{CAType: userCA}).Order("created_at").Find(&certModels).Error; err != nil {
+		return nil, err
+	}
+
+	for _, certModel := range certModels {
+		cert, err := ParseCertificatePEM([]byte(certModel.CertificatePEM))
+		if err != nil {
+			c.log.Warn(fmt.Sprintf("failed to parse certificate %s: %v", certModel.CommonName, err))
+			continue
+		}
+
+		entry := Certificate{
+			Certificate: cert,
+			CAType:      certModel.CAType,
+			Purpose:     PurposeServer,
+			Profile:     certModel.Profile,
+			Stored:      certModel.CreatedAt,
+		}
+
+		// Client certificates are stored as "client.<user>[.<label>]".
+		if namespace, name, found := strings.Cut(certModel.CommonName, "."); found && namespace == clientNamespace {
+			entry.Purpose = PurposeClient
+			entry.User, entry.Label, _ = strings.Cut(name, ".")
+		}
+
+		inventory = append(inventory, entry)
+	}
+
+	return inventory, nil
+}
@@ -0,0 +1,375 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// -----------------------
+//  ENCRYPTED PRIVATE KEYS
+// -----------------------
+
+var (
+	// ErrCAKeyLocked - Returned when using a CA whose private key is encrypted, without its passphrase.
+	ErrCAKeyLocked = errors.New("CA private key is encrypted: a passphrase is required")
+
+	// ErrPassphrase - Returned when decrypting a private key with an incorrect passphrase.
+	ErrPassphrase = errors.New("incorrect passphrase")
+
+	// ErrExportPassphrase - Returned when exporting a private key without a passphrase to encrypt it with.
+	ErrExportPassphrase = errors.New("a passphrase is required to export a private key")
+)
+
+// Encrypted keys are PKCS #8 EncryptedPrivateKeyInfo (RFC 5208), with the PBES2 scheme
+// (RFC 8018): AES-256-CBC, with a key derived from the passphrase by PBKDF2-HMAC-SHA256.
+// They can be read by other tools, like `openssl pkey -in key.pem`.
+const (
+	encryptedKeyType  = "ENCRYPTED PRIVATE KEY"
+	pbkdf2Iterations  = 600000
+	pbkdf2SaltLen     = 16
+	encryptionKeySize = 32
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// IsEncryptedPEM reports whether a PEM-encoded private key is encrypted with a passphrase.
+func IsEncryptedPEM(keyPEM []byte) bool {
+	block, _ := pem.Decode(keyPEM)
+	return block != nil && block.Type == encryptedKeyType
+}
+
+// EncryptPrivateKeyPEM encrypts a PEM-encoded private key with a passphrase,
+// returning it as a PEM-encoded PKCS #8 encrypted private key.
+func EncryptPrivateKeyPEM(keyPEM, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+
+	key, err := parsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, pbkdf2SaltLen)
+	iv := make([]byte, aes.BlockSize)
+
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, pbkdf2Iterations, encryptionKeySize, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	encrypted := append(der, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pbkdf2Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: encryptedKeyType, Bytes: info}), nil
+}
+
+// DecryptPrivateKeyPEM decrypts a PEM-encoded PKCS #8 encrypted private key with its passphrase,
+// returning it as a PEM-encoded PKCS #8 private key. Keys which are not encrypted are returned as is.
+// Keys encrypted by other tools are accepted if they use PBES2 with PBKDF2 (HMAC-SHA1 or SHA-256)
+// and AES-CBC, which are the defaults of OpenSSL.
+func DecryptPrivateKeyPEM(keyPEM, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse private key PEM")
+	}
+
+	if block.Type != encryptedKeyType {
+		return keyPEM, nil
+	}
+
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%w: private key is encrypted", ErrPassphrase)
+	}
+
+	info := encryptedPrivateKeyInfo{}
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted private key: %w", err)
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported private key encryption %s (PBES2 only)", info.Algorithm.Algorithm)
+	}
+
+	params := pbes2Params{}
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse PBES2 parameters: %w", err)
+	}
+
+	cipherBlock, iv, err := pbes2Cipher(params, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := info.EncryptedData
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted private key length")
+	}
+
+	der := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(cipherBlock, iv).CryptBlocks(der, encrypted)
+
+	// Bad padding or key encoding: most likely an incorrect passphrase.
+	padding := int(der[len(der)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(der[len(der)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrPassphrase
+	}
+
+	der = der[:len(der)-padding]
+
+	if _, err := x509.ParsePKCS8PrivateKey(der); err != nil {
+		return nil, ErrPassphrase
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// pbes2Cipher returns the AES cipher and IV of PBES2 parameters, for a passphrase.
+func pbes2Cipher(params pbes2Params, passphrase []byte) (cipher.Block, []byte, error) {
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, fmt.Errorf("unsupported key derivation function %s (PBKDF2 only)", params.KeyDerivationFunc.Algorithm)
+	}
+
+	kdf := pbkdf2Params{}
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse PBKDF2 parameters: %w", err)
+	}
+
+	var prf func() hash.Hash
+
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, nil, fmt.Errorf("unsupported PBKDF2 function %s", kdf.PRF.Algorithm)
+	}
+
+	var keySize int
+
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keySize = 16
+	case scheme.Equal(oidAES192CBC):
+		keySize = 24
+	case scheme.Equal(oidAES256CBC):
+		keySize = 32
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key cipher %s (AES-CBC only)", scheme)
+	}
+
+	if kdf.KeyLength != 0 && kdf.KeyLength != keySize {
+		return nil, nil, fmt.Errorf("invalid PBKDF2 key length %d", kdf.KeyLength)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, nil, errors.New("invalid AES-CBC parameters")
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, kdf.Salt, kdf.Iterations, keySize, prf))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return block, iv, nil
+}
+
+// decryptedKey is a CA private key decrypted from its stored (encrypted) PEM.
+type decryptedKey struct {
+	stored []byte
+	key    crypto.Signer
+}
+
+// UsersCALocked reports whether the private key of the users CA is encrypted, and
+// the manager has not been given its passphrase: see Manager.UnlockUsersCA().
+func (c *Manager) UsersCALocked() bool {
+	_, _, err := c.getCA(userCA)
+	return errors.Is(err, ErrCAKeyLocked)
+}
+
+// UnlockUsersCA decrypts the private key of the users CA with its passphrase,
+// which is used from now on to encrypt the CA private keys saved by the manager.
+func (c *Manager) UnlockUsersCA(passphrase []byte) error {
+	_, keyPEM, err := c.getCAPEM(userCA)
+	if err != nil {
+		return err
+	}
+
+	if _, err := DecryptPrivateKeyPEM(keyPEM, passphrase); err != nil {
+		return err
+	}
+
+	c.keysMutex.Lock()
+	c.passphrase = bytes.Clone(passphrase)
+	c.keysMutex.Unlock()
+
+	_, _, err = c.getCA(userCA)
+
+	return err
+}
+
+// SetUsersCAPassphrase encrypts the private key of the users CA with a new passphrase, also
+// used from now on for the CA private keys saved by the manager. With an empty passphrase,
+// the key is stored in cleartext. The users CA must not be locked.
+func (c *Manager) SetUsersCAPassphrase(passphrase []byte) error {
+	_, key, err := c.getCA(userCA)
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err := c.privateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+
+	certPEM, _, err := c.getCAPEM(userCA)
+	if err != nil {
+		return err
+	}
+
+	c.keysMutex.Lock()
+	previous := c.passphrase
+	c.passphrase = bytes.Clone(passphrase)
+	c.keysMutex.Unlock()
+
+	// The key is still stored with the previous passphrase if it cannot be saved.
+	if err := c.saveCA(userCA, certPEM, keyPEM); err != nil {
+		c.keysMutex.Lock()
+		c.passphrase = previous
+		c.keysMutex.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// ExportUsersCAKey returns the private key of the users CA, encrypted with a passphrase,
+// or with the one of the manager if empty. Without any, ErrExportPassphrase is returned:
+// the key is never exported in cleartext. The users CA must not be locked.
+func (c *Manager) ExportUsersCAKey(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		c.keysMutex.Lock()
+		passphrase = bytes.Clone(c.passphrase)
+		c.keysMutex.Unlock()
+	}
+
+	if len(passphrase) == 0 {
+		return nil, ErrExportPassphrase
+	}
+
+	_, key, err := c.getCA(userCA)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := c.privateKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncryptPrivateKeyPEM(keyPEM, passphrase)
+}
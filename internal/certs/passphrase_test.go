@@ -0,0 +1,127 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/reeflective/team/internal/assets"
+	"github.com/reeflective/team/internal/db"
+)
+
+// TestEncryptedCAKey checks that the users CA private key is stored encrypted with the
+// manager passphrase, that it is locked without it, and that it can be exported with
+// another passphrase and imported back.
+func TestEncryptedCAKey(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.NewClient(&db.Config{
+		Dialect:      db.Sqlite,
+		Database:     db.SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create in-memory database: %v", err)
+	}
+
+	fs := assets.NewFileSystem(true)
+	passphrase := []byte("correct horse battery staple")
+
+	certs, err := NewManager(fs, database, logger, "testapp", "/app", nil, passphrase, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	certPEM, keyPEM, err := certs.GetUsersCAPEM()
+	if err != nil {
+		t.Fatalf("GetUsersCAPEM: %v", err)
+	}
+
+	if !IsEncryptedPEM(keyPEM) {
+		t.Fatal("CA private key should be stored encrypted")
+	}
+
+	if _, err := DecryptPrivateKeyPEM(keyPEM, []byte("wrong")); !errors.Is(err, ErrPassphrase) {
+		t.Fatalf("decryption with a wrong passphrase should fail with ErrPassphrase, got %v", err)
+	}
+
+	// Without the passphrase, the CA is locked.
+	locked, err := NewManager(fs, database, logger, "testapp", "/app", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	if !locked.UsersCALocked() {
+		t.Fatal("CA should be locked without its passphrase")
+	}
+
+	if _, _, err := locked.UserClientGenerateCertificate("alice"); !errors.Is(err, ErrCAKeyLocked) {
+		t.Fatalf("issuing with a locked CA should fail with ErrCAKeyLocked, got %v", err)
+	}
+
+	if err := locked.UnlockUsersCA([]byte("wrong")); !errors.Is(err, ErrPassphrase) {
+		t.Fatalf("unlocking with a wrong passphrase should fail with ErrPassphrase, got %v", err)
+	}
+
+	if err := locked.UnlockUsersCA(passphrase); err != nil {
+		t.Fatalf("UnlockUsersCA: %v", err)
+	}
+
+	if _, _, err := locked.UserClientGenerateCertificate("alice"); err != nil {
+		t.Fatalf("issuing with an unlocked CA: %v", err)
+	}
+
+	// Exports are encrypted with their own passphrase, and imported with it.
+	exported, err := certs.ExportUsersCAKey([]byte("export"))
+	if err != nil {
+		t.Fatalf("ExportUsersCAKey: %v", err)
+	}
+
+	if _, err := DecryptPrivateKeyPEM(exported, passphrase); !errors.Is(err, ErrPassphrase) {
+		t.Fatal("exported key should be encrypted with the export passphrase")
+	}
+
+	other := newTestManager(t)
+
+	if err := other.ImportUsersCA(certPEM, exported, []byte("export")); err != nil {
+		t.Fatalf("ImportUsersCA: %v", err)
+	}
+
+	if _, importedKey, _ := other.GetUsersCAPEM(); IsEncryptedPEM(importedKey) {
+		t.Fatal("imported key should be stored like the others, in cleartext without passphrase")
+	}
+
+	if _, err := other.ExportUsersCAKey(nil); !errors.Is(err, ErrExportPassphrase) {
+		t.Fatalf("exporting without any passphrase should fail with ErrExportPassphrase, got %v", err)
+	}
+
+	// Removing the passphrase stores the key in cleartext.
+	if err := certs.SetUsersCAPassphrase(nil); err != nil {
+		t.Fatalf("SetUsersCAPassphrase: %v", err)
+	}
+
+	if _, keyPEM, _ := certs.GetUsersCAPEM(); IsEncryptedPEM(keyPEM) {
+		t.Fatal("CA private key should be stored in cleartext without passphrase")
+	}
+}
@@ -0,0 +1,361 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Key algorithms of certificate profiles.
+const (
+	KeyECDSA   = "ecdsa"
+	KeyRSA     = "rsa"
+	KeyEd25519 = "ed25519"
+)
+
+// Purposes of certificates, for which different profiles can be used.
+const (
+	PurposeCA     = "ca"     // Certificate authorities.
+	PurposeServer = "server" // Server authentication (listeners).
+	PurposeClient = "client" // Client authentication (users credentials).
+)
+
+// Default profile values.
+const (
+	defaultCurve    = "P-256"
+	defaultCACurve  = "P-384"
+	defaultRSABits  = 3072
+	minRSABits      = 2048
+	defaultBackdate = time.Hour
+	defaultValidFor = validForYears * daysInYear * hoursInDay * time.Hour
+)
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// Profile determines how the certificates of a given CA and purpose are issued:
+// their key algorithm and size, validity period and key usages. Zero fields, except
+// Backdate (zero: not backdated), are replaced with the defaults of the purpose (see
+// DefaultProfile), so that issuance never depends on random choices.
+type Profile struct {
+	Algorithm   string             // KeyECDSA, KeyRSA or KeyEd25519.
+	Curve       string             // ECDSA curve: P-256, P-384 or P-521.
+	RSABits     int                // RSA key size, in bits (2048 at least).
+	Validity    time.Duration      // Validity period, starting from NotBefore.
+	Backdate    time.Duration      // NotBefore is set this long before issuance (clock skews).
+	KeyUsage    []string           // Key usages (eg. "digitalSignature"), see KeyUsages().
+	ExtKeyUsage []string           // Extended key usages (eg. "clientAuth"), see ExtKeyUsages().
+	keyUsage    x509.KeyUsage      // Parsed from KeyUsage.
+	extKeyUsage []x509.ExtKeyUsage // Parsed from ExtKeyUsage.
+}
+
+// Profiles are certificate profiles, keyed by "<ca type>/<purpose>" for a single CA,
+// or by "<purpose>" for the certificates of all CAs with that purpose.
+type Profiles map[string]Profile
+
+// DefaultProfile returns the profile used for certificates of a given purpose,
+// unless configured otherwise: ECDSA keys (P-384 for authorities, P-256 for
+// others), valid for 3 years from an hour before their issuance.
+func DefaultProfile(purpose string) Profile {
+	profile := Profile{
+		Algorithm: KeyECDSA,
+		Curve:     defaultCurve,
+		Validity:  defaultValidFor,
+		Backdate:  defaultBackdate,
+	}
+
+	switch purpose {
+	case PurposeCA:
+		profile.Curve = defaultCACurve
+		profile.KeyUsage = []string{"certSign", "crlSign", "digitalSignature"}
+		profile.ExtKeyUsage = []string{"serverAuth", "clientAuth"}
+	case PurposeClient:
+		profile.KeyUsage = []string{"digitalSignature"}
+		profile.ExtKeyUsage = []string{"clientAuth"}
+	default:
+		profile.KeyUsage = []string{"digitalSignature"}
+		profile.ExtKeyUsage = []string{"serverAuth"}
+	}
+
+	return profile
+}
+
+// KeyUsages returns the names of the key usages that profiles accept.
+func KeyUsages() []string {
+	return sortedKeys(keyUsages)
+}
+
+// ExtKeyUsages returns the names of the extended key usages that profiles accept.
+func ExtKeyUsages() []string {
+	return sortedKeys(extKeyUsages)
+}
+
+// Validate checks a profile and fills its zero fields with
+// the defaults for the purpose, returning the complete profile.
+func (p Profile) Validate(purpose string) (Profile, error) {
+	defaults := DefaultProfile(purpose)
+
+	if p.Algorithm == "" {
+		p.Algorithm = defaults.Algorithm
+	}
+
+	switch p.Algorithm {
+	case KeyECDSA:
+		if p.Curve == "" {
+			p.Curve = defaults.Curve
+		}
+
+		if _, found := curves[p.Curve]; !found {
+			return p, fmt.Errorf("invalid ECDSA curve '%s' (P-256, P-384 or P-521)", p.Curve)
+		}
+
+		p.RSABits = 0
+	case KeyRSA:
+		if p.RSABits == 0 {
+			p.RSABits = defaultRSABits
+		}
+
+		if p.RSABits < minRSABits {
+			return p, fmt.Errorf("RSA keys must be %d bits at least (got %d)", minRSABits, p.RSABits)
+		}
+
+		p.Curve = ""
+	case KeyEd25519:
+		p.Curve, p.RSABits = "", 0
+	default:
+		return p, fmt.Errorf("invalid key algorithm '%s' (%s, %s or %s)", p.Algorithm, KeyECDSA, KeyRSA, KeyEd25519)
+	}
+
+	if p.Validity <= 0 {
+		p.Validity = defaults.Validity
+	}
+
+	if p.Backdate < 0 {
+		return p, fmt.Errorf("invalid negative backdate %s", p.Backdate)
+	}
+
+	if len(p.KeyUsage) == 0 {
+		p.KeyUsage = defaults.KeyUsage
+
+		// RSA keys can also be used to encipher TLS keys.
+		if p.Algorithm == KeyRSA {
+			p.KeyUsage = append(slices.Clone(p.KeyUsage), "keyEncipherment")
+		}
+	}
+
+	if len(p.ExtKeyUsage) == 0 {
+		p.ExtKeyUsage = defaults.ExtKeyUsage
+	}
+
+	p.keyUsage = 0
+
+	for _, name := range p.KeyUsage {
+		usage, found := keyUsages[name]
+		if !found {
+			return p, fmt.Errorf("invalid key usage '%s'", name)
+		}
+
+		p.keyUsage |= usage
+	}
+
+	// Authorities must be able to sign certificates.
+	if purpose == PurposeCA && p.keyUsage&x509.KeyUsageCertSign == 0 {
+		return p, fmt.Errorf("certificate authorities require the certSign key usage")
+	}
+
+	p.extKeyUsage = make([]x509.ExtKeyUsage, 0, len(p.ExtKeyUsage))
+
+	for _, name := range p.ExtKeyUsage {
+		usage, found := extKeyUsages[name]
+		if !found {
+			return p, fmt.Errorf("invalid extended key usage '%s'", name)
+		}
+
+		p.extKeyUsage = append(p.extKeyUsage, usage)
+	}
+
+	return p, nil
+}
+
+// String returns the JSON description of the profile, as recorded with certificates.
+func (p Profile) String() string {
+	record, _ := json.Marshal(struct {
+		Algorithm   string   `json:"algorithm"`
+		Curve       string   `json:"curve,omitempty"`
+		RSABits     int      `json:"rsa_bits,omitempty"`
+		Validity    string   `json:"validity"`
+		Backdate    string   `json:"backdate"`
+		KeyUsage    []string `json:"key_usage"`
+		ExtKeyUsage []string `json:"ext_key_usage"`
+	}{
+		Algorithm:   p.Algorithm,
+		Curve:       p.Curve,
+		RSABits:     p.RSABits,
+		Validity:    p.Validity.String(),
+		Backdate:    p.Backdate.String(),
+		KeyUsage:    p.KeyUsage,
+		ExtKeyUsage: p.ExtKeyUsage,
+	})
+
+	return string(record)
+}
+
+// generateKey generates a private key as specified by the profile.
+func (p Profile) generateKey() (crypto.Signer, error) {
+	switch p.Algorithm {
+	case KeyRSA:
+		return rsa.GenerateKey(rand.Reader, p.RSABits)
+	case KeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return ecdsa.GenerateKey(curves[p.Curve], rand.Reader)
+	}
+}
+
+// withPublicKey returns the profile with the key curve or size of a public key that
+// was not generated by us, checking that the key is of the profile algorithm (and,
+// for RSA keys, at least as large as the profile ones).
+func (p Profile) withPublicKey(pub crypto.PublicKey) (Profile, error) {
+	want := p
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		p.Algorithm, p.Curve, p.RSABits = KeyECDSA, key.Curve.Params().Name, 0
+
+		if _, found := curves[p.Curve]; !found {
+			return p, fmt.Errorf("unsupported ECDSA curve %s", p.Curve)
+		}
+	case *rsa.PublicKey:
+		p.Algorithm, p.Curve, p.RSABits = KeyRSA, "", key.N.BitLen()
+
+		if p.RSABits < minRSABits {
+			return p, fmt.Errorf("RSA keys must be %d bits at least (got %d)", minRSABits, p.RSABits)
+		}
+	case ed25519.PublicKey:
+		p.Algorithm, p.Curve, p.RSABits = KeyEd25519, "", 0
+	default:
+		return p, fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	if p.Algorithm != want.Algorithm {
+		return p, fmt.Errorf("%s key does not match the %s key algorithm of the profile", p.Algorithm, want.Algorithm)
+	}
+
+	if p.RSABits < want.RSABits {
+		return p, fmt.Errorf("RSA keys must be %d bits at least (got %d)", want.RSABits, p.RSABits)
+	}
+
+	return p, nil
+}
+
+// Validate checks all profiles, completing them with the defaults of their purpose.
+func (p Profiles) Validate() (Profiles, error) {
+	valid := make(Profiles, len(p))
+
+	for key, profile := range p {
+		purpose := key[strings.LastIndex(key, "/")+1:]
+
+		if purpose != PurposeCA && purpose != PurposeServer && purpose != PurposeClient {
+			return nil, fmt.Errorf("invalid certificate profile '%s': unknown purpose '%s'", key, purpose)
+		}
+
+		profile, err := profile.Validate(purpose)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate profile '%s': %w", key, err)
+		}
+
+		valid[key] = profile
+	}
+
+	return valid, nil
+}
+
+// get returns the profile of certificates issued by a CA for a purpose:
+// the one of this CA if any, or the one for all CAs, or the default one.
+func (p Profiles) get(caType, purpose string) Profile {
+	if profile, found := p[caType+"/"+purpose]; found {
+		return profile
+	}
+
+	if profile, found := p[purpose]; found {
+		return profile
+	}
+
+	profile, _ := DefaultProfile(purpose).Validate(purpose)
+
+	return profile
+}
+
+// profilePurpose returns the purpose of a certificate.
+func profilePurpose(isCA, isClient bool) string {
+	switch {
+	case isCA:
+		return PurposeCA
+	case isClient:
+		return PurposeClient
+	default:
+		return PurposeServer
+	}
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
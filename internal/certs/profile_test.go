@@ -0,0 +1,149 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// TestProfilesValidate checks that profiles are completed with the defaults
+// of their purpose, and that invalid ones are refused.
+func TestProfilesValidate(t *testing.T) {
+	profiles, err := Profiles{
+		"client":      {Algorithm: KeyRSA},
+		"user/server": {Curve: "P-521", Validity: time.Hour},
+	}.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	client := profiles["client"]
+	if client.RSABits != defaultRSABits || client.Validity != defaultValidFor || client.Backdate != 0 {
+		t.Fatalf("client profile not completed with defaults: %s", client)
+	}
+
+	if client.keyUsage != x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment {
+		t.Fatalf("RSA client profile should allow key encipherment: %v", client.KeyUsage)
+	}
+
+	server := profiles.get(userCA, PurposeServer)
+	if server.Algorithm != KeyECDSA || server.Curve != "P-521" || server.Validity != time.Hour {
+		t.Fatalf("CA-specific server profile not used: %s", server)
+	}
+
+	if ca := profiles.get(userCA, PurposeCA); ca.Curve != defaultCACurve {
+		t.Fatalf("default CA profile not used: %s", ca)
+	}
+
+	invalid := map[string]Profiles{
+		"purpose":   {"user/intermediate": {}},
+		"algorithm": {"client": {Algorithm: "dsa"}},
+		"curve":     {"client": {Curve: "P-224"}},
+		"rsa bits":  {"client": {Algorithm: KeyRSA, RSABits: 1024}},
+		"usage":     {"client": {KeyUsage: []string{"everything"}}},
+		"ca usage":  {"ca": {KeyUsage: []string{"digitalSignature"}}},
+	}
+
+	for name, profiles := range invalid {
+		if _, err := profiles.Validate(); err == nil {
+			t.Errorf("invalid %s should be refused", name)
+		}
+	}
+}
+
+// TestCertificateProfiles checks that certificates are issued as specified by the
+// profiles of their CA and purpose, and that the profile is recorded with them.
+func TestCertificateProfiles(t *testing.T) {
+	profiles, err := Profiles{
+		"ca":     {Validity: 10 * 365 * 24 * time.Hour},
+		"client": {Algorithm: KeyEd25519, Validity: 30 * 24 * time.Hour, Backdate: time.Minute},
+	}.Validate()
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	certs := newTestManagerWithProfiles(t, profiles)
+
+	caCert, caKey, err := certs.GetUsersCA()
+	if err != nil {
+		t.Fatalf("GetUsersCA: %v", err)
+	}
+
+	if key, isECDSA := caKey.(*ecdsa.PrivateKey); !isECDSA || key.Curve.Params().Name != defaultCACurve {
+		t.Fatalf("CA key should be the default %s ECDSA key, got %T", defaultCACurve, caKey)
+	}
+
+	if validity := caCert.NotAfter.Sub(caCert.NotBefore); validity != profiles["ca"].Validity {
+		t.Fatalf("CA validity should be %s, got %s", profiles["ca"].Validity, validity)
+	}
+
+	certPEM, _, err := certs.UserClientGenerateCredentialCertificate("alice", "laptop")
+	if err != nil {
+		t.Fatalf("UserClientGenerateCredentialCertificate: %v", err)
+	}
+
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatalf("ParseCertificatePEM: %v", err)
+	}
+
+	if _, isEd25519 := cert.PublicKey.(ed25519.PublicKey); !isEd25519 {
+		t.Fatalf("client key should be Ed25519, got %T", cert.PublicKey)
+	}
+
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != 30*24*time.Hour {
+		t.Fatalf("client validity should be 30 days, got %s", validity)
+	}
+
+	if backdate := time.Since(cert.NotBefore); backdate < time.Minute || backdate > 2*time.Minute {
+		t.Fatalf("client certificate should be backdated by a minute, got %s", backdate)
+	}
+
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature || !slices.Equal(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) {
+		t.Fatalf("unexpected client key usages: %v %v", cert.KeyUsage, cert.ExtKeyUsage)
+	}
+
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("client certificate not signed by the CA: %v", err)
+	}
+
+	// The Ed25519 key must be stored and read back.
+	if _, keyPEM, err := certs.UserClientGetCredentialCertificate("alice", "laptop"); err != nil {
+		t.Fatalf("UserClientGetCredentialCertificate: %v", err)
+	} else if _, err := parsePrivateKeyPEM(keyPEM); err != nil {
+		t.Fatalf("stored Ed25519 key: %v", err)
+	}
+
+	certModel := &db.Certificate{}
+	if err := certs.db().Where(&db.Certificate{CommonName: userClientCertName("alice", "laptop")}).First(certModel).Error; err != nil {
+		t.Fatalf("certificate lookup: %v", err)
+	}
+
+	if !strings.Contains(certModel.Profile, `"algorithm":"ed25519"`) || !strings.Contains(certModel.Profile, `"validity":"720h0m0s"`) {
+		t.Fatalf("profile not recorded with the certificate: %q", certModel.Profile)
+	}
+}
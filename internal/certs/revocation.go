@@ -0,0 +1,307 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// Revocation reasons, as defined in RFC 5280 (section 5.3.1).
+// The certificateHold (6) and removeFromCRL (8) reasons are not supported,
+// since teamserver revocations are permanent.
+const (
+	ReasonUnspecified          = 0
+	ReasonKeyCompromise        = 1
+	ReasonCACompromise         = 2
+	ReasonAffiliationChanged   = 3
+	ReasonSuperseded           = 4
+	ReasonCessationOfOperation = 5
+	ReasonPrivilegeWithdrawn   = 9
+
+	// crlValidFor is the validity period of generated CRLs (NextUpdate - ThisUpdate).
+	crlValidFor = 7 * hoursInDay * time.Hour
+)
+
+// ErrInvalidSerial - Returned when a certificate serial number cannot be parsed.
+var ErrInvalidSerial = errors.New("invalid certificate serial number")
+
+var reasonNames = map[int]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+}
+
+// ReasonName returns the RFC 5280 name of a revocation reason code.
+func ReasonName(reason int) string {
+	if name, found := reasonNames[reason]; found {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", reason)
+}
+
+// ParseReason returns the revocation reason code for its RFC 5280 name
+// (case-insensitive). An empty name is parsed as an unspecified reason.
+func ParseReason(name string) (int, error) {
+	if name == "" {
+		return ReasonUnspecified, nil
+	}
+
+	for code, reason := range reasonNames {
+		if strings.EqualFold(reason, name) {
+			return code, nil
+		}
+	}
+
+	return ReasonUnspecified, fmt.Errorf("Invalid revocation reason '%s'", name)
+}
+
+// ReasonNames returns the names of all supported revocation reasons.
+func ReasonNames() []string {
+	names := make([]string, 0, len(reasonNames))
+	for _, name := range reasonNames {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// FormatSerial returns the canonical (lowercase hexadecimal) form of a serial number.
+func FormatSerial(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+// ParseSerial parses a hexadecimal certificate serial number, optionally colon-separated.
+func ParseSerial(serial string) (*big.Int, error) {
+	hexSerial := strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(serial), "0x"), ":", "")
+
+	number, ok := new(big.Int).SetString(hexSerial, 16)
+	if !ok || hexSerial == "" {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidSerial, serial)
+	}
+
+	return number, nil
+}
+
+// RevokeCertificate - Revoke a certificate issued by the given CA.
+// Revoking an already revoked certificate is a no-op.
+func (c *Manager) RevokeCertificate(caType string, cert *x509.Certificate, reason int) error {
+	return c.revoke(c.db(), caType, FormatSerial(cert.SerialNumber), cert.Subject.CommonName, reason)
+}
+
+// RevokeSerial - Revoke a certificate by serial number, for when the certificate itself
+// is not available anymore (eg. deleted from the database, or issued by another server).
+func (c *Manager) RevokeSerial(caType string, serial string, reason int) error {
+	number, err := ParseSerial(serial)
+	if err != nil {
+		return err
+	}
+
+	return c.revoke(c.db(), caType, FormatSerial(number), "", reason)
+}
+
+// IsRevoked - Returns true if the certificate serial number has been revoked.
+func (c *Manager) IsRevoked(serial *big.Int) (bool, error) {
+	var count int64
+
+	err := c.db().Model(&db.RevokedCertificate{}).
+		Where(&db.RevokedCertificate{SerialNumber: FormatSerial(serial)}).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+// RevokedCertificates - Get all certificates revoked for the given CA, oldest first.
+func (c *Manager) RevokedCertificates(caType string) ([]*db.RevokedCertificate, error) {
+	revoked := []*db.RevokedCertificate{}
+
+	err := c.db().Where(&db.RevokedCertificate{CAType: caType}).
+		Order("revoked_at").
+		Find(&revoked).Error
+
+	return revoked, err
+}
+
+// UserClientRevokeCertificates - Revoke all client certificates issued to a user.
+func (c *Manager) UserClientRevokeCertificates(user string, reason int) error {
+	userCerts, err := c.userClientCertificates(user)
+	if err != nil {
+		return err
+	}
+
+	for _, certModel := range userCerts {
+		if err := c.revokeCertificatePEM(user, certModel, reason); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UserClientRevokeCredentialCertificate - Revoke the client certificate of a user credential.
+func (c *Manager) UserClientRevokeCredentialCertificate(user, label string, reason int) error {
+	certModel := &db.Certificate{}
+
+	result := c.db().Where(&db.Certificate{
+		CAType:     userCA,
+		KeyType:    ECCKey,
+		CommonName: userClientCertName(user, label),
+	}).Limit(1).Find(certModel)
+
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	return c.revokeCertificatePEM(user, certModel, reason)
+}
+
+// UserRevokeSerial - Revoke a user certificate by serial number.
+func (c *Manager) UserRevokeSerial(serial string, reason int) error {
+	return c.RevokeSerial(userCA, serial, reason)
+}
+
+// UserRevokedCertificates - Get all revoked user certificates, oldest first.
+func (c *Manager) UserRevokedCertificates() ([]*db.RevokedCertificate, error) {
+	return c.RevokedCertificates(userCA)
+}
+
+// GetUsersCRLPEM - Get a PEM-encoded X.509 certificate revocation list for the users CA,
+// signed by it and containing all revoked user certificates.
+func (c *Manager) GetUsersCRLPEM() ([]byte, error) {
+	return c.getCRLPEM(userCA)
+}
+
+func (c *Manager) getCRLPEM(caType string) ([]byte, error) {
+	caCert, caKey, err := c.getCA(caType)
+	if err != nil {
+		return nil, err
+	}
+
+	// CAs generated by older teamservers cannot sign revocation lists.
+	if caCert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		if caCert, err = c.reissueCA(caType, caCert, caKey); err != nil {
+			return nil, fmt.Errorf("the %s CA cannot sign revocation lists: %w", caType, err)
+		}
+	}
+
+	revoked, err := c.RevokedCertificates(caType)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+
+	for _, rev := range revoked {
+		serial, err := ParseSerial(rev.SerialNumber)
+		if err != nil {
+			c.log.Warn(err.Error())
+			continue
+		}
+
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: rev.RevokedAt.UTC(),
+			ReasonCode:     rev.Reason,
+		})
+	}
+
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidFor),
+		RevokedCertificateEntries: entries,
+	}
+
+	derBytes, err := x509.CreateRevocationList(rand.Reader, template, caCert, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: derBytes}), nil
+}
+
+func (c *Manager) revoke(database *gorm.DB, caType, serial, commonName string, reason int) error {
+	if _, valid := reasonNames[reason]; !valid {
+		return fmt.Errorf("Invalid revocation reason code %d", reason)
+	}
+
+	revoked := &db.RevokedCertificate{}
+	result := database.Where(&db.RevokedCertificate{SerialNumber: serial}).Limit(1).Find(revoked)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	c.log.Info(fmt.Sprintf("Revoking certificate cn = '%s', serial = %s (%s)", commonName, serial, ReasonName(reason)))
+
+	return database.Create(&db.RevokedCertificate{
+		SerialNumber: serial,
+		CommonName:   commonName,
+		CAType:       caType,
+		Reason:       reason,
+	}).Error
+}
+
+func (c *Manager) revokeCertificatePEM(user string, certModel *db.Certificate, reason int) error {
+	return c.revokeCertificateModel(c.db(), user, certModel, reason)
+}
+
+// revokeCertificateModel revokes a user certificate with the given database session (eg. a transaction).
+func (c *Manager) revokeCertificateModel(database *gorm.DB, user string, certModel *db.Certificate, reason int) error {
+	cert, err := ParseCertificatePEM([]byte(certModel.CertificatePEM))
+	if err != nil {
+		c.log.Warn(fmt.Sprintf("failed to parse certificate of user %s: %s", user, err))
+		return nil
+	}
+
+	return c.revoke(database, userCA, FormatSerial(cert.SerialNumber), cert.Subject.CommonName, reason)
+}
+
+// ParseCertificatePEM parses the first certificate of a PEM-encoded certificate.
+func ParseCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse certificate PEM")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
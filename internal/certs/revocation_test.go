@@ -0,0 +1,218 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// TestUserClientRevokeCertificates checks that revoking the certificates of a
+// user records their serials, and that revoking them again is a no-op.
+func TestUserClientRevokeCertificates(t *testing.T) {
+	certs := newTestManager(t)
+
+	certPEM, _, err := certs.UserClientGenerateCertificate("alice")
+	if err != nil {
+		t.Fatalf("UserClientGenerateCertificate: %v", err)
+	}
+
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	if revoked, err := certs.IsRevoked(cert.SerialNumber); err != nil || revoked {
+		t.Fatalf("fresh certificate should not be revoked (revoked=%v, err=%v)", revoked, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := certs.UserClientRevokeCertificates("alice", ReasonKeyCompromise); err != nil {
+			t.Fatalf("UserClientRevokeCertificates: %v", err)
+		}
+	}
+
+	if revoked, err := certs.IsRevoked(cert.SerialNumber); err != nil || !revoked {
+		t.Fatalf("certificate should be revoked (revoked=%v, err=%v)", revoked, err)
+	}
+
+	revoked, err := certs.UserRevokedCertificates()
+	if err != nil {
+		t.Fatalf("UserRevokedCertificates: %v", err)
+	}
+
+	if len(revoked) != 1 {
+		t.Fatalf("expected 1 revoked certificate, got %d", len(revoked))
+	}
+
+	if revoked[0].CommonName != "alice" || revoked[0].Reason != ReasonKeyCompromise {
+		t.Fatalf("unexpected revocation: %+v", revoked[0])
+	}
+}
+
+// TestUsersCRL checks that the exported CRL is a PEM-encoded X.509 CRL signed
+// by the users CA, and listing all revoked serial numbers with their reasons.
+func TestUsersCRL(t *testing.T) {
+	certs := newTestManager(t)
+
+	certPEM, _, err := certs.UserClientGenerateCertificate("bob")
+	if err != nil {
+		t.Fatalf("UserClientGenerateCertificate: %v", err)
+	}
+
+	cert, _ := ParseCertificatePEM(certPEM)
+
+	if err := certs.UserClientRevokeCertificates("bob", ReasonSuperseded); err != nil {
+		t.Fatalf("UserClientRevokeCertificates: %v", err)
+	}
+
+	if err := certs.UserRevokeSerial("0A:1B:2C", ReasonUnspecified); err != nil {
+		t.Fatalf("UserRevokeSerial: %v", err)
+	}
+
+	crlPEM, err := certs.GetUsersCRLPEM()
+	if err != nil {
+		t.Fatalf("GetUsersCRLPEM: %v", err)
+	}
+
+	block, _ := pem.Decode(crlPEM)
+	if block == nil || block.Type != "X509 CRL" {
+		t.Fatal("CRL is not a PEM-encoded X509 CRL")
+	}
+
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseRevocationList: %v", err)
+	}
+
+	ca, _, err := certs.GetUsersCA()
+	if err != nil {
+		t.Fatalf("GetUsersCA: %v", err)
+	}
+
+	if err := crl.CheckSignatureFrom(ca); err != nil {
+		t.Fatalf("CRL is not signed by the users CA: %v", err)
+	}
+
+	if len(crl.RevokedCertificateEntries) != 2 {
+		t.Fatalf("expected 2 CRL entries, got %d", len(crl.RevokedCertificateEntries))
+	}
+
+	entry := crl.RevokedCertificateEntries[0]
+	if entry.SerialNumber.Cmp(cert.SerialNumber) != 0 || entry.ReasonCode != ReasonSuperseded {
+		t.Fatalf("unexpected first CRL entry: serial %s, reason %d", entry.SerialNumber, entry.ReasonCode)
+	}
+
+	if serial := FormatSerial(crl.RevokedCertificateEntries[1].SerialNumber); serial != "a1b2c" {
+		t.Fatalf("unexpected second CRL entry serial: %s", serial)
+	}
+}
+
+// TestParseRevocationInputs pins the parsing of user-provided serials and reasons.
+func TestParseRevocationInputs(t *testing.T) {
+	for _, serial := range []string{"", "xyz", "0x"} {
+		if _, err := ParseSerial(serial); !errors.Is(err, ErrInvalidSerial) {
+			t.Errorf("ParseSerial(%q) should fail with ErrInvalidSerial, got %v", serial, err)
+		}
+	}
+
+	if reason, err := ParseReason("KEYCOMPROMISE"); err != nil || reason != ReasonKeyCompromise {
+		t.Errorf("ParseReason should be case-insensitive, got %d (%v)", reason, err)
+	}
+
+	if _, err := ParseReason("certificateHold"); err == nil {
+		t.Error("certificateHold should not be a supported reason")
+	}
+}
+
+// TestUsersCRLLegacyCA checks that a users CA created without the cRLSign key usage is
+// reissued with it when exporting the CRL, without invalidating the certificates it issued.
+func TestUsersCRLLegacyCA(t *testing.T) {
+	certs := newTestManager(t)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "legacy"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, _ := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	err := certs.SaveUsersCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatalf("SaveUsersCA: %v", err)
+	}
+
+	legacy, _, _ := certs.GetUsersCA()
+
+	certPEM, _, err := certs.UserClientGenerateCertificate("bob")
+	if err != nil {
+		t.Fatalf("UserClientGenerateCertificate: %v", err)
+	}
+
+	crlPEM, err := certs.GetUsersCRLPEM()
+	if err != nil {
+		t.Fatalf("GetUsersCRLPEM: %v", err)
+	}
+
+	ca, _, err := certs.GetUsersCA()
+	if err != nil {
+		t.Fatalf("GetUsersCA: %v", err)
+	}
+
+	if ca.KeyUsage&x509.KeyUsageCRLSign == 0 || !bytes.Equal(ca.RawSubject, legacy.RawSubject) ||
+		!bytes.Equal(ca.RawSubjectPublicKeyInfo, legacy.RawSubjectPublicKeyInfo) {
+		t.Fatal("legacy CA should be reissued with the cRLSign key usage, for the same subject and key")
+	}
+
+	block, _ := pem.Decode(crlPEM)
+
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil || crl.CheckSignatureFrom(ca) != nil {
+		t.Fatalf("CRL is not signed by the reissued users CA (%v)", err)
+	}
+
+	// Certificates issued before, and teamclients trusting the former CA, are not affected.
+	cert, _ := ParseCertificatePEM(certPEM)
+
+	for _, root := range []*x509.Certificate{ca, legacy} {
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+			t.Fatalf("certificate issued by the legacy CA should remain valid: %v", err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/reeflective/team/internal/assets"
+)
+
+// -----------------------
+//  CA ROTATION
+// -----------------------
+
+// ErrRotationInProgress - Returned when rotating a CA whose previous rotation window is not over.
+var ErrRotationInProgress = errors.New("a CA rotation is already in progress")
+
+// Files of a CA rotation in progress, next to the CA certificate and key files.
+const (
+	previousCAFile = "ca-previous-cert" // Certificate of the previous CA, still trusted.
+	crossCAFile    = "ca-cross-cert"    // Certificate of the new CA, signed by the previous one.
+)
+
+// RotateUsersCA replaces the users CA with a new one, and issues a certificate of the new CA
+// signed by the previous one (cross-signed), valid for the rotation window: see RotateCA.
+func (c *Manager) RotateUsersCA(window time.Duration) error {
+	return c.rotateCA(userCA, window)
+}
+
+// UsersCARotation returns the previous users CA and the cross-signed certificate of the
+// current one, if a rotation is in progress (nil certificates otherwise). The rotation
+// window ends with the cross-signed certificate: see Manager.RetireUsersCA().
+func (c *Manager) UsersCARotation() (previous, cross *x509.Certificate, err error) {
+	return c.caRotation(userCA)
+}
+
+// RetireUsersCA ends a users CA rotation in progress, if any: the previous CA and the
+// cross-signed certificate of the current one are removed, and should not be trusted.
+func (c *Manager) RetireUsersCA() error {
+	return c.retireCA(userCA)
+}
+
+// rotateCA generates a new CA for a given type, with the same common name as the current one,
+// which is kept as the previous CA. The new CA certificate is also signed by the previous CA
+// (cross-signed), until the end of the window, so that peers trusting only the previous CA
+// can still authenticate certificates issued by the new one, if sent along them.
+func (c *Manager) rotateCA(caType string, window time.Duration) error {
+	if window <= 0 {
+		return fmt.Errorf("invalid CA rotation window %s", window)
+	}
+
+	// Intermediates are renewed by their PKI, and imported again.
+	if chain, err := c.caChain(caType); err != nil {
+		return err
+	} else if len(chain) > 0 {
+		return fmt.Errorf("%w: import a new CA instead", ErrExternalCA)
+	}
+
+	if _, cross, err := c.caRotation(caType); err != nil {
+		return err
+	} else if cross != nil {
+		return fmt.Errorf("%w (until %s)", ErrRotationInProgress, cross.NotAfter.Format(time.RFC1123))
+	}
+
+	previousCert, previousKey, err := c.getCA(caType)
+	if err != nil {
+		return fmt.Errorf("failed to load current CA: %w", err)
+	}
+
+	c.log.Info(fmt.Sprintf("Rotating certificate authority for '%s'", caType))
+
+	certPEM, keyPEM, _, err := c.GenerateCertificate(caType, previousCert.Subject.CommonName, true, false)
+	if err != nil {
+		return fmt.Errorf("failed to generate new CA: %w", err)
+	}
+
+	cert, err := ParseCertificatePEM(certPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse new CA: %w", err)
+	}
+
+	// The cross-signed certificate cannot outlive the previous CA.
+	notAfter := time.Now().Add(window)
+	if notAfter.After(previousCert.NotAfter) {
+		notAfter = previousCert.NotAfter
+	}
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), serialNumberLen)
+	serialNumber, _ := rand.Int(rand.Reader, serialNumberLimit)
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               cert.Subject,
+		SubjectKeyId:          cert.SubjectKeyId,
+		NotBefore:             cert.NotBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              cert.KeyUsage,
+		ExtKeyUsage:           cert.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	crossDER, err := x509.CreateCertificate(rand.Reader, template, previousCert, cert.PublicKey, previousKey)
+	if err != nil {
+		return fmt.Errorf("failed to cross-sign new CA: %w", err)
+	}
+
+	previousPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: previousCert.Raw})
+	crossPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crossDER})
+
+	if err := c.fs.WriteFile(c.caFilePath(caType, previousCAFile), previousPEM, assets.FileReadPerm); err != nil {
+		return fmt.Errorf("failed to save previous CA: %w", err)
+	}
+
+	if err := c.fs.WriteFile(c.caFilePath(caType, crossCAFile), crossPEM, assets.FileReadPerm); err != nil {
+		return fmt.Errorf("failed to save cross-signed CA: %w", err)
+	}
+
+	return c.saveCA(caType, certPEM, keyPEM)
+}
+
+// caRotation returns the previous CA and the cross-signed current one, if any.
+func (c *Manager) caRotation(caType string) (previous, cross *x509.Certificate, err error) {
+	previousPEM, err := c.fs.ReadFile(c.caFilePath(caType, previousCAFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	crossPEM, err := c.fs.ReadFile(c.caFilePath(caType, crossCAFile))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if previous, err = ParseCertificatePEM(previousPEM); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse previous CA: %w", err)
+	}
+
+	if cross, err = ParseCertificatePEM(crossPEM); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse cross-signed CA: %w", err)
+	}
+
+	return previous, cross, nil
+}
+
+// retireCA removes the previous CA and the cross-signed current one, if any.
+func (c *Manager) retireCA(caType string) error {
+	for _, file := range []string{crossCAFile, previousCAFile} {
+		err := c.fs.Remove(c.caFilePath(caType, file))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	c.log.Info(fmt.Sprintf("Retired previous certificate authority for '%s'", caType))
+
+	return nil
+}
+
+// caFilePath returns the path of a file stored along the certificate and key of a CA.
+func (c *Manager) caFilePath(caType, file string) string {
+	return filepath.Join(c.getCertDir(), fmt.Sprintf("%s_%s-%s.%s", c.appName, filepath.Base(caType), file, certFileExt))
+}
@@ -0,0 +1,104 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRotateUsersCA checks that a rotated users CA issues certificates that peers
+// trusting only the previous CA can verify with the cross-signed certificate, until
+// the rotation is retired, and that only one rotation can be in progress at a time.
+func TestRotateUsersCA(t *testing.T) {
+	certs := newTestManager(t)
+
+	previousPEM, _, err := certs.GetUsersCAPEM()
+	if err != nil {
+		t.Fatalf("GetUsersCAPEM: %v", err)
+	}
+
+	if _, cross, err := certs.UsersCARotation(); err != nil || cross != nil {
+		t.Fatalf("no rotation should be in progress, got %v (%v)", cross, err)
+	}
+
+	if err := certs.RotateUsersCA(time.Hour); err != nil {
+		t.Fatalf("RotateUsersCA: %v", err)
+	}
+
+	previous, cross, err := certs.UsersCARotation()
+	if err != nil || cross == nil {
+		t.Fatalf("rotation should be in progress, got %v", err)
+	}
+
+	if !pemEqual(previousPEM, previous.Raw) {
+		t.Fatal("previous CA not kept during the rotation")
+	}
+
+	current, _, err := certs.GetUsersCA()
+	if err != nil {
+		t.Fatalf("GetUsersCA: %v", err)
+	}
+
+	if current.Equal(previous) || string(current.RawSubjectPublicKeyInfo) != string(cross.RawSubjectPublicKeyInfo) {
+		t.Fatal("cross-signed certificate should be the one of the new CA")
+	}
+
+	if validity := time.Until(cross.NotAfter); validity > time.Hour || validity < 59*time.Minute {
+		t.Fatalf("cross-signed certificate should end with the window, got %s", validity)
+	}
+
+	leafPEM, _, err := certs.GenerateECCCertificate(userCA, "localhost", false, false)
+	if err != nil {
+		t.Fatalf("GenerateECCCertificate: %v", err)
+	}
+	leaf, _ := pem.Decode(leafPEM)
+
+	if err := RootOnlyVerifyCertificate(string(previousPEM), [][]byte{leaf.Bytes}); err == nil {
+		t.Fatal("new CA certificate should not verify against the previous CA alone")
+	}
+
+	if err := RootOnlyVerifyCertificate(string(previousPEM), [][]byte{leaf.Bytes, cross.Raw}); err != nil {
+		t.Fatalf("new CA certificate should verify through the cross-signed CA: %v", err)
+	}
+
+	if err := certs.RotateUsersCA(time.Hour); !errors.Is(err, ErrRotationInProgress) {
+		t.Fatalf("second rotation should be refused, got %v", err)
+	}
+
+	if err := certs.RetireUsersCA(); err != nil {
+		t.Fatalf("RetireUsersCA: %v", err)
+	}
+
+	if _, cross, err := certs.UsersCARotation(); err != nil || cross != nil {
+		t.Fatalf("rotation should be retired, got %v (%v)", cross, err)
+	}
+
+	if err := certs.RotateUsersCA(time.Hour); err != nil {
+		t.Fatalf("rotation after retirement: %v", err)
+	}
+}
+
+func pemEqual(certPEM, der []byte) bool {
+	block, _ := pem.Decode(certPEM)
+
+	return block != nil && string(block.Bytes) == string(der)
+}
@@ -0,0 +1,450 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"gorm.io/gorm"
+
+	"github.com/reeflective/team/internal/assets"
+	"github.com/reeflective/team/internal/db"
+)
+
+// ---------------
+//  SECRET STORES
+// ---------------
+
+// ErrSecretNotFound - Returned by secret stores when getting a secret they don't have.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretStore stores the private material of the certificate infrastructure: the private
+// keys of the certificate authorities and of the certificates they issued. Secrets are
+// identified by slash-separated names (eg. "ca/users.key"), which are valid io/fs paths.
+// Stores must be safe for concurrent use.
+type SecretStore interface {
+	// Get returns a secret, or ErrSecretNotFound.
+	Get(name string) ([]byte, error)
+	// Put creates or replaces a secret.
+	Put(name string, secret []byte) error
+	// Delete removes a secret, if it exists.
+	Delete(name string) error
+	// List returns the names of all secrets, sorted.
+	List() ([]string, error)
+}
+
+// MigrateSecrets moves all secrets of a store into another one: they are all copied
+// before being removed from the source, which is left untouched if any copy fails.
+// The copied function, if not nil, is called once all are copied and before any is
+// removed (eg. to record the store in use from now on): if it fails, the copies are
+// removed instead. Returns the number of secrets moved.
+func MigrateSecrets(from, to SecretStore, copied func() error) (int, error) {
+	names, err := from.List()
+	if err != nil {
+		return 0, err
+	}
+
+	removeCopies := func(names []string) {
+		for _, name := range names {
+			_ = to.Delete(name)
+		}
+	}
+
+	for i, name := range names {
+		secret, err := from.Get(name)
+		if err != nil {
+			removeCopies(names[:i])
+			return 0, fmt.Errorf("failed to read secret %s: %w", name, err)
+		}
+
+		if err := to.Put(name, secret); err != nil {
+			removeCopies(names[:i+1])
+			return 0, fmt.Errorf("failed to write secret %s: %w", name, err)
+		}
+	}
+
+	if copied != nil {
+		if err := copied(); err != nil {
+			removeCopies(names)
+			return 0, err
+		}
+	}
+
+	for _, name := range names {
+		if err := from.Delete(name); err != nil {
+			return len(names), fmt.Errorf("failed to remove moved secret %s: %w", name, err)
+		}
+	}
+
+	return len(names), nil
+}
+
+// SecretStore returns the store of the private keys of the manager.
+func (c *Manager) SecretStore() SecretStore {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+
+	return c.secrets
+}
+
+// MigrateSecrets moves all private keys of the manager to another secret store, which the
+// manager uses from now on. The copied function is called as with the MigrateSecrets function:
+// the manager keeps its store if it fails. Returns the number of secrets moved.
+func (c *Manager) MigrateSecrets(to SecretStore, copied func() error) (int, error) {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+
+	moved, err := MigrateSecrets(c.secrets, to, copied)
+	if err != nil && moved == 0 {
+		return 0, err
+	}
+
+	// Secrets which could not be removed from the previous store are in the new one anyway.
+	c.secrets = to
+	c.log.Info(fmt.Sprintf("Moved %d private keys to the new secret store", moved))
+
+	return moved, err
+}
+
+// moveLegacySecrets moves into the secret store the private keys stored by older versions:
+// CA keys in files next to their certificates, and certificate keys in the database.
+func (c *Manager) moveLegacySecrets() error {
+	keyPath := c.caFilePath(userCA, "ca-key")
+
+	if keyPEM, err := c.fs.ReadFile(keyPath); err == nil {
+		c.log.Info(fmt.Sprintf("Moving private key %s to the secret store", keyPath))
+
+		if err := c.secrets.Put(caKeySecret(userCA), keyPEM); err != nil {
+			return err
+		}
+
+		if err := c.fs.Remove(keyPath); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, iofs.ErrNotExist) {
+		return err
+	}
+
+	legacy := []*db.Certificate{}
+	if err := c.db().Where("private_key_pem <> ?", "").Find(&legacy).Error; err != nil {
+		return err
+	}
+
+	for _, certModel := range legacy {
+		name := certificateKeySecret(certModel.CAType, certModel.KeyType, certModel.CommonName)
+		if err := c.secrets.Put(name, []byte(certModel.PrivateKeyPEM)); err != nil {
+			return err
+		}
+
+		err := c.db().Model(certModel).Update("private_key_pem", "").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(legacy) > 0 {
+		c.log.Info(fmt.Sprintf("Moved %d certificate private keys to the secret store", len(legacy)))
+	}
+
+	return nil
+}
+
+// caKeySecret returns the name of the private key of a CA in the secret store.
+func caKeySecret(caType string) string {
+	return path.Join("ca", url.PathEscape(caType)+".key")
+}
+
+// certificateKeySecret returns the name of the private key of a certificate in the secret store.
+func certificateKeySecret(caType, keyType, commonName string) string {
+	return path.Join("certificates", url.PathEscape(caType), url.PathEscape(keyType), url.PathEscape(commonName)+".key")
+}
+
+// -----------------
+//  FILESYSTEM STORE
+// -----------------
+
+type fileSecretStore struct {
+	fs  *assets.FS
+	dir string
+}
+
+// NewFileSecretStore returns a secret store keeping each secret in a file of a directory,
+// as is: keys are only protected by the permissions of their files, or by their own
+// encryption (like CA keys stored with a passphrase).
+func NewFileSecretStore(filesystem *assets.FS, dir string) SecretStore {
+	return &fileSecretStore{fs: filesystem, dir: dir}
+}
+
+func (s *fileSecretStore) Get(name string) ([]byte, error) {
+	filePath, err := secretPath(s.dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.fs.ReadFile(filePath)
+	if errors.Is(err, iofs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	return data, err
+}
+
+func (s *fileSecretStore) Put(name string, secret []byte) error {
+	filePath, err := secretPath(s.dir, name)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.MkdirAll(filepath.Dir(filePath), assets.DirPerm); err != nil {
+		return err
+	}
+
+	return s.fs.WriteFile(filePath, secret, assets.FileReadPerm)
+}
+
+func (s *fileSecretStore) Delete(name string) error {
+	filePath, err := secretPath(s.dir, name)
+	if err != nil {
+		return err
+	}
+
+	if err := s.fs.Remove(filePath); err != nil && !errors.Is(err, iofs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileSecretStore) List() ([]string, error) {
+	return listSecretFiles(s.fs, s.dir)
+}
+
+// secretPath returns the path of the file of a secret in a directory.
+func secretPath(dir, name string) (string, error) {
+	if !iofs.ValidPath(name) || name == "." || strings.HasPrefix(path.Base(name), ".") {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// listSecretFiles returns the names of the secret files of a directory, ignoring
+// hidden files (the metadata of stores), sorted.
+func listSecretFiles(filesystem *assets.FS, dir string) ([]string, error) {
+	names := []string{}
+
+	err := filesystem.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if errors.Is(err, iofs.ErrNotExist) && filePath == dir {
+			return nil // No secrets stored yet.
+		} else if err != nil {
+			return err
+		}
+
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		names = append(names, filepath.ToSlash(rel))
+
+		return nil
+	})
+
+	sort.Strings(names)
+
+	return names, err
+}
+
+// ---------------
+//  DATABASE STORE
+// ---------------
+
+type databaseSecretStore struct {
+	database *gorm.DB
+}
+
+// NewDatabaseSecretStore returns a secret store keeping secrets in a table of the
+// teamserver database, along with the certificates: they are as protected as it is
+// (eg. encrypted at rest with the SQLite database key).
+func NewDatabaseSecretStore(database *gorm.DB) SecretStore {
+	return &databaseSecretStore{database: database}
+}
+
+func (s *databaseSecretStore) Get(name string) ([]byte, error) {
+	secret := &db.Secret{}
+
+	err := s.database.Where(&db.Secret{Name: name}).First(secret).Error
+	if errors.Is(err, db.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	return secret.Data, err
+}
+
+func (s *databaseSecretStore) Put(name string, secret []byte) error {
+	if name == "" {
+		return errors.New("invalid empty secret name")
+	}
+
+	return s.database.Save(&db.Secret{Name: name, Data: secret}).Error
+}
+
+func (s *databaseSecretStore) Delete(name string) error {
+	return s.database.Where(&db.Secret{Name: name}).Delete(&db.Secret{}).Error
+}
+
+func (s *databaseSecretStore) List() ([]string, error) {
+	names := []string{}
+	err := s.database.Model(&db.Secret{}).Order("name").Pluck("name", &names).Error
+
+	return names, err
+}
+
+// ---------------------
+//  ENCRYPTED FILE STORE
+// ---------------------
+
+// Secrets of encrypted file stores are encrypted with AES-256-GCM, authenticating their
+// name, with a key derived from the passphrase of the store by PBKDF2-HMAC-SHA256. The
+// salt of the store is kept in its metadata file, along with a value checking the key.
+const storeMetadataFile = ".store"
+
+type encryptedFileSecretStore struct {
+	fileSecretStore
+	aead cipher.AEAD
+}
+
+// NewEncryptedFileSecretStore returns a secret store keeping each secret in a file of a
+// directory, encrypted with a key derived from a passphrase. The store is created if
+// needed, and opening an existing store with another passphrase fails with ErrPassphrase.
+func NewEncryptedFileSecretStore(filesystem *assets.FS, dir string, passphrase []byte) (SecretStore, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("an encrypted secret store requires a passphrase")
+	}
+
+	if err := filesystem.MkdirAll(dir, assets.DirPerm); err != nil {
+		return nil, err
+	}
+
+	metadataPath := filepath.Join(dir, storeMetadataFile)
+
+	metadata, err := filesystem.ReadFile(metadataPath)
+	if errors.Is(err, iofs.ErrNotExist) {
+		metadata = make([]byte, pbkdf2SaltLen)
+		_, err = rand.Read(metadata)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metadata) < pbkdf2SaltLen {
+		return nil, fmt.Errorf("invalid secret store metadata in %s", metadataPath)
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, metadata[:pbkdf2SaltLen], pbkdf2Iterations, encryptionKeySize, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &encryptedFileSecretStore{
+		fileSecretStore: fileSecretStore{fs: filesystem, dir: dir},
+		aead:            aead,
+	}
+
+	// A new store saves its salt, with an empty secret checking the key of the passphrase.
+	if len(metadata) == pbkdf2SaltLen {
+		check, err := store.seal(storeMetadataFile, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return store, filesystem.WriteFile(metadataPath, append(metadata, check...), assets.FileReadPerm)
+	}
+
+	if _, err := store.open(storeMetadataFile, metadata[pbkdf2SaltLen:]); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *encryptedFileSecretStore) Get(name string) ([]byte, error) {
+	sealed, err := s.fileSecretStore.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.open(name, sealed)
+}
+
+func (s *encryptedFileSecretStore) Put(name string, secret []byte) error {
+	sealed, err := s.seal(name, secret)
+	if err != nil {
+		return err
+	}
+
+	return s.fileSecretStore.Put(name, sealed)
+}
+
+func (s *encryptedFileSecretStore) seal(name string, secret []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, secret, []byte(name)), nil
+}
+
+func (s *encryptedFileSecretStore) open(name string, sealed []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted secret %s", name)
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+
+	secret, err := s.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot decrypt secret %s", ErrPassphrase, name)
+	}
+
+	return secret, nil
+}
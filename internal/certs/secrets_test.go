@@ -0,0 +1,216 @@
+package certs
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/reeflective/team/internal/assets"
+	"github.com/reeflective/team/internal/db"
+)
+
+// TestSecretStores checks that the built-in secret stores keep, list and delete secrets,
+// that encrypted stores are opened with their passphrase only, and that the private keys
+// of a manager can be moved from one store to another.
+func TestSecretStores(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.NewClient(&db.Config{
+		Dialect:      db.Sqlite,
+		Database:     db.SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create in-memory database: %v", err)
+	}
+
+	fs := assets.NewFileSystem(true)
+
+	encrypted, err := NewEncryptedFileSecretStore(fs, "/app/secrets.enc", []byte("secret"))
+	if err != nil {
+		t.Fatalf("NewEncryptedFileSecretStore: %v", err)
+	}
+
+	stores := map[string]SecretStore{
+		"filesystem":     NewFileSecretStore(fs, "/app/secrets"),
+		"database":       NewDatabaseSecretStore(database),
+		"encrypted-file": encrypted,
+	}
+
+	for name, store := range stores {
+		if err := store.Put("certificates/user/ecc/client.alice.key", []byte("key")); err != nil {
+			t.Fatalf("%s: Put: %v", name, err)
+		}
+
+		if secret, err := store.Get("certificates/user/ecc/client.alice.key"); err != nil || !bytes.Equal(secret, []byte("key")) {
+			t.Fatalf("%s: Get returned %q, %v", name, secret, err)
+		}
+
+		if names, err := store.List(); err != nil || !slices.Equal(names, []string{"certificates/user/ecc/client.alice.key"}) {
+			t.Fatalf("%s: List returned %v, %v", name, names, err)
+		}
+
+		if err := store.Delete("certificates/user/ecc/client.alice.key"); err != nil {
+			t.Fatalf("%s: Delete: %v", name, err)
+		}
+
+		if _, err := store.Get("certificates/user/ecc/client.alice.key"); !errors.Is(err, ErrSecretNotFound) {
+			t.Fatalf("%s: Get of a deleted secret should fail with ErrSecretNotFound, got %v", name, err)
+		}
+	}
+
+	if _, err := NewEncryptedFileSecretStore(fs, "/app/secrets.enc", []byte("wrong")); !errors.Is(err, ErrPassphrase) {
+		t.Fatalf("opening an encrypted store with a wrong passphrase should fail with ErrPassphrase, got %v", err)
+	}
+
+	// Moving the keys of a manager.
+	certs, err := NewManager(fs, database, logger, "testapp", "/app", nil, nil, stores["filesystem"])
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	certPEM, keyPEM, err := certs.UserClientGenerateCertificate("alice")
+	if err != nil {
+		t.Fatalf("UserClientGenerateCertificate: %v", err)
+	}
+
+	for _, target := range []string{"encrypted-file", "database"} {
+		moved, err := certs.MigrateSecrets(stores[target], nil)
+		if err != nil || moved != 2 {
+			t.Fatalf("MigrateSecrets to %s moved %d secrets: %v", target, moved, err)
+		}
+
+		if _, gotKey, err := certs.UserClientGetCertificate("alice"); err != nil || !bytes.Equal(gotKey, keyPEM) {
+			t.Fatalf("certificate key not found in the %s store: %v", target, err)
+		}
+	}
+
+	if names, _ := stores["encrypted-file"].List(); len(names) != 0 {
+		t.Fatalf("secrets should have been moved out of the previous store, got %v", names)
+	}
+
+	// A manager started on the same database and filesystem finds the keys in their store.
+	restarted, err := NewManager(fs, database, logger, "testapp", "/app", nil, nil, stores["database"])
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	if gotCert, _, err := restarted.UserClientGetCertificate("alice"); err != nil || !bytes.Equal(gotCert, certPEM) {
+		t.Fatalf("UserClientGetCertificate after restart: %v", err)
+	}
+}
+
+// TestMigrateSecretsCopiedFailure checks that secrets are kept in their store, and not
+// copied in the other one, when the copied function of a migration fails.
+func TestMigrateSecretsCopiedFailure(t *testing.T) {
+	fs := assets.NewFileSystem(true)
+	from, to := NewFileSecretStore(fs, "/app/from"), NewFileSecretStore(fs, "/app/to")
+
+	for _, name := range []string{"ca/users.key", "certificates/user/ecc/client.alice.key"} {
+		if err := from.Put(name, []byte(name)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	errSave := errors.New("save failure")
+
+	if _, err := MigrateSecrets(from, to, func() error { return errSave }); !errors.Is(err, errSave) {
+		t.Fatalf("MigrateSecrets should fail with the error of its copied function, got %v", err)
+	}
+
+	if names, _ := from.List(); len(names) != 2 {
+		t.Fatalf("secrets should be kept in their store, got %v", names)
+	}
+
+	if names, _ := to.List(); len(names) != 0 {
+		t.Fatalf("copies should be removed from the other store, got %v", names)
+	}
+}
+
+// TestLegacySecrets checks that the private keys stored by older versions, the users CA
+// key in a file and certificate keys in the database, are moved into the secret store.
+func TestLegacySecrets(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.NewClient(&db.Config{
+		Dialect:      db.Sqlite,
+		Database:     db.SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create in-memory database: %v", err)
+	}
+
+	fs := assets.NewFileSystem(true)
+	certs, err := NewManager(fs, database, logger, "testapp", "/app", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	_, caKey, err := certs.GetUsersCAPEM()
+	if err != nil {
+		t.Fatalf("GetUsersCAPEM: %v", err)
+	}
+
+	_, key, err := certs.UserClientGenerateCertificate("alice")
+	if err != nil {
+		t.Fatalf("UserClientGenerateCertificate: %v", err)
+	}
+
+	// Store the keys like older versions did.
+	store := certs.SecretStore()
+	_ = store.Delete(caKeySecret(userCA))
+	_ = store.Delete(certificateKeySecret(userCA, ECCKey, userClientCertName("alice", "")))
+
+	if err := fs.WriteFile(certs.caFilePath(userCA, "ca-key"), caKey, assets.FileReadPerm); err != nil {
+		t.Fatalf("failed to write legacy CA key: %v", err)
+	}
+
+	err = database.Model(&db.Certificate{}).Where("common_name = ?", userClientCertName("alice", "")).
+		Update("private_key_pem", string(key)).Error
+	if err != nil {
+		t.Fatalf("failed to store legacy certificate key: %v", err)
+	}
+
+	restarted, err := NewManager(fs, database, logger, "testapp", "/app", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	if _, gotKey, err := restarted.GetUsersCAPEM(); err != nil || !bytes.Equal(gotKey, caKey) {
+		t.Fatalf("legacy CA key was not moved to the secret store: %v", err)
+	}
+
+	if _, gotKey, err := restarted.UserClientGetCertificate("alice"); err != nil || !bytes.Equal(gotKey, key) {
+		t.Fatalf("legacy certificate key was not moved to the secret store: %v", err)
+	}
+
+	if exists, _ := fs.Exists(certs.caFilePath(userCA, "ca-key")); exists {
+		t.Fatal("legacy CA key file should have been removed")
+	}
+}
@@ -56,6 +56,20 @@ func (c *Manager) OpenTLSKeyLogFile() *os.File {
 // we have to disable all of the certificate validation and re-implement everything.
 // https://github.com/golang/go/issues/21971
 func RootOnlyVerifyCertificate(caCertificate string, rawCerts [][]byte) error {
+	return verifyCertificate(caCertificate, "", rawCerts)
+}
+
+// VerifyCertificateHost is RootOnlyVerifyCertificate, also verifying that the leaf
+// certificate is valid for a host name or IP address, as standard TLS clients do.
+func VerifyCertificateHost(caCertificate, host string, rawCerts [][]byte) error {
+	if host == "" {
+		return fmt.Errorf("No host to verify the certificate for")
+	}
+
+	return verifyCertificate(caCertificate, host, rawCerts)
+}
+
+func verifyCertificate(caCertificate, host string, rawCerts [][]byte) error {
 	roots := x509.NewCertPool()
 
 	ok := roots.AppendCertsFromPEM([]byte(caCertificate))
@@ -63,17 +77,29 @@ func RootOnlyVerifyCertificate(caCertificate string, rawCerts [][]byte) error {
 		return fmt.Errorf("Failed to parse root certificate")
 	}
 
-	cert, err := x509.ParseCertificate(rawCerts[0]) // We should only get one cert
+	cert, err := x509.ParseCertificate(rawCerts[0]) // The leaf certificate comes first
 	if err != nil {
 		log.Printf("Failed to parse certificate: " + err.Error())
 		return err
 	}
 
+	// Other certificates are intermediates, such as the cross-signed
+	// certificate of a new CA sent by teamservers rotating their CA.
+	intermediates := x509.NewCertPool()
+
+	for _, raw := range rawCerts[1:] {
+		if intermediate, err := x509.ParseCertificate(raw); err == nil {
+			intermediates.AddCert(intermediate)
+		}
+	}
+
 	// Basically we only care if the certificate was signed by our authority
 	// Go selects sensible defaults for time and EKU, basically we're only
 	// skipping the hostname check, I think?
 	options := x509.VerifyOptions{
-		Roots: roots,
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       host,
 	}
 
 	if options.Roots == nil {
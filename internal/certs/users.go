@@ -19,9 +19,15 @@ package certs
 */
 
 import (
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
 
 	"github.com/reeflective/team/internal/db"
 )
@@ -33,24 +39,156 @@ const (
 	clientNamespace  = "client"    // User clients
 	serverNamespace  = "server"    // User servers
 	userCertHostname = "teamusers" // Hostname used on certificate
+
+	// EnrollmentServerName is the TLS server name requested by teamclients enrolling
+	// with an invitation code: they have no client certificate to authenticate with yet.
+	EnrollmentServerName = "enroll." + userCertHostname
 )
 
 // UserClientGenerateCertificate - Generate a certificate signed with a given CA.
 func (c *Manager) UserClientGenerateCertificate(user string) ([]byte, []byte, error) {
-	cert, key := c.GenerateECCCertificate(userCA, user, false, true)
-	err := c.saveCertificate(userCA, ECCKey, fmt.Sprintf("%s.%s", clientNamespace, user), cert, key)
-
-	return cert, key, err
+	return c.UserClientGenerateCredentialCertificate(user, "")
 }
 
 // UserClientGetCertificate - Helper function to fetch a client cert.
 func (c *Manager) UserClientGetCertificate(user string) ([]byte, []byte, error) {
-	return c.GetECCCertificate(userCA, fmt.Sprintf("%s.%s", clientNamespace, user))
+	return c.UserClientGetCredentialCertificate(user, "")
 }
 
 // UserClientRemoveCertificate - Helper function to remove a client cert.
 func (c *Manager) UserClientRemoveCertificate(user string) error {
-	return c.RemoveCertificate(userCA, ECCKey, fmt.Sprintf("%s.%s", clientNamespace, user))
+	return c.UserClientRemoveCredentialCertificate(user, "")
+}
+
+// UserClientGenerateCredentialCertificate - Generate a client certificate for one of the
+// user credentials (devices). All certificates of a user have the user name as common name,
+// and an empty label is the user default certificate.
+func (c *Manager) UserClientGenerateCredentialCertificate(user, label string) ([]byte, []byte, error) {
+	if _, _, err := c.getCA(userCA); err != nil {
+		return nil, nil, err
+	}
+
+	cert, key, profile, err := c.GenerateCertificate(userCA, user, false, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = c.saveCertificate(userCA, ECCKey, userClientCertName(user, label), cert, key, profile)
+
+	return cert, key, err
+}
+
+// UserClientSignCredentialCSR - Sign the certificate request of a client enrolling for
+// a user credential, and save the certificate. The teamserver never sees the private key,
+// so it is not stored either. The key of the request must be of the client profile algorithm.
+func (c *Manager) UserClientSignCredentialCSR(user, label string, csrPEM []byte) ([]byte, error) {
+	cert, profile, err := c.signCredentialCSR(user, csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.saveCertificate(userCA, ECCKey, userClientCertName(user, label), cert, nil, profile)
+
+	return cert, err
+}
+
+// UserClientEnrollCredentialCSR - Sign the certificate request of a client enrolling for
+// a user credential, and save the certificate in place of the current one of the credential
+// (revoked as superseded), if any. The request is signed first: nothing is changed if it is
+// invalid. The certificate is then saved in a database transaction, which also runs the
+// enroll function: nothing is changed either if it fails.
+func (c *Manager) UserClientEnrollCredentialCSR(user, label string, csrPEM []byte,
+	enroll func(tx *gorm.DB, certPEM []byte) error,
+) ([]byte, error) {
+	return c.replaceCredentialCSR(user, label, csrPEM, enroll)
+}
+
+// UserClientRenewCredentialCSR - Sign the certificate request of a client renewing the
+// certificate of its credential (eg. after a CA rotation) with the current users CA.
+// As with UserClientEnrollCredentialCSR, the previous certificate of the credential is
+// revoked (superseded) and replaced in a database transaction, which runs the renew
+// function: the credential keeps its certificate if anything fails.
+func (c *Manager) UserClientRenewCredentialCSR(user, label string, csrPEM []byte,
+	renew func(tx *gorm.DB, certPEM []byte) error,
+) ([]byte, error) {
+	return c.replaceCredentialCSR(user, label, csrPEM, renew)
+}
+
+// replaceCredentialCSR signs a client certificate request for a user credential, and replaces
+// its current certificate (revoked as superseded) in a transaction also running the update.
+func (c *Manager) replaceCredentialCSR(user, label string, csrPEM []byte,
+	update func(tx *gorm.DB, certPEM []byte) error,
+) ([]byte, error) {
+	cert, profile, err := c.signCredentialCSR(user, csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.replaceCertificate(userCA, ECCKey, userClientCertName(user, label), cert, nil, profile,
+		func(tx *gorm.DB, previous *db.Certificate) error {
+			if previous != nil {
+				if err := c.revokeCertificateModel(tx, user, previous, ReasonSuperseded); err != nil {
+					return err
+				}
+			}
+
+			return update(tx, cert)
+		})
+
+	return cert, err
+}
+
+// signCredentialCSR signs a client certificate request for a user, returning
+// the certificate and the profile with which it was issued.
+func (c *Manager) signCredentialCSR(user string, csrPEM []byte) ([]byte, Profile, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return nil, Profile{}, errors.New("failed to parse certificate request PEM")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, Profile{}, err
+	}
+
+	// The certificate is issued with the client profile, but for the key of the request.
+	profile, err := c.profiles.get(userCA, PurposeClient).withPublicKey(csr.PublicKey)
+	if err != nil {
+		return nil, Profile{}, fmt.Errorf("unsupported certificate request key: %w", err)
+	}
+
+	cert, err := c.SignCertificateRequest(userCA, user, csrPEM, true)
+	if err != nil {
+		return nil, Profile{}, err
+	}
+
+	return cert, profile, nil
+}
+
+// UserClientGetCredentialCertificate - Fetch the client certificate of a user credential.
+func (c *Manager) UserClientGetCredentialCertificate(user, label string) ([]byte, []byte, error) {
+	return c.GetECCCertificate(userCA, userClientCertName(user, label))
+}
+
+// UserClientRemoveCredentialCertificate - Remove the client certificate of a user credential.
+func (c *Manager) UserClientRemoveCredentialCertificate(user, label string) error {
+	return c.RemoveCertificate(userCA, ECCKey, userClientCertName(user, label))
+}
+
+// UserClientRemoveCertificates - Remove all client certificates of a user.
+func (c *Manager) UserClientRemoveCertificates(user string) error {
+	userCerts, err := c.userClientCertificates(user)
+	if err != nil {
+		return err
+	}
+
+	for _, certModel := range userCerts {
+		if err := c.RemoveCertificate(userCA, ECCKey, certModel.CommonName); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // UserServerGetCertificate - Helper function to fetch a server cert.
@@ -58,10 +196,25 @@ func (c *Manager) UserServerGetCertificate() ([]byte, []byte, error) {
 	return c.GetECCCertificate(userCA, fmt.Sprintf("%s.%s", serverNamespace, userCertHostname))
 }
 
-// UserServerGenerateCertificate - Generate a certificate signed with a given CA.
-func (c *Manager) UserServerGenerateCertificate() ([]byte, []byte, error) {
-	cert, key := c.GenerateECCCertificate(userCA, userCertHostname, false, false)
-	err := c.saveCertificate(userCA, ECCKey, fmt.Sprintf("%s.%s", serverNamespace, userCertHostname), cert, key)
+// UserServerGenerateCertificate - Generate a certificate signed with a given CA,
+// replacing the current one if any (eg. after a CA rotation). The certificate
+// authenticates the users hostname, and the host names and IP addresses given.
+// The current certificate is only replaced once the new one is generated and
+// saved: it is kept if anything fails.
+func (c *Manager) UserServerGenerateCertificate(names ...string) ([]byte, []byte, error) {
+	name := fmt.Sprintf("%s.%s", serverNamespace, userCertHostname)
+
+	if _, _, err := c.getCA(userCA); err != nil {
+		return nil, nil, err
+	}
+
+	profile := c.profiles.get(userCA, PurposeServer)
+	cert, key, err := c.generateProfileCertificate(userCA, userCertHostname, false, false, profile, names...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = c.replaceCertificate(userCA, ECCKey, name, cert, key, profile, nil)
 
 	return cert, key, err
 }
@@ -98,3 +251,44 @@ func (c *Manager) UserClientListCertificates() []*x509.Certificate {
 
 	return certs
 }
+
+// userClientCertificates returns the client certificates of all the user credentials.
+func (c *Manager) userClientCertificates(user string) ([]*db.Certificate, error) {
+	candidates := []*db.Certificate{}
+
+	err := c.db().Where(&db.Certificate{CAType: userCA, KeyType: ECCKey}).
+		Where("common_name LIKE ?", fmt.Sprintf("%s.%s%%", clientNamespace, user)).
+		Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// LIKE patterns match other users with the same prefix.
+	userCerts := make([]*db.Certificate, 0, len(candidates))
+	defaultName := userClientCertName(user, "")
+
+	for _, certModel := range candidates {
+		if certModel.CommonName == defaultName || strings.HasPrefix(certModel.CommonName, defaultName+".") {
+			userCerts = append(userCerts, certModel)
+		}
+	}
+
+	return userCerts, nil
+}
+
+// userClientCertName returns the name under which the client certificate of a user
+// credential is stored. Certificates of default credentials have no label, which is
+// the way all user certificates were stored before users could have several of them.
+func userClientCertName(user, label string) string {
+	if label == "" {
+		return fmt.Sprintf("%s.%s", clientNamespace, user)
+	}
+
+	return fmt.Sprintf("%s.%s.%s", clientNamespace, user, label)
+}
+
+// Fingerprint returns the hex-encoded SHA-256 digest of a DER-encoded certificate.
+func Fingerprint(der []byte) string {
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,53 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+)
+
+// AuditEvent - An administrative action performed on the teamserver (users,
+// credentials, certificate authorities, listeners...), with who performed it
+// and from where. Audit events are never modified nor deleted by the teamserver.
+type AuditEvent struct {
+	ID        uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt time.Time `gorm:"->;<-:create;index"`
+	Actor     string    `gorm:"index"` // User (or OS user, for local calls) performing the action.
+	Origin    string    // Local API call (CLI) or remote call (RPC).
+	Action    string    `gorm:"index"` // Action performed (eg. "user.create").
+	Target    string    // Object of the action (eg. a user name or listener ID).
+	Details   string
+}
+
+// BeforeCreate - GORM hook.
+func (a *AuditEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	a.ID, err = uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// Export reads all rows of the teamserver tables (and of the application models, see
+// RegisterModels), with the schema version of the database, in a single read-only
+// transaction: the export is consistent even while the teamserver runs. Rows are
+// exported as JSON arrays of models, by table name, and encrypted columns are exported
+// decrypted. The data keys and the secrets (exported from the secret store of the
+// teamserver) are not exported.
+func Export(dbClient *gorm.DB) (uint, map[string][]byte, error) {
+	version, err := SchemaVersion(dbClient)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tables := make(map[string][]byte)
+
+	err = dbClient.Transaction(func(tx *gorm.DB) error {
+		for _, model := range exportedModels(tx) {
+			table, err := tableName(tx, model)
+			if err != nil {
+				return err
+			}
+
+			rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+			if err := tx.Find(rows.Interface()).Error; err != nil {
+				return fmt.Errorf("failed to read table %s: %w", table, err)
+			}
+
+			if tables[table], err = json.Marshal(rows.Interface()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, snapshotOptions(dbClient)...)
+
+	return version, tables, err
+}
+
+// Import replaces all rows of the teamserver tables with the ones of an export, in
+// a single transaction. Tables missing from the export are emptied, and the rows
+// of unknown tables are ignored. Model hooks are skipped, so that rows are imported
+// as they were exported (identifiers and creation times), encrypted if needed.
+func Import(dbClient *gorm.DB, tables map[string][]byte) error {
+	return dbClient.Transaction(func(tx *gorm.DB) error {
+		for _, model := range exportedModels(tx) {
+			table, err := tableName(tx, model)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to empty table %s: %w", table, err)
+			}
+
+			data, found := tables[table]
+			if !found {
+				continue
+			}
+
+			rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+			if err := json.Unmarshal(data, rows.Interface()); err != nil {
+				return fmt.Errorf("invalid rows for table %s: %w", table, err)
+			}
+
+			if rows.Elem().Len() == 0 {
+				continue
+			}
+
+			err = tx.Session(&gorm.Session{SkipHooks: true}).CreateInBatches(rows.Interface(), 100).Error
+			if err != nil {
+				return fmt.Errorf("failed to import table %s: %w", table, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// exportedModels returns the models of the client exported with Export().
+func exportedModels(dbClient *gorm.DB) []any {
+	var models []any
+
+	for _, model := range Models(dbClient) {
+		switch model.(type) {
+		case *DataKey, *Secret:
+		default:
+			models = append(models, model)
+		}
+	}
+
+	return models
+}
+
+// snapshotOptions returns the options of a read-only transaction reading all tables at once.
+func snapshotOptions(dbClient *gorm.DB) []*sql.TxOptions {
+	// Without a repeatable read isolation, PostgreSQL would read each table at a different
+	// time. MySQL (InnoDB) uses it by default, and SQLite transactions are serializable.
+	switch dbClient.Dialector.Name() {
+	case "postgres":
+		return []*sql.TxOptions{{Isolation: sql.LevelRepeatableRead, ReadOnly: true}}
+	case "mysql":
+		return []*sql.TxOptions{{ReadOnly: true}}
+	default:
+		return nil
+	}
+}
+
+func tableName(dbClient *gorm.DB, model any) (string, error) {
+	stmt := &gorm.Statement{DB: dbClient}
+	if err := stmt.Parse(model); err != nil {
+		return "", err
+	}
+
+	return stmt.Schema.Table, nil
+}
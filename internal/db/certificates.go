@@ -33,7 +33,8 @@ type Certificate struct {
 	CAType         string
 	KeyType        string
 	CertificatePEM string
-	PrivateKeyPEM  string
+	PrivateKeyPEM  string `gorm:"serializer:encrypted"`
+	Profile        string // JSON description of the profile with which it was issued.
 }
 
 // BeforeCreate - GORM hook to automatically set values.
@@ -37,7 +37,7 @@ type Config struct {
 	Dialect  string `json:"dialect"`
 	Database string `json:"database"`
 	Username string `json:"username"`
-	Password string `json:"password"`
+	Password string `json:"password"` // A cleartext password, or a secret reference resolved by the teamserver.
 	Host     string `json:"host"`
 	Port     uint16 `json:"port"`
 
@@ -48,12 +48,28 @@ type Config struct {
 
 	LogLevel string `json:"log_level"`
 
+	// TablePrefix is prepended to the names of all tables, so that several
+	// applications can share the same database (eg. "myapp_" for "myapp_users").
+	// Changing it on an existing database gives a new, empty set of tables.
+	TablePrefix string `json:"table_prefix,omitempty"`
+
 	// EncryptionKey, when set, enables transparent encryption-at-rest for
 	// on-disk SQLite databases through the pure-Go adiantum VFS (available on
 	// the default and wasm_sqlite builds). It is deliberately NOT serialized:
 	// persisting the key next to the database it protects would defeat the
 	// purpose. Applications supply it out-of-band (option, env, KMS, prompt).
+	// With any dialect, it also encrypts the sensitive columns (see EnableEncryption).
 	EncryptionKey string `json:"-"`
+
+	// EncryptionKeyRef is a reference to the encryption key, resolved by the teamserver
+	// when it opens the database: "env:NAME", "file:/path" or "prompt". Unlike the key,
+	// it is saved in the configuration file, and it may not be a cleartext key.
+	EncryptionKeyRef string `json:"encryption_key,omitempty"`
+
+	// Models are the models registered by the application, whose tables are
+	// migrated, encrypted, backed up and copied along the teamserver ones (see
+	// RegisterModels). Like the key, they are not part of the configuration file.
+	Models []any `json:"-"`
 }
 
 // DSN - Get the db connections string
@@ -0,0 +1,156 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// ErrCopy - The tables of a database cannot be copied to another one.
+var ErrCopy = errors.New("database copy")
+
+// Copy copies all rows of the tables of the given models from a database to another one,
+// whatever their SQL dialects, and returns the number of rows copied. Rows are read in a
+// single read-only transaction, and written in a single transaction: the target database
+// must be migrated and empty. Values of encrypted columns are read decrypted and written
+// encrypted with the keys of the target client, if any (see EnableEncryption), and the
+// data keys of the database are not copied. Once copied, the number of rows of each
+// table must be the same in both databases.
+func Copy(source, target *gorm.DB, models []any) (int64, error) {
+	var copied []any
+
+	for _, model := range models {
+		if _, isKey := model.(*DataKey); !isKey {
+			copied = append(copied, model)
+		}
+	}
+
+	for _, model := range copied {
+		table, count, err := countRows(target, model)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrCopy, err)
+		} else if count > 0 {
+			return 0, fmt.Errorf("%w: target table %s is not empty", ErrCopy, table)
+		}
+	}
+
+	tables := make([]reflect.Value, len(copied))
+
+	err := source.Transaction(func(tx *gorm.DB) error {
+		for i, model := range copied {
+			tables[i] = reflect.New(reflect.SliceOf(reflect.TypeOf(model)))
+			if err := tx.Find(tables[i].Interface()).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, snapshotOptions(source)...)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to read source tables: %w", ErrCopy, err)
+	}
+
+	var total int64
+
+	err = target.Transaction(func(tx *gorm.DB) error {
+		for i, rows := range tables {
+			if rows.Elem().Len() == 0 {
+				continue
+			}
+
+			// Hooks are skipped, so that rows keep their identifiers and creation times.
+			err := tx.Session(&gorm.Session{SkipHooks: true}).CreateInBatches(rows.Interface(), 100).Error
+			if err != nil {
+				return err
+			}
+
+			if err := resetSequence(tx, copied[i]); err != nil {
+				return err
+			}
+
+			total += int64(rows.Elem().Len())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to write target tables: %w", ErrCopy, err)
+	}
+
+	for _, model := range copied {
+		table, count, err := countRows(source, model)
+		if err != nil {
+			return total, fmt.Errorf("%w: %w", ErrCopy, err)
+		}
+
+		_, copyCount, err := countRows(target, model)
+		if err != nil {
+			return total, fmt.Errorf("%w: %w", ErrCopy, err)
+		}
+
+		if count != copyCount {
+			return total, fmt.Errorf("%w: target table %s has %d rows instead of %d (source changed while copied?)",
+				ErrCopy, table, copyCount, count)
+		}
+	}
+
+	return total, nil
+}
+
+// countRows returns the table of a model and its number of rows.
+func countRows(dbClient *gorm.DB, model any) (string, int64, error) {
+	table, err := tableName(dbClient, model)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var count int64
+	if err := dbClient.Model(model).Count(&count).Error; err != nil {
+		return table, 0, fmt.Errorf("failed to count rows of table %s: %w", table, err)
+	}
+
+	return table, count, nil
+}
+
+// resetSequence sets the sequence of the auto-incremented primary key of a model after
+// its greatest value, on PostgreSQL: rows inserted with their key do not advance it.
+func resetSequence(tx *gorm.DB, model any) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+
+	primary := stmt.Schema.PrioritizedPrimaryField
+	if primary == nil || !primary.AutoIncrement {
+		return nil
+	}
+
+	query := fmt.Sprintf("SELECT setval(pg_get_serial_sequence(?, ?), (SELECT COALESCE(MAX(%[1]s), 0) + 1 FROM %[2]s), false)",
+		tx.Statement.Quote(primary.DBName), tx.Statement.Quote(stmt.Schema.Table))
+
+	return tx.Exec(query, stmt.Schema.Table, primary.DBName).Error
+}
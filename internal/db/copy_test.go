@@ -0,0 +1,95 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+// TestCopy copies a database with encrypted columns to another one (a second SQLite
+// file standing for another dialect) with another key, and checks the copied rows.
+func TestCopy(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	source, err := NewClient(&Config{
+		Dialect:       Sqlite,
+		Database:      SQLiteInMemoryHost,
+		MaxIdleConns:  1,
+		MaxOpenConns:  1,
+		LogLevel:      "error",
+		EncryptionKey: "source key",
+	}, logger)
+	if err != nil {
+		t.Fatalf("NewClient(source): %v", err)
+	}
+
+	cert := &Certificate{CommonName: "copied", PrivateKeyPEM: "PRIVATE KEY"}
+	user := &User{Name: "alice"}
+
+	for _, row := range []any{cert, user, &Credential{UserName: "alice", Label: "default", Token: "token"}} {
+		if err := source.Create(row).Error; err != nil {
+			t.Fatalf("Create(%T): %v", row, err)
+		}
+	}
+
+	target, err := NewClient(&Config{
+		Dialect:       Sqlite,
+		Database:      filepath.Join(t.TempDir(), "target.db"),
+		MaxIdleConns:  1,
+		MaxOpenConns:  1,
+		LogLevel:      "error",
+		EncryptionKey: "target key",
+	}, logger)
+	if err != nil {
+		t.Fatalf("NewClient(target): %v", err)
+	}
+
+	count, err := Copy(source, target, Schema())
+	if err != nil || count != 3 {
+		t.Fatalf("Copy = %d rows, %v", count, err)
+	}
+
+	var copied Certificate
+	if err := target.Where(&Certificate{CommonName: "copied"}).First(&copied).Error; err != nil {
+		t.Fatalf("read copied certificate: %v", err)
+	}
+	if copied.ID != cert.ID || copied.PrivateKeyPEM != cert.PrivateKeyPEM {
+		t.Fatalf("copied certificate = %v %q", copied.ID, copied.PrivateKeyPEM)
+	}
+
+	var credential Credential
+	if err := target.Where(&Credential{Token: "token"}).First(&credential).Error; err != nil {
+		t.Fatalf("look up copied credential by token: %v", err)
+	}
+
+	var stored string
+	if err := target.Table("certificates").Select("private_key_pem").Row().Scan(&stored); err != nil {
+		t.Fatalf("read raw column: %v", err)
+	} else if !isEncrypted([]byte(stored)) {
+		t.Fatal("copied private key stored in cleartext")
+	}
+
+	if _, err := Copy(source, target, Schema()); !errors.Is(err, ErrCopy) {
+		t.Fatalf("Copy(non-empty target) = %v, want ErrCopy", err)
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+
+	v3 "github.com/reeflective/team/internal/db/v3"
+)
+
+// DefaultCredential is the label of the credential issued along with a new user.
+const DefaultCredential = "default"
+
+// Credential - A set of credentials issued to a teamserver user, usually for one
+// of its devices: an API token, bound to a client certificate. A user can have
+// any number of credentials, each identified by a label unique for this user.
+type Credential struct {
+	ID        uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt time.Time `gorm:"->;<-:create;"`
+	LastUsed  time.Time
+	UserName  string `gorm:"index;uniqueIndex:idx_credentials_user_label"`
+	Label     string `gorm:"uniqueIndex:idx_credentials_user_label"`
+	Token     string `gorm:"uniqueIndex;serializer:encrypted_lookup"`
+
+	// Token lifetime: a zero expiry means the token never expires. When a token is
+	// rotated, the previous one remains valid until the end of its grace period.
+	TokenExpiresAt         time.Time
+	PreviousToken          string `gorm:"index;serializer:encrypted_lookup"`
+	PreviousTokenExpiresAt time.Time
+
+	// Hex-encoded serial number of the client certificate to which the token is bound.
+	CertificateSerial string
+}
+
+// BeforeCreate - GORM hook.
+func (c *Credential) BeforeCreate(tx *gorm.DB) (err error) {
+	c.ID, err = uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	c.CreatedAt = time.Now()
+
+	return nil
+}
+
+// migrateUserTokens moves the API tokens stored along with users by older versions
+// of the teamserver into a default credential for each of them. The legacy token
+// column is kept (and emptied), since dropping columns is not portable.
+func migrateUserTokens(dbClient *gorm.DB) error {
+	migrator := dbClient.Migrator()
+	if !migrator.HasColumn(&User{}, "token") {
+		return nil
+	}
+
+	columns := "name, last_seen, token"
+	if migrator.HasColumn(&User{}, "token_expires_at") {
+		columns += ", token_expires_at"
+	}
+
+	legacy := []struct {
+		Name           string
+		LastSeen       time.Time
+		Token          string
+		TokenExpiresAt time.Time
+	}{}
+
+	users, err := tableName(dbClient, &User{})
+	if err != nil {
+		return err
+	}
+
+	err = dbClient.Table(users).Select(columns).
+		Where("token IS NOT NULL AND token <> ''").
+		Scan(&legacy).Error
+	if err != nil {
+		return err
+	}
+
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	return dbClient.Transaction(func(tx *gorm.DB) error {
+		for _, user := range legacy {
+			err := tx.Create(&Credential{
+				UserName:       user.Name,
+				Label:          DefaultCredential,
+				LastUsed:       user.LastSeen,
+				Token:          user.Token,
+				TokenExpiresAt: user.TokenExpiresAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Table(users).Where("token IS NOT NULL").Update("token", nil).Error
+	})
+}
+
+// uniqueCredentialLabels enforces the uniqueness of credential labels for each user.
+// Credentials created with the label of another one of the same user, which older
+// versions of the teamserver did not always prevent, keep working but are renamed
+// after their ID: only the oldest one keeps its label.
+func uniqueCredentialLabels(tx *gorm.DB) error {
+	var credentials []v3.Credential
+
+	if err := tx.Order("created_at").Find(&credentials).Error; err != nil {
+		return err
+	}
+
+	labels := make(map[[2]string]bool, len(credentials))
+
+	for _, cred := range credentials {
+		label := [2]string{cred.UserName, cred.Label}
+		if !labels[label] {
+			labels[label] = true
+			continue
+		}
+
+		renamed := fmt.Sprintf("%s-%s", cred.Label, cred.ID.String()[:8])
+
+		err := tx.Model(&v3.Credential{}).Where("id = ?", cred.ID).Update("label", renamed).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Migrator().CreateIndex(&v3.Credential{}, v3.CredentialIndex)
+}
@@ -0,0 +1,137 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestMigrateUserTokens checks that tokens stored with users by older
+// teamservers are moved into default credentials, only once.
+func TestMigrateUserTokens(t *testing.T) {
+	dbClient, err := Open(&Config{
+		Dialect:      Sqlite,
+		Database:     SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// Legacy schema: no migrations history, and the users table has a token column.
+	if err := dbClient.AutoMigrate(&User{}); err != nil {
+		t.Fatalf("failed to create legacy table: %v", err)
+	}
+
+	if err := dbClient.Exec("ALTER TABLE users ADD COLUMN token text").Error; err != nil {
+		t.Fatalf("failed to add legacy column: %v", err)
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		if err := dbClient.Create(&User{Name: name}).Error; err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		err := dbClient.Table("users").Where("name = ?", name).Update("token", name+"-hash").Error
+		if err != nil {
+			t.Fatalf("failed to set legacy token: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Migrate(dbClient); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+	}
+
+	creds := []*Credential{}
+	if err := dbClient.Order("user_name").Find(&creds).Error; err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+
+	if len(creds) != 2 {
+		t.Fatalf("expected 2 migrated credentials, got %d", len(creds))
+	}
+
+	if creds[0].UserName != "alice" || creds[0].Label != DefaultCredential || creds[0].Token != "alice-hash" {
+		t.Fatalf("unexpected migrated credential: %+v", creds[0])
+	}
+
+	// New users are created without legacy tokens.
+	if err := dbClient.Create(&User{Name: "carol"}).Error; err != nil {
+		t.Fatalf("Create after migration: %v", err)
+	}
+}
+
+// TestUniqueCredentialLabels checks that credentials sharing the label of another one
+// of their user are renamed when migrating, and that such credentials cannot be created anymore.
+func TestUniqueCredentialLabels(t *testing.T) {
+	dbClient, err := Open(&Config{
+		Dialect:      Sqlite,
+		Database:     SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if _, err := MigrateTo(dbClient, 2); err != nil {
+		t.Fatalf("MigrateTo(2): %v", err)
+	}
+
+	for _, cred := range []*Credential{
+		{UserName: "alice", Label: "laptop", Token: "first"},
+		{UserName: "alice", Label: "laptop", Token: "second"},
+		{UserName: "bob", Label: "laptop", Token: "third"},
+	} {
+		if err := dbClient.Create(cred).Error; err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := Migrate(dbClient); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	creds := []*Credential{}
+	if err := dbClient.Order("created_at").Find(&creds).Error; err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+
+	if creds[0].Label != "laptop" || creds[2].Label != "laptop" {
+		t.Fatalf("the oldest credential of each user should keep its label: %q, %q", creds[0].Label, creds[2].Label)
+	}
+
+	if creds[1].Label != "laptop-"+creds[1].ID.String()[:8] || creds[1].Token != "second" {
+		t.Fatalf("duplicate credential should be renamed after its ID, got %q", creds[1].Label)
+	}
+
+	if err := dbClient.Create(&Credential{UserName: "alice", Label: "laptop", Token: "fourth"}).Error; err == nil {
+		t.Fatal("a credential with the label of another one of the user should be refused")
+	}
+}
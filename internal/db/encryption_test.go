@@ -22,6 +22,7 @@ package db
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"log/slog"
 	"os"
@@ -142,3 +143,102 @@ func TestUnencryptedDatabaseIsPlaintext(t *testing.T) {
 		t.Fatal("expected a standard SQLite header for an unencrypted database")
 	}
 }
+
+// TestRekey encrypts a plaintext database, changes its key and decrypts it again,
+// checking each time the file on disk and the values of the encrypted columns.
+func TestRekey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rekey.db")
+	config := newTestDBConfig(path, "")
+
+	client, err := NewClient(config, discardLogger())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	cert := &Certificate{CommonName: plaintextMarker, PrivateKeyPEM: "PRIVATE KEY"}
+	if err := client.Create(cert).Error; err != nil {
+		t.Fatalf("insert marker: %v", err)
+	}
+
+	for _, key := range []string{"first key", "second key", ""} {
+		previous := config.EncryptionKey
+
+		if client, err = Rekey(client, config, key, discardLogger()); err != nil {
+			t.Fatalf("Rekey(%q): %v", key, err)
+		}
+
+		config.EncryptionKey = key
+
+		var got Certificate
+		if err := client.Where(&Certificate{CommonName: plaintextMarker}).First(&got).Error; err != nil {
+			t.Fatalf("read marker after Rekey(%q): %v", key, err)
+		}
+		if got.PrivateKeyPEM != cert.PrivateKeyPEM {
+			t.Fatalf("private key after Rekey(%q) = %q", key, got.PrivateKeyPEM)
+		}
+
+		var stored string
+		if err := client.Table("certificates").Select("private_key_pem").Row().Scan(&stored); err != nil {
+			t.Fatalf("read raw column: %v", err)
+		}
+		if encrypted := isEncrypted([]byte(stored)); encrypted != (key != "") {
+			t.Fatalf("column encrypted = %t with key %q", encrypted, key)
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read db file: %v", err)
+		}
+		if plaintext := bytes.HasPrefix(raw, []byte("SQLite format 3")); plaintext != (key == "") {
+			t.Fatalf("SQLite header present = %t with key %q", plaintext, key)
+		}
+
+		if _, err := os.Stat(path + ".rekey"); !os.IsNotExist(err) {
+			t.Fatalf("copy of the database left behind: %v", err)
+		}
+
+		if previous != "" {
+			if _, err := Open(newTestDBConfig(path, previous), discardLogger()); err == nil {
+				t.Fatalf("database still opens with the previous key %q", previous)
+			}
+		}
+	}
+
+	if _, err := Rekey(client, newTestDBConfig(SQLiteInMemoryHost, ""), "key", discardLogger()); !errors.Is(err, ErrRekey) {
+		t.Fatalf("Rekey(in-memory) = %v, want ErrRekey", err)
+	}
+}
+
+// TestRekeyRenameFailure checks that if the database file cannot be replaced with its
+// copy, the database is left unchanged, and opened again with the previous key.
+func TestRekeyRenameFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rekey.db")
+	config := newTestDBConfig(path, "first key")
+
+	client, err := NewClient(config, discardLogger())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if err := client.Create(&Certificate{CommonName: plaintextMarker}).Error; err != nil {
+		t.Fatalf("insert marker: %v", err)
+	}
+
+	errRename := errors.New("injected rename failure")
+
+	renameFile = func(string, string) error { return errRename }
+	defer func() { renameFile = os.Rename }()
+
+	client, err = Rekey(client, config, "second key", discardLogger())
+	if !errors.Is(err, errRename) || client == nil {
+		t.Fatalf("Rekey = %v (client %v), want the rename error and the database opened again", err, client)
+	}
+
+	if err := client.Where(&Certificate{CommonName: plaintextMarker}).First(&Certificate{}).Error; err != nil {
+		t.Fatalf("database not usable after a failed Rekey: %v", err)
+	}
+
+	if _, err := os.Stat(path + ".rekey"); !os.IsNotExist(err) {
+		t.Fatalf("copy of the database left behind: %v", err)
+	}
+}
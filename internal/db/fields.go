@@ -0,0 +1,799 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Sensitive columns are tagged with one of the serializers below, which encrypt
+// their values with the data key of the database when field encryption is enabled,
+// and store them as "enc:<key version>:<base64 nonce and ciphertext>". Columns on
+// which the teamserver looks up rows (token hashes, invitation codes) are encrypted
+// deterministically (the nonce is a MAC of the value), so that equal values have
+// equal ciphertexts and struct conditions still match. Without field encryption,
+// values are stored as is, and cleartext values are always read back as is.
+const (
+	encryptedSerializer       = "encrypted"
+	encryptedLookupSerializer = "encrypted_lookup"
+
+	encryptedPrefix  = "enc:"
+	encryptionPlugin = "team:field-encryption"
+
+	dataKeySize = 64 // AES-256 key, followed by the HMAC-SHA256 key of deterministic nonces.
+	kekSaltSize = 16
+)
+
+// ErrEncryptionKey - The database key cannot decrypt the sensitive columns.
+var ErrEncryptionKey = errors.New("database field encryption")
+
+func init() {
+	schema.RegisterSerializer(encryptedSerializer, fieldSerializer{})
+	schema.RegisterSerializer(encryptedLookupSerializer, fieldSerializer{lookup: true})
+}
+
+// DataKey - A key encrypting the sensitive columns of the database, stored wrapped
+// with a key derived (Argon2id) from the database key. Only the most recent version
+// is used to encrypt, and older ones are deleted once rotated out.
+type DataKey struct {
+	Version    uint `gorm:"primaryKey;autoIncrement:false"`
+	Salt       []byte
+	WrappedKey []byte
+	CreatedAt  time.Time
+}
+
+// EnableEncryption loads the data key of the database (creating it if needed) with the
+// given database key, and returns a client transparently encrypting and decrypting the
+// sensitive columns, whatever the SQL dialect. Values still stored in cleartext (or
+// with a rotated data key) are encrypted with the current data key.
+func EnableEncryption(dbClient *gorm.DB, key string) (*gorm.DB, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%w: no database key", ErrEncryptionKey)
+	}
+
+	keys := &fieldKeys{}
+
+	if err := keys.load(dbClient, key); err != nil {
+		return nil, err
+	}
+
+	// A client reopened on the same connection pool keeps the plugin
+	// registered the first time, which now uses the keys just loaded.
+	plugin, registered := dbClient.Config.Plugins[encryptionPlugin].(*fieldEncryption)
+	if registered {
+		plugin.set(keys)
+	} else {
+		plugin = &fieldEncryption{keys: keys}
+		if err := dbClient.Use(plugin); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrEncryptionKey, err)
+		}
+	}
+
+	encrypted := dbClient.WithContext(withFieldKeys(dbClient.Statement.Context, plugin))
+
+	if _, err := encryptFields(encrypted, keys); err != nil {
+		return nil, fmt.Errorf("%w: failed to encrypt existing values: %w", ErrEncryptionKey, err)
+	}
+
+	return encrypted, nil
+}
+
+// EncryptionEnabled returns true if the client encrypts the sensitive columns.
+func EncryptionEnabled(dbClient *gorm.DB) bool {
+	_, enabled := dbClient.Config.Plugins[encryptionPlugin]
+	return enabled
+}
+
+// RotateEncryptionKey generates a new data key, re-encrypts all sensitive values with it
+// and deletes the previous ones, in a single transaction. It returns the number of values
+// encrypted again. The client must have been returned by EnableEncryption.
+func RotateEncryptionKey(dbClient *gorm.DB) (int, error) {
+	plugin, enabled := dbClient.Config.Plugins[encryptionPlugin].(*fieldEncryption)
+	if !enabled {
+		return 0, fmt.Errorf("%w: not enabled", ErrEncryptionKey)
+	}
+
+	keys := plugin.get()
+	rotated := keys.clone()
+
+	var count int
+
+	err := dbClient.WithContext(withFieldKeys(dbClient.Statement.Context, rotated)).Transaction(func(tx *gorm.DB) error {
+		version, err := rotated.generate(tx)
+		if err != nil {
+			return err
+		}
+
+		count, err = encryptFields(tx, rotated)
+		if err != nil {
+			return err
+		}
+
+		rotated.prune(version)
+
+		return tx.Where("version <> ?", version).Delete(&DataKey{}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrEncryptionKey, err)
+	}
+
+	plugin.set(rotated)
+
+	return count, nil
+}
+
+// changeEncryptionKey wraps the data keys of the database with a new database key, in a
+// single transaction. Without the current key, the sensitive values are encrypted with a
+// new data key, and without the new one, they are stored in cleartext and the data keys
+// are deleted. It returns the number of values encrypted or decrypted.
+func changeEncryptionKey(dbClient *gorm.DB, key, newKey string) (int, error) {
+	if key == "" && newKey == "" {
+		return 0, nil
+	}
+
+	var count int
+
+	err := dbClient.Transaction(func(tx *gorm.DB) error {
+		keys := &fieldKeys{}
+
+		if key == "" {
+			var err error
+			if err = keys.load(tx, newKey); err == nil {
+				count, err = encryptFields(tx, keys)
+			}
+
+			return err
+		}
+
+		if err := keys.load(tx, key); err != nil {
+			return err
+		}
+
+		if newKey == "" {
+			var err error
+			if count, err = decryptFields(tx, keys); err != nil {
+				return err
+			}
+
+			return tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&DataKey{}).Error
+		}
+
+		return keys.rewrap(tx, newKey)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrEncryptionKey, err)
+	}
+
+	return count, nil
+}
+
+// fieldKeys holds the data keys of a database, unwrapped.
+type fieldKeys struct {
+	kek    []byte
+	salt   []byte
+	keys   map[uint]*dataKey
+	active uint
+}
+
+type dataKey struct {
+	raw  []byte
+	aead cipher.AEAD
+	mac  []byte
+}
+
+// load unwraps the data keys of the database, or creates the first one.
+func (k *fieldKeys) load(dbClient *gorm.DB, key string) error {
+	var stored []DataKey
+	if err := dbClient.Order("version").Find(&stored).Error; err != nil {
+		return fmt.Errorf("%w: %w", ErrEncryptionKey, err)
+	}
+
+	k.keys = make(map[uint]*dataKey)
+
+	if len(stored) == 0 {
+		k.salt = make([]byte, kekSaltSize)
+		if _, err := rand.Read(k.salt); err != nil {
+			return fmt.Errorf("%w: %w", ErrEncryptionKey, err)
+		}
+
+		k.kek = deriveKEK(key, k.salt)
+
+		if _, err := k.generate(dbClient); err != nil {
+			return fmt.Errorf("%w: %w", ErrEncryptionKey, err)
+		}
+
+		return nil
+	}
+
+	keks := make(map[string][]byte)
+
+	for _, stored := range stored {
+		kek, found := keks[string(stored.Salt)]
+		if !found {
+			kek = deriveKEK(key, stored.Salt)
+			keks[string(stored.Salt)] = kek
+		}
+
+		raw, err := unwrapKey(kek, stored)
+		if err != nil {
+			return err
+		}
+
+		if k.keys[stored.Version], err = newDataKey(raw); err != nil {
+			return err
+		}
+
+		k.kek, k.salt, k.active = kek, stored.Salt, stored.Version
+	}
+
+	return nil
+}
+
+// generate creates a new data key, saves it wrapped and makes it the active one.
+func (k *fieldKeys) generate(tx *gorm.DB) (uint, error) {
+	raw := make([]byte, dataKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return 0, err
+	}
+
+	key, err := newDataKey(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	version := k.active + 1
+
+	wrapped, err := wrapKey(k.kek, version, raw)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Create(&DataKey{
+		Version:    version,
+		Salt:       k.salt,
+		WrappedKey: wrapped,
+		CreatedAt:  time.Now(),
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	k.keys[version] = key
+	k.active = version
+
+	return version, nil
+}
+
+// rewrap saves all data keys wrapped with a key derived from a new database key.
+func (k *fieldKeys) rewrap(tx *gorm.DB, key string) error {
+	salt := make([]byte, kekSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	kek := deriveKEK(key, salt)
+
+	for version, dataKey := range k.keys {
+		wrapped, err := wrapKey(kek, version, dataKey.raw)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&DataKey{Version: version}).Updates(&DataKey{Salt: salt, WrappedKey: wrapped}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	k.kek, k.salt = kek, salt
+
+	return nil
+}
+
+func (k *fieldKeys) get() *fieldKeys {
+	return k
+}
+
+func (k *fieldKeys) clone() *fieldKeys {
+	clone := &fieldKeys{kek: k.kek, salt: k.salt, active: k.active, keys: make(map[uint]*dataKey)}
+	for version, key := range k.keys {
+		clone.keys[version] = key
+	}
+
+	return clone
+}
+
+func (k *fieldKeys) prune(active uint) {
+	for version := range k.keys {
+		if version != active {
+			delete(k.keys, version)
+		}
+	}
+}
+
+// encrypt seals a value of a column with the active data key.
+func (k *fieldKeys) encrypt(column string, plaintext []byte, lookup bool) ([]byte, error) {
+	key := k.keys[k.active]
+	nonce := make([]byte, key.aead.NonceSize())
+
+	if lookup {
+		mac := hmac.New(sha256.New, key.mac)
+		mac.Write([]byte(column))
+		mac.Write([]byte{0})
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := key.aead.Seal(nonce, nonce, plaintext, []byte(column))
+
+	value := fmt.Appendf(nil, "%s%d:", encryptedPrefix, k.active)
+
+	return base64.RawStdEncoding.AppendEncode(value, sealed), nil
+}
+
+// decrypt opens a value of a column, with the data key whose version it is sealed with.
+func (k *fieldKeys) decrypt(column string, value []byte) ([]byte, error) {
+	version, sealed, err := parseEncrypted(value)
+	if err != nil {
+		return nil, err
+	}
+
+	key, found := k.keys[version]
+	if !found {
+		return nil, fmt.Errorf("%w: no data key version %d for column %s", ErrEncryptionKey, version, column)
+	}
+
+	nonceSize := key.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("%w: invalid value for column %s", ErrEncryptionKey, column)
+	}
+
+	plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(column))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decrypt column %s: %w", ErrEncryptionKey, column, err)
+	}
+
+	return plaintext, nil
+}
+
+func isEncrypted(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(encryptedPrefix))
+}
+
+// parseEncrypted returns the data key version and the sealed bytes of an encrypted value.
+func parseEncrypted(value []byte) (uint, []byte, error) {
+	version, encoded, found := bytes.Cut(bytes.TrimPrefix(value, []byte(encryptedPrefix)), []byte(":"))
+	if !found {
+		return 0, nil, fmt.Errorf("%w: invalid encrypted value", ErrEncryptionKey)
+	}
+
+	number, err := strconv.ParseUint(string(version), 10, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: invalid encrypted value: %w", ErrEncryptionKey, err)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: invalid encrypted value: %w", ErrEncryptionKey, err)
+	}
+
+	return uint(number), sealed, nil
+}
+
+func deriveKEK(key string, salt []byte) []byte {
+	return argon2.IDKey([]byte(key), salt, 1, 64*1024, 4, 32)
+}
+
+func wrapKey(kek []byte, version uint, raw []byte) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, raw, []byte(strconv.FormatUint(uint64(version), 10))), nil
+}
+
+func unwrapKey(kek []byte, stored DataKey) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncryptionKey, err)
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(stored.WrappedKey) < nonceSize {
+		return nil, fmt.Errorf("%w: invalid data key version %d", ErrEncryptionKey, stored.Version)
+	}
+
+	aad := []byte(strconv.FormatUint(uint64(stored.Version), 10))
+
+	raw, err := aead.Open(nil, stored.WrappedKey[:nonceSize], stored.WrappedKey[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrong database key (data key version %d)", ErrEncryptionKey, stored.Version)
+	}
+
+	return raw, nil
+}
+
+func newDataKey(raw []byte) (*dataKey, error) {
+	if len(raw) != dataKeySize {
+		return nil, fmt.Errorf("%w: invalid data key size", ErrEncryptionKey)
+	}
+
+	aead, err := newAEAD(raw[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataKey{raw: raw, aead: aead, mac: raw[32:]}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encryptFields encrypts with the active data key all values of the sensitive
+// columns stored in cleartext or with another data key.
+func encryptFields(tx *gorm.DB, keys *fieldKeys) (int, error) {
+	return rewriteFields(tx, func(field *schema.Field, value []byte) (any, error) {
+		return reencrypt(keys, field, value)
+	})
+}
+
+// decryptFields stores in cleartext all encrypted values of the sensitive columns.
+func decryptFields(tx *gorm.DB, keys *fieldKeys) (int, error) {
+	return rewriteFields(tx, func(field *schema.Field, value []byte) (any, error) {
+		if !isEncrypted(value) {
+			return nil, nil
+		}
+
+		plaintext, err := keys.decrypt(field.DBName, value)
+		if err != nil || field.FieldType.Kind() != reflect.String {
+			return plaintext, err
+		}
+
+		return string(plaintext), nil
+	})
+}
+
+// rewriteFields updates all values of the sensitive columns with the ones returned by
+// rewrite, unless nil. Values are read and written directly, without the serializers
+// of their columns, and the number of values updated is returned.
+func rewriteFields(tx *gorm.DB, rewrite func(field *schema.Field, value []byte) (any, error)) (int, error) {
+	var count int
+
+	for _, model := range Models(tx) {
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err != nil {
+			return count, err
+		}
+
+		primary := stmt.Schema.PrioritizedPrimaryField
+		fields := encryptedFields(stmt.Schema)
+
+		if primary == nil || len(fields) == 0 {
+			continue
+		}
+
+		columns := []string{primary.DBName}
+		for _, field := range fields {
+			columns = append(columns, field.DBName)
+		}
+
+		rows, err := readColumns(tx.Table(stmt.Schema.Table).Select(columns), len(columns))
+		if err != nil {
+			return count, err
+		}
+
+		for _, row := range rows {
+			updates := make(map[string]any)
+
+			for i, field := range fields {
+				value, err := rewrite(field, row[i+1])
+				if err != nil {
+					return count, err
+				}
+
+				if value != nil {
+					updates[field.DBName] = value
+				}
+			}
+
+			if len(updates) == 0 {
+				continue
+			}
+
+			err := tx.Table(stmt.Schema.Table).Where(primary.DBName+" = ?", string(row[0])).Updates(updates).Error
+			if err != nil {
+				return count, err
+			}
+
+			count += len(updates)
+		}
+	}
+
+	return count, nil
+}
+
+// reencrypt returns the value of a column sealed with the active data
+// key, or nil if the value is empty or already sealed with this key.
+func reencrypt(keys *fieldKeys, field *schema.Field, value []byte) (any, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	plaintext := value
+
+	if isEncrypted(value) {
+		version, _, err := parseEncrypted(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if version == keys.active {
+			return nil, nil
+		}
+
+		if plaintext, err = keys.decrypt(field.DBName, value); err != nil {
+			return nil, err
+		}
+	}
+
+	sealed, err := keys.encrypt(field.DBName, plaintext, isLookupField(field))
+	if err != nil {
+		return nil, err
+	}
+
+	if field.FieldType.Kind() == reflect.String {
+		return string(sealed), nil
+	}
+
+	return sealed, nil
+}
+
+// readColumns reads all rows of a query as raw column values, all loaded
+// before returning, since SQLite clients have a single connection.
+func readColumns(query *gorm.DB, count int) ([][][]byte, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values [][][]byte
+
+	for rows.Next() {
+		row := make([][]byte, count)
+		dest := make([]any, count)
+
+		for i := range row {
+			dest[i] = &row[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		values = append(values, row)
+	}
+
+	return values, rows.Err()
+}
+
+func encryptedFields(sch *schema.Schema) []*schema.Field {
+	var fields []*schema.Field
+
+	for _, field := range sch.Fields {
+		if _, encrypted := field.Serializer.(fieldSerializer); encrypted {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+func isLookupField(field *schema.Field) bool {
+	serializer, _ := field.Serializer.(fieldSerializer)
+	return serializer.lookup
+}
+
+// fieldSerializer encrypts and decrypts the values of a sensitive column
+// (string or bytes) with the data keys found in the statement context.
+type fieldSerializer struct {
+	lookup bool
+}
+
+// Scan implements the gorm schema.SerializerInterface.
+func (s fieldSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var data []byte
+
+	switch value := dbValue.(type) {
+	case nil:
+	case []byte:
+		data = bytes.Clone(value)
+	case string:
+		data = []byte(value)
+	default:
+		return fmt.Errorf("%w: unsupported value %T for column %s", ErrEncryptionKey, dbValue, field.DBName)
+	}
+
+	if isEncrypted(data) {
+		keys := fieldKeysFrom(ctx)
+		if keys == nil {
+			return fmt.Errorf("%w: column %s is encrypted, but no database key is set", ErrEncryptionKey, field.DBName)
+		}
+
+		plaintext, err := keys.decrypt(field.DBName, data)
+		if err != nil {
+			return err
+		}
+
+		data = plaintext
+	}
+
+	value := reflect.New(field.FieldType).Elem()
+
+	if field.FieldType.Kind() == reflect.String {
+		value.SetString(string(data))
+	} else {
+		value.SetBytes(data)
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(value)
+
+	return nil
+}
+
+// Value implements the gorm schema.SerializerValuerInterface.
+func (s fieldSerializer) Value(ctx context.Context, field *schema.Field, _ reflect.Value, fieldValue any) (any, error) {
+	var data []byte
+
+	switch value := fieldValue.(type) {
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	}
+
+	keys := fieldKeysFrom(ctx)
+	if len(data) == 0 || keys == nil {
+		return fieldValue, nil
+	}
+
+	sealed, err := keys.encrypt(field.DBName, data, s.lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	if field.FieldType.Kind() == reflect.String {
+		return string(sealed), nil
+	}
+
+	return sealed, nil
+}
+
+// keysProvider returns the data keys used by statements: either a fixed set
+// of keys, or the current keys of the plugin, which change when rotated.
+type keysProvider interface {
+	get() *fieldKeys
+}
+
+type fieldKeysContext struct{}
+
+func withFieldKeys(ctx context.Context, keys keysProvider) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return context.WithValue(ctx, fieldKeysContext{}, keys)
+}
+
+func fieldKeysFrom(ctx context.Context) *fieldKeys {
+	if ctx == nil {
+		return nil
+	}
+
+	keys, found := ctx.Value(fieldKeysContext{}).(keysProvider)
+	if !found {
+		return nil
+	}
+
+	return keys.get()
+}
+
+// fieldEncryption is the gorm plugin adding the data keys to the context of all
+// statements, so that values are never written in cleartext, even by a session
+// whose context was replaced. Conditions being built before statements are run,
+// clients must also carry the keys in their base context (see EnableEncryption).
+type fieldEncryption struct {
+	mutex sync.RWMutex
+	keys  *fieldKeys
+}
+
+// Name implements the gorm.Plugin interface.
+func (p *fieldEncryption) Name() string {
+	return encryptionPlugin
+}
+
+// Initialize implements the gorm.Plugin interface.
+func (p *fieldEncryption) Initialize(dbClient *gorm.DB) error {
+	callbacks := dbClient.Callback()
+
+	for _, err := range []error{
+		callbacks.Create().Before("*").Register(encryptionPlugin, p.withKeys),
+		callbacks.Query().Before("*").Register(encryptionPlugin, p.withKeys),
+		callbacks.Update().Before("*").Register(encryptionPlugin, p.withKeys),
+		callbacks.Delete().Before("*").Register(encryptionPlugin, p.withKeys),
+		callbacks.Row().Before("*").Register(encryptionPlugin, p.withKeys),
+		callbacks.Raw().Before("*").Register(encryptionPlugin, p.withKeys),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *fieldEncryption) withKeys(tx *gorm.DB) {
+	if fieldKeysFrom(tx.Statement.Context) == nil {
+		tx.Statement.Context = withFieldKeys(tx.Statement.Context, p)
+	}
+}
+
+func (p *fieldEncryption) get() *fieldKeys {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.keys
+}
+
+func (p *fieldEncryption) set(keys *fieldKeys) {
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+}
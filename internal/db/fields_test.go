@@ -0,0 +1,87 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// TestFieldEncryption checks that enabling field encryption encrypts the existing
+// cleartext values, that encrypted values are transparently read and looked up,
+// and that a wrong database key cannot unwrap the data key.
+func TestFieldEncryption(t *testing.T) {
+	client, err := NewClient(&Config{
+		Dialect:      Sqlite,
+		Database:     SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	if err := client.Create(&Credential{UserName: "alice", Label: DefaultCredential, Token: "hash"}).Error; err != nil {
+		t.Fatalf("creating a cleartext credential: %v", err)
+	}
+
+	if err := client.Create(&Secret{Name: "ca/user.key", Data: []byte("private key")}).Error; err != nil {
+		t.Fatalf("creating a cleartext secret: %v", err)
+	}
+
+	if _, err := EnableEncryption(client.Session(&gorm.Session{}), ""); !errors.Is(err, ErrEncryptionKey) {
+		t.Fatalf("enabling encryption without a key should fail, got %v", err)
+	}
+
+	encrypted, err := EnableEncryption(client, "key")
+	if err != nil {
+		t.Fatalf("EnableEncryption: %v", err)
+	}
+
+	var token string
+	if err := encrypted.Raw("SELECT token FROM credentials").Scan(&token).Error; err != nil || !strings.HasPrefix(token, encryptedPrefix) {
+		t.Fatalf("existing token should have been encrypted, got %q (%v)", token, err)
+	}
+
+	var data []byte
+	if err := encrypted.Raw("SELECT data FROM secrets").Row().Scan(&data); err != nil || bytes.Contains(data, []byte("private key")) {
+		t.Fatalf("existing secret should have been encrypted, got %q (%v)", data, err)
+	}
+
+	cred := &Credential{}
+	if err := encrypted.Where(&Credential{Token: "hash"}).First(cred).Error; err != nil || cred.Token != "hash" {
+		t.Fatalf("looking up an encrypted token: %+v (%v)", cred, err)
+	}
+
+	secret := &Secret{}
+	if err := encrypted.First(secret, "name = ?", "ca/user.key").Error; err != nil || string(secret.Data) != "private key" {
+		t.Fatalf("reading an encrypted secret: %q (%v)", secret.Data, err)
+	}
+
+	if _, err := EnableEncryption(client, "wrong"); !errors.Is(err, ErrEncryptionKey) {
+		t.Fatalf("enabling encryption with a wrong key should fail with ErrEncryptionKey, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+)
+
+// Invitation - A single-use code with which a teamclient can enroll
+// for a user credential, sending its own certificate request.
+type Invitation struct {
+	ID        uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt time.Time `gorm:"->;<-:create;"`
+	UserName  string
+	Label     string
+	Code      string `gorm:"uniqueIndex;serializer:encrypted_lookup"` // Hashed, like tokens.
+	ExpiresAt time.Time
+}
+
+// BeforeCreate - GORM hook.
+func (i *Invitation) BeforeCreate(tx *gorm.DB) (err error) {
+	i.ID, err = uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	i.CreatedAt = time.Now()
+
+	return nil
+}
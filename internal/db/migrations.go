@@ -0,0 +1,284 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	v1 "github.com/reeflective/team/internal/db/v1"
+	v3 "github.com/reeflective/team/internal/db/v3"
+)
+
+var (
+	// ErrMigration - A database migration failed, or cannot be applied or rolled back.
+	ErrMigration = errors.New("database migration")
+
+	// ErrSchemaVersion - The database schema is more recent than the teamserver.
+	ErrSchemaVersion = errors.New("database schema is newer than this teamserver")
+)
+
+// Migration - A versioned change of the database schema or data. Migrations are
+// applied in order of version, each in its own transaction, and recorded in the
+// schema_migrations table. A migration without Down step cannot be rolled back.
+type Migration struct {
+	Version     uint
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// SchemaMigration - A migration applied to the database.
+type SchemaMigration struct {
+	Version     uint `gorm:"primaryKey;autoIncrement:false"`
+	Description string
+	AppliedAt   time.Time
+}
+
+// MigrationStatus - A migration known by the teamserver, or found applied in the
+// database (in which case it has been applied by a more recent teamserver).
+type MigrationStatus struct {
+	Version     uint
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+	Known       bool // The migration is known by this teamserver.
+	Reversible  bool // The migration has a Down step.
+}
+
+// Migrations returns all the migrations of the teamserver database, in order.
+//
+// The first one creates the tables (or updates the ones of databases created before
+// versioned migrations) with the frozen models of the version 1 schema: later changes
+// of the models must come with their own migration, adding indexes, renaming columns
+// or moving data as needed. It cannot be rolled back, which would drop all the tables.
+func Migrations() []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "Create the teamserver tables",
+			Up: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(v1.Schema()...)
+			},
+		},
+		{
+			Version:     2,
+			Description: "Move the users tokens into default credentials",
+			Up:          migrateUserTokens,
+			Down: func(*gorm.DB) error {
+				// Credentials are part of the version 1 tables: the tokens
+				// can stay there, and the legacy column is not used anymore.
+				return nil
+			},
+		},
+		{
+			Version:     3,
+			Description: "Make the credential labels unique for each user",
+			Up:          uniqueCredentialLabels,
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropIndex(&v3.Credential{}, v3.CredentialIndex)
+			},
+		},
+	}
+}
+
+// LatestVersion returns the version of the most recent migration.
+func LatestVersion() uint {
+	migrations := Migrations()
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies all pending migrations to the database, and creates or updates the
+// tables of the application models (see RegisterModels). It fails without changing
+// anything if the database schema is more recent than the teamserver.
+func Migrate(dbClient *gorm.DB) error {
+	_, err := MigrateTo(dbClient, LatestVersion())
+	return err
+}
+
+// SchemaVersion returns the version of the last migration applied to the database,
+// or 0 if none was (a new database, or one created before versioned migrations).
+func SchemaVersion(dbClient *gorm.DB) (uint, error) {
+	if err := dbClient.AutoMigrate(&SchemaMigration{}); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrMigration, err)
+	}
+
+	var version uint
+
+	err := dbClient.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrMigration, err)
+	}
+
+	return version, nil
+}
+
+// MigrationsStatus returns the status of all migrations, known or applied, in order.
+func MigrationsStatus(dbClient *gorm.DB) ([]MigrationStatus, error) {
+	if err := dbClient.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMigration, err)
+	}
+
+	var applied []SchemaMigration
+	if err := dbClient.Order("version").Find(&applied).Error; err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMigration, err)
+	}
+
+	appliedAt := make(map[uint]SchemaMigration)
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration
+	}
+
+	var status []MigrationStatus
+
+	for _, migration := range Migrations() {
+		record, isApplied := appliedAt[migration.Version]
+		delete(appliedAt, migration.Version)
+
+		status = append(status, MigrationStatus{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Applied:     isApplied,
+			AppliedAt:   record.AppliedAt,
+			Known:       true,
+			Reversible:  migration.Down != nil,
+		})
+	}
+
+	for _, migration := range applied {
+		if _, unknown := appliedAt[migration.Version]; unknown {
+			status = append(status, MigrationStatus{
+				Version:     migration.Version,
+				Description: migration.Description,
+				Applied:     true,
+				AppliedAt:   migration.AppliedAt,
+			})
+		}
+	}
+
+	return status, nil
+}
+
+// MigrateTo applies the pending migrations up to the given version, and returns them.
+// Once at the latest version, the tables of the application models are migrated too.
+func MigrateTo(dbClient *gorm.DB, version uint) ([]Migration, error) {
+	current, err := checkSchemaVersion(dbClient)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > LatestVersion() {
+		return nil, fmt.Errorf("%w: unknown version %d (latest is %d)", ErrMigration, version, LatestVersion())
+	}
+
+	var applied []Migration
+
+	for _, migration := range Migrations() {
+		if migration.Version <= current || migration.Version > version {
+			continue
+		}
+
+		err := dbClient.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaMigration{
+				Version:     migration.Version,
+				Description: migration.Description,
+				AppliedAt:   time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("%w: version %d (%s): %w", ErrMigration, migration.Version, migration.Description, err)
+		}
+
+		applied = append(applied, migration)
+	}
+
+	if version == LatestVersion() {
+		return applied, migrateApplicationModels(dbClient)
+	}
+
+	return applied, nil
+}
+
+// Rollback rolls back the migrations applied after the given version, most recent
+// first, and returns them. It fails before rolling back anything if one of them
+// cannot be rolled back, or if the database schema is more recent than the teamserver.
+func Rollback(dbClient *gorm.DB, version uint) ([]Migration, error) {
+	current, err := checkSchemaVersion(dbClient)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := Migrations()
+
+	var pending []Migration
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if migration.Version <= version || migration.Version > current {
+			continue
+		}
+
+		if migration.Down == nil {
+			return nil, fmt.Errorf("%w: version %d (%s) cannot be rolled back", ErrMigration, migration.Version, migration.Description)
+		}
+
+		pending = append(pending, migration)
+	}
+
+	var rolledBack []Migration
+
+	for _, migration := range pending {
+		err := dbClient.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+
+			return tx.Delete(&SchemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return rolledBack, fmt.Errorf("%w: version %d (%s): %w", ErrMigration, migration.Version, migration.Description, err)
+		}
+
+		rolledBack = append(rolledBack, migration)
+	}
+
+	return rolledBack, nil
+}
+
+// checkSchemaVersion returns the current schema version, or
+// an error if the schema is more recent than the teamserver.
+func checkSchemaVersion(dbClient *gorm.DB) (uint, error) {
+	current, err := SchemaVersion(dbClient)
+	if err != nil {
+		return 0, err
+	}
+
+	if current > LatestVersion() {
+		return current, fmt.Errorf("%w (version %d, latest known is %d)", ErrSchemaVersion, current, LatestVersion())
+	}
+
+	return current, nil
+}
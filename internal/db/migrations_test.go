@@ -0,0 +1,96 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestMigrations checks that migrations are applied once and in order, rolled back,
+// and that a database whose schema is more recent than the teamserver is refused.
+func TestMigrations(t *testing.T) {
+	dbClient, err := Open(&Config{
+		Dialect:      Sqlite,
+		Database:     SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if version, err := SchemaVersion(dbClient); err != nil || version != 0 {
+		t.Fatalf("new database should be at version 0, got %d (%v)", version, err)
+	}
+
+	applied, err := MigrateTo(dbClient, 1)
+	if err != nil || len(applied) != 1 {
+		t.Fatalf("MigrateTo(1) should create the tables: %d applied (%v)", len(applied), err)
+	}
+
+	// The frozen version 1 models create the tables of the current ones.
+	for _, model := range Schema() {
+		if !dbClient.Migrator().HasTable(model) {
+			t.Fatalf("MigrateTo(1) should create the table of %T", model)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Migrate(dbClient); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+	}
+
+	if version, _ := SchemaVersion(dbClient); version != LatestVersion() {
+		t.Fatalf("database should be at version %d, got %d", LatestVersion(), version)
+	}
+
+	if _, err := Rollback(dbClient, 0); !errors.Is(err, ErrMigration) || !dbClient.Migrator().HasTable(&User{}) {
+		t.Fatalf("the tables should never be dropped by a rollback, got %v", err)
+	}
+
+	rolledBack, err := Rollback(dbClient, 1)
+	if err != nil || len(rolledBack) != int(LatestVersion())-1 {
+		t.Fatalf("Rollback(1): %d rolled back (%v)", len(rolledBack), err)
+	}
+
+	if err := Migrate(dbClient); err != nil {
+		t.Fatalf("Migrate after rollback: %v", err)
+	}
+
+	// A migration applied by a more recent teamserver.
+	newer := &SchemaMigration{Version: LatestVersion() + 1, Description: "newer", AppliedAt: time.Now()}
+	if err := dbClient.Create(newer).Error; err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	if err := Migrate(dbClient); !errors.Is(err, ErrSchemaVersion) {
+		t.Fatalf("migrating a newer schema should fail with ErrSchemaVersion, got %v", err)
+	}
+
+	status, err := MigrationsStatus(dbClient)
+	if err != nil || len(status) != int(LatestVersion())+1 || status[len(status)-1].Known {
+		t.Fatalf("status should include the unknown migration: %+v (%v)", status, err)
+	}
+}
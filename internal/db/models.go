@@ -0,0 +1,157 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// ErrModel - A model registered by the application is invalid.
+var ErrModel = errors.New("database model")
+
+const modelsPlugin = "team:models"
+
+// RegisterModels registers models of the application with a database client: their tables
+// are created and updated (AutoMigrate) once the teamserver migrations are applied, their
+// encrypted columns (tagged with the "encrypted" or "encrypted_lookup" serializer) are
+// encrypted with the database key, and their rows are backed up and copied along with
+// the teamserver ones (see Models). Open registers the models of its configuration.
+//
+// Models whose table is one of the teamserver ones, or the one of another registered
+// model, are refused: none of the models given is registered in this case.
+func RegisterModels(dbClient *gorm.DB, models ...any) error {
+	tables := make([]string, len(models))
+
+	for i, model := range models {
+		table, err := tableName(dbClient, model)
+		if err != nil {
+			return fmt.Errorf("%w: %T: %w", ErrModel, model, err)
+		}
+
+		tables[i] = table
+	}
+
+	for _, model := range append(Schema(), &SchemaMigration{}) {
+		table, err := tableName(dbClient, model)
+		if err != nil {
+			return fmt.Errorf("%w: %T: %w", ErrModel, model, err)
+		}
+
+		if i := slices.Index(tables, table); i >= 0 {
+			return fmt.Errorf("%w: %T: table %s is a teamserver table", ErrModel, models[i], table)
+		}
+	}
+
+	plugin, registered := dbClient.Config.Plugins[modelsPlugin].(*registeredModels)
+	if !registered {
+		plugin = &registeredModels{}
+		if err := dbClient.Use(plugin); err != nil {
+			return fmt.Errorf("%w: %w", ErrModel, err)
+		}
+	}
+
+	return plugin.add(models, tables)
+}
+
+// Models returns the models of the teamserver (Schema), followed
+// by the ones registered by the application with the client.
+func Models(dbClient *gorm.DB) []any {
+	return append(Schema(), applicationModels(dbClient)...)
+}
+
+func applicationModels(dbClient *gorm.DB) []any {
+	plugin, registered := dbClient.Config.Plugins[modelsPlugin].(*registeredModels)
+	if !registered {
+		return nil
+	}
+
+	return plugin.get()
+}
+
+// migrateApplicationModels creates or updates the tables of the application models.
+func migrateApplicationModels(dbClient *gorm.DB) error {
+	models := applicationModels(dbClient)
+	if len(models) == 0 {
+		return nil
+	}
+
+	if err := dbClient.AutoMigrate(models...); err != nil {
+		return fmt.Errorf("%w: %w", ErrModel, err)
+	}
+
+	return nil
+}
+
+// registeredModels is the gorm plugin holding the models registered by the
+// application, shared by all the sessions and transactions of a client.
+type registeredModels struct {
+	mutex  sync.RWMutex
+	models []any
+	tables map[string]any // Registered model of each table.
+}
+
+// Name implements the gorm.Plugin interface.
+func (p *registeredModels) Name() string {
+	return modelsPlugin
+}
+
+// Initialize implements the gorm.Plugin interface.
+func (p *registeredModels) Initialize(*gorm.DB) error {
+	return nil
+}
+
+// add registers models with their tables, unless one of the tables is already registered.
+func (p *registeredModels) add(models []any, tables []string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.tables == nil {
+		p.tables = make(map[string]any)
+	}
+
+	for i, table := range tables {
+		if registered, found := p.tables[table]; found {
+			return fmt.Errorf("%w: %T: table %s is already the one of %T", ErrModel, models[i], table, registered)
+		}
+
+		if j := slices.Index(tables[:i], table); j >= 0 {
+			return fmt.Errorf("%w: %T: table %s is already the one of %T", ErrModel, models[i], table, models[j])
+		}
+	}
+
+	for i, table := range tables {
+		p.tables[table] = models[i]
+	}
+
+	p.models = append(p.models, models...)
+
+	return nil
+}
+
+func (p *registeredModels) get() []any {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return append([]any(nil), p.models...)
+}
@@ -0,0 +1,120 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+type note struct {
+	ID   uint
+	Text string `gorm:"serializer:encrypted"`
+}
+
+// TestRegisterModels checks that application models are migrated with prefixed
+// tables, that their columns are encrypted and that they are exported.
+func TestRegisterModels(t *testing.T) {
+	dbClient, err := NewClient(&Config{
+		Dialect:       Sqlite,
+		Database:      SQLiteInMemoryHost,
+		MaxIdleConns:  1,
+		MaxOpenConns:  1,
+		LogLevel:      "error",
+		EncryptionKey: "key",
+		TablePrefix:   "app_",
+		Models:        []any{&note{}},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	for _, table := range []string{"app_notes", "app_users", "app_schema_migrations"} {
+		if !dbClient.Migrator().HasTable(table) {
+			t.Fatalf("table %s not created", table)
+		}
+	}
+
+	if err := dbClient.Create(&note{Text: "secret note"}).Error; err != nil {
+		t.Fatalf("Create(note): %v", err)
+	}
+
+	var stored string
+	if err := dbClient.Table("app_notes").Select("text").Row().Scan(&stored); err != nil {
+		t.Fatalf("read raw column: %v", err)
+	} else if !isEncrypted([]byte(stored)) {
+		t.Fatal("application model column stored in cleartext")
+	}
+
+	_, tables, err := Export(dbClient)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	} else if _, found := tables["app_notes"]; !found {
+		t.Fatal("application model table not exported")
+	}
+
+	if err := RegisterModels(dbClient, 42); !errors.Is(err, ErrModel) {
+		t.Fatalf("RegisterModels(invalid) = %v, want ErrModel", err)
+	}
+}
+
+type memo struct {
+	ID   uint
+	Text string
+}
+
+// users collides with the table of the teamserver users.
+type users struct {
+	ID   uint
+	Name string
+}
+
+// TestRegisterModelsCollisions checks that models whose table is a teamserver one,
+// or the one of another registered model, are refused without registering any model.
+func TestRegisterModelsCollisions(t *testing.T) {
+	dbClient, err := NewClient(&Config{
+		Dialect:      Sqlite,
+		Database:     SQLiteInMemoryHost,
+		MaxIdleConns: 1,
+		MaxOpenConns: 1,
+		LogLevel:     "error",
+		Models:       []any{&note{}},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	for _, models := range [][]any{
+		{&users{}},
+		{&SchemaMigration{}},
+		{&note{}},
+		{&memo{}, &users{}},
+		{&memo{}, &memo{}},
+	} {
+		if err := RegisterModels(dbClient, models...); !errors.Is(err, ErrModel) {
+			t.Fatalf("RegisterModels(%T) = %v, want ErrModel", models, err)
+		}
+	}
+
+	if models := applicationModels(dbClient); len(models) != 1 {
+		t.Fatalf("refused models should not be registered, got %d models", len(models))
+	}
+}
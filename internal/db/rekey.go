@@ -0,0 +1,224 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// ErrRekey - An SQLite database file cannot be encrypted, decrypted or re-keyed.
+var ErrRekey = errors.New("database rekey")
+
+// Rekey replaces an on-disk SQLite database with a copy encrypted with a new key (or
+// stored in cleartext if empty), whose encrypted columns use the new key as well. The
+// copy is written next to the database file, then verified: it must open with the new
+// key, pass an integrity check and hold as many rows as the database. Only then is the
+// client closed and the database file atomically replaced with the copy, which is
+// opened with the new key and returned. On error, the database is left unchanged,
+// and the client still usable, unless it could not be closed. If the file cannot be
+// replaced once the client is closed, the database is opened again and returned with
+// the error. If the copy cannot be opened once swapped, the error is returned alone.
+//
+// Changes written by other clients of the database while it is copied are not in the
+// copy: the teamserver keeps running meanwhile, but should be otherwise idle.
+func Rekey(dbClient *gorm.DB, dbConfig *Config, key string, dbLogger *slog.Logger) (*gorm.DB, error) {
+	if dbConfig.Dialect != Sqlite || dbConfig.Database == SQLiteInMemoryHost {
+		return nil, fmt.Errorf("%w: not an on-disk SQLite database", ErrRekey)
+	}
+
+	newConfig := *dbConfig
+	newConfig.EncryptionKey = key
+
+	copyConfig := newConfig
+	copyConfig.Database = dbConfig.Database + ".rekey"
+
+	removeDatabaseFiles(copyConfig.Database)
+
+	if err := copyDatabase(dbClient, dbConfig, &copyConfig, dbLogger); err != nil {
+		removeDatabaseFiles(copyConfig.Database)
+		return nil, fmt.Errorf("%w: %w", ErrRekey, err)
+	}
+
+	if err := verifyCopy(dbClient, &copyConfig, dbLogger); err != nil {
+		removeDatabaseFiles(copyConfig.Database)
+		return nil, fmt.Errorf("%w: copy verification failed: %w", ErrRekey, err)
+	}
+
+	if err := closeClient(dbClient); err != nil {
+		removeDatabaseFiles(copyConfig.Database)
+		return nil, fmt.Errorf("%w: %w", ErrRekey, err)
+	}
+
+	if err := renameFile(copyConfig.Database, dbConfig.Database); err != nil {
+		removeDatabaseFiles(copyConfig.Database)
+
+		dbClient, openErr := openWithKey(dbConfig, dbLogger)
+		if openErr != nil {
+			return nil, fmt.Errorf("%w: failed to replace the database file: %w (and to open it again: %w)", ErrRekey, err, openErr)
+		}
+
+		return dbClient, fmt.Errorf("%w: failed to replace the database file: %w", ErrRekey, err)
+	}
+
+	// Leftover journals of the previous file must not be applied to the new one.
+	for _, suffix := range journalSuffixes {
+		os.Remove(dbConfig.Database + suffix)
+	}
+
+	return openWithKey(&newConfig, dbLogger)
+}
+
+// openWithKey opens a database, and enables the encryption of its columns with its key, if any.
+func openWithKey(dbConfig *Config, dbLogger *slog.Logger) (*gorm.DB, error) {
+	dbClient, err := Open(dbConfig, dbLogger)
+	if err != nil || dbConfig.EncryptionKey == "" {
+		return dbClient, err
+	}
+
+	return EnableEncryption(dbClient, dbConfig.EncryptionKey)
+}
+
+var journalSuffixes = []string{"-journal", "-wal", "-shm"}
+
+// renameFile replaces the database file with its copy (replaced by tests to make it fail).
+var renameFile = os.Rename
+
+// copyDatabase writes a copy of the database encrypted with the key of the copy
+// config, and changes the key of its encrypted columns to this same key.
+func copyDatabase(dbClient *gorm.DB, dbConfig, copyConfig *Config, dbLogger *slog.Logger) error {
+	info, err := os.Stat(dbConfig.Database)
+	if err != nil {
+		return err
+	}
+
+	target := *copyConfig
+
+	// Without a key, the copy would be attached with the (encrypting) VFS of the database.
+	if target.EncryptionKey == "" && dbConfig.EncryptionKey != "" {
+		target.Params = maps.Clone(target.Params)
+		if target.Params == nil {
+			target.Params = make(map[string]string)
+		}
+
+		target.Params["vfs"] = "os"
+	}
+
+	dsn, err := target.DSN()
+	if err != nil {
+		return err
+	}
+
+	// The DSN of the copy holds its key, which must not be logged with the query.
+	quiet := dbClient.Session(&gorm.Session{Logger: logger.Discard})
+	if err := quiet.Exec("VACUUM INTO ?", dsn).Error; err != nil {
+		return fmt.Errorf("failed to copy the database: %w", err)
+	}
+
+	if err := os.Chmod(copyConfig.Database, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	copyClient, err := Open(copyConfig, dbLogger)
+	if err != nil {
+		return err
+	}
+	defer closeClient(copyClient)
+
+	_, err = changeEncryptionKey(copyClient, dbConfig.EncryptionKey, copyConfig.EncryptionKey)
+
+	return err
+}
+
+// verifyCopy opens the copy of a database with its key, checks its integrity,
+// the data keys of its encrypted columns, and that its tables hold as many rows.
+func verifyCopy(dbClient *gorm.DB, copyConfig *Config, dbLogger *slog.Logger) error {
+	copyClient, err := Open(copyConfig, dbLogger)
+	if err != nil {
+		return err
+	}
+	defer closeClient(copyClient)
+
+	var result string
+	if err := copyClient.Raw("PRAGMA integrity_check").Scan(&result).Error; err != nil {
+		return err
+	} else if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+
+	if copyConfig.EncryptionKey != "" {
+		keys := &fieldKeys{}
+		if err := keys.load(copyClient, copyConfig.EncryptionKey); err != nil {
+			return err
+		}
+	} else {
+		var count int64
+		if err := copyClient.Model(&DataKey{}).Count(&count).Error; err != nil {
+			return err
+		} else if count > 0 {
+			return errors.New("data keys left in a database without key")
+		}
+	}
+
+	for _, model := range Models(copyClient) {
+		if _, isKey := model.(*DataKey); isKey {
+			continue
+		}
+
+		var count, copyCount int64
+
+		if err := dbClient.Model(model).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if err := copyClient.Model(model).Count(&copyCount).Error; err != nil {
+			return err
+		}
+
+		if count != copyCount {
+			table, _ := tableName(copyClient, model)
+			return fmt.Errorf("table %s has %d rows instead of %d (changed while copied?)", table, copyCount, count)
+		}
+	}
+
+	return nil
+}
+
+func closeClient(dbClient *gorm.DB) error {
+	sqlDB, err := dbClient.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
+func removeDatabaseFiles(path string) {
+	os.Remove(path)
+
+	for _, suffix := range journalSuffixes {
+		os.Remove(path + suffix)
+	}
+}
@@ -0,0 +1,55 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"gorm.io/gorm"
+)
+
+// RevokedCertificate - A certificate revoked by the teamserver, which must not be
+// accepted anymore even if it is still cryptographically valid against its CA.
+// Revocations are never deleted: they are the source of the exported X.509 CRL.
+type RevokedCertificate struct {
+	ID           uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt    time.Time `gorm:"->;<-:create;"`
+	SerialNumber string    `gorm:"uniqueIndex"` // Hex-encoded serial number
+	CommonName   string
+	CAType       string
+	Reason       int // RFC 5280 CRLReason code
+	RevokedAt    time.Time
+}
+
+// BeforeCreate - GORM hook to automatically set values.
+func (r *RevokedCertificate) BeforeCreate(tx *gorm.DB) (err error) {
+	r.ID, err = uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	r.CreatedAt = time.Now()
+
+	if r.RevokedAt.IsZero() {
+		r.RevokedAt = r.CreatedAt
+	}
+
+	return nil
+}
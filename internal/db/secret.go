@@ -0,0 +1,29 @@
+package db
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import "time"
+
+// Secret - A private key (or other private material of the certificate infrastructure),
+// stored by name when the teamserver uses the database secret store.
+type Secret struct {
+	Name      string `gorm:"primaryKey"`
+	Data      []byte `gorm:"serializer:encrypted;type:bytes"`
+	UpdatedAt time.Time
+}
@@ -23,12 +23,8 @@ package db
 import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-func sqliteClient(dsn string, log logger.Interface) (*gorm.DB, error) {
-	return gorm.Open(sqlite.Open(dsn), &gorm.Config{
-		PrepareStmt: true,
-		Logger:      log,
-	})
+func sqliteClient(dsn string, config *gorm.Config) (*gorm.DB, error) {
+	return gorm.Open(sqlite.Open(dsn), config)
 }
@@ -28,16 +28,12 @@ import (
 	// (vfs=adiantum&textkey=...) transparently encrypts the database at rest.
 	_ "github.com/ncruces/go-sqlite3/vfs/adiantum"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-func sqliteClient(dsn string, log logger.Interface) (*gorm.DB, error) {
+func sqliteClient(dsn string, config *gorm.Config) (*gorm.DB, error) {
 	// Reuse a persistent on-disk cache of the compiled SQLite WASM module, so we
 	// don't pay the ~2s wazero compilation cost on every process start.
 	configureSQLiteRuntime()
 
-	return gorm.Open(gormlite.Open(dsn), &gorm.Config{
-		PrepareStmt: true,
-		Logger:      log,
-	})
+	return gorm.Open(gormlite.Open(dsn), config)
 }
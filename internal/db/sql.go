@@ -27,7 +27,7 @@ import (
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
 )
 
 const (
@@ -44,8 +44,31 @@ var (
 	ErrUnsupportedDialect = errors.New("Unknown/unsupported DB Dialect")
 )
 
-// NewClient initializes a database client connection to a backend specified in config.
+// NewClient initializes a database client connection to a backend specified in config,
+// applies all pending migrations and, with an encryption key, enables field encryption.
 func NewClient(dbConfig *Config, dbLogger *slog.Logger) (*gorm.DB, error) {
+	dbClient, err := Open(dbConfig, dbLogger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Migrate(dbClient); err != nil {
+		return nil, err
+	}
+
+	// The database key also encrypts the sensitive columns (private keys, token
+	// hashes), so that they are not readable as is in PostgreSQL or MySQL backends.
+	if dbConfig.EncryptionKey != "" {
+		return EnableEncryption(dbClient, dbConfig.EncryptionKey)
+	}
+
+	return dbClient, nil
+}
+
+// Open initializes a database client connection to a backend specified in config,
+// without applying any migration (see Migrate, MigrateTo and Rollback), and registers
+// the application models of the config with it.
+func Open(dbConfig *Config, dbLogger *slog.Logger) (*gorm.DB, error) {
 	var dbClient *gorm.DB
 
 	dsn, err := dbConfig.DSN()
@@ -53,15 +76,19 @@ func NewClient(dbConfig *Config, dbLogger *slog.Logger) (*gorm.DB, error) {
 		return nil, fmt.Errorf("Failed to marshal database DSN: %w", err)
 	}
 
-	// Logging middleware (queries)
-	dbLog := newGormLogger(dbLogger, dbConfig.LogLevel)
+	// Logging middleware (queries), and table names prefixed if needed.
+	gormConfig := &gorm.Config{
+		PrepareStmt:    true,
+		Logger:         newGormLogger(dbLogger, dbConfig.LogLevel),
+		NamingStrategy: schema.NamingStrategy{TablePrefix: dbConfig.TablePrefix},
+	}
 	logDbDsn := fmt.Sprintf("%s (%s:%d)", dbConfig.Database, dbConfig.Host, dbConfig.Port)
 
 	switch dbConfig.Dialect {
 	case Sqlite:
 		dbLogger.Debug(fmt.Sprintf("Connecting to SQLite database %s", logDbDsn))
 
-		dbClient, err = sqliteClient(dsn, dbLog)
+		dbClient, err = sqliteClient(dsn, gormConfig)
 		if err != nil {
 			return nil, fmt.Errorf("Database connection failed: %w", err)
 		}
@@ -69,7 +96,7 @@ func NewClient(dbConfig *Config, dbLogger *slog.Logger) (*gorm.DB, error) {
 	case Postgres:
 		dbLogger.Debug(fmt.Sprintf("Connecting to PostgreSQL database %s", logDbDsn))
 
-		dbClient, err = postgresClient(dsn, dbLog)
+		dbClient, err = postgresClient(dsn, gormConfig)
 		if err != nil {
 			return nil, fmt.Errorf("Database connection failed: %w", err)
 		}
@@ -77,7 +104,7 @@ func NewClient(dbConfig *Config, dbLogger *slog.Logger) (*gorm.DB, error) {
 	case MySQL:
 		dbLogger.Debug(fmt.Sprintf("Connecting to MySQL database %s", logDbDsn))
 
-		dbClient, err = mySQLClient(dsn, dbLog)
+		dbClient, err = mySQLClient(dsn, gormConfig)
 		if err != nil {
 			return nil, fmt.Errorf("Database connection failed: %w", err)
 		}
@@ -95,15 +122,10 @@ func NewClient(dbConfig *Config, dbLogger *slog.Logger) (*gorm.DB, error) {
 		}
 	}
 
-	err = dbClient.AutoMigrate(Schema()...)
-	if err != nil {
-		dbLogger.Error(err.Error())
-	}
-
 	// Get generic database object sql.DB to use its functions
 	sqlDB, err := dbClient.DB()
 	if err != nil {
-		dbLogger.Error(err.Error())
+		return nil, fmt.Errorf("Database connection failed: %w", err)
 	}
 
 	// SetMaxIdleConns sets the maximum number of connections in the idle connection pool.
@@ -115,28 +137,34 @@ func NewClient(dbConfig *Config, dbLogger *slog.Logger) (*gorm.DB, error) {
 	// SetConnMaxLifetime sets the maximum amount of time a connection may be reused.
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
+	if len(dbConfig.Models) > 0 {
+		if err := RegisterModels(dbClient, dbConfig.Models...); err != nil {
+			return nil, err
+		}
+	}
+
 	return dbClient, nil
 }
 
-// Schema returns all objects which should be registered
-// to the teamserver database backend.
+// Schema returns all objects which should be registered to the teamserver
+// database backend (see Models for the ones registered by the application).
 func Schema() []any {
 	return []any{
 		&Certificate{},
 		&User{},
+		&Credential{},
+		&Invitation{},
+		&RevokedCertificate{},
+		&AuditEvent{},
+		&Secret{},
+		&DataKey{},
 	}
 }
 
-func postgresClient(dsn string, log logger.Interface) (*gorm.DB, error) {
-	return gorm.Open(postgres.Open(dsn), &gorm.Config{
-		PrepareStmt: true,
-		Logger:      log,
-	})
+func postgresClient(dsn string, config *gorm.Config) (*gorm.DB, error) {
+	return gorm.Open(postgres.Open(dsn), config)
 }
 
-func mySQLClient(dsn string, log logger.Interface) (*gorm.DB, error) {
-	return gorm.Open(mysql.Open(dsn), &gorm.Config{
-		PrepareStmt: true,
-		Logger:      log,
-	})
+func mySQLClient(dsn string, config *gorm.Config) (*gorm.DB, error) {
+	return gorm.Open(mysql.Open(dsn), config)
 }
@@ -25,7 +25,8 @@ import (
 	"gorm.io/gorm"
 )
 
-// User - A teamserver user (an authenticated identity + its credentials).
+// User - A teamserver user (an authenticated identity), with its credentials
+// stored separately (see Credential).
 // The teamserver stores no authorization/permission data: applications own
 // their own role model, keyed by the user Name.
 type User struct {
@@ -33,7 +34,6 @@ type User struct {
 	CreatedAt time.Time `gorm:"->;<-:create;"`
 	LastSeen  time.Time
 	Name      string
-	Token     string `gorm:"uniqueIndex"`
 }
 
 // BeforeCreate - GORM hook.
@@ -0,0 +1,125 @@
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package v1 holds the models of the teamserver tables as created by the version 1
+// migration of the database schema. They are frozen: changes of the teamserver models
+// come with their own migration, so that databases at any version are migrated alike.
+// The models keep the names of the current ones, and thus the names of their tables
+// and indexes.
+package v1
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Schema returns the models of the version 1 tables.
+func Schema() []any {
+	return []any{
+		&Certificate{},
+		&User{},
+		&Credential{},
+		&Invitation{},
+		&RevokedCertificate{},
+		&AuditEvent{},
+		&Secret{},
+		&DataKey{},
+	}
+}
+
+// Certificate - A certificate and its private key, if stored in the database.
+type Certificate struct {
+	ID             uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt      time.Time `gorm:"->;<-:create;"`
+	CommonName     string
+	CAType         string
+	KeyType        string
+	CertificatePEM string
+	PrivateKeyPEM  string `gorm:"serializer:encrypted"`
+	Profile        string
+}
+
+// User - A teamserver user.
+type User struct {
+	ID        uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt time.Time `gorm:"->;<-:create;"`
+	LastSeen  time.Time
+	Name      string
+}
+
+// Credential - A named credential (API token and client certificate) of a user.
+type Credential struct {
+	ID                     uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt              time.Time `gorm:"->;<-:create;"`
+	LastUsed               time.Time
+	UserName               string `gorm:"index"`
+	Label                  string
+	Token                  string `gorm:"uniqueIndex;serializer:encrypted_lookup"`
+	TokenExpiresAt         time.Time
+	PreviousToken          string `gorm:"index;serializer:encrypted_lookup"`
+	PreviousTokenExpiresAt time.Time
+	CertificateSerial      string
+}
+
+// Invitation - A one-time invitation code with which a teamclient enrolls.
+type Invitation struct {
+	ID        uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt time.Time `gorm:"->;<-:create;"`
+	UserName  string
+	Label     string
+	Code      string `gorm:"uniqueIndex;serializer:encrypted_lookup"`
+	ExpiresAt time.Time
+}
+
+// RevokedCertificate - A certificate revoked by the teamserver.
+type RevokedCertificate struct {
+	ID           uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt    time.Time `gorm:"->;<-:create;"`
+	SerialNumber string    `gorm:"uniqueIndex"`
+	CommonName   string
+	CAType       string
+	Reason       int
+	RevokedAt    time.Time
+}
+
+// AuditEvent - An action recorded in the audit trail.
+type AuditEvent struct {
+	ID        uuid.UUID `gorm:"primaryKey;->;<-:create;type:uuid;"`
+	CreatedAt time.Time `gorm:"->;<-:create;index"`
+	Actor     string    `gorm:"index"`
+	Origin    string
+	Action    string `gorm:"index"`
+	Target    string
+	Details   string
+}
+
+// Secret - A private key stored by the database secret store.
+type Secret struct {
+	Name      string `gorm:"primaryKey"`
+	Data      []byte `gorm:"serializer:encrypted;type:bytes"`
+	UpdatedAt time.Time
+}
+
+// DataKey - A wrapped key encrypting the sensitive columns.
+type DataKey struct {
+	Version    uint `gorm:"primaryKey;autoIncrement:false"`
+	Salt       []byte
+	WrappedKey []byte
+	CreatedAt  time.Time
+}
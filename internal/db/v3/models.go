@@ -0,0 +1,38 @@
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package v3 holds the models of the teamserver tables changed by the version 3
+// migration of the database schema, frozen like the ones of the v1 package.
+package v3
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// CredentialIndex is the unique index of the credential labels of each user.
+const CredentialIndex = "idx_credentials_user_label"
+
+// Credential - The columns of the credentials needed to make their labels unique.
+type Credential struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserName  string `gorm:"uniqueIndex:idx_credentials_user_label"`
+	Label     string `gorm:"uniqueIndex:idx_credentials_user_label"`
+}
@@ -0,0 +1,194 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"os/user"
+	"slices"
+	"time"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// Origins of audited actions.
+const (
+	AuditOriginCLI = "cli" // Local call to the teamserver API (eg. teamserver commands).
+	AuditOriginRPC = "rpc" // Remote call made by a teamclient.
+)
+
+// Actions recorded in the teamserver audit trail.
+const (
+	AuditUserCreate        = "user.create"
+	AuditUserDelete        = "user.delete"
+	AuditUserRevoke        = "user.revoke"
+	AuditUserInvite        = "user.invite"
+	AuditUserEnroll        = "user.enroll"
+	AuditTokenRotate       = "token.rotate"
+	AuditTokenRefresh      = "token.refresh"
+	AuditCredentialAdd     = "credential.add"
+	AuditCredentialRevoke  = "credential.revoke"
+	AuditCertRevoke        = "certificate.revoke"
+	AuditCertRenew         = "certificate.renew"
+	AuditCAImport          = "ca.import"
+	AuditCAExport          = "ca.export"
+	AuditCARotate          = "ca.rotate"
+	AuditCARetire          = "ca.retire"
+	AuditCAPassphrase      = "ca.passphrase"
+	AuditSecretsMigrate    = "secrets.migrate"
+	AuditDatabaseRotateKey = "database.rotate_key"
+	AuditDatabaseMigrate   = "database.migrate"
+	AuditDatabaseRollback  = "database.rollback"
+	AuditDatabaseRekey     = "database.rekey"
+	AuditDatabaseMigrateTo = "database.migrate_to"
+	AuditBackup            = "backup.create"
+	AuditRestore           = "backup.restore"
+	AuditListenerStart     = "listener.start"
+	AuditListenerStop      = "listener.stop"
+	AuditListenerAdd       = "listener.add"
+	AuditListenerRemove    = "listener.remove"
+	AuditSessionKick       = "session.kick"
+	AuditUnban             = "ban.lift"
+)
+
+// AuditEvent is an administrative action recorded in the teamserver audit trail.
+type AuditEvent struct {
+	Time    time.Time `json:"time"`    // Time at which the action was performed.
+	Actor   string    `json:"actor"`   // User (OS user for local calls) who performed the action.
+	Origin  string    `json:"origin"`  // server.AuditOriginCLI or server.AuditOriginRPC.
+	Action  string    `json:"action"`  // Action performed (eg. server.AuditUserCreate).
+	Target  string    `json:"target"`  // Object of the action (eg. a user name, a listener ID).
+	Details string    `json:"details"` // Additional information, if any.
+}
+
+// AuditFilter selects audit events. Zero values match all events.
+type AuditFilter struct {
+	Since  time.Time // Only events recorded at or after this time.
+	Until  time.Time // Only events recorded before this time.
+	Actor  string    // Only events performed by this actor.
+	Action string    // Only events of this action, or of this family of actions (eg. "user").
+	Limit  int       // Only the most recent events, up to this number.
+}
+
+// Audit records an administrative action in the teamserver audit trail. The teamserver
+// records its own administrative actions: applications can use this call to record the
+// ones they implement in their own handlers. An empty time is replaced with the current
+// one, and an empty actor and origin with the OS user of the teamserver process (CLI).
+func (ts *Server) Audit(event AuditEvent) error {
+	if err := ts.initDatabase(); err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	if event.Actor == "" && event.Origin == "" {
+		event.Actor, event.Origin = localActor(), AuditOriginCLI
+	}
+
+	err := ts.Database().Create(&db.AuditEvent{
+		CreatedAt: event.Time,
+		Actor:     event.Actor,
+		Origin:    event.Origin,
+		Action:    event.Action,
+		Target:    event.Target,
+		Details:   event.Details,
+	}).Error
+	if err != nil {
+		return ts.errorf("%w: failed to record audit event: %w", ErrDatabase, err)
+	}
+
+	return nil
+}
+
+// AuditEvents returns the audit events matching a filter, oldest first.
+func (ts *Server) AuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	if err := ts.initDatabase(); err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	query := ts.Database().Model(&db.AuditEvent{})
+
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+
+	if filter.Action != "" {
+		query = query.Where("action = ? OR action LIKE ?", filter.Action, filter.Action+".%")
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	// Most recent first, so that the limit keeps them.
+	eventsDB := []*db.AuditEvent{}
+
+	err := query.Order("created_at desc").Find(&eventsDB).Error
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	events := make([]AuditEvent, len(eventsDB))
+	for i, event := range eventsDB {
+		events[i] = AuditEvent{
+			Time:    event.CreatedAt,
+			Actor:   event.Actor,
+			Origin:  event.Origin,
+			Action:  event.Action,
+			Target:  event.Target,
+			Details: event.Details,
+		}
+	}
+
+	slices.Reverse(events)
+
+	return events, nil
+}
+
+// audit records an action performed with a local call to the teamserver API.
+func (ts *Server) audit(action, target, details string) {
+	ts.auditAs("", "", action, target, details)
+}
+
+// auditAs records an action performed by a given actor. Failing to record the
+// action is logged by Audit(), but does not fail the action, already performed.
+func (ts *Server) auditAs(actor, origin, action, target, details string) {
+	_ = ts.Audit(AuditEvent{
+		Actor:   actor,
+		Origin:  origin,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	})
+}
+
+// localActor returns the name of the OS user running the teamserver.
+func localActor() string {
+	current, err := user.Current()
+	if err != nil || current.Username == "" {
+		return "server"
+	}
+
+	return current.Username
+}
@@ -0,0 +1,91 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"testing"
+	"time"
+)
+
+// TestAuditEvents checks that administrative actions are recorded with their actor
+// and origin, and that they can be filtered by time, actor and family of actions.
+func TestAuditEvents(t *testing.T) {
+	ts := newTestServer(t)
+	start := time.Now().Add(-time.Second)
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.UserCredentialAdd("alice", "laptop", "localhost", 31337); err != nil {
+		t.Fatalf("UserCredentialAdd: %v", err)
+	}
+
+	if _, _, err := ts.UserRefreshToken(config.Token); err != nil {
+		t.Fatalf("UserRefreshToken: %v", err)
+	}
+
+	if err := ts.UserDelete("alice"); err != nil {
+		t.Fatalf("UserDelete: %v", err)
+	}
+
+	events, err := ts.AuditEvents(AuditFilter{Since: start})
+	if err != nil {
+		t.Fatalf("AuditEvents: %v", err)
+	}
+
+	actions := []string{AuditUserCreate, AuditCredentialAdd, AuditTokenRefresh, AuditUserDelete}
+	if len(events) != len(actions) {
+		t.Fatalf("expected %d events, got %+v", len(actions), events)
+	}
+
+	for i, event := range events {
+		if event.Action != actions[i] || event.Target != "alice" {
+			t.Fatalf("event %d: expected %s of alice, got %+v", i, actions[i], event)
+		}
+	}
+
+	if events[0].Origin != AuditOriginCLI || events[0].Actor == "" {
+		t.Fatalf("local calls should be recorded with the OS user: %+v", events[0])
+	}
+
+	if refresh := events[2]; refresh.Origin != AuditOriginRPC || refresh.Actor != "alice" {
+		t.Fatalf("token refreshes should be recorded as performed by the user: %+v", refresh)
+	}
+
+	users, err := ts.AuditEvents(AuditFilter{Action: "user"})
+	if err != nil || len(users) != 2 {
+		t.Fatalf("expected the user actions only, got %+v (%v)", users, err)
+	}
+
+	byAlice, err := ts.AuditEvents(AuditFilter{Actor: "alice"})
+	if err != nil || len(byAlice) != 1 || byAlice[0].Action != AuditTokenRefresh {
+		t.Fatalf("expected the actions of alice only, got %+v (%v)", byAlice, err)
+	}
+
+	latest, err := ts.AuditEvents(AuditFilter{Limit: 1})
+	if err != nil || len(latest) != 1 || latest[0].Action != AuditUserDelete {
+		t.Fatalf("expected the most recent action only, got %+v (%v)", latest, err)
+	}
+
+	if future, _ := ts.AuditEvents(AuditFilter{Since: time.Now().Add(time.Hour)}); len(future) != 0 {
+		t.Fatalf("expected no events, got %+v", future)
+	}
+}
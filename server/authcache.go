@@ -0,0 +1,133 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"sync"
+	"time"
+
+	"github.com/reeflective/team"
+)
+
+// authCachePruneSize is the number of cached tokens above which
+// entries past their TTL are removed when caching a new one.
+const authCachePruneSize = 1024
+
+// cachedToken is an authenticated token (hash) in the server cache.
+type cachedToken struct {
+	user        *team.User
+	credential  string // Label of the credential.
+	serial      string // Serial of the credential certificate.
+	expiresAt   time.Time
+	cachedUntil time.Time // The token must be checked against the database again after.
+}
+
+func (c *cachedToken) expired() bool {
+	return !c.expiresAt.IsZero() && time.Now().After(c.expiresAt)
+}
+
+// authCache caches authenticated tokens (by hash) for a limited time, so that
+// most requests are authenticated without querying the database. Entries are
+// invalidated per user or per credential when those are deleted or modified.
+type authCache struct {
+	entries map[string]*cachedToken
+	mutex   sync.RWMutex
+}
+
+func newAuthCache() *authCache {
+	return &authCache{
+		entries: make(map[string]*cachedToken),
+	}
+}
+
+// get returns the cached token for a hash, if any and still within its TTL.
+// Expired tokens are returned, so that the caller can report their expiry.
+func (c *authCache) get(hash string) (*cachedToken, bool) {
+	c.mutex.RLock()
+	cached, found := c.entries[hash]
+	c.mutex.RUnlock()
+
+	if !found || time.Now().After(cached.cachedUntil) {
+		return nil, false
+	}
+
+	return cached, true
+}
+
+// put caches an authenticated token for the given TTL. Zero disables caching.
+func (c *authCache) put(hash string, cached *cachedToken, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	now := time.Now()
+	cached.cachedUntil = now.Add(ttl)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.entries) >= authCachePruneSize {
+		for hash, entry := range c.entries {
+			if now.After(entry.cachedUntil) {
+				delete(c.entries, hash)
+			}
+		}
+	}
+
+	c.entries[hash] = cached
+}
+
+// reset removes all tokens from the cache.
+func (c *authCache) reset() {
+	c.mutex.Lock()
+	c.entries = make(map[string]*cachedToken)
+	c.mutex.Unlock()
+}
+
+// delete removes a single token from the cache.
+func (c *authCache) delete(hash string) {
+	c.mutex.Lock()
+	delete(c.entries, hash)
+	c.mutex.Unlock()
+}
+
+// invalidateUser removes all the cached tokens of a user.
+func (c *authCache) invalidateUser(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for hash, entry := range c.entries {
+		if entry.user.Name == name {
+			delete(c.entries, hash)
+		}
+	}
+}
+
+// invalidateCredential removes all the cached tokens (current
+// and previous ones, if any) of a single user credential.
+func (c *authCache) invalidateCredential(name, label string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for hash, entry := range c.entries {
+		if entry.user.Name == name && entry.credential == label {
+			delete(c.entries, hash)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reeflective/team"
+	"github.com/reeflective/team/internal/db"
+)
+
+// TestAuthCacheInvalidation checks that deleting a credential only evicts its own
+// cached tokens, and that cached tokens are checked again after their TTL.
+func TestAuthCacheInvalidation(t *testing.T) {
+	ts := newTestServer(t)
+
+	alice, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	laptop, err := ts.UserCredentialAdd("alice", "laptop", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCredentialAdd: %v", err)
+	}
+
+	for _, token := range []string{alice.Token, laptop.Token} {
+		if _, err := ts.Authenticate(token); err != nil {
+			t.Fatalf("Authenticate: %v", err)
+		}
+	}
+
+	if err := ts.UserCredentialRevoke("alice", "laptop"); err != nil {
+		t.Fatalf("UserCredentialRevoke: %v", err)
+	}
+
+	if _, cached := ts.authCache.get(hashToken(laptop.Token)); cached {
+		t.Fatal("the revoked credential token should have been evicted")
+	}
+
+	cached, found := ts.authCache.get(hashToken(alice.Token))
+	if !found {
+		t.Fatal("the other credentials of the user should remain cached")
+	}
+
+	if _, err := ts.Authenticate(laptop.Token); err == nil {
+		t.Fatal("the revoked credential token should be refused")
+	}
+
+	// Past their TTL, tokens are not served from the cache anymore.
+	cached.cachedUntil = time.Now().Add(-time.Second)
+
+	if _, cached := ts.authCache.get(hashToken(alice.Token)); cached {
+		t.Fatal("tokens past their TTL should not be served from the cache")
+	}
+
+	if user, err := ts.Authenticate(alice.Token); err != nil || user.Name != "alice" {
+		t.Fatalf("token past its TTL should be checked again: user=%v err=%v", user, err)
+	}
+}
+
+// TestLastSeenBatching checks that last seen times are not written on each request,
+// but that they are written before the users and their credentials are listed.
+func TestLastSeenBatching(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Users.LastSeenInterval = "1h"
+	saveTestConfig(t, ts)
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	for range 10 {
+		if _, err := ts.Authenticate(config.Token); err != nil {
+			t.Fatalf("Authenticate: %v", err)
+		}
+	}
+
+	user := &db.User{}
+	if err := ts.Database().Where(&db.User{Name: "alice"}).First(user).Error; err != nil {
+		t.Fatalf("user lookup: %v", err)
+	}
+
+	if !user.LastSeen.IsZero() {
+		t.Fatal("last seen times should be written in batches, not on each request")
+	}
+
+	users, err := ts.Users()
+	if err != nil || len(users) != 1 || users[0].LastSeen.IsZero() {
+		t.Fatalf("listed users should have their last seen time: %+v (%v)", users, err)
+	}
+
+	creds, err := ts.UserCredentials("alice")
+	if err != nil || len(creds) != 1 || creds[0].LastUsed.IsZero() {
+		t.Fatalf("listed credentials should have their last used time: %+v (%v)", creds, err)
+	}
+}
+
+// TestLastSeenFlushFailure checks that last seen times which could not be written
+// are kept for the next batch, instead of being lost.
+func TestLastSeenFlushFailure(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Users.LastSeenInterval = "1h"
+	saveTestConfig(t, ts)
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.Authenticate(config.Token); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	if err := ts.Database().Exec("ALTER TABLE users RENAME TO users_unavailable").Error; err != nil {
+		t.Fatalf("rename users table: %v", err)
+	}
+
+	ts.flushLastSeen(false)
+
+	if err := ts.Database().Exec("ALTER TABLE users_unavailable RENAME TO users").Error; err != nil {
+		t.Fatalf("rename users table: %v", err)
+	}
+
+	users, err := ts.Users()
+	if err != nil || len(users) != 1 || users[0].LastSeen.IsZero() {
+		t.Fatalf("last seen times should be written by the next batch: %+v (%v)", users, err)
+	}
+}
+
+// TestLastSeenExternal checks that the users authenticated by other authenticators
+// than the token store have their last seen time recorded, but not their credentials.
+func TestLastSeenExternal(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Users.LastSeenInterval = "1h"
+	saveTestConfig(t, ts)
+
+	ts.apply(WithAuthenticators(team.AuthenticatorFunc(func(creds team.Credentials) (*team.User, error) {
+		if name, found := strings.CutPrefix(creds.Token, "ext-"); found {
+			return &team.User{Name: name}, nil
+		}
+
+		return nil, team.ErrUnknownCredentials
+	})))
+
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.Authenticate("ext-alice"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	users, err := ts.Users()
+	if err != nil || len(users) != 1 || users[0].LastSeen.IsZero() {
+		t.Fatalf("externally authenticated users should have their last seen time: %+v (%v)", users, err)
+	}
+
+	creds, err := ts.UserCredentials("alice")
+	if err != nil || len(creds) != 1 || !creds[0].LastUsed.IsZero() {
+		t.Fatalf("credentials not used should have no last used time: %+v (%v)", creds, err)
+	}
+}
+
+// BenchmarkAuthenticate compares the throughput of concurrent authentications with
+// the authentication cache and batched last seen updates, against the same requests
+// checked against the database and followed by synchronous updates, as if uncached.
+func BenchmarkAuthenticate(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		ts := newTestServer(b)
+		ts.opts.config.Users.LastSeenInterval = "100ms"
+		saveTestConfig(b, ts)
+
+		benchmarkAuthenticate(b, ts, func(*cachedToken) {})
+	})
+
+	b.Run("synchronous", func(b *testing.B) {
+		ts := newTestServer(b)
+		ts.opts.config.Users.AuthCacheTTL = "0s"
+		saveTestConfig(b, ts)
+
+		benchmarkAuthenticate(b, ts, func(cached *cachedToken) {
+			lastSeen := time.Now().Round(1 * time.Second)
+			ts.Database().Model(&db.User{}).Where("name", cached.user.Name).Update("LastSeen", lastSeen)
+			ts.Database().Model(&db.Credential{}).
+				Where(&db.Credential{UserName: cached.user.Name, Label: cached.credential}).
+				Update("LastUsed", lastSeen)
+		})
+	})
+}
+
+func benchmarkAuthenticate(b *testing.B, ts *Server, after func(cached *cachedToken)) {
+	b.Helper()
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		b.Fatalf("UserCreate: %v", err)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cached, err := ts.authenticate(config.Token)
+			if err != nil {
+				b.Errorf("authenticate: %v", err)
+				return
+			}
+
+			after(cached)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+
+	"github.com/reeflective/team"
+	"github.com/reeflective/team/internal/certs"
+)
+
+// Authenticator returns the authenticator used by the teamserver to verify the credentials
+// of its teamclients, and which handlers should consult in their authentication middleware.
+// It is a chain (team.Authenticators) made of:
+//   - The teamserver token store, which knows the tokens it issued to its users (and binds
+//     them to the certificate of their credential, when given one).
+//   - The authenticators given with server.WithAuthenticators(), in order, for credentials
+//     that are not teamserver tokens (eg. JWTs, directory passwords).
+//
+// Whatever authenticator recognizes the credentials, a client certificate, if any, must be
+// one of the user that is authenticated. On failure, the returned error is ErrUnauthenticated.
+//
+// Failures are tracked per remote address and client certificate of the credentials, which
+// are refused for a while after each failure, and banned after too many of them (ErrBanned).
+// See the Lockout section of the teamserver Config, and Server.Bans()/Server.Unban().
+func (ts *Server) Authenticator() team.Authenticator {
+	return team.AuthenticatorFunc(ts.authenticateCredentials)
+}
+
+func (ts *Server) authenticateCredentials(creds team.Credentials) (*team.User, error) {
+	log := ts.NamedLogger("server", "auth")
+
+	// Refuse banned addresses and credentials before verifying anything.
+	settings := ts.lockoutSettings()
+	subjects := lockoutSubjects(creds)
+
+	if settings.maxFailures > 0 && len(subjects) > 0 {
+		if err := ts.lockoutCheck(subjects, settings); err != nil {
+			return nil, ts.errorWith(log, "%w", err)
+		}
+	}
+
+	user, err := ts.verifyCredentials(creds)
+
+	if settings.maxFailures > 0 && len(subjects) > 0 {
+		if errors.Is(err, ErrUnauthenticated) {
+			ts.lockoutFailure(subjects, settings)
+		} else if err == nil {
+			ts.lockoutSuccess(subjects)
+		}
+	}
+
+	return user, err
+}
+
+// verifyCredentials consults the authenticator chain of the teamserver.
+func (ts *Server) verifyCredentials(creds team.Credentials) (*team.User, error) {
+	log := ts.NamedLogger("server", "auth")
+
+	// The token store records the use of its credentials, but the users authenticated
+	// by other authenticators (which have no teamserver credential) are recorded here.
+	var external bool
+
+	chain := team.Authenticators{team.AuthenticatorFunc(ts.authenticateToken)}
+
+	for _, auth := range ts.opts.authenticators {
+		if auth == nil {
+			continue
+		}
+
+		chain = append(chain, team.AuthenticatorFunc(func(creds team.Credentials) (*team.User, error) {
+			external = true
+			return auth.Authenticate(creds)
+		}))
+	}
+
+	user, err := chain.Authenticate(creds)
+
+	// Failures of the token store are already logged.
+	if errors.Is(err, ErrUnauthenticated) || errors.Is(err, ErrDatabase) {
+		return nil, err
+	} else if err != nil {
+		return nil, ts.errorWith(log, "%w: %w", ErrUnauthenticated, err)
+	}
+
+	if creds.Certificate != nil && creds.Certificate.Subject.CommonName != user.Name {
+		return nil, ts.errorWith(log, "%w: credentials of user %s used with certificate of %s",
+			ErrUnauthenticated, user.Name, creds.Certificate.Subject.CommonName)
+	}
+
+	if external {
+		ts.recordLastSeen(user.Name, "")
+	}
+
+	return user, nil
+}
+
+// authenticateToken is the authenticator of the teamserver token store.
+func (ts *Server) authenticateToken(creds team.Credentials) (*team.User, error) {
+	cached, err := ts.authenticate(creds.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, cert := cached.user, creds.Certificate
+
+	if cert == nil {
+		return user, nil
+	}
+
+	if cert.Subject.CommonName != user.Name {
+		return nil, ts.errorWith(ts.NamedLogger("server", "auth"), "%w: token of user %s used with certificate of %s",
+			ErrUnauthenticated, user.Name, cert.Subject.CommonName)
+	}
+
+	if cached.serial == "" || cached.serial != certs.FormatSerial(cert.SerialNumber) {
+		return nil, ts.errorWith(ts.NamedLogger("server", "auth"),
+			"%w: certificate of user %s is not the one of its credential '%s' (serial %s)",
+			ErrUnauthenticated, user.Name, cached.credential, certs.FormatSerial(cert.SerialNumber))
+	}
+
+	return user, nil
+}
@@ -0,0 +1,79 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/reeflective/team"
+	"github.com/reeflective/team/internal/certs"
+)
+
+// TestAuthenticatorChain checks that tokens unknown to the teamserver are verified by the
+// authenticators it was given, in order, and that their users are bound to their certificate.
+func TestAuthenticatorChain(t *testing.T) {
+	ts := newTestServer(t)
+
+	refusing := team.AuthenticatorFunc(func(creds team.Credentials) (*team.User, error) {
+		if creds.Token == "ext-mallory" {
+			return nil, errors.New("mallory is banned")
+		}
+
+		return nil, team.ErrUnknownCredentials
+	})
+
+	external := team.AuthenticatorFunc(func(creds team.Credentials) (*team.User, error) {
+		if name, found := strings.CutPrefix(creds.Token, "ext-"); found {
+			return &team.User{Name: name}, nil
+		}
+
+		return nil, team.ErrUnknownCredentials
+	})
+
+	ts.apply(WithAuthenticators(refusing, external))
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	cert, _ := certs.ParseCertificatePEM([]byte(config.Certificate))
+
+	if user, err := ts.AuthenticatePeer(config.Token, cert); err != nil || user.Name != "alice" {
+		t.Fatalf("teamserver token refused: %v", err)
+	}
+
+	if user, err := ts.AuthenticatePeer("ext-alice", cert); err != nil || user.Name != "alice" {
+		t.Fatalf("external credentials refused: %v", err)
+	}
+
+	if _, err := ts.AuthenticatePeer("ext-bob", cert); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("external credentials used with the certificate of another user should be refused, got %v", err)
+	}
+
+	if _, err := ts.Authenticate("ext-mallory"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("refused credentials should not fall back to other authenticators, got %v", err)
+	}
+
+	if _, err := ts.Authenticate("unknown"); !errors.Is(err, team.ErrUnknownCredentials) {
+		t.Fatalf("credentials unknown to all authenticators should be refused, got %v", err)
+	}
+}
@@ -0,0 +1,685 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/reeflective/team/internal/assets"
+	"github.com/reeflective/team/internal/certs"
+	"github.com/reeflective/team/internal/db"
+)
+
+// Backup archives are gzipped tarballs with a manifest, the rows of the database
+// tables, the private keys of the secret store, the users CA files and the server
+// and database configurations. Encrypted archives start with a header (magic,
+// PBKDF2 salt and AES-GCM nonce), followed by the sealed tarball.
+const (
+	backupFormat     = 1
+	backupMagic      = "TEAMBACKUP"
+	backupIterations = 600000
+	backupSaltLen    = 16
+
+	backupManifest       = "manifest.json"
+	backupDatabaseDir    = "database/"
+	backupSecretsDir     = "secrets/"
+	backupCertsDir       = "certificates/"
+	backupServerConfig   = "configs/server.json"
+	backupDatabaseConfig = "configs/database.json"
+)
+
+// backupInfo is the manifest of a backup archive.
+type backupInfo struct {
+	Format        int       `json:"format"`
+	Application   string    `json:"application"`
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion uint      `json:"schema_version"`
+	Dialect       string    `json:"dialect"`
+	SecretStore   string    `json:"secret_store"`
+}
+
+// backupArchive is the content of a backup archive, by file name.
+type backupArchive struct {
+	info  backupInfo
+	files map[string][]byte
+}
+
+// Backup writes an archive of the complete teamserver state: the database (read in a single
+// transaction, so that it is consistent while the teamserver runs), the private keys of the
+// secret store, the users CA files, and the server and database configurations. The archive
+// is encrypted with the backup passphrase, if any: it takes precedence over the environment
+// variable APP_BACKUP_PASSPHRASE, and the backup.passphrase_file of the configuration.
+//
+// Without passphrase, the archive holds private keys in cleartext: store it accordingly. A
+// passphrase configured with a reference or a file that cannot be read fails the backup.
+func (ts *Server) Backup(w io.Writer) error {
+	if err := ts.initCerts(); err != nil {
+		return err
+	}
+
+	passphrase, err := ts.backupPassphrase()
+	if err != nil {
+		return ts.errorf("%w: %w", ErrBackup, err)
+	}
+
+	// Write pending last seen times first.
+	ts.flushLastSeen(false)
+
+	archive := &backupArchive{
+		info: backupInfo{
+			Format:      backupFormat,
+			Application: ts.Name(),
+			CreatedAt:   time.Now(),
+			Dialect:     ts.db.Dialector.Name(),
+			SecretStore: ts.SecretStoreName(),
+		},
+		files: make(map[string][]byte),
+	}
+
+	version, tables, err := db.Export(ts.Database())
+	if err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	archive.info.SchemaVersion = version
+
+	for table, rows := range tables {
+		archive.files[backupDatabaseDir+table+".json"] = rows
+	}
+
+	if err := ts.backupSecrets(archive); err != nil {
+		return err
+	}
+
+	if err := ts.backupFiles(archive); err != nil {
+		return err
+	}
+
+	data, err := archive.marshal()
+	if err != nil {
+		return ts.errorf("%w: %w", ErrBackup, err)
+	}
+
+	if len(passphrase) > 0 {
+		if data, err = sealBackup(data, passphrase); err != nil {
+			return ts.errorf("%w: %w", ErrBackup, err)
+		}
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return ts.errorf("%w: failed to write archive: %w", ErrBackup, err)
+	}
+
+	encryption := "cleartext"
+	if len(passphrase) > 0 {
+		encryption = "encrypted"
+	}
+
+	ts.audit(AuditBackup, ts.Name(), fmt.Sprintf("schema version %d, %s", version, encryption))
+
+	return nil
+}
+
+// Restore replaces the teamserver state with the one of an archive written by server.Backup(),
+// decrypted with the backup passphrase if encrypted. The archive must be one of the same
+// application, and its database schema cannot be more recent than the one of the teamserver.
+//
+// Rows are restored in the current database (whatever its SQL dialect, and encrypted with its
+// key if any), and private keys in the current secret store: the database configuration of the
+// archive is not restored, and the restored server configuration keeps the current secret store.
+// No listener must be running: other teamserver processes using the same state (eg. a daemon)
+// must be stopped, and restarted after. If restoring fails, the current state is left as is.
+func (ts *Server) Restore(r io.Reader) error {
+	if len(ts.Listeners()) > 0 {
+		return ts.errorf("%w: listeners must be stopped before restoring", ErrBackup)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ts.errorf("%w: failed to read archive: %w", ErrBackup, err)
+	}
+
+	if bytes.HasPrefix(data, []byte(backupMagic)) {
+		passphrase, err := ts.backupPassphrase()
+		if err != nil {
+			return ts.errorf("%w: %w", ErrBackup, err)
+		}
+
+		if data, err = openBackup(data, passphrase); err != nil {
+			return ts.errorf("%w: %w", ErrBackup, err)
+		}
+	}
+
+	archive, err := unmarshalBackup(data)
+	if err != nil {
+		return ts.errorf("%w: %w", ErrBackup, err)
+	}
+
+	if err := ts.checkBackup(archive.info); err != nil {
+		return err
+	}
+
+	if err := ts.initDatabase(); err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	ts.flushLastSeen(false)
+
+	tables := make(map[string][]byte)
+
+	for name, rows := range archive.files {
+		if table, found := strings.CutPrefix(name, backupDatabaseDir); found {
+			tables[strings.TrimSuffix(table, ".json")] = rows
+		}
+	}
+
+	config, err := ts.restoredConfig(archive)
+	if err != nil {
+		return err
+	}
+
+	// Private keys and files are written along the current ones, which are only deleted
+	// once the database is restored: on failure, the previous state is put back instead.
+	commitSecrets, rollbackSecrets, err := ts.restoreSecrets(archive)
+	if err != nil {
+		return err
+	}
+
+	commitFiles, rollbackFiles, err := ts.restoreFiles(archive)
+	if err != nil {
+		rollbackSecrets()
+		return err
+	}
+
+	previousConfig := ts.opts.config
+
+	rollback := func() {
+		rollbackFiles()
+		rollbackSecrets()
+
+		if config != nil {
+			ts.opts.config = previousConfig
+			_ = ts.SaveConfig(previousConfig)
+		}
+	}
+
+	if config != nil {
+		if err := ts.SaveConfig(config); err != nil {
+			rollback()
+			return err
+		}
+
+		ts.opts.config = config
+	}
+
+	if err := db.Import(ts.Database(), tables); err != nil {
+		rollback()
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	commitSecrets()
+	commitFiles()
+
+	// Caches and certificate infrastructure of the previous state.
+	ts.authCache.reset()
+	ts.resetUsersTLS()
+
+	if ts.certs != nil {
+		ts.certsErr = ts.newCertificateManager()
+	}
+
+	ts.audit(AuditRestore, archive.info.Application, fmt.Sprintf("archive of %s, schema version %d",
+		archive.info.CreatedAt.Format(time.RFC1123), archive.info.SchemaVersion))
+
+	return ts.certsErr
+}
+
+// checkBackup refuses archives of another application, or more recent than the teamserver.
+func (ts *Server) checkBackup(info backupInfo) error {
+	switch {
+	case info.Format > backupFormat:
+		return ts.errorf("%w: unsupported archive format %d (latest is %d)", ErrBackup, info.Format, backupFormat)
+	case info.Application != ts.Name():
+		return ts.errorf("%w: archive of application %q, not %q", ErrBackup, info.Application, ts.Name())
+	case info.SchemaVersion > db.LatestVersion():
+		return ts.errorf("%w: %w (archive version %d, latest known is %d)",
+			ErrBackup, db.ErrSchemaVersion, info.SchemaVersion, db.LatestVersion())
+	}
+
+	return nil
+}
+
+func (ts *Server) backupSecrets(archive *backupArchive) error {
+	store := ts.certs.SecretStore()
+
+	names, err := store.List()
+	if err != nil {
+		return ts.errorf("%w: failed to list secrets: %w", ErrSecretStore, err)
+	}
+
+	for _, name := range names {
+		secret, err := store.Get(name)
+		if err != nil {
+			return ts.errorf("%w: failed to read secret %s: %w", ErrSecretStore, name, err)
+		}
+
+		archive.files[backupSecretsDir+name] = secret
+	}
+
+	return nil
+}
+
+// restoreSecrets writes the private keys of an archive in the current secret store, and returns
+// functions deleting the other ones once restored (commit), or putting back the previous ones.
+func (ts *Server) restoreSecrets(archive *backupArchive) (commit, rollback func(), err error) {
+	store, err := ts.secretStore()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names, err := store.List()
+	if err != nil {
+		return nil, nil, ts.errorf("%w: failed to list secrets: %w", ErrSecretStore, err)
+	}
+
+	log := ts.NamedLogger("server", "backup")
+	previous := make(map[string][]byte)
+	restored := make(map[string]bool)
+
+	rollback = func() {
+		for name := range restored {
+			var err error
+			if secret, found := previous[name]; found {
+				err = store.Put(name, secret)
+			} else {
+				err = store.Delete(name)
+			}
+
+			if err != nil {
+				log.Error(fmt.Sprintf("Failed to put back secret %s: %s", name, err))
+			}
+		}
+	}
+
+	for file, secret := range archive.files {
+		name, found := strings.CutPrefix(file, backupSecretsDir)
+		if !found {
+			continue
+		}
+
+		current, err := store.Get(name)
+		if err == nil {
+			previous[name] = current
+		} else if !errors.Is(err, certs.ErrSecretNotFound) {
+			rollback()
+			return nil, nil, ts.errorf("%w: failed to read secret %s: %w", ErrSecretStore, name, err)
+		}
+
+		restored[name] = true
+
+		if err := store.Put(name, secret); err != nil {
+			rollback()
+			return nil, nil, ts.errorf("%w: failed to restore secret %s: %w", ErrSecretStore, name, err)
+		}
+	}
+
+	commit = func() {
+		for _, name := range names {
+			if restored[name] {
+				continue
+			}
+
+			if err := store.Delete(name); err != nil {
+				log.Warn(fmt.Sprintf("Failed to delete secret %s: %s", name, err))
+			}
+		}
+	}
+
+	return commit, rollback, nil
+}
+
+func (ts *Server) backupFiles(archive *backupArchive) error {
+	certsDir := ts.CertificatesDir()
+
+	err := ts.fs.Walk(certsDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		data, err := ts.fs.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(certsDir, filePath)
+		if err != nil {
+			return err
+		}
+
+		archive.files[backupCertsDir+filepath.ToSlash(name)] = data
+
+		return nil
+	})
+	if err != nil {
+		return ts.errorf("%w: failed to read certificates: %w", ErrBackup, err)
+	}
+
+	config, err := json.MarshalIndent(ts.GetConfig(), "", "    ")
+	if err != nil {
+		return ts.errorf("%w: %w", ErrConfig, err)
+	}
+
+	archive.files[backupServerConfig] = config
+
+	// Saved for reference only, since it is not restored: it might point to another
+	// database. Its password is left out, unless it is a secret reference.
+	dbConfig := *ts.opts.dbConfig
+	if current := ts.DatabaseConfig(); current != nil {
+		dbConfig = *current
+	}
+
+	if !isSecretReference(dbConfig.Password) {
+		dbConfig.Password = ""
+	}
+
+	dbConfigData, err := json.MarshalIndent(&dbConfig, "", "    ")
+	if err != nil {
+		return ts.errorf("%w: %w", ErrConfig, err)
+	}
+
+	archive.files[backupDatabaseConfig] = dbConfigData
+
+	return nil
+}
+
+// restoreFiles writes the certificate files of an archive in a new directory, swapped with the current
+// one, and returns functions deleting the previous directory (commit), or swapping it back.
+func (ts *Server) restoreFiles(archive *backupArchive) (commit, rollback func(), err error) {
+	certsDir := ts.CertificatesDir()
+	staging := certsDir + ".restore"
+	previous := certsDir + ".previous"
+
+	fail := func(err error) (func(), func(), error) {
+		_ = ts.fs.RemoveAll(staging)
+		return nil, nil, ts.errorf("%w: failed to restore certificates: %w", ErrBackup, err)
+	}
+
+	if err := ts.fs.RemoveAll(staging); err != nil {
+		return fail(err)
+	}
+
+	if err := ts.fs.MkdirAll(staging, assets.DirPerm); err != nil {
+		return fail(err)
+	}
+
+	for file, data := range archive.files {
+		name, found := strings.CutPrefix(file, backupCertsDir)
+		if !found {
+			continue
+		} else if !iofs.ValidPath(name) {
+			return fail(fmt.Errorf("invalid certificate file name %q", name))
+		}
+
+		filePath := path.Join(staging, name)
+
+		if err := ts.fs.MkdirAll(path.Dir(filePath), assets.DirPerm); err != nil {
+			return fail(err)
+		}
+
+		if err := ts.fs.WriteFile(filePath, data, assets.FileReadPerm); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := ts.fs.RemoveAll(previous); err != nil {
+		return fail(err)
+	}
+
+	if err := ts.fs.Rename(certsDir, previous); err != nil {
+		return fail(err)
+	}
+
+	if err := ts.fs.Rename(staging, certsDir); err != nil {
+		_ = ts.fs.Rename(previous, certsDir)
+		return fail(err)
+	}
+
+	log := ts.NamedLogger("server", "backup")
+
+	rollback = func() {
+		if err := ts.fs.RemoveAll(certsDir); err != nil {
+			log.Error(fmt.Sprintf("Failed to remove restored certificates: %s", err))
+		}
+
+		if err := ts.fs.Rename(previous, certsDir); err != nil {
+			log.Error(fmt.Sprintf("Failed to put back certificates: %s", err))
+		}
+	}
+
+	commit = func() {
+		if err := ts.fs.RemoveAll(previous); err != nil {
+			log.Warn(fmt.Sprintf("Failed to remove previous certificates: %s", err))
+		}
+	}
+
+	return commit, rollback, nil
+}
+
+// restoredConfig returns the server configuration of an archive, if any,
+// keeping the current secret store, in which private keys are restored.
+func (ts *Server) restoredConfig(archive *backupArchive) (*Config, error) {
+	data, found := archive.files[backupServerConfig]
+	if !found {
+		return nil, nil
+	}
+
+	config := getDefaultServerConfig()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, ts.errorf("%w: invalid server configuration: %w", ErrConfig, err)
+	}
+
+	config.Certificates.SecretStore = ts.GetConfig().Certificates.SecretStore
+
+	return config, nil
+}
+
+// backupPassphrase returns the passphrase of backup archives, if any, or an error if
+// one is configured but cannot be read: archives are never written in cleartext then.
+func (ts *Server) backupPassphrase() ([]byte, error) {
+	backup := ts.opts.config.Backup
+
+	return ts.readPassphrase("Backup passphrase", ts.opts.backupKey, "BACKUP_PASSPHRASE", backup.Passphrase, backup.PassphraseFile)
+}
+
+// marshal writes the archive as a gzipped tarball, starting with its manifest.
+func (a *backupArchive) marshal() ([]byte, error) {
+	manifest, err := json.MarshalIndent(a.info, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+
+	write := func(name string, data []byte) error {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    int64(assets.FileReadPerm),
+			Size:    int64(len(data)),
+			ModTime: a.info.CreatedAt,
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		_, err := tw.Write(data)
+
+		return err
+	}
+
+	if err := write(backupManifest, manifest); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(a.files))
+	for name := range a.files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := write(name, a.files[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unmarshalBackup reads an archive written by backupArchive.marshal().
+func unmarshalBackup(data []byte) (*backupArchive, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+
+	archive := &backupArchive{files: make(map[string][]byte)}
+	tr := tar.NewReader(gz)
+
+	var manifest []byte
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+
+		if header.Name == backupManifest {
+			manifest = content
+		} else {
+			archive.files[header.Name] = content
+		}
+	}
+
+	if manifest == nil {
+		return nil, errors.New("invalid archive: no manifest")
+	}
+
+	if err := json.Unmarshal(manifest, &archive.info); err != nil {
+		return nil, fmt.Errorf("invalid archive manifest: %w", err)
+	}
+
+	return archive, nil
+}
+
+// sealBackup encrypts an archive with a key derived from the passphrase.
+func sealBackup(data, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, backupSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := append(append(append([]byte(backupMagic), backupFormat), salt...), nonce...)
+
+	return aead.Seal(header, nonce, data, header), nil
+}
+
+// openBackup decrypts an archive encrypted by sealBackup().
+func openBackup(data, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("the archive is encrypted, but no backup passphrase is set")
+	}
+
+	headerLen := len(backupMagic) + 1 + backupSaltLen + 12
+	if len(data) < headerLen {
+		return nil, errors.New("invalid encrypted archive")
+	}
+
+	salt := data[len(backupMagic)+1 : len(backupMagic)+1+backupSaltLen]
+
+	aead, err := backupCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := data[:headerLen]
+
+	archive, err := aead.Open(nil, header[headerLen-aead.NonceSize():], data[headerLen:], header)
+	if err != nil {
+		return nil, errors.New("incorrect backup passphrase, or corrupted archive")
+	}
+
+	return archive, nil
+}
+
+func backupCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, backupIterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
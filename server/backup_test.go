@@ -0,0 +1,235 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// TestBackupRestore checks that the state of a teamserver (users, credentials and users CA)
+// is restored from an encrypted archive into another one, with its own database key.
+func TestBackupRestore(t *testing.T) {
+	ts, err := New("backup", WithInMemory(), WithBackupPassphrase("secret"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	user, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	archive := &bytes.Buffer{}
+	if err := ts.Backup(archive); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	if !bytes.HasPrefix(archive.Bytes(), []byte(backupMagic)) {
+		t.Fatal("archive should be encrypted with the backup passphrase")
+	}
+
+	wrong, err := New("backup", WithInMemory(), WithBackupPassphrase("wrong"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if err := wrong.Restore(bytes.NewReader(archive.Bytes())); !errors.Is(err, ErrBackup) {
+		t.Fatalf("restoring with a wrong passphrase should fail with ErrBackup, got %v", err)
+	}
+
+	other, err := New("other", WithInMemory(), WithBackupPassphrase("secret"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if err := other.Restore(bytes.NewReader(archive.Bytes())); !errors.Is(err, ErrBackup) {
+		t.Fatalf("restoring the archive of another application should fail with ErrBackup, got %v", err)
+	}
+
+	restored, err := New("backup", WithInMemory(), WithBackupPassphrase("secret"), WithDatabaseKey("key"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if err := restored.Restore(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if _, err := restored.Authenticate(user.Token); err != nil {
+		t.Fatalf("restored user token should be valid: %v", err)
+	}
+
+	caCert, _, _ := ts.UsersExportCA("export")
+	restoredCert, restoredKey, err := restored.UsersExportCA("export")
+
+	if err != nil || !bytes.Equal(caCert, restoredCert) || len(restoredKey) == 0 {
+		t.Fatalf("users CA should have been restored (%v)", err)
+	}
+
+	if _, err := restored.UserCreate("bob", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate with the restored CA: %v", err)
+	}
+
+	newer := backupInfo{Format: backupFormat, Application: "backup", SchemaVersion: db.LatestVersion() + 1}
+	if err := restored.checkBackup(newer); !errors.Is(err, db.ErrSchemaVersion) {
+		t.Fatalf("archive with a newer schema should be refused with ErrSchemaVersion, got %v", err)
+	}
+}
+
+// TestBackupDatabasePassword checks that the database configuration is archived without
+// its password, unless the password is a secret reference.
+func TestBackupDatabasePassword(t *testing.T) {
+	ts := newTestServer(t)
+
+	for password, archived := range map[string]bool{
+		"cleartext password":   false,
+		"env:TEAM_DB_PASSWORD": true,
+	} {
+		ts.opts.dbConfig.Password = password
+
+		data := &bytes.Buffer{}
+		if err := ts.Backup(data); err != nil {
+			t.Fatalf("Backup: %v", err)
+		}
+
+		archive, err := unmarshalBackup(data.Bytes())
+		if err != nil {
+			t.Fatalf("unmarshalBackup: %v", err)
+		}
+
+		config, found := archive.files[backupDatabaseConfig]
+		if !found {
+			t.Fatal("database configuration not archived")
+		}
+
+		if bytes.Contains(config, []byte(password)) != archived {
+			t.Fatalf("password %q archived = %t, want %t", password, !archived, archived)
+		}
+	}
+}
+
+// TestBackupPassphraseUnreadable checks that no archive is written when the configured
+// backup passphrase cannot be read, rather than a cleartext one.
+func TestBackupPassphraseUnreadable(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, source := range []struct{ ref, path string }{
+		{ref: "env:TEAM_TEST_UNSET_PASSPHRASE"},
+		{ref: "cleartext passphrase"},
+		{path: filepath.Join(t.TempDir(), "missing")},
+	} {
+		ts.opts.config.Backup.Passphrase = source.ref
+		ts.opts.config.Backup.PassphraseFile = source.path
+
+		data := &bytes.Buffer{}
+		if err := ts.Backup(data); !errors.Is(err, ErrBackup) || data.Len() > 0 {
+			t.Fatalf("backup with passphrase %+v should fail with ErrBackup, got %v (%d bytes)", source, err, data.Len())
+		}
+	}
+}
+
+// TestRestoreFailure checks that a failed restore leaves the current state of the teamserver,
+// and its private keys, as they were.
+func TestRestoreFailure(t *testing.T) {
+	source := newTestServer(t)
+
+	if _, err := source.UserCreate("carol", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	data := &bytes.Buffer{}
+	if err := source.Backup(data); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	ts := newTestServer(t)
+
+	user, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	caCert, _, _ := ts.certs.GetUsersCAPEM()
+	secrets, _ := ts.certs.SecretStore().List()
+
+	for _, corrupt := range []struct {
+		name    string
+		archive func(files map[string][]byte)
+		err     error
+	}{
+		{
+			name: "invalid certificate file name",
+			archive: func(files map[string][]byte) {
+				files[backupCertsDir+"../escape"] = []byte("data")
+			},
+			err: ErrBackup,
+		},
+		{
+			name: "invalid database rows",
+			archive: func(files map[string][]byte) {
+				for name := range files {
+					if strings.HasPrefix(name, backupDatabaseDir) {
+						files[name] = []byte("invalid rows")
+					}
+				}
+			},
+			err: ErrDatabase,
+		},
+	} {
+		archive, err := unmarshalBackup(data.Bytes())
+		if err != nil {
+			t.Fatalf("unmarshalBackup: %v", err)
+		}
+
+		corrupt.archive(archive.files)
+
+		corrupted, err := archive.marshal()
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+
+		if err := ts.Restore(bytes.NewReader(corrupted)); !errors.Is(err, corrupt.err) {
+			t.Fatalf("restoring an archive with %s should fail with %v, got %v", corrupt.name, corrupt.err, err)
+		}
+
+		if _, err := ts.Authenticate(user.Token); err != nil {
+			t.Fatalf("%s: user should still be valid after a failed restore: %v", corrupt.name, err)
+		}
+
+		restoredCert, _, err := ts.certs.GetUsersCAPEM()
+		if err != nil || !bytes.Equal(caCert, restoredCert) {
+			t.Fatalf("%s: users CA should be the previous one after a failed restore (%v)", corrupt.name, err)
+		}
+
+		if after, _ := ts.certs.SecretStore().List(); !slices.Equal(secrets, after) {
+			t.Fatalf("%s: secrets should be put back after a failed restore: %v, was %v", corrupt.name, after, secrets)
+		}
+	}
+
+	if _, err := ts.UserCreate("bob", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate after a failed restore: %v", err)
+	}
+}
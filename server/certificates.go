@@ -0,0 +1,323 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/reeflective/team/internal/certs"
+)
+
+// CertificateInfo describes a certificate issued by the teamserver users CA (or the CA itself).
+type CertificateInfo struct {
+	Subject     string    // Common name of the certificate.
+	Issuer      string    // Common name of the issuing CA.
+	Serial      string    // Hex-encoded serial number.
+	Fingerprint string    // SHA-256 fingerprint of the certificate.
+	Purpose     string    // "ca", "server" or "client".
+	User        string    // User owning the certificate, for client certificates.
+	Credential  string    // Credential of the user owning the certificate, if any.
+	Algorithm   string    // Public key algorithm (eg. "ECDSA").
+	NotBefore   time.Time // Start of the validity period.
+	NotAfter    time.Time // Expiry time.
+	Profile     string    // JSON description of the profile it was issued with, if known.
+	PEM         string    // PEM-encoded certificate.
+}
+
+// certMonitor keeps track of the certificate expiry warnings already reported,
+// and of the periodic checks running while the teamserver has listeners.
+type certMonitor struct {
+	reported map[string]time.Duration // Smallest warning reported, by certificate fingerprint.
+	stop     chan struct{}            // Closed to stop the periodic checks, nil if not running.
+	mutex    sync.Mutex
+}
+
+func newCertMonitor() *certMonitor {
+	return &certMonitor{
+		reported: make(map[string]time.Duration),
+	}
+}
+
+// Certificates returns the users CA and all the certificates it issued that are still in use
+// (user credentials and server certificates, but not revoked ones), ordered by issuance.
+func (ts *Server) Certificates() ([]CertificateInfo, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	inventory, err := ts.certs.ListCertificates()
+	if err != nil {
+		return nil, ts.errorf("%w: failed to list certificates: %w", ErrCertificate, err)
+	}
+
+	infos := make([]CertificateInfo, len(inventory))
+	for i, cert := range inventory {
+		infos[i] = certificateInfo(cert)
+	}
+
+	return infos, nil
+}
+
+// Certificate returns a certificate of the inventory (see server.Certificates())
+// by serial number or by fingerprint, both hexadecimal, and which may be truncated.
+func (ts *Server) Certificate(id string) (*CertificateInfo, error) {
+	infos, err := ts.Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	id = strings.ToLower(strings.ReplaceAll(id, ":", ""))
+
+	var found []CertificateInfo
+
+	for _, info := range infos {
+		if id != "" && (strings.HasPrefix(info.Serial, id) || strings.HasPrefix(info.Fingerprint, id)) {
+			found = append(found, info)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, ts.errorf("%w: %w (%s)", ErrCertificate, certs.ErrCertDoesNotExist, id)
+	case 1:
+		return &found[0], nil
+	default:
+		return nil, ts.errorf("%w: %d certificates match '%s'", ErrCertificate, len(found), id)
+	}
+}
+
+// CheckCertificates checks the expiry of the users CA and of the certificates it issued (see
+// server.Certificates()). Those expiring within one of the configured warnings (certificates
+// expiry_warnings) are logged and published as EventCertExpiring (or EventCertExpired) events,
+// once for each warning. The server certificate is renewed when expiring within the configured
+// renew_server_before (or the third of its validity, if shorter), and the TLS configurations
+// returned by UsersTLSConfig() use it from then on, without restarting listeners.
+//
+// Teamservers call it periodically (certificates monitor_interval) while they have listeners.
+func (ts *Server) CheckCertificates() error {
+	infos, err := ts.Certificates()
+	if err != nil {
+		return err
+	}
+
+	log := ts.NamedLogger("certs", "monitor")
+	_, warnings, renewBefore := ts.certMonitorSettings()
+
+	var serverFingerprint string
+	if certPEM, _, err := ts.certs.UserServerGetCertificate(); err == nil {
+		if cert, err := certs.ParseCertificatePEM(certPEM); err == nil {
+			serverFingerprint = certs.Fingerprint(cert.Raw)
+		}
+	}
+
+	for _, info := range infos {
+		remaining := time.Until(info.NotAfter)
+
+		if info.Fingerprint == serverFingerprint && remaining <= min(renewBefore, info.NotAfter.Sub(info.NotBefore)/3) {
+			if err := ts.renewServerCertificate(info); err != nil {
+				log.Error(err.Error())
+			} else {
+				continue
+			}
+		}
+
+		ts.reportCertificateExpiry(info, remaining, warnings)
+	}
+
+	return nil
+}
+
+// startCertMonitor checks certificates, then starts checking them periodically,
+// unless this is already done. Listeners start it, and the last one to close stops it.
+func (ts *Server) startCertMonitor() {
+	ts.certMonitor.mutex.Lock()
+	if ts.certMonitor.stop != nil {
+		ts.certMonitor.mutex.Unlock()
+		return
+	}
+
+	stop := make(chan struct{})
+	ts.certMonitor.stop = stop
+	ts.certMonitor.mutex.Unlock()
+
+	_ = ts.CheckCertificates()
+
+	interval, _, _ := ts.certMonitorSettings()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				_ = ts.CheckCertificates()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopCertMonitor stops the periodic certificate checks, if no listener is running anymore.
+// The listeners are counted under the monitor lock, so that a listener starting meanwhile
+// either is counted here, or restarts the checks after they are stopped.
+func (ts *Server) stopCertMonitor() {
+	ts.certMonitor.mutex.Lock()
+	defer ts.certMonitor.mutex.Unlock()
+
+	if ts.certMonitor.stop == nil || len(ts.Listeners()) > 0 {
+		return
+	}
+
+	close(ts.certMonitor.stop)
+	ts.certMonitor.stop = nil
+}
+
+// reportCertificateExpiry logs and publishes the expiry of a certificate,
+// if it reached a warning that has not been reported for it yet.
+func (ts *Server) reportCertificateExpiry(info CertificateInfo, remaining time.Duration, warnings []time.Duration) {
+	warning := time.Duration(-1)
+
+	if remaining <= 0 {
+		warning = 0
+	} else {
+		for _, threshold := range warnings {
+			if remaining <= threshold {
+				warning = threshold
+			}
+		}
+	}
+
+	if warning < 0 {
+		return
+	}
+
+	ts.certMonitor.mutex.Lock()
+	reported, found := ts.certMonitor.reported[info.Fingerprint]
+	if !found || warning < reported {
+		ts.certMonitor.reported[info.Fingerprint] = warning
+	}
+	ts.certMonitor.mutex.Unlock()
+
+	if found && warning >= reported {
+		return
+	}
+
+	event := Event{Type: EventCertExpiring, Subject: info.Serial}
+	if warning == 0 {
+		event.Type = EventCertExpired
+		event.Message = fmt.Sprintf("%s certificate %s (%s) expired on %s",
+			info.Purpose, info.Subject, info.Serial, info.NotAfter.Format(time.RFC1123))
+	} else {
+		event.Message = fmt.Sprintf("%s certificate %s (%s) expires in %s, on %s",
+			info.Purpose, info.Subject, info.Serial, remaining.Round(time.Minute), info.NotAfter.Format(time.RFC1123))
+	}
+
+	ts.NamedLogger("certs", "monitor").Warn(event.Message)
+	ts.publish(event)
+}
+
+// renewServerCertificate reissues the users server certificate, which expires soon.
+func (ts *Server) renewServerCertificate(info CertificateInfo) error {
+	if err := ts.generateServerCertificate(); err != nil {
+		return fmt.Errorf("%w: failed to renew server certificate: %w", ErrCertificate, err)
+	}
+
+	ts.resetUsersTLS()
+
+	message := fmt.Sprintf("Renewed server certificate %s, which expires on %s", info.Serial, info.NotAfter.Format(time.RFC1123))
+
+	ts.NamedLogger("certs", "monitor").Info(message)
+	ts.publish(Event{Type: EventCertRenewed, Subject: info.Serial, Message: message})
+	ts.audit(AuditCertRenew, "server", "serial "+info.Serial)
+
+	return nil
+}
+
+// generateServerCertificate issues a new users server certificate, valid for the current
+// server names (see serverCertificateNames()), and replacing the current one if any.
+func (ts *Server) generateServerCertificate() error {
+	_, _, err := ts.certs.UserServerGenerateCertificate(ts.serverCertificateNames()...)
+	return err
+}
+
+// serverCertificateNames returns the host names and IP addresses for which the users server
+// certificate is issued, sorted: the ones of the daemon, of the persistent and running listeners,
+// and the server names of the configuration. Hosts binding all interfaces are not names.
+func (ts *Server) serverCertificateNames() []string {
+	config := ts.opts.config
+
+	hosts := []string{config.DaemonMode.Host}
+
+	for _, listener := range config.Listeners {
+		hosts = append(hosts, listener.Host)
+	}
+
+	ts.jobs.active.Range(func(_, value any) bool {
+		hosts = append(hosts, value.(*job).host)
+		return true
+	})
+
+	hosts = append(hosts, config.Certificates.ServerNames...)
+
+	names := make([]string, 0, len(hosts))
+
+	for _, host := range hosts {
+		host = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(host), "["), "]")
+
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			continue
+		}
+
+		names = append(names, host)
+	}
+
+	slices.Sort(names)
+
+	return slices.Compact(names)
+}
+
+func certificateInfo(cert certs.Certificate) CertificateInfo {
+	credential := cert.Label
+	if cert.Purpose == certs.PurposeClient && credential == "" {
+		credential = DefaultCredential
+	}
+
+	return CertificateInfo{
+		Subject:     cert.Subject.CommonName,
+		Issuer:      cert.Issuer.CommonName,
+		Serial:      certs.FormatSerial(cert.SerialNumber),
+		Fingerprint: certs.Fingerprint(cert.Raw),
+		Purpose:     cert.Purpose,
+		User:        cert.User,
+		Credential:  credential,
+		Algorithm:   cert.PublicKeyAlgorithm.String(),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		Profile:     cert.Profile,
+		PEM:         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	}
+}
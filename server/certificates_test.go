@@ -0,0 +1,307 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestCertificatesInventory checks that the certificates in use are listed with their
+// owner, and can be looked up by serial number or fingerprint.
+func TestCertificatesInventory(t *testing.T) {
+	ts := newTestServer(t)
+
+	if _, err := ts.UsersTLSConfig(); err != nil {
+		t.Fatalf("UsersTLSConfig: %v", err)
+	}
+
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.UserCredentialAdd("alice", "laptop", "localhost", 31337); err != nil {
+		t.Fatalf("UserCredentialAdd: %v", err)
+	}
+
+	infos, err := ts.Certificates()
+	if err != nil {
+		t.Fatalf("Certificates: %v", err)
+	}
+
+	purposes := make(map[string]int)
+	owners := make(map[string]bool)
+
+	for _, info := range infos {
+		purposes[info.Purpose]++
+		owners[info.User+"/"+info.Credential] = true
+	}
+
+	if purposes["ca"] != 1 || purposes["server"] != 1 || purposes["client"] != 2 {
+		t.Fatalf("expected the CA, a server and 2 client certificates, got %v", purposes)
+	}
+
+	if !owners["alice/"+DefaultCredential] || !owners["alice/laptop"] {
+		t.Fatalf("client certificates should be owned by alice credentials, got %v", owners)
+	}
+
+	info, err := ts.Certificate(infos[len(infos)-1].Serial[:12])
+	if err != nil || info.Credential != "laptop" {
+		t.Fatalf("certificate lookup by serial: %+v (%v)", info, err)
+	}
+
+	if info, err := ts.Certificate(infos[0].Fingerprint); err != nil || info.Purpose != "ca" {
+		t.Fatalf("certificate lookup by fingerprint: %+v (%v)", info, err)
+	}
+
+	if _, err := ts.Certificate("zz"); !errors.Is(err, ErrCertificate) {
+		t.Fatalf("unknown certificate should fail with ErrCertificate, got %v", err)
+	}
+}
+
+// TestCheckCertificates checks that certificates close to expiry are reported once per
+// warning, and that the server certificate is renewed and used by the TLS configuration.
+func TestCheckCertificates(t *testing.T) {
+	ts, err := New("expiry", WithInMemory(), WithCertificateProfiles(
+		CertificateProfile{Purpose: "server", Validity: "3h", Backdate: "150m"},
+		CertificateProfile{Purpose: "client", Validity: "12h", Backdate: "0s"},
+	))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	tlsConfig, err := ts.UsersTLSConfig()
+	if err != nil {
+		t.Fatalf("UsersTLSConfig: %v", err)
+	}
+
+	previous, _ := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	events, unsubscribe := ts.Subscribe()
+	defer unsubscribe()
+
+	received := func() map[string]int {
+		types := make(map[string]int)
+
+		for {
+			select {
+			case event := <-events:
+				types[event.Type]++
+			default:
+				return types
+			}
+		}
+	}
+
+	if err := ts.CheckCertificates(); err != nil {
+		t.Fatalf("CheckCertificates: %v", err)
+	}
+
+	if types := received(); types[EventCertRenewed] != 1 || types[EventCertExpiring] != 1 {
+		t.Fatalf("expected the server certificate renewal and a client expiry warning, got %v", types)
+	}
+
+	renewed, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || string(renewed.Certificate[0]) == string(previous.Certificate[0]) {
+		t.Fatalf("TLS configuration should use the renewed server certificate (%v)", err)
+	}
+
+	// The client warning is not reported again.
+	if err := ts.CheckCertificates(); err != nil {
+		t.Fatalf("CheckCertificates: %v", err)
+	}
+
+	if types := received(); types[EventCertExpiring] != 0 {
+		t.Fatalf("expiry warnings should be reported once, got %v", types)
+	}
+}
+
+// TestCertMonitorStopped checks that the periodic certificate checks run while
+// the teamserver has listeners, and stop when the last one is closed.
+func TestCertMonitorStopped(t *testing.T) {
+	ts := newTestServer(t)
+
+	listen := func() string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("net.Listen: %v", err)
+		}
+
+		id, err := getRandomID()
+		if err != nil {
+			t.Fatalf("getRandomID: %v", err)
+		}
+
+		ts.addListenerJob(id, "test", "127.0.0.1", 0, ln)
+		ts.startCertMonitor()
+
+		return id
+	}
+
+	first, second := listen(), listen()
+
+	ts.certMonitor.mutex.Lock()
+	stop := ts.certMonitor.stop
+	ts.certMonitor.mutex.Unlock()
+
+	if stop == nil {
+		t.Fatal("certificates should be checked while listeners are running")
+	}
+
+	if err := ts.ListenerClose(first); err != nil {
+		t.Fatalf("ListenerClose: %v", err)
+	}
+
+	if err := ts.ListenerClose(second); err != nil {
+		t.Fatalf("ListenerClose: %v", err)
+	}
+
+	select {
+	case <-stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("certificates checks should stop with the last listener")
+	}
+
+	// They start again with a new listener.
+	third := listen()
+	defer ts.ListenerClose(third)
+
+	ts.certMonitor.mutex.Lock()
+	defer ts.certMonitor.mutex.Unlock()
+
+	if ts.certMonitor.stop == nil || ts.certMonitor.stop == stop {
+		t.Fatal("certificates should be checked again with a new listener")
+	}
+}
+
+// TestCertMonitorSettingsParsedOnce checks that the certificates monitor settings are parsed
+// when the configuration is saved, invalid durations being replaced by defaults or dropped.
+func TestCertMonitorSettingsParsedOnce(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Certificates.MonitorInterval = "invalid"
+	ts.opts.config.Certificates.ExpiryWarnings = []string{"1h", "invalid", "48h"}
+	saveTestConfig(t, ts)
+
+	interval, warnings, renewBefore := ts.certMonitorSettings()
+	if interval != defaultCertMonitorInterval || renewBefore != defaultServerCertRenewal {
+		t.Fatalf("invalid durations should be replaced by defaults, got %s and %s", interval, renewBefore)
+	}
+
+	if len(warnings) != 2 || warnings[0] != 48*time.Hour || warnings[1] != time.Hour {
+		t.Fatalf("valid expiry warnings should be kept, longest first: %v", warnings)
+	}
+
+	ts.opts.config.Certificates.MonitorInterval = "1s"
+
+	if interval, _, _ := ts.certMonitorSettings(); interval != defaultCertMonitorInterval {
+		t.Fatal("certificates monitor settings should only be parsed when the configuration is saved")
+	}
+}
+
+// TestServerCertificateNames checks that the server certificate is valid for the configured
+// server names and listeners hosts, and that it is reissued when a new one is added.
+func TestServerCertificateNames(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Certificates.ServerNames = []string{"team.example.com", "10.0.0.1"}
+
+	tlsConfig, err := ts.UsersTLSConfig()
+	if err != nil {
+		t.Fatalf("UsersTLSConfig: %v", err)
+	}
+
+	leaf := func() *x509.Certificate {
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("GetCertificate: %v", err)
+		}
+
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("ParseCertificate: %v", err)
+		}
+
+		return parsed
+	}
+
+	previous := leaf()
+	for _, name := range []string{"team.example.com", "10.0.0.1"} {
+		if err := previous.VerifyHostname(name); err != nil {
+			t.Fatalf("server certificate should be valid for %s: %v", name, err)
+		}
+	}
+
+	// Hosts binding all interfaces are not names.
+	if err := ts.ListenerAdd("", "0.0.0.0", 31337); err != nil {
+		t.Fatalf("ListenerAdd: %v", err)
+	}
+
+	if current := leaf(); !current.Equal(previous) {
+		t.Fatal("server certificate should not be reissued for an unspecified address")
+	}
+
+	if err := ts.ListenerAdd("", "teamserver.lan", 31338); err != nil {
+		t.Fatalf("ListenerAdd: %v", err)
+	}
+
+	current := leaf()
+	if current.Equal(previous) {
+		t.Fatal("server certificate should be reissued for a new listener host")
+	}
+
+	for _, name := range []string{"teamserver.lan", "team.example.com", "10.0.0.1"} {
+		if err := current.VerifyHostname(name); err != nil {
+			t.Fatalf("reissued certificate should be valid for %s: %v", name, err)
+		}
+	}
+}
+
+// failingStore is a secret store whose writes fail.
+type failingStore struct {
+	SecretStore
+}
+
+func (failingStore) Put(string, []byte) error { return errors.New("injected store failure") }
+
+// TestCertificateErrors checks that failures of the certificate infrastructure
+// are returned by the teamserver as certificate errors, instead of exiting.
+func TestCertificateErrors(t *testing.T) {
+	ts, err := New("certerrors", WithInMemory(), WithCustomSecretStore(failingStore{}))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	// Errors are returned by all uses of the certificate infrastructure.
+	for range 2 {
+		if _, err := ts.UserCreate("alice", "localhost", 31337); !errors.Is(err, ErrCertificate) {
+			t.Fatalf("UserCreate should fail with ErrCertificate, got %v", err)
+		}
+
+		if _, err := ts.UsersTLSConfig(); !errors.Is(err, ErrCertificate) {
+			t.Fatalf("UsersTLSConfig should fail with ErrCertificate, got %v", err)
+		}
+	}
+}
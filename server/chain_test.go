@@ -0,0 +1,173 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/reeflective/team/internal/certs"
+)
+
+// TestUsersImportCAChain checks that with an intermediate users CA, teamclients and the
+// teamserver send their certificate along the chain, and verify each other up to the root.
+func TestUsersImportCAChain(t *testing.T) {
+	ts := newTestServer(t)
+
+	newCA := func(name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+
+		der, _ := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		cert, _ := x509.ParseCertificate(der)
+
+		return cert, key
+	}
+
+	root, rootKey := newCA("Org Root", nil, nil)
+	intermediate, key := newCA("Org Teamserver", root, rootKey)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	intermediatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediate.Raw})
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	chainPEM := append(append([]byte{}, intermediatePEM...), rootPEM...)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if err := ts.UsersImportCA(intermediatePEM, keyPEM, ""); !errors.Is(err, ErrCertificate) {
+		t.Fatalf("intermediate CA without its root should fail with ErrCertificate, got %v", err)
+	}
+
+	if err := ts.UsersImportCA(chainPEM, keyPEM, ""); err != nil {
+		t.Fatalf("UsersImportCA: %v", err)
+	}
+
+	if _, err := ts.UsersRotateCA(time.Hour); !errors.Is(err, ErrCertificate) {
+		t.Fatalf("rotating an intermediate CA should fail, got %v", err)
+	}
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if caChain, _ := certs.ParseCertificatesPEM([]byte(config.CACertificate)); len(caChain) != 2 {
+		t.Fatalf("client config should contain the users CA chain, got %d certificates", len(caChain))
+	}
+
+	serverConfig, err := ts.UsersTLSConfig()
+	if err != nil {
+		t.Fatalf("UsersTLSConfig: %v", err)
+	}
+
+	handshake := func(certPEM, keyPEM string) error {
+		clientCert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			t.Fatalf("client key pair: %v", err)
+		}
+
+		var serverChain [][]byte
+
+		clientConfig := &tls.Config{
+			Certificates:       []tls.Certificate{clientCert},
+			InsecureSkipVerify: true,
+			MinVersion:         tls.VersionTLS13,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				serverChain = rawCerts
+				return certs.RootOnlyVerifyCertificate(string(rootPEM), rawCerts)
+			},
+		}
+
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+
+		errs := make(chan error, 1)
+
+		go func() {
+			conn := tls.Server(serverConn, serverConfig)
+			err := conn.Handshake()
+			if err == nil {
+				// TLS 1.3 client certificates are verified after the client handshake.
+				_, err = conn.Write([]byte{0})
+			}
+			serverConn.Close()
+			errs <- err
+		}()
+
+		conn := tls.Client(clientConn, clientConfig)
+		if err := conn.Handshake(); err != nil {
+			return err
+		}
+
+		_, err = conn.Read(make([]byte, 1))
+		if serverErr := <-errs; serverErr != nil {
+			return serverErr
+		}
+
+		if len(serverChain) != 2 {
+			t.Fatalf("server certificate should be sent along the intermediate, got %d certificates", len(serverChain))
+		}
+
+		return err
+	}
+
+	if err := handshake(config.Certificate, config.PrivateKey); err != nil {
+		t.Fatalf("handshake with the client certificate chain: %v", err)
+	}
+
+	leaf, _ := pem.Decode([]byte(config.Certificate))
+	if err := handshake(string(pem.EncodeToMemory(leaf)), config.PrivateKey); err == nil {
+		t.Fatal("client certificate without chain should not verify up to the root")
+	}
+
+	// Certificates issued by another CA of the organization are valid against the root only.
+	sibling, siblingKey := newCA("Org Other", root, rootKey)
+	other, otherKey := newCA("alice", sibling, siblingKey)
+	otherKeyDER, _ := x509.MarshalECPrivateKey(otherKey)
+
+	otherPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.Raw})
+	otherPEM = append(otherPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: sibling.Raw})...)
+
+	err = handshake(string(otherPEM), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: otherKeyDER})))
+	if err == nil {
+		t.Fatal("client certificate of another CA of the PKI should not be trusted")
+	}
+}
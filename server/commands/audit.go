@@ -0,0 +1,129 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func auditCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		since, _ := cmd.Flags().GetString("since")
+		until, _ := cmd.Flags().GetString("until")
+		actor, _ := cmd.Flags().GetString("user")
+		action, _ := cmd.Flags().GetString("action")
+		limit, _ := cmd.Flags().GetInt("limit")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		filter := server.AuditFilter{Actor: actor, Action: action, Limit: limit}
+
+		var err error
+
+		if filter.Since, err = parseAuditTime(since); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Invalid --since time: %s\n", err)
+			return
+		}
+
+		if filter.Until, err = parseAuditTime(until); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Invalid --until time: %s\n", err)
+			return
+		}
+
+		events, err := serv.AuditEvents(filter)
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if asJSON {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "    ")
+
+			if err := encoder.Encode(events); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"JSON marshaling error: %s\n", err)
+			}
+
+			return
+		}
+
+		if len(events) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), command.Info+"No audit events")
+			return
+		}
+
+		tbl := &table.Table{}
+		tbl.SetStyle(command.TableStyle)
+
+		tbl.AppendHeader(table.Row{
+			"Time",
+			"Actor",
+			"Origin",
+			"Action",
+			"Target",
+			"Details",
+		})
+
+		for _, event := range events {
+			tbl.AppendRow(table.Row{
+				event.Time.Format(time.RFC1123),
+				event.Actor,
+				event.Origin,
+				event.Action,
+				event.Target,
+				event.Details,
+			})
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), tbl.Render())
+	}
+}
+
+// parseAuditTime parses an audit time filter, either as a duration
+// before now (eg. "24h"), an RFC3339 time or a date (2006-01-02).
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago), nil
+	}
+
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+
+	return time.ParseInLocation(time.DateOnly, value, time.Local)
+}
@@ -0,0 +1,101 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func backupCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if args[0] == "-" {
+			if err := serv.Backup(cmd.OutOrStdout()); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			}
+
+			return
+		}
+
+		file, err := os.OpenFile(args[0], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		err = serv.Backup(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			os.Remove(args[0])
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+
+			return
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Saved the teamserver state to %s\n", args[0])
+	}
+}
+
+func restoreCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		var archive io.Reader = cmd.InOrStdin()
+
+		if args[0] != "-" {
+			file, err := os.Open(args[0])
+			if err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				return
+			}
+			defer file.Close()
+
+			archive = file
+		}
+
+		if err := serv.Restore(archive); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Restored the teamserver state from %s\n", args[0])
+	}
+}
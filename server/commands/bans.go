@@ -0,0 +1,96 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func unbanCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		all, _ := cmd.Flags().GetBool("all")
+
+		if len(args) == 0 && !all {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn+"Provide at least one banned address or credential, or --all")
+			return
+		}
+
+		if all {
+			args = args[:0]
+			for _, ban := range serv.Bans() {
+				args = append(args, ban.Subject)
+			}
+		}
+
+		for _, subject := range args {
+			if err := serv.Unban(subject); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				continue
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Unbanned %s\n", subject)
+		}
+	}
+}
+
+// bansTable returns the banned addresses and credentials, or an empty string if none.
+func bansTable(serv *server.Server) string {
+	bans := serv.Bans()
+	if len(bans) == 0 {
+		return ""
+	}
+
+	tbl := &table.Table{}
+	tbl.SetStyle(command.TableStyle)
+
+	tbl.AppendHeader(table.Row{
+		"Subject",
+		"Kind",
+		"Failures",
+		"Banned",
+		"Expires",
+	})
+
+	for _, ban := range bans {
+		tbl.AppendRow(table.Row{
+			ban.Subject,
+			ban.Kind,
+			ban.Failures,
+			ban.BannedAt.Format(time.RFC1123),
+			ban.ExpiresAt.Format(time.RFC1123),
+		})
+	}
+
+	return tbl.Render()
+}
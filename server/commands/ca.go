@@ -0,0 +1,121 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func caRotateCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		window, _ := cmd.Flags().GetDuration("window")
+
+		rotation, err := serv.UsersRotateCA(window)
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Rotated users CA (new CA %s)\n", rotation.Current[:16])
+		fmt.Fprintf(cmd.OutOrStdout(), "    The previous CA is trusted until %s: users must run\n", rotation.Ends.Format(time.RFC1123))
+		fmt.Fprintln(cmd.OutOrStdout(), "    'teamclient renew' before then, or be given new configs.")
+	}
+}
+
+func caStatusCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		rotation, err := serv.UsersCARotation()
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if rotation == nil {
+			fmt.Fprintln(cmd.OutOrStdout(), command.Info+"No users CA rotation in progress")
+			return
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Users CA rotation in progress, until %s (%s left)\n",
+			rotation.Ends.Format(time.RFC1123), time.Until(rotation.Ends).Round(time.Minute))
+		fmt.Fprintf(cmd.OutOrStdout(), "    Current CA:  %s\n", rotation.Current)
+		fmt.Fprintf(cmd.OutOrStdout(), "    Previous CA: %s\n", rotation.Previous)
+	}
+}
+
+func caImportCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		chainPEM, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Cannot read CA certificate chain: %v\n", err)
+			return
+		}
+
+		keyPEM, err := os.ReadFile(args[1])
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Cannot read CA private key: %v\n", err)
+			return
+		}
+
+		if err := importUsersCA(cmd, serv, chainPEM, keyPEM); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), command.Info+"Imported users CA: users need new configs, issued by this CA.")
+	}
+}
@@ -0,0 +1,160 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func certsListCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		infos, err := serv.Certificates()
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), certificatesTable(infos))
+	}
+}
+
+func certsShowCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		info, err := serv.Certificate(args[0])
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if printPEM, _ := cmd.Flags().GetBool("pem"); printPEM {
+			fmt.Fprint(cmd.OutOrStdout(), info.PEM)
+			return
+		}
+
+		out := cmd.OutOrStdout()
+
+		fmt.Fprintf(out, "Subject:      %s\n", info.Subject)
+		fmt.Fprintf(out, "Issuer:       %s\n", info.Issuer)
+		fmt.Fprintf(out, "Purpose:      %s\n", info.Purpose)
+		fmt.Fprintf(out, "Owner:        %s\n", certificateOwner(*info))
+		fmt.Fprintf(out, "Serial:       %s\n", info.Serial)
+		fmt.Fprintf(out, "Fingerprint:  %s\n", info.Fingerprint)
+		fmt.Fprintf(out, "Algorithm:    %s\n", info.Algorithm)
+		fmt.Fprintf(out, "Not before:   %s\n", info.NotBefore.Format(time.RFC1123))
+		fmt.Fprintf(out, "Not after:    %s (%s)\n", info.NotAfter.Format(time.RFC1123), certificateExpiry(*info))
+
+		if info.Profile != "" {
+			fmt.Fprintf(out, "Profile:      %s\n", info.Profile)
+		}
+	}
+}
+
+func certsMigrateCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		previous := serv.SecretStoreName()
+
+		moved, err := serv.SecretsMigrate(args[0])
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Moved %d private keys from the %s store to the %s store.\n", moved, previous, args[0])
+	}
+}
+
+func certificatesTable(infos []server.CertificateInfo) string {
+	tbl := &table.Table{}
+	tbl.SetStyle(command.TableStyle)
+
+	tbl.AppendHeader(table.Row{
+		"Subject",
+		"Purpose",
+		"Owner",
+		"Serial",
+		"Not after",
+		"Expires",
+	})
+
+	for _, info := range infos {
+		tbl.AppendRow(table.Row{
+			info.Subject,
+			info.Purpose,
+			certificateOwner(info),
+			info.Serial,
+			info.NotAfter.Format(time.RFC1123),
+			certificateExpiry(info),
+		})
+	}
+
+	return tbl.Render()
+}
+
+// certificateOwner returns the user and credential owning a
+// client certificate, or the teamserver for other certificates.
+func certificateOwner(info server.CertificateInfo) string {
+	if info.User == "" {
+		return "teamserver"
+	}
+
+	return fmt.Sprintf("%s (%s)", info.User, info.Credential)
+}
+
+// certificateExpiry returns how long before a certificate expires.
+func certificateExpiry(info server.CertificateInfo) string {
+	remaining := time.Until(info.NotAfter)
+	if remaining <= 0 {
+		return "expired"
+	}
+
+	if remaining < 48*time.Hour {
+		return "in " + remaining.Round(time.Minute).String()
+	}
+
+	return fmt.Sprintf("in %d days", int(remaining.Hours()/24))
+}
@@ -20,6 +20,8 @@ package commands
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/carapace-sh/carapace"
 	"github.com/spf13/cobra"
@@ -28,6 +30,7 @@ import (
 	"github.com/reeflective/team/client"
 	cli "github.com/reeflective/team/client/commands"
 	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/internal/db"
 	"github.com/reeflective/team/log"
 	"github.com/reeflective/team/server"
 )
@@ -228,13 +231,130 @@ is given. --user sets the OS user the service runs as (a value, e.g. --user myap
 		Use:   "status",
 		Short: "Show the status of the teamserver (listeners, configurations, health...)",
 		Long: `Show the teamserver's home directory, database, config path, log files and levels,
-certificate files, and the state of all listeners (running and saved/persistent).`,
+certificate files, the state of all listeners (running and saved/persistent), the live
+client sessions, and the addresses and credentials banned after failed authentications.`,
 		GroupID: command.TeamServerGroup,
 		Run:     statusCmd(server),
 	}
 
 	teamCmd.AddCommand(statusCmd)
 
+	// Live client sessions
+	sessionsCmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "List the teamclients currently connected to the teamserver",
+		Long: `List the live client sessions: one for each connected teamclient, with its user,
+listener stack, remote address, client version and connection time. Use --user to
+only show the sessions of a given user.`,
+		Example: `  teamserver sessions
+  teamserver sessions --user alice`,
+		GroupID: command.TeamServerGroup,
+		Run:     sessionsCmd(server),
+	}
+
+	sessionsCmd.Flags().StringP("user", "u", "", "only show sessions of this user")
+	carapace.Gen(sessionsCmd).FlagCompletion(carapace.ActionMap{
+		"user": carapace.ActionCallback(userCompleter(server)),
+	})
+
+	teamCmd.AddCommand(sessionsCmd)
+
+	// Kick live client sessions
+	kickCmd := &cobra.Command{
+		Use:   "kick",
+		Short: "Disconnect one or more teamclient sessions",
+		Long: `Terminate live client sessions by ID (a unique prefix is enough), or all sessions
+of a user with --user. The connections are closed immediately, but the users and their
+credentials are left untouched: kicked clients can reconnect. Use 'delete' to revoke
+a user for good. Session IDs are shown by 'sessions' and are completed.`,
+		Example: `  teamserver kick 5b2e90ad
+  teamserver kick --user alice`,
+		GroupID: command.TeamServerGroup,
+		Run:     kickCmd(server),
+	}
+
+	kickCmd.Flags().StringP("user", "u", "", "kick all sessions of this user")
+
+	kickComps := carapace.Gen(kickCmd)
+	kickComps.FlagCompletion(carapace.ActionMap{
+		"user": carapace.ActionCallback(userCompleter(server)),
+	})
+	kickComps.PositionalAnyCompletion(carapace.ActionCallback(sessionIDCompleter(server)))
+
+	kickComps.PreRun(func(cmd *cobra.Command, args []string) {
+		if cmd.PersistentPreRunE != nil {
+			cmd.PersistentPreRunE(cmd, args)
+		}
+
+		if cmd.PreRunE != nil {
+			cmd.PreRunE(cmd, args)
+		}
+	})
+
+	// Lift bans of failed authentications
+	unbanCmd := &cobra.Command{
+		Use:   "unban",
+		Short: "Lift the ban of remote addresses or credentials",
+		Long: `Remote addresses and client certificates (credentials, as <user>/<serial>) are banned
+for a while after too many failed authentication attempts, as set in the lockout section
+of the teamserver configuration. Bans are shown by 'status' and are completed. Lifting a
+ban also forgets the failed attempts of the address or credential.`,
+		Example: `  teamserver unban 203.0.113.7
+  teamserver unban alice/4f1c2a9e
+  teamserver unban --all`,
+		GroupID: command.TeamServerGroup,
+		Run:     unbanCmd(server),
+	}
+
+	unbanCmd.Flags().BoolP("all", "a", false, "lift all bans")
+
+	unbanComps := carapace.Gen(unbanCmd)
+	unbanComps.PositionalAnyCompletion(carapace.ActionCallback(banCompleter(server)))
+
+	unbanComps.PreRun(func(cmd *cobra.Command, args []string) {
+		if cmd.PersistentPreRunE != nil {
+			cmd.PersistentPreRunE(cmd, args)
+		}
+
+		if cmd.PreRunE != nil {
+			cmd.PreRunE(cmd, args)
+		}
+	})
+
+	teamCmd.AddCommand(kickCmd)
+	teamCmd.AddCommand(unbanCmd)
+
+	// Administrative audit trail
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show the administrative actions performed on the teamserver",
+		Long: `Show the audit trail of the teamserver: users, credentials, certificate authorities,
+listeners and sessions managed with these commands (origin "cli", with the OS user as
+actor) or by teamclients (origin "rpc"), oldest first. Times given to --since/--until
+are either durations before now (eg. 24h), RFC3339 times or dates (2006-01-02).
+An --action family (eg. user) matches all its actions (user.create, user.delete...).`,
+		Example: `  teamserver audit --since 24h
+  teamserver audit --user alice --action credential
+  teamserver audit --since 2024-01-01 --until 2024-02-01 --json`,
+		GroupID: command.TeamServerGroup,
+		Args:    cobra.NoArgs,
+		Run:     auditCmd(server),
+	}
+
+	auditCmd.Flags().StringP("since", "s", "", "only show actions performed since this time")
+	auditCmd.Flags().StringP("until", "U", "", "only show actions performed before this time")
+	auditCmd.Flags().StringP("user", "u", "", "only show actions performed by this user")
+	auditCmd.Flags().StringP("action", "a", "", "only show this action, or family of actions")
+	auditCmd.Flags().IntP("limit", "n", 100, "only show the most recent actions (0: all)")
+	auditCmd.Flags().BoolP("json", "j", false, "print the actions as JSON")
+
+	carapace.Gen(auditCmd).FlagCompletion(carapace.ActionMap{
+		"user":   carapace.ActionCallback(userCompleter(server)),
+		"action": auditActionCompleter(),
+	})
+
+	teamCmd.AddCommand(auditCmd)
+
 	// [ Users and data control commands ] -------------------------------------------------
 
 	// Add user
@@ -267,6 +387,117 @@ user and save into this app's client configs directory).`,
 	userComps["host"] = interfacesCompleter()
 	carapace.Gen(userCmd).FlagCompletion(userComps)
 
+	// User credentials (devices)
+	credsCmd := &cobra.Command{
+		Use:   "credentials",
+		Short: "Manage the credentials (one per device) of a user",
+		Long: `A user can have several credentials, usually one per device it connects from: each
+has its own client certificate, API token and *.teamclient.cfg file, and can be revoked
+without affecting the others. The 'user' command issues (or replaces) the credential
+labeled "default"; use 'add' to issue more of them.`,
+		Example: `  teamserver user credentials list alice
+  teamserver user credentials add alice --label laptop --host teamserver.example.com
+  teamserver user credentials revoke alice laptop`,
+	}
+
+	userCmd.AddCommand(credsCmd)
+
+	credsListCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the credentials of a user",
+		Args:  cobra.ExactArgs(1),
+		Run:   credentialsListCmd(server),
+	}
+
+	credsCmd.AddCommand(credsListCmd)
+
+	credsAddCmd := &cobra.Command{
+		Use:   "add",
+		Short: "Issue a new credential to a user, and generate its client configuration file",
+		Long: `Issue a new credential to an existing user, and write its *.teamclient.cfg to the
+current directory (or --save <dir|file>). The label must be unique for this user.`,
+		Args: cobra.ExactArgs(1),
+		Run:  credentialsAddCmd(server),
+	}
+
+	credsAddFlags := pflag.NewFlagSet("credentials", pflag.ContinueOnError)
+	credsAddFlags.StringP("label", "L", "", "credential label (eg. the device name)")
+	credsAddFlags.StringP("host", "l", "", "listen host")
+	credsAddFlags.Uint16P("port", "p", 0, "listen port")
+	credsAddFlags.StringP("save", "s", "", "directory/file in which to save config")
+	credsAddCmd.Flags().AddFlagSet(credsAddFlags)
+	credsAddCmd.MarkFlagRequired("label")
+
+	credsCmd.AddCommand(credsAddCmd)
+
+	credsRevokeCmd := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke one or more credentials of a user",
+		Long: `Revoke credentials of a user by label: their tokens are refused, their certificates
+revoked, and the sessions established with them closed. The other credentials of the
+user keep working.`,
+		Args: cobra.MinimumNArgs(2),
+		Run:  credentialsRevokeCmd(server),
+	}
+
+	credsCmd.AddCommand(credsRevokeCmd)
+
+	for _, credCmd := range []*cobra.Command{credsListCmd, credsAddCmd, credsRevokeCmd} {
+		credComps := carapace.Gen(credCmd)
+		credComps.PositionalCompletion(carapace.ActionCallback(userCompleter(server)))
+
+		credComps.PreRun(func(cmd *cobra.Command, args []string) {
+			if cmd.PersistentPreRunE != nil {
+				cmd.PersistentPreRunE(cmd, args)
+			}
+
+			if cmd.PreRunE != nil {
+				cmd.PreRunE(cmd, args)
+			}
+		})
+	}
+
+	carapace.Gen(credsAddCmd).FlagCompletion(carapace.ActionMap{
+		"save": carapace.ActionFiles(),
+		"host": interfacesCompleter(),
+	})
+	carapace.Gen(credsRevokeCmd).PositionalAnyCompletion(carapace.ActionCallback(credentialCompleter(server)))
+
+	// Invite a user to enroll a teamclient.
+	inviteCmd := &cobra.Command{
+		Use:   "invite",
+		Short: "Create a one-time invitation code for a user to enroll a teamclient with",
+		Long: `Create a short-lived, single-use invitation code, which the user passes to the
+'teamclient enroll' command: the teamclient generates its own private key and sends a
+certificate request, and receives a signed certificate and API token in exchange.
+The private key never leaves the user's machine, and no config file must be handed out.
+
+The user is created if needed. Without --label, the code issues the "default" credential
+of the user, replacing the current one when enrolling.`,
+		Example: `  teamserver user invite alice
+  teamserver user invite alice --label laptop --expiry 30m`,
+		Args: cobra.ExactArgs(1),
+		Run:  inviteUserCmd(server),
+	}
+
+	inviteCmd.Flags().StringP("label", "L", "", "credential label (eg. the device name)")
+	inviteCmd.Flags().DurationP("expiry", "e", time.Hour, "validity of the invitation code")
+
+	userCmd.AddCommand(inviteCmd)
+
+	inviteComps := carapace.Gen(inviteCmd)
+	inviteComps.PositionalCompletion(carapace.ActionCallback(userCompleter(server)))
+
+	inviteComps.PreRun(func(cmd *cobra.Command, args []string) {
+		if cmd.PersistentPreRunE != nil {
+			cmd.PersistentPreRunE(cmd, args)
+		}
+
+		if cmd.PreRunE != nil {
+			cmd.PreRunE(cmd, args)
+		}
+	})
+
 	// Delete and kick user
 	rmUserCmd := &cobra.Command{
 		Use:   "delete",
@@ -296,12 +527,116 @@ working.`,
 		}
 	})
 
+	// Rotate user tokens
+	rotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Issue new API tokens to one or more users, keeping their certificates",
+		Long: `Issue a new API token to each given user, and print it. By default the token of
+the "default" credential is rotated: use --credential for another one. The user and its
+TLS certificates are kept, and its previous token keeps working for the grace period set
+in the teamserver config (users.token_grace_period), after which the user must have
+updated the "token" field of its client config.
+
+Connected teamclients can instead refresh their own token with 'teamclient refresh'.
+Token lifetimes are set in the teamserver config (users.token_expiry).`,
+		Example: `  teamserver rotate alice`,
+		GroupID: command.UserManagementGroup,
+		Args:    cobra.MinimumNArgs(1),
+		Run:     rotateTokenCmd(server),
+	}
+
+	rotateCmd.Flags().StringP("credential", "c", "default", "label of the credential to rotate the token of")
+
+	rotateComps := carapace.Gen(rotateCmd)
+	rotateComps.FlagCompletion(carapace.ActionMap{
+		"credential": carapace.ActionCallback(credentialCompleter(server)),
+	})
+	rotateComps.PositionalAnyCompletion(carapace.ActionCallback(userCompleter(server)))
+
+	rotateComps.PreRun(func(cmd *cobra.Command, args []string) {
+		if cmd.PersistentPreRunE != nil {
+			cmd.PersistentPreRunE(cmd, args)
+		}
+
+		if cmd.PreRunE != nil {
+			cmd.PreRunE(cmd, args)
+		}
+	})
+
+	teamCmd.AddCommand(rotateCmd)
+
+	// Revoke user certificates
+	revokeCmd := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke the certificates of one or more users, or certificates by serial number",
+		Long: `Revoke all client certificates of the given users: they are refused during the
+TLS handshake from now on, and the users live sessions are closed. Unlike 'delete',
+the users are kept, and running 'user' again for them issues new certificates.
+
+Certificates unknown to the teamserver (eg. of already deleted users) can be revoked
+by their hexadecimal serial number with --serial. Revocations are permanent, and are
+listed (and exported as a standard X.509 CRL) with the 'crl' command.`,
+		Example: `  teamserver revoke alice --reason keyCompromise
+  teamserver revoke --serial 5f3a9c01b2e4d6f8a0c2e4f6a8b0c2d4`,
+		GroupID: command.UserManagementGroup,
+		Run:     revokeCmd(server),
+	}
+
+	revokeCmd.Flags().StringP("reason", "r", "", "RFC 5280 revocation reason (completed)")
+	revokeCmd.Flags().StringSliceP("serial", "S", []string{}, "revoke certificates by hexadecimal serial number")
+
+	revokeComps := carapace.Gen(revokeCmd)
+	revokeComps.FlagCompletion(carapace.ActionMap{
+		"reason": revocationReasonCompleter(),
+	})
+	revokeComps.PositionalAnyCompletion(carapace.ActionCallback(userCompleter(server)))
+
+	revokeComps.PreRun(func(cmd *cobra.Command, args []string) {
+		if cmd.PersistentPreRunE != nil {
+			cmd.PersistentPreRunE(cmd, args)
+		}
+
+		if cmd.PreRunE != nil {
+			cmd.PreRunE(cmd, args)
+		}
+	})
+
+	teamCmd.AddCommand(revokeCmd)
+
+	// Certificate revocation list
+	crlCmd := &cobra.Command{
+		Use:   "crl",
+		Short: "Show or export the users certificate revocation list (CRL)",
+		Long: `List all revoked user certificates, or export them as a standard PEM-encoded X.509
+CRL signed by the users CA, with --export <file|dir> or --pem (print to stdout). Other
+services relying on the teamserver users CA can use it to refuse revoked certificates.
+Users CAs created by older teamservers cannot sign CRLs: they are reissued with the same
+subject and key the first time, which leaves issued certificates and teamclients valid.`,
+		Example: `  teamserver crl
+  teamserver crl --export ~/handout/
+  teamserver crl --pem | openssl crl -noout -text`,
+		GroupID: command.UserManagementGroup,
+		Args:    cobra.NoArgs,
+		Run:     crlCmd(server),
+	}
+
+	crlCmd.Flags().StringP("export", "e", "", "export the CRL (PEM) to this file/directory")
+	crlCmd.Flags().BoolP("pem", "P", false, "print the CRL (PEM) to stdout")
+
+	carapace.Gen(crlCmd).FlagCompletion(carapace.ActionMap{
+		"export": carapace.ActionFiles(),
+	})
+
+	teamCmd.AddCommand(crlCmd)
+
 	// Import a list of users and their credentials.
 	cmdImportCA := &cobra.Command{
 		Use:   "import",
 		Short: "Import a certificate Authority file containing teamserver users",
 		Long: `Import a users Certificate Authority exported by another teamserver, adding its
-users to this one. The file is JSON of the form {"certificate":"...","private_key":"..."}.`,
+users to this one. The file is JSON of the form {"certificate":"...","private_key":"..."}.
+An encrypted private key is decrypted with the passphrase of --passphrase-file, or else
+with the users CA passphrase, or else with a prompted one.`,
 		Example: `  teamserver import ~/.other_app/teamserver/certs/other_app_user-ca-cert.teamserver.pem`,
 		GroupID: command.UserManagementGroup,
 		Args:    cobra.ExactArgs(1),
@@ -316,6 +651,11 @@ users to this one. The file is JSON of the form {"certificate":"...","private_ke
 		).ToA(),
 	)
 
+	cmdImportCA.Flags().String("passphrase-file", "", "file containing the passphrase of the private key")
+	carapace.Gen(cmdImportCA).FlagCompletion(carapace.ActionMap{
+		"passphrase-file": carapace.ActionFiles(),
+	})
+
 	teamCmd.AddCommand(cmdImportCA)
 
 	// Export the list of users and their credentials.
@@ -324,16 +664,364 @@ users to this one. The file is JSON of the form {"certificate":"...","private_ke
 		Short: "Export a Certificate Authority file containing the teamserver users",
 		Long: `Export this teamserver's users CA (all users) to a file, so another teamserver can
 import and trust the same operators. Writes to the current directory when no path is
-given.`,
+given. The private key is encrypted with the passphrase of --passphrase-file, or else
+with the users CA passphrase, if any, or else with a passphrase prompted for: it is
+never exported in cleartext.`,
 		Example: `  teamserver export ~/myapp-users.teamserver.ca`,
 		GroupID: command.UserManagementGroup,
 		Args:    cobra.RangeArgs(0, 1),
 		Run:     exportCACmd(server),
 	}
 
-	carapace.Gen(cmdExportCA).PositionalCompletion(carapace.ActionFiles())
+	cmdExportCA.Flags().String("passphrase-file", "", "file containing the passphrase to encrypt the private key with")
+
+	exportComps := carapace.Gen(cmdExportCA)
+	exportComps.PositionalCompletion(carapace.ActionFiles())
+	exportComps.FlagCompletion(carapace.ActionMap{
+		"passphrase-file": carapace.ActionFiles(),
+	})
+
 	teamCmd.AddCommand(cmdExportCA)
 
+	// Users CA rotation
+	caCmd := &cobra.Command{
+		Use:   "ca",
+		Short: "Manage the users certificate authority (rotation, import, passphrase)",
+		Long: `Manage the users Certificate Authority, which issues the certificates of all users
+and of the teamserver itself.`,
+		GroupID: command.UserManagementGroup,
+	}
+
+	caRotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Replace the users CA with a new one, trusting the previous one for a while",
+		Long: `Generate a new users CA, issuing all certificates from now on, and reissue the
+teamserver certificate with it. During the rotation window (--window, or the
+certificates.rotation_window of the server config), the previous CA is still trusted,
+so that users can connect and fetch a new certificate with 'teamclient renew'. At the
+end of the window, the previous CA is retired: users not renewed by then need new configs.`,
+		Example: `  teamserver ca rotate
+  teamserver ca rotate --window 72h`,
+		Args: cobra.NoArgs,
+		Run:  caRotateCmd(server),
+	}
+
+	caRotateCmd.Flags().DurationP("window", "w", 0, "how long the previous CA is trusted (default: server config)")
+
+	caCmd.AddCommand(caRotateCmd)
+
+	caStatusCmd := &cobra.Command{
+		Use:     "status",
+		Short:   "Show the users CA rotation in progress, if any",
+		Example: `  teamserver ca status`,
+		Args:    cobra.NoArgs,
+		Run:     caStatusCmd(server),
+	}
+
+	caCmd.AddCommand(caStatusCmd)
+
+	caImportCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import a users CA, possibly an intermediate of an external PKI",
+		Long: `Replace the users CA with a CA certificate and its private key (PEM files).
+The certificate file can contain the chain of its issuers, up to a self-signed root:
+the users CA is then an intermediate of your PKI, and all user and server certificates
+are issued by it, sent along the chain and verified up to the root. Users need new configs.
+Such a CA cannot be rotated with 'ca rotate': import a new one when it is renewed.
+An encrypted private key (PKCS #8) is decrypted with the passphrase of --passphrase-file,
+or else with the users CA passphrase, or else with a prompted one.`,
+		Example: `  teamserver ca import intermediate-chain.pem intermediate-key.pem`,
+		Args:    cobra.ExactArgs(2),
+		Run:     caImportCmd(server),
+	}
+
+	caImportCmd.Flags().String("passphrase-file", "", "file containing the passphrase of the private key")
+
+	caImportComps := carapace.Gen(caImportCmd)
+	caImportComps.PositionalCompletion(carapace.ActionFiles(), carapace.ActionFiles())
+	caImportComps.FlagCompletion(carapace.ActionMap{
+		"passphrase-file": carapace.ActionFiles(),
+	})
+
+	caCmd.AddCommand(caImportCmd)
+
+	caPassphraseCmd := &cobra.Command{
+		Use:   "passphrase",
+		Short: "Encrypt the users CA private key with a new passphrase",
+		Long: fmt.Sprintf(`Encrypt the private key of the users CA with a new passphrase (prompted, or read
+from --passphrase-file), or store it in cleartext with --remove. The teamserver then
+needs the passphrase to issue certificates: give it with the %[1]s_CA_PASSPHRASE
+environment variable, the certificates.ca_passphrase_file of the server config, or
+at the prompt of the commands needing it (eg. 'teamserver daemon').`, strings.ToUpper(name)),
+		Example: `  teamserver ca passphrase
+  teamserver ca passphrase --passphrase-file /run/secrets/ca-passphrase
+  teamserver ca passphrase --remove`,
+		Args: cobra.NoArgs,
+		Run:  caPassphraseCmd(server),
+	}
+
+	caPassphraseCmd.Flags().String("passphrase-file", "", "file containing the new passphrase")
+	caPassphraseCmd.Flags().Bool("remove", false, "store the private key in cleartext")
+
+	carapace.Gen(caPassphraseCmd).FlagCompletion(carapace.ActionMap{
+		"passphrase-file": carapace.ActionFiles(),
+	})
+
+	caCmd.AddCommand(caPassphraseCmd)
+
+	teamCmd.AddCommand(caCmd)
+
+	// Certificates inventory
+	certsCmd := &cobra.Command{
+		Use:   "certs",
+		Short: "List and inspect the certificates issued by the teamserver",
+		Long: `List and inspect the users CA and the certificates it issued (users credentials and
+teamserver certificates), with their expiry. A running teamserver reports certificates
+close to expiry in its logs, and renews its own certificate before it expires.
+Their private keys are kept in a secret store, which can be changed with 'certs migrate'.`,
+		GroupID: command.UserManagementGroup,
+	}
+
+	certsListCmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List the certificates issued by the teamserver, with their owner and expiry",
+		Example: `  teamserver certs list`,
+		Args:    cobra.NoArgs,
+		Run:     certsListCmd(server),
+	}
+
+	certsCmd.AddCommand(certsListCmd)
+
+	certsShowCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show a certificate by serial number or fingerprint (possibly truncated)",
+		Example: `  teamserver certs show 3f2a9c
+  teamserver certs show 3f2a9c --pem > cert.pem`,
+		Args: cobra.ExactArgs(1),
+		Run:  certsShowCmd(server),
+	}
+
+	certsShowCmd.Flags().BoolP("pem", "P", false, "print the certificate (PEM) to stdout")
+	carapace.Gen(certsShowCmd).PositionalCompletion(carapace.ActionCallback(certificateCompleter(server)))
+
+	certsCmd.AddCommand(certsShowCmd)
+
+	certsMigrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move all private keys to another secret store",
+		Long: fmt.Sprintf(`Move the private keys of the users CA and of the certificates it issued to another
+secret store, used from now on (saved in the certificates.secret_store of the server
+config). Stores are 'filesystem' (files of the teamserver directory, the default),
+'database' (the teamserver database), and 'encrypted-file' (files encrypted with the
+passphrase of %[1]s_SECRETS_PASSPHRASE or certificates.secrets_passphrase_file).`, strings.ToUpper(name)),
+		Example: fmt.Sprintf(`  teamserver certs migrate database
+  %s_SECRETS_PASSPHRASE=... teamserver certs migrate encrypted-file`, strings.ToUpper(name)),
+		Args: cobra.ExactArgs(1),
+		Run:  certsMigrateCmd(server),
+	}
+
+	carapace.Gen(certsMigrateCmd).PositionalCompletion(secretStoreCompleter())
+
+	certsCmd.AddCommand(certsMigrateCmd)
+
+	teamCmd.AddCommand(certsCmd)
+
+	// Backup and restore
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Save the complete teamserver state to an archive",
+		Long: fmt.Sprintf(`Save the complete teamserver state to an archive (or to stdout with '-'): the database,
+read consistently even while the teamserver runs, the private keys, the users CA files
+and the configurations. The archive is encrypted with the passphrase given by the
+%[1]s_BACKUP_PASSPHRASE environment variable, or the backup.passphrase_file of the
+server config. Without, it holds private keys in cleartext.`, strings.ToUpper(name)),
+		Example: fmt.Sprintf(`  %s_BACKUP_PASSPHRASE=... teamserver backup state.backup
+  teamserver backup - | ssh backups 'cat > state.backup'`, strings.ToUpper(name)),
+		GroupID: command.TeamServerGroup,
+		Args:    cobra.ExactArgs(1),
+		Run:     backupCmd(server),
+	}
+
+	carapace.Gen(backupCmd).PositionalCompletion(carapace.ActionFiles())
+
+	teamCmd.AddCommand(backupCmd)
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Replace the teamserver state with the one of an archive",
+		Long: `Replace the teamserver state with the one of an archive written by 'backup' (or read
+from stdin with '-'), decrypted with the backup passphrase if encrypted. The archive
+must be one of this application, with a database schema not more recent than the one of
+this teamserver. Data is restored in the current database and secret store: the database
+config of the archive is not restored. Stop the teamserver daemon first, and start it after.`,
+		Example: `  teamserver restore state.backup`,
+		GroupID: command.TeamServerGroup,
+		Args:    cobra.ExactArgs(1),
+		Run:     restoreCmd(server),
+	}
+
+	carapace.Gen(restoreCmd).PositionalCompletion(carapace.ActionFiles())
+
+	teamCmd.AddCommand(restoreCmd)
+
+	// Database
+	dbCmd := &cobra.Command{
+		Use:   "db",
+		Short: "Manage the teamserver database",
+		Long: `Manage the teamserver database: its versioned schema migrations, the encryption of its
+SQLite file with a database key and, with this key, the data key with which its sensitive
+columns (private keys, token hashes and invitation codes) are encrypted, itself stored
+encrypted with the database key. The database file cannot be encrypted, decrypted or
+re-keyed while a teamserver daemon is running in another process: stop it first.`,
+		GroupID: command.TeamServerGroup,
+	}
+
+	dbRotateKeyCmd := &cobra.Command{
+		Use:   "rotate-key",
+		Short: "Encrypt the sensitive columns of the database with a new data key",
+		Long: `Generate a new data key, encrypt all the sensitive columns of the database again with
+it, and delete the previous one. The database key itself is unchanged.`,
+		Example: `  teamserver db rotate-key`,
+		Args:    cobra.NoArgs,
+		Run:     dbRotateKeyCmd(server),
+	}
+
+	dbCmd.AddCommand(dbRotateKeyCmd)
+
+	dbStatusCmd := &cobra.Command{
+		Use:     "status",
+		Short:   "Show the schema migrations of the database, applied or pending",
+		Example: `  teamserver db status`,
+		Args:    cobra.NoArgs,
+		Run:     dbStatusCmd(server),
+	}
+
+	dbCmd.AddCommand(dbStatusCmd)
+
+	dbMigrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply the pending schema migrations of the database",
+		Long: `Apply the pending schema migrations of the database, up to a version if one is given.
+The teamserver applies them when it starts anyway, and refuses to use a database whose
+schema is more recent than itself.`,
+		Example: `  teamserver db migrate
+  teamserver db migrate 2`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  dbMigrateCmd(server),
+	}
+
+	dbCmd.AddCommand(dbMigrateCmd)
+
+	dbRollbackCmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Roll back the schema migrations of the database",
+		Long: `Roll back the schema migrations of the database applied after a version, or only the
+last one without version. The first version, which creates the teamserver tables,
+cannot be rolled back. Since the teamserver applies pending migrations when it starts,
+use this just before running an older version of it.`,
+		Example: `  teamserver db rollback
+  teamserver db rollback 1`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  dbRollbackCmd(server),
+	}
+
+	dbCmd.AddCommand(dbRollbackCmd)
+
+	dbEncryptCmd := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt the SQLite database file of the teamserver with a key",
+		Long: `Encrypt the on-disk SQLite database of the teamserver, and its sensitive columns, with a
+new key (prompted, or read from --key-file). The database file is replaced with an
+encrypted copy of it, once verified: the teamserver must then be given the key with
+server.WithDatabaseKey() to open it.`,
+		Example: `  teamserver db encrypt
+  teamserver db encrypt --key-file /run/secrets/db-key`,
+		Args: cobra.NoArgs,
+		Run:  dbEncryptCmd(server),
+	}
+
+	dbEncryptCmd.Flags().String("key-file", "", "file containing the database key")
+
+	carapace.Gen(dbEncryptCmd).FlagCompletion(carapace.ActionMap{
+		"key-file": carapace.ActionFiles(),
+	})
+
+	dbCmd.AddCommand(dbEncryptCmd)
+
+	dbDecryptCmd := &cobra.Command{
+		Use:   "decrypt",
+		Short: "Store the SQLite database file of the teamserver in cleartext",
+		Long: `Store the on-disk SQLite database of the teamserver, and its sensitive columns, in
+cleartext. The database file is replaced with a decrypted copy of it, once verified:
+the teamserver does not need the database key anymore.`,
+		Example: `  teamserver db decrypt`,
+		Args:    cobra.NoArgs,
+		Run:     dbDecryptCmd(server),
+	}
+
+	dbCmd.AddCommand(dbDecryptCmd)
+
+	dbRekeyCmd := &cobra.Command{
+		Use:   "rekey",
+		Short: "Encrypt the SQLite database file of the teamserver with a new key",
+		Long: `Encrypt the on-disk SQLite database of the teamserver, and the data keys of its sensitive
+columns, with a new key (prompted, or read from --key-file). The database file is
+replaced with a copy encrypted with the new key, once verified: the teamserver must then
+be given the new key instead of the current one. To only change the data key of the
+sensitive columns, use 'teamserver db rotate-key'.`,
+		Example: `  teamserver db rekey
+  teamserver db rekey --key-file /run/secrets/db-key`,
+		Args: cobra.NoArgs,
+		Run:  dbRekeyCmd(server),
+	}
+
+	dbRekeyCmd.Flags().String("key-file", "", "file containing the new database key")
+
+	carapace.Gen(dbRekeyCmd).FlagCompletion(carapace.ActionMap{
+		"key-file": carapace.ActionFiles(),
+	})
+
+	dbCmd.AddCommand(dbRekeyCmd)
+
+	dbMigrateToCmd := &cobra.Command{
+		Use:   "migrate-to",
+		Short: "Move the teamserver database to another backend (eg. PostgreSQL)",
+		Long: `Copy all the tables of the teamserver database to another database backend, whatever
+its dialect: its tables are created first, and must be empty. Once the rows of all tables
+are verified, the database config file is rewritten with the new backend, which the
+teamserver uses from now on. The previous database is left untouched.
+
+The password of PostgreSQL and MySQL databases is a secret reference, saved as is in the
+config file and resolved on each start: "env:NAME" (environment variable), "file:/path"
+(content of a file) or "prompt" (asked on the terminal of the teamserver).`,
+		Example: `  teamserver db migrate-to --dialect postgresql --host db.example.com --port 5432 --database team --username team --password env:TEAM_DB_PASSWORD
+  teamserver db migrate-to --dialect mysql --host 127.0.0.1 --port 3306 --database team --username team --password file:/etc/team/db-password
+  teamserver db migrate-to --dialect sqlite3 --database /var/lib/team/team.db`,
+		Args: cobra.NoArgs,
+		Run:  dbMigrateToCmd(server),
+	}
+
+	dbMigrateToCmd.Flags().String("dialect", "", "dialect of the target database (sqlite3, postgresql or mysql)")
+	dbMigrateToCmd.Flags().String("database", "", "name of the target database (file path with sqlite3)")
+	dbMigrateToCmd.Flags().String("host", "", "host of the target database server")
+	dbMigrateToCmd.Flags().Uint16("port", 0, "port of the target database server")
+	dbMigrateToCmd.Flags().String("username", "", "user of the target database server")
+	dbMigrateToCmd.Flags().String("password", "", "secret reference to the password of the database user (env:NAME, file:/path or prompt)")
+	dbMigrateToCmd.Flags().StringToString("param", nil, "connection parameters of the target database (eg. sslmode=require)")
+	dbMigrateToCmd.Flags().String("table-prefix", "", "prefix of the table names in the target database (eg. to share it with other applications)")
+	dbMigrateToCmd.MarkFlagRequired("dialect")
+	dbMigrateToCmd.MarkFlagRequired("database")
+
+	carapace.Gen(dbMigrateToCmd).FlagCompletion(carapace.ActionMap{
+		"dialect":  carapace.ActionValues(db.Sqlite, db.Postgres, db.MySQL),
+		"database": carapace.ActionFiles(),
+		"password": carapace.ActionValues("env:", "file:", "prompt").NoSpace(':'),
+	})
+
+	dbCmd.AddCommand(dbMigrateToCmd)
+
+	teamCmd.AddCommand(dbCmd)
+
 	// [ Holistic help ] -------------------------------------------------------------------
 
 	// A cobra "additional help topic" (no Run): a single walkthrough that stays out of
@@ -357,6 +1045,18 @@ to them.
    The teamserver only proves WHO an operator is. Authorization — what each operator
    may do — is entirely up to %[1]s.
 
+   An operator using several devices gets one config (credential) for each of them,
+   so that losing a laptop only requires revoking the laptop's credential:
+       teamserver user credentials add alice --label laptop --host <bind-address>
+       teamserver user credentials list alice
+       teamserver user credentials revoke alice laptop
+   Running 'user' again for alice only replaces alice's "default" credential.
+
+   Operators can also enroll with a one-time invitation code instead of a config file,
+   so that their private key never leaves their machine:
+       teamserver user invite alice --label laptop --expiry 30m
+       teamclient enroll <code> --host <server-address> --port <port>
+
 2. Listeners
    A listener is a bind job for a transport stack. Start one without blocking:
        teamserver listen --host <bind> --port <port> --persistent
@@ -376,14 +1076,51 @@ to them.
    operators:
        teamserver export users.ca
        teamserver import users.ca
-
-5. Revoking access
-   Delete a user; its live sessions are refused on the next request and its TLS
-   credentials stop working immediately:
+   The exported private key is encrypted with the users CA passphrase, if any, or the
+   one read with --passphrase-file. Encrypt the users CA key at rest: the teamserver then asks for its passphrase when starting (or
+   reads it from %[2]s_CA_PASSPHRASE, or the certificates.ca_passphrase_file):
+       teamserver ca passphrase
+   Replace the users CA with a new one: the previous one stays trusted during a
+   rotation window, in which operators fetch new certificates on their own:
+       teamserver ca rotate --window 72h
+       teamclient renew
+   List the certificates in use and their expiry (a running teamserver warns about
+   those close to expiry, and renews its own certificate on time):
+       teamserver certs list
+       teamserver certs show <serial>
+
+5. Connected clients
+   List the teamclients currently connected, and disconnect some of them (they
+   keep their credentials and may reconnect):
+       teamserver sessions
+       teamserver kick <id-prefix>
+       teamserver kick --user alice
+   Addresses and credentials making too many failed authentication attempts are
+   throttled, then banned for a while (see "lockout" in the server config). Bans
+   are shown by 'status', and can be lifted early:
+       teamserver unban <address|user/serial>
+   All administrative actions (on users, credentials, listeners, sessions...) are
+   recorded with who performed them, and from where:
+       teamserver audit --since 24h --user alice
+
+6. Token lifetime
+   API tokens expire after users.token_expiry (teamserver config; never by default).
+   Operators refresh their own token, or an administrator rotates it:
+       teamserver client refresh
+       teamserver rotate alice
+   The previous token keeps working for users.token_grace_period.
+
+7. Revoking access
+   Delete a user; its live sessions are closed and its TLS certificate is revoked,
+   so that its credentials stop working immediately:
        teamserver delete alice
+   Or only revoke its certificate (running 'user' again issues a new one), and
+   show/export the revocation list as a standard X.509 CRL:
+       teamserver revoke alice --reason keyCompromise
+       teamserver crl --export users.crl.pem
 
 Shell completion:
-    source <(teamserver _carapace <shell>)`, name),
+    source <(teamserver _carapace <shell>)`, name, strings.ToUpper(name)),
 	}
 
 	teamCmd.AddCommand(guideCmd)
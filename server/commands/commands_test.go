@@ -151,7 +151,13 @@ func TestCommandExportCA(t *testing.T) {
 		t.Fatalf("mkdir: %v", err)
 	}
 
-	out, err := runCommand(t, ts, tc, "export", dest)
+	// The private key is never exported in cleartext.
+	passphrase := filepath.Join(home, "passphrase")
+	if err := os.WriteFile(passphrase, []byte("export\n"), 0o600); err != nil {
+		t.Fatalf("write passphrase: %v", err)
+	}
+
+	out, err := runCommand(t, ts, tc, "export", dest, "--passphrase-file", passphrase)
 	if err != nil {
 		t.Fatalf("export: %v\noutput:\n%s", err, out)
 	}
@@ -162,6 +168,52 @@ func TestCommandExportCA(t *testing.T) {
 	}
 }
 
+// TestCommandKickAmbiguous checks that a session ID prefix matching several
+// sessions kicks none of them, and lists the candidates instead.
+func TestCommandKickAmbiguous(t *testing.T) {
+	ts, tc, _ := newSandbox(t)
+
+	// With more sessions than hex digits, two IDs share their first digit.
+	byDigit := make(map[string]string)
+
+	var first, second string
+
+	for range 17 {
+		id, err := ts.SessionAdd(server.Session{User: "alice", Handler: "test"}, io.NopCloser(nil))
+		if err != nil {
+			t.Fatalf("SessionAdd: %v", err)
+		}
+
+		if other, found := byDigit[id[:1]]; found && first == "" {
+			first, second = other, id
+		}
+
+		byDigit[id[:1]] = id
+	}
+
+	out, err := runCommand(t, ts, tc, "kick", first[:1])
+	if err != nil {
+		t.Fatalf("kick: %v\noutput:\n%s", err, out)
+	}
+
+	if !strings.Contains(out, "ambiguous") || !strings.Contains(out, first) || !strings.Contains(out, second) {
+		t.Fatalf("kick output should list the candidates of the prefix:\n%s", out)
+	}
+
+	if len(ts.Sessions()) != 17 {
+		t.Fatalf("no session should be kicked with an ambiguous prefix, %d left", len(ts.Sessions()))
+	}
+
+	out, err = runCommand(t, ts, tc, "kick", first)
+	if err != nil {
+		t.Fatalf("kick: %v\noutput:\n%s", err, out)
+	}
+
+	if len(ts.Sessions()) != 16 {
+		t.Fatalf("the session of a unique prefix should be kicked, %d left:\n%s", len(ts.Sessions()), out)
+	}
+}
+
 // TestCommandGuide renders the built-in usage guide.
 func TestCommandGuide(t *testing.T) {
 	ts, tc, _ := newSandbox(t)
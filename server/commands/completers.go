@@ -22,10 +22,12 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/carapace-sh/carapace"
 
 	"github.com/reeflective/team/client"
+	"github.com/reeflective/team/internal/certs"
 	"github.com/reeflective/team/server"
 )
 
@@ -86,6 +88,41 @@ func userCompleter(server *server.Server) carapace.CompletionCallback {
 	}
 }
 
+// credentialCompleter completes the credential labels of the user given as first argument.
+func credentialCompleter(server *server.Server) carapace.CompletionCallback {
+	return func(c carapace.Context) carapace.Action {
+		if len(c.Args) == 0 {
+			return carapace.ActionMessage("no user specified")
+		}
+
+		creds, err := server.UserCredentials(c.Args[0])
+		if err != nil {
+			return carapace.ActionMessage("Failed to get credentials: %s", err)
+		}
+
+		results := make([]string, 0, len(creds))
+		for _, cred := range creds {
+			results = append(results, cred.Label)
+		}
+
+		if len(results) == 0 {
+			return carapace.ActionMessage("user %s has no credentials", c.Args[0])
+		}
+
+		return carapace.ActionValues(results...).Tag("credentials of " + c.Args[0])
+	}
+}
+
+// revocationReasonCompleter completes RFC 5280 certificate revocation reasons.
+func revocationReasonCompleter() carapace.Action {
+	return carapace.ActionValues(certs.ReasonNames()...).Tag("revocation reasons")
+}
+
+// secretStoreCompleter completes the built-in stores of the teamserver private keys.
+func secretStoreCompleter() carapace.Action {
+	return carapace.ActionValues(server.SecretStores()...).Tag("secret stores")
+}
+
 // listenerIDCompleter completes ID for running teamserver listeners.
 func listenerIDCompleter(client *client.Client, server *server.Server) carapace.CompletionCallback {
 	return func(c carapace.Context) carapace.Action {
@@ -126,6 +163,97 @@ func listenerIDCompleter(client *client.Client, server *server.Server) carapace.
 	}
 }
 
+// sessionIDCompleter completes IDs of live teamclient sessions.
+func sessionIDCompleter(server *server.Server) carapace.CompletionCallback {
+	return func(c carapace.Context) carapace.Action {
+		var results []string
+
+		for _, sess := range server.Sessions() {
+			results = append(results, formatSmallID(sess.ID))
+			results = append(results, fmt.Sprintf("%s (%s, %s)", sess.User, sess.RemoteAddr, sess.Handler))
+		}
+
+		if len(results) == 0 {
+			return carapace.ActionMessage(fmt.Sprintf("no clients connected to %s teamserver", server.Name()))
+		}
+
+		return carapace.ActionValuesDescribed(results...).Tag("live teamclient sessions")
+	}
+}
+
+// auditActionCompleter completes the actions recorded in the teamserver audit trail.
+func auditActionCompleter() carapace.Action {
+	return carapace.ActionValues(
+		server.AuditUserCreate,
+		server.AuditUserDelete,
+		server.AuditUserRevoke,
+		server.AuditUserInvite,
+		server.AuditUserEnroll,
+		server.AuditTokenRotate,
+		server.AuditTokenRefresh,
+		server.AuditCredentialAdd,
+		server.AuditCredentialRevoke,
+		server.AuditCertRevoke,
+		server.AuditCertRenew,
+		server.AuditCAImport,
+		server.AuditCAExport,
+		server.AuditCARotate,
+		server.AuditCARetire,
+		server.AuditCAPassphrase,
+		server.AuditSecretsMigrate,
+		server.AuditDatabaseRotateKey,
+		server.AuditDatabaseMigrate,
+		server.AuditDatabaseRollback,
+		server.AuditDatabaseRekey,
+		server.AuditDatabaseMigrateTo,
+		server.AuditBackup,
+		server.AuditRestore,
+		server.AuditListenerStart,
+		server.AuditListenerStop,
+		server.AuditListenerAdd,
+		server.AuditListenerRemove,
+		server.AuditSessionKick,
+		server.AuditUnban,
+	).Tag("audit actions")
+}
+
+// certificateCompleter completes the serial numbers of the certificates issued by the teamserver.
+func certificateCompleter(server *server.Server) carapace.CompletionCallback {
+	return func(c carapace.Context) carapace.Action {
+		var results []string
+
+		infos, _ := server.Certificates()
+		for _, info := range infos {
+			results = append(results, info.Serial)
+			results = append(results, fmt.Sprintf("%s %s, expires %s", info.Purpose, info.Subject, info.NotAfter.Format(time.DateOnly)))
+		}
+
+		if len(results) == 0 {
+			return carapace.ActionMessage(fmt.Sprintf("no certificates on %s teamserver", server.Name()))
+		}
+
+		return carapace.ActionValuesDescribed(results...).Tag("certificates")
+	}
+}
+
+// banCompleter completes the addresses and credentials currently banned by the teamserver.
+func banCompleter(server *server.Server) carapace.CompletionCallback {
+	return func(c carapace.Context) carapace.Action {
+		var results []string
+
+		for _, ban := range server.Bans() {
+			results = append(results, ban.Subject)
+			results = append(results, fmt.Sprintf("%s, %d failures", ban.Kind, ban.Failures))
+		}
+
+		if len(results) == 0 {
+			return carapace.ActionMessage(fmt.Sprintf("no bans on %s teamserver", server.Name()))
+		}
+
+		return carapace.ActionValuesDescribed(results...).Tag("banned addresses and credentials")
+	}
+}
+
 // listenerTypeCompleter completes the different types of teamserver listener/handler stacks available.
 func listenerTypeCompleter(client *client.Client, server *server.Server) carapace.CompletionCallback {
 	return func(c carapace.Context) carapace.Action {
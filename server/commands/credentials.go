@@ -0,0 +1,217 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/assets"
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func credentialsListCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		name := args[0]
+
+		creds, err := serv.UserCredentials(name)
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if len(creds) == 0 {
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"User %s has no credentials\n", name)
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), credentialsTable(creds))
+	}
+}
+
+func credentialsAddCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		name := args[0]
+		label, _ := cmd.Flags().GetString("label")
+		lhost, _ := cmd.Flags().GetString("host")
+		lport, _ := cmd.Flags().GetUint16("port")
+		save, _ := cmd.Flags().GetString("save")
+
+		if save == "" {
+			save, _ = os.Getwd()
+		}
+
+		saveTo, _ := filepath.Abs(save)
+
+		configFile, err := os.Stat(saveTo)
+		if !os.IsNotExist(err) && !configFile.IsDir() {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"File already exists %s\n", err)
+			return
+		}
+
+		if !os.IsNotExist(err) && configFile.IsDir() {
+			filename := fmt.Sprintf("%s_%s_%s.teamclient.cfg", filepath.Base(name), filepath.Base(label), filepath.Base(lhost))
+			saveTo = filepath.Join(saveTo, filename)
+		}
+
+		config, err := serv.UserCredentialAdd(name, label, lhost, lport)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"%s\n", err)
+			return
+		}
+
+		configJSON, err := json.Marshal(config)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"JSON marshaling error: %s\n", err)
+			return
+		}
+
+		err = os.WriteFile(saveTo, configJSON, assets.FileReadPerm)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Failed to write config to %s: %s\n", saveTo, err)
+			return
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, command.Info+"Added credential %q to user %q\n", label, config.User)
+		fmt.Fprintf(out, "    server: %s\n", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+
+		if expiry, ok := certExpiry(config.Certificate); ok {
+			fmt.Fprintf(out, "    expires: %s\n", expiry.Format(time.RFC1123))
+		}
+
+		fmt.Fprintf(out, "    config: %s\n", saveTo)
+	}
+}
+
+func credentialsRevokeCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		name := args[0]
+
+		for _, label := range args[1:] {
+			if err := serv.UserCredentialRevoke(name, label); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				continue
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Revoked credential %q of user %s\n", label, name)
+		}
+	}
+}
+
+func credentialsTable(creds []server.Credential) string {
+	tbl := &table.Table{}
+	tbl.SetStyle(command.TableStyle)
+
+	tbl.AppendHeader(table.Row{
+		"Label",
+		"Created",
+		"Last used",
+		"Token expires",
+		"Certificate serial",
+	})
+
+	for _, cred := range creds {
+		lastUsed := "never"
+		if !cred.LastUsed.IsZero() {
+			lastUsed = cred.LastUsed.Format(time.RFC1123)
+		}
+
+		expires := "never"
+		if !cred.ExpiresAt.IsZero() {
+			expires = cred.ExpiresAt.Format(time.RFC1123)
+		}
+
+		tbl.AppendRow(table.Row{
+			cred.Label,
+			cred.CreatedAt.Format(time.RFC1123),
+			lastUsed,
+			expires,
+			cred.Serial,
+		})
+	}
+
+	return tbl.Render()
+}
+
+func inviteUserCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		label, _ := cmd.Flags().GetString("label")
+		expiry, _ := cmd.Flags().GetDuration("expiry")
+
+		invitation, err := serv.UserInvite(args[0], label, expiry)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"%s\n", err)
+			return
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, command.Info+"Invited user %s to enroll credential %q\n", invitation.User, invitation.Credential)
+		fmt.Fprintf(out, "    code: %s\n", invitation.Code)
+		fmt.Fprintf(out, "    expires: %s\n", invitation.ExpiresAt.Format(time.RFC1123))
+	}
+}
@@ -0,0 +1,321 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/internal/db"
+	"github.com/reeflective/team/server"
+)
+
+func dbRotateKeyCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		count, err := serv.DatabaseRotateKey()
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Encrypted %d values with a new data key.\n", count)
+	}
+}
+
+func dbStatusCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		migrations, err := serv.DatabaseMigrations()
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), migrationsTable(migrations))
+	}
+}
+
+func dbMigrateCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		var version uint64
+
+		if len(args) > 0 {
+			var err error
+			if version, err = strconv.ParseUint(args[0], 10, 32); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, fmt.Errorf("invalid version: %w", err))
+				return
+			}
+		}
+
+		applied, err := serv.DatabaseMigrate(uint(version))
+
+		for _, migration := range applied {
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Applied migration %d: %s\n", migration.Version, migration.Description)
+		}
+
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if len(applied) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), command.Info+"No pending migrations.")
+		}
+	}
+}
+
+func dbRollbackCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		var version uint64
+
+		if len(args) > 0 {
+			var err error
+			if version, err = strconv.ParseUint(args[0], 10, 32); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, fmt.Errorf("invalid version: %w", err))
+				return
+			}
+		} else {
+			// Without version, only roll back the last applied migration.
+			migrations, err := serv.DatabaseMigrations()
+			if err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				return
+			}
+
+			version = uint64(previousVersion(migrations))
+		}
+
+		rolledBack, err := serv.DatabaseRollback(uint(version))
+
+		for _, migration := range rolledBack {
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Rolled back migration %d: %s\n", migration.Version, migration.Description)
+		}
+
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if len(rolledBack) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), command.Info+"No migrations to roll back.")
+		}
+	}
+}
+
+// previousVersion returns the version of the migration applied before the last one.
+func previousVersion(migrations []server.DatabaseMigration) uint {
+	var last, previous uint
+
+	for _, migration := range migrations {
+		if migration.Applied && migration.Version > last {
+			previous, last = last, migration.Version
+		}
+	}
+
+	return previous
+}
+
+func migrationsTable(migrations []server.DatabaseMigration) string {
+	tbl := &table.Table{}
+	tbl.SetStyle(command.TableStyle)
+
+	tbl.AppendHeader(table.Row{
+		"Version",
+		"Description",
+		"Status",
+		"Applied at",
+	})
+
+	for _, migration := range migrations {
+		status := "pending"
+		appliedAt := ""
+
+		if migration.Applied {
+			status = "applied"
+			appliedAt = migration.AppliedAt.Format(time.RFC1123)
+		}
+
+		if !migration.Known {
+			status = "unknown (newer teamserver)"
+		} else if migration.Applied && !migration.Reversible {
+			status += " (irreversible)"
+		}
+
+		tbl.AppendRow(table.Row{
+			migration.Version,
+			migration.Description,
+			status,
+			appliedAt,
+		})
+	}
+
+	return tbl.Render()
+}
+
+func dbEncryptCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		key, err := databaseKeyFrom(cmd)
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if err := serv.DatabaseEncrypt(key); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), command.Info+"Database is now encrypted with the new key.")
+		fmt.Fprintln(cmd.OutOrStdout(), "    The teamserver needs it to open the database from now on.")
+	}
+}
+
+func dbDecryptCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if err := serv.DatabaseDecrypt(); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), command.Info+"Database is now stored in cleartext.")
+		fmt.Fprintln(cmd.OutOrStdout(), "    Stop giving the database key to the teamserver.")
+	}
+}
+
+func dbRekeyCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		key, err := databaseKeyFrom(cmd)
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if err := serv.DatabaseRekey(key); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), command.Info+"Database is now encrypted with the new key.")
+		fmt.Fprintln(cmd.OutOrStdout(), "    The teamserver needs it instead of the previous one from now on.")
+	}
+}
+
+// databaseKeyFrom returns the database key read from the file of
+// the --key-file flag of a command, if any, or else prompts for it.
+func databaseKeyFrom(cmd *cobra.Command) (string, error) {
+	path, _ := cmd.Flags().GetString("key-file")
+	if path == "" {
+		return promptPassphrase("New database key:", true)
+	}
+
+	return readPassphraseFile(path)
+}
+
+func dbMigrateToCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		config := targetDatabaseConfig(cmd)
+
+		count, err := serv.DatabaseMigrateTo(config)
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Copied %d rows to the %s database %s.\n", count, config.Dialect, config.Database)
+		fmt.Fprintln(cmd.OutOrStdout(), "    The teamserver uses it from now on: the previous database is left untouched.")
+	}
+}
+
+// targetDatabaseConfig returns the database configuration given with the flags of a
+// command. The password is a secret reference, saved as is in the configuration file.
+func targetDatabaseConfig(cmd *cobra.Command) *db.Config {
+	config := &db.Config{}
+
+	config.Dialect, _ = cmd.Flags().GetString("dialect")
+	config.Database, _ = cmd.Flags().GetString("database")
+	config.Host, _ = cmd.Flags().GetString("host")
+	config.Port, _ = cmd.Flags().GetUint16("port")
+	config.Username, _ = cmd.Flags().GetString("username")
+	config.Password, _ = cmd.Flags().GetString("password")
+	config.Params, _ = cmd.Flags().GetStringToString("param")
+	config.TablePrefix, _ = cmd.Flags().GetString("table-prefix")
+
+	return config
+}
@@ -0,0 +1,188 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func caPassphraseCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		var passphrase string
+
+		if remove, _ := cmd.Flags().GetBool("remove"); !remove {
+			var err error
+			if passphrase, err = passphraseFrom(cmd, "New users CA passphrase:", true); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				return
+			}
+		}
+
+		if err := serv.UsersSetCAPassphrase(passphrase); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if passphrase == "" {
+			fmt.Fprintln(cmd.OutOrStdout(), command.Info+"Users CA private key is now stored in cleartext.")
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), command.Info+"Users CA private key is now encrypted with the new passphrase.")
+		fmt.Fprintf(cmd.OutOrStdout(), "    Give it to the teamserver with %s_CA_PASSPHRASE, the certificates.ca_passphrase_file\n",
+			strings.ToUpper(serv.Name()))
+		fmt.Fprintln(cmd.OutOrStdout(), "    of the server config, or at the prompt when starting the daemon.")
+	}
+}
+
+// unlockUsersCA prompts for the passphrase of the users CA private key, if it is encrypted and
+// the teamserver was not given it otherwise: commands issuing certificates need it unlocked.
+func unlockUsersCA(serv *server.Server) error {
+	if !serv.UsersCALocked() {
+		return nil
+	}
+
+	passphrase, err := promptPassphrase("Users CA passphrase:", false)
+	if err != nil {
+		return fmt.Errorf("%w: %w", server.ErrCALocked, err)
+	}
+
+	return serv.UsersUnlockCA(passphrase)
+}
+
+// passphraseFrom returns the passphrase read from the file of the --passphrase-file
+// flag of a command, if any, or else prompts for it (and for its confirmation).
+func passphraseFrom(cmd *cobra.Command, message string, confirm bool) (string, error) {
+	path, _ := cmd.Flags().GetString("passphrase-file")
+	if path == "" {
+		return promptPassphrase(message, confirm)
+	}
+
+	return readPassphraseFile(path)
+}
+
+// readPassphraseFile returns the non-empty passphrase of a file, without its trailing newline.
+func readPassphraseFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Cannot read passphrase file: %w", err)
+	}
+
+	passphrase := strings.TrimRight(string(data), "\r\n")
+	if passphrase == "" {
+		return "", errors.New("empty passphrase file")
+	}
+
+	return passphrase, nil
+}
+
+// promptPassphrase prompts for a non-empty passphrase, twice if it must be confirmed.
+func promptPassphrase(message string, confirm bool) (string, error) {
+	var passphrase, confirmation string
+
+	err := survey.AskOne(&survey.Password{Message: message}, &passphrase, survey.WithValidator(survey.Required))
+	if err != nil {
+		return "", err
+	}
+
+	if !confirm {
+		return passphrase, nil
+	}
+
+	if err := survey.AskOne(&survey.Password{Message: "Confirm passphrase:"}, &confirmation); err != nil {
+		return "", err
+	}
+
+	if confirmation != passphrase {
+		return "", errors.New("passphrases do not match")
+	}
+
+	return passphrase, nil
+}
+
+// importUsersCA imports a users CA with the passphrase of the --passphrase-file flag, if any, or
+// else prompts for it if the private key is not encrypted with the CA passphrase: for example,
+// if the CA was exported by another teamserver.
+func importUsersCA(cmd *cobra.Command, serv *server.Server, chainPEM, keyPEM []byte) error {
+	var passphrase string
+
+	if cmd.Flags().Changed("passphrase-file") {
+		var err error
+		if passphrase, err = passphraseFrom(cmd, "", false); err != nil {
+			return err
+		}
+	}
+
+	err := serv.UsersImportCA(chainPEM, keyPEM, passphrase)
+	if passphrase != "" || !errors.Is(err, server.ErrCAPassphrase) {
+		return err
+	}
+
+	if passphrase, err = promptPassphrase("Passphrase of the imported CA private key:", false); err != nil {
+		return err
+	}
+
+	return serv.UsersImportCA(chainPEM, keyPEM, passphrase)
+}
+
+// exportUsersCA exports the users CA with its private key encrypted with the passphrase
+// of the --passphrase-file flag, if any, or else with the CA passphrase, if any, or else
+// with a passphrase prompted for: the private key is never exported in cleartext.
+func exportUsersCA(cmd *cobra.Command, serv *server.Server) (certPEM, keyPEM []byte, err error) {
+	var passphrase string
+
+	if cmd.Flags().Changed("passphrase-file") {
+		if passphrase, err = passphraseFrom(cmd, "", false); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	certPEM, keyPEM, err = serv.UsersExportCA(passphrase)
+	if passphrase != "" || !errors.Is(err, server.ErrExportPassphrase) {
+		return certPEM, keyPEM, err
+	}
+
+	if passphrase, err = promptPassphrase("Passphrase to encrypt the exported CA private key:", true); err != nil {
+		return nil, nil, err
+	}
+
+	return serv.UsersExportCA(passphrase)
+}
@@ -0,0 +1,162 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/assets"
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func revokeCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		reason, _ := cmd.Flags().GetString("reason")
+		serials, _ := cmd.Flags().GetStringSlice("serial")
+
+		if len(args) == 0 && len(serials) == 0 {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn+"Provide at least one user, or a certificate serial with --serial")
+			return
+		}
+
+		for _, name := range args {
+			if err := serv.UserRevoke(name, reason); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				continue
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Revoked certificates of user %s\n", name)
+		}
+
+		for _, serial := range serials {
+			if err := serv.UsersRevokeSerial(serial, reason); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				continue
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Revoked certificate %s\n", serial)
+		}
+	}
+}
+
+func crlCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		export, _ := cmd.Flags().GetString("export")
+		printPEM, _ := cmd.Flags().GetBool("pem")
+
+		if export == "" && !printPEM {
+			revoked, err := serv.UsersRevoked()
+			if err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				return
+			}
+
+			if len(revoked) == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), command.Info+"No revoked certificates for the %s teamserver\n", serv.Name())
+				return
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), revokedTable(revoked))
+
+			return
+		}
+
+		crl, err := serv.UsersGetCRL()
+		if err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
+		if printPEM {
+			fmt.Fprint(cmd.OutOrStdout(), string(crl))
+		}
+
+		if export == "" {
+			return
+		}
+
+		saveTo, _ := filepath.Abs(export)
+
+		if crlFile, err := os.Stat(saveTo); err == nil && crlFile.IsDir() {
+			saveTo = filepath.Join(saveTo, fmt.Sprintf("%s-%s.crl.pem", serv.Name(), "users"))
+		}
+
+		if err := os.WriteFile(saveTo, crl, assets.FileWritePerm); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Write failed: %s (%s)\n", saveTo, err)
+			return
+		}
+
+		fmt.Fprintf(cmd.ErrOrStderr(), command.Info+"Saved users CRL to %s\n", saveTo)
+	}
+}
+
+func revokedTable(revoked []server.RevokedCertificate) string {
+	tbl := &table.Table{}
+	tbl.SetStyle(command.TableStyle)
+
+	tbl.AppendHeader(table.Row{
+		"Serial",
+		"Common name",
+		"Reason",
+		"Revoked",
+	})
+
+	for _, rev := range revoked {
+		tbl.AppendRow(table.Row{
+			rev.Serial,
+			rev.CommonName,
+			rev.Reason,
+			rev.RevokedAt.Format(time.RFC1123),
+		})
+	}
+
+	return tbl.Render()
+}
@@ -0,0 +1,192 @@
+package commands
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+
+	"github.com/reeflective/team/internal/command"
+	"github.com/reeflective/team/server"
+)
+
+func sessionsCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, _ []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		user, _ := cmd.Flags().GetString("user")
+
+		sessionsTable := sessionsTable(serv, user)
+		if sessionsTable == "" {
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"No clients connected to the %s teamserver\n", serv.Name())
+			return
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), sessionsTable)
+	}
+}
+
+func kickCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		user, _ := cmd.Flags().GetString("user")
+
+		if len(args) == 0 && user == "" {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn+"Provide at least one session ID, or a user with --user")
+			return
+		}
+
+		matches, ambiguous := matchSessions(serv.Sessions(), user, args)
+
+		// Never kick several sessions when only one of them was meant to be.
+		if len(ambiguous) > 0 {
+			for prefix, candidates := range ambiguous {
+				ids := make([]string, 0, len(candidates))
+				for _, sess := range candidates {
+					ids = append(ids, fmt.Sprintf("%s (%s, %s)", sess.ID, sess.User, sess.RemoteAddr))
+				}
+
+				fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Session ID prefix %s is ambiguous, it matches: %s\n",
+					prefix, strings.Join(ids, ", "))
+			}
+
+			return
+		}
+
+		var kicked int
+
+		for _, sess := range matches {
+			if err := serv.KickSession(sess.ID); err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				continue
+			}
+
+			kicked++
+
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Kicked %s session %s (%s)\n",
+				sess.User, formatSmallID(sess.ID), sess.RemoteAddr)
+		}
+
+		if kicked == 0 {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn+"No live session matches the provided IDs/user")
+		}
+	}
+}
+
+// matchSessions returns the sessions belonging to the user (if not empty), or whose ID
+// starts with one of the ID prefixes provided. Prefixes matching more than one session
+// are returned along with their candidates instead, since each must designate one session.
+func matchSessions(sessions []server.Session, user string, prefixes []string) ([]server.Session, map[string][]server.Session) {
+	var matches []server.Session
+
+	ambiguous := make(map[string][]server.Session)
+	candidates := make(map[string][]server.Session)
+
+	for _, sess := range sessions {
+		for _, prefix := range prefixes {
+			if prefix != "" && strings.HasPrefix(sess.ID, prefix) {
+				candidates[prefix] = append(candidates[prefix], sess)
+			}
+		}
+	}
+
+	for prefix, sessions := range candidates {
+		if len(sessions) > 1 {
+			ambiguous[prefix] = sessions
+		}
+	}
+
+	for _, sess := range sessions {
+		if (user != "" && sess.User == user) || matchesPrefix(sess, candidates) {
+			matches = append(matches, sess)
+		}
+	}
+
+	return matches, ambiguous
+}
+
+// matchesPrefix returns true if the session is the only candidate of an ID prefix.
+func matchesPrefix(sess server.Session, candidates map[string][]server.Session) bool {
+	for _, sessions := range candidates {
+		if len(sessions) == 1 && sessions[0].ID == sess.ID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sessionsTable(serv *server.Server, user string) string {
+	sessions := serv.Sessions()
+
+	tbl := &table.Table{}
+	tbl.SetStyle(command.TableStyle)
+
+	tbl.AppendHeader(table.Row{
+		"ID",
+		"User",
+		"Credential",
+		"Listener",
+		"Address",
+		"Client version",
+		"Connected",
+	})
+
+	var count int
+
+	for _, sess := range sessions {
+		if user != "" && sess.User != user {
+			continue
+		}
+
+		count++
+
+		tbl.AppendRow(table.Row{
+			formatSmallID(sess.ID),
+			sess.User,
+			sess.Credential,
+			sess.Handler,
+			sess.RemoteAddr,
+			sess.ClientVersion,
+			sess.ConnectedAt.Format(time.RFC1123),
+		})
+	}
+
+	if count > 0 {
+		return tbl.Render()
+	}
+
+	return ""
+}
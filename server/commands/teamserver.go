@@ -44,6 +44,10 @@ func daemoncmd(serv *server.Server) func(cmd *cobra.Command, args []string) erro
 			}
 		}
 
+		if err := unlockUsersCA(serv); err != nil {
+			return err
+		}
+
 		lhost, err := cmd.Flags().GetString("host")
 		if err != nil {
 			return fmt.Errorf("Failed to get --host flag: %w", err)
@@ -86,6 +90,10 @@ func startListenerCmd(serv *server.Server) func(cmd *cobra.Command, args []strin
 			}
 		}
 
+		if err := unlockUsersCA(serv); err != nil {
+			return err
+		}
+
 		lhost, _ := cmd.Flags().GetString("host")
 		lport, _ := cmd.Flags().GetUint16("port")
 		persistent, _ := cmd.Flags().GetBool("persistent")
@@ -245,6 +253,15 @@ func statusCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
 			"Audit", filepath.Join(serv.LogsDir(), "audit.json"),
 		}))
 
+		// Where secrets come from (never their values).
+		var secrets []string
+		for _, secret := range serv.SecretSources() {
+			secrets = append(secrets, secret.Name, secret.Source)
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), formatSection("Secrets"))
+		fmt.Fprint(cmd.OutOrStdout(), displayGroup(secrets))
+
 		// Certificate files.
 		certsPath := serv.CertificatesDir()
 		if dir, err := serv.Filesystem().Stat(certsPath); err == nil && dir.IsDir() {
@@ -265,6 +282,22 @@ func statusCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
 			fmt.Fprintln(cmd.OutOrStdout(), formatSection("Listeners"))
 			fmt.Fprintln(cmd.OutOrStdout(), listenersTable)
 		}
+
+		// Connected clients
+		sessionsTable := sessionsTable(serv, "")
+
+		if sessionsTable != "" {
+			fmt.Fprintln(cmd.OutOrStdout(), formatSection("Sessions"))
+			fmt.Fprintln(cmd.OutOrStdout(), sessionsTable)
+		}
+
+		// Addresses and credentials banned after failed authentications
+		bansTable := bansTable(serv)
+
+		if bansTable != "" {
+			fmt.Fprintln(cmd.OutOrStdout(), formatSection("Bans"))
+			fmt.Fprintln(cmd.OutOrStdout(), bansTable)
+		}
 	}
 }
 
@@ -31,6 +31,11 @@ func createUserCmd(serv *server.Server, cli *client.Client) func(cmd *cobra.Comm
 			}
 		}
 
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
 		name, _ := cmd.Flags().GetString("name")
 		lhost, _ := cmd.Flags().GetString("host")
 		lport, _ := cmd.Flags().GetUint16("port")
@@ -135,6 +140,11 @@ func rmUserCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
 			}
 		}
 
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
 		user := args[0]
 
 		// Certificate/token removal is logged by the teamserver's own (slog)
@@ -158,6 +168,11 @@ func importCACmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
 			}
 		}
 
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
 		load := args[0]
 
 		fi, err := os.Stat(load)
@@ -185,7 +200,10 @@ func importCACmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
 
 		cert := []byte(importCA.Certificate)
 		key := []byte(importCA.PrivateKey)
-		serv.UsersSaveCA(cert, key)
+
+		if err := importUsersCA(cmd, serv, cert, key); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+		}
 	}
 }
 
@@ -198,6 +216,11 @@ func exportCACmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
 			}
 		}
 
+		if err := unlockUsersCA(serv); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+			return
+		}
+
 		var save string
 		if len(args) == 1 {
 			save = args[0]
@@ -207,7 +230,7 @@ func exportCACmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
 			save, _ = os.Getwd()
 		}
 
-		certificateData, privateKeyData, err := serv.UsersGetCA()
+		certificateData, privateKeyData, err := exportUsersCA(cmd, serv)
 		if err != nil {
 			fmt.Fprintf(cmd.ErrOrStderr(), command.Warn+"Error reading CA %s\n", err)
 			return
@@ -246,3 +269,32 @@ func exportCACmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
 		}
 	}
 }
+
+func rotateTokenCmd(serv *server.Server) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		if cmd.Flags().Changed("verbosity") {
+			logLevel, err := cmd.Flags().GetCount("verbosity")
+			if err == nil {
+				serv.SetLogLevel(int(slog.LevelWarn) - logLevel*4)
+			}
+		}
+
+		label, _ := cmd.Flags().GetString("credential")
+
+		for _, name := range args {
+			token, expiresAt, err := serv.UserRotateToken(name, label)
+			if err != nil {
+				fmt.Fprintln(cmd.ErrOrStderr(), command.Warn, err)
+				continue
+			}
+
+			expiry := "never"
+			if !expiresAt.IsZero() {
+				expiry = expiresAt.Format(time.RFC1123)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), command.Info+"Rotated API token of user %s, credential %s (expires: %s)\n", name, label, expiry)
+			fmt.Fprintf(cmd.OutOrStdout(), "    %s\n", token)
+		}
+	}
+}
@@ -19,16 +19,18 @@ package server
 */
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	insecureRand "math/rand"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/reeflective/team/internal/assets"
+	"github.com/reeflective/team/internal/certs"
 	"github.com/reeflective/team/internal/command"
 	"github.com/reeflective/team/log"
 )
@@ -38,8 +40,26 @@ const (
 	blankPort   = uint16(0)
 	tokenLength = 32
 	defaultPort = 31416 // Should be 31415, but... go to hell with limits.
+
+	defaultTokenGracePeriod = time.Hour
+	defaultAuthCacheTTL     = 5 * time.Minute
+	defaultLastSeenInterval = 5 * time.Second
+
+	defaultLockoutMaxFailures = 10
+	defaultLockoutWindow      = 15 * time.Minute
+	defaultLockoutBackoff     = time.Second
+	defaultLockoutMaxBackoff  = time.Minute
+	defaultLockoutBanDuration = time.Hour
+
+	defaultCARotationWindow = 7 * 24 * time.Hour
+
+	defaultCertMonitorInterval = time.Hour
+	defaultServerCertRenewal   = 30 * 24 * time.Hour
 )
 
+// defaultCertExpiryWarnings are the times before expiry at which certificates are reported.
+var defaultCertExpiryWarnings = []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour}
+
 // Config represents the configuration of a given application teamserver.
 // It contains anonymous embedded structs as subsections, for logging,
 // daemon mode bind addresses, and persistent teamserver listeners
@@ -49,6 +69,13 @@ const (
 //   - Daemon host: ""
 //   - Daemon port: 31416
 //   - logging file level: Info.
+//   - Users tokens: never expire, 1 hour grace period after rotation.
+//   - Users authentication cache: 5 minutes, last seen times written every 5 seconds.
+//   - Lockout: ban for 1 hour after 10 failures within 15 minutes, 1s-1m backoff.
+//   - Certificates: ECDSA keys (P-384 for the CA, P-256 otherwise), valid 3 years.
+//   - Users CA rotation: the previous CA is trusted for 7 days.
+//   - Certificates expiry: checked hourly, reported 30, 7 and 1 day(s) before expiry,
+//     and the server certificate is renewed 30 days before.
 type Config struct {
 	// When the teamserver command `app teamserver daemon` is executed
 	// without --host/--port flags, the teamserver will use the config.
@@ -66,6 +93,68 @@ type Config struct {
 		TLSKeyLogger       bool `json:"tls_key_logger"`
 	} `json:"log"`
 
+	// Users controls the lifetime of users API tokens, as Go durations (eg. "720h").
+	// Tokens expire after TokenExpiry (empty or zero: never), and a rotated token
+	// keeps working during TokenGracePeriod, so that clients can switch to the new.
+	// Authenticated tokens are cached for AuthCacheTTL (zero: not cached), and the
+	// last time users were seen is written to the database every LastSeenInterval.
+	Users struct {
+		TokenExpiry      string `json:"token_expiry"`
+		TokenGracePeriod string `json:"token_grace_period"`
+		AuthCacheTTL     string `json:"auth_cache_ttl"`
+		LastSeenInterval string `json:"last_seen_interval"`
+	} `json:"users"`
+
+	// Lockout controls the protection against brute-force authentication attempts, tracked
+	// per remote address and per client certificate (credential). After each failure, the
+	// next attempt is refused during a backoff delay, starting at Backoff and doubled for each
+	// new failure (up to MaxBackoff). After MaxFailures failures (zero disables lockout), with
+	// less than Window between each, the address or credential is banned for BanDuration.
+	// Durations are Go durations (eg. "15m").
+	Lockout struct {
+		MaxFailures int    `json:"max_failures"`
+		Window      string `json:"window"`
+		Backoff     string `json:"backoff"`
+		MaxBackoff  string `json:"max_backoff"`
+		BanDuration string `json:"ban_duration"`
+	} `json:"lockout"`
+
+	// Certificates controls how the teamserver issues certificates, with profiles
+	// for certificate authorities, server and client certificates (see CertificateProfile).
+	// Certificates without a profile for their CA and purpose use the default ones.
+	// After a users CA rotation, the previous CA is trusted during RotationWindow.
+	// Certificates expiry is checked every MonitorInterval: certificates expiring within
+	// one of the ExpiryWarnings are reported (logs and events), and the server certificate
+	// is renewed when expiring within RenewServerBefore. Durations are Go durations.
+	// The server certificate is valid for the daemon and listeners hosts, and for the
+	// ServerNames (host names or IP addresses clients use to reach the teamserver):
+	// it is reissued when these change. The users CA private key is stored encrypted with
+	// the passphrase of the CAPassphrase secret reference ("env:NAME", "file:/path" or
+	// "prompt"), or else read from CAPassphraseFile, if not given otherwise (see UsersUnlockCA).
+	// Private keys are kept in the SecretStore ("filesystem", "database" or "encrypted-file",
+	// whose passphrase is given by SecretsPassphrase or SecretsPassphraseFile likewise).
+	Certificates struct {
+		Profiles              []CertificateProfile `json:"profiles"`
+		RotationWindow        string               `json:"rotation_window"`
+		MonitorInterval       string               `json:"monitor_interval"`
+		ExpiryWarnings        []string             `json:"expiry_warnings"`
+		RenewServerBefore     string               `json:"renew_server_before"`
+		ServerNames           []string             `json:"server_names"`
+		CAPassphrase          string               `json:"ca_passphrase,omitempty"`
+		CAPassphraseFile      string               `json:"ca_passphrase_file"`
+		SecretStore           string               `json:"secret_store"`
+		SecretsPassphrase     string               `json:"secrets_passphrase,omitempty"`
+		SecretsPassphraseFile string               `json:"secrets_passphrase_file"`
+	} `json:"certificates"`
+
+	// Backup controls the archives of the teamserver state (see server.Backup): they are
+	// encrypted with the passphrase of the Passphrase secret reference, or else read from
+	// PassphraseFile, if not given otherwise.
+	Backup struct {
+		Passphrase     string `json:"passphrase,omitempty"`
+		PassphraseFile string `json:"passphrase_file"`
+	} `json:"backup"`
+
 	// Listeners is a list of persistent teamserver listeners.
 	// They are started when the teamserver daemon command/mode is.
 	Listeners []struct {
@@ -76,6 +165,23 @@ type Config struct {
 	} `json:"listeners"`
 }
 
+// CertificateProfile determines how the teamserver issues a type of certificates: their key
+// algorithm ("ecdsa", "rsa" or "ed25519") and its curve or size, their validity and the time
+// by which they are backdated (as Go durations, eg. "8760h"), and their key usages (eg.
+// "digitalSignature", "clientAuth"). Empty fields are set to the defaults of the purpose,
+// and a "0s" backdate issues certificates valid from the time of their issuance.
+type CertificateProfile struct {
+	CA          string   `json:"ca"`            // Certificate authority ("user" for users), empty for all.
+	Purpose     string   `json:"purpose"`       // "ca", "server" or "client".
+	Algorithm   string   `json:"algorithm"`     // "ecdsa", "rsa" or "ed25519".
+	Curve       string   `json:"curve"`         // ECDSA curve: "P-256", "P-384" or "P-521".
+	RSABits     int      `json:"rsa_bits"`      // RSA key size, 2048 bits at least.
+	Validity    string   `json:"validity"`      // Validity period, eg. "8760h".
+	Backdate    string   `json:"backdate"`      // Certificates are valid from this long before issuance.
+	KeyUsage    []string `json:"key_usage"`     // Key usages, see certs.KeyUsages().
+	ExtKeyUsage []string `json:"ext_key_usage"` // Extended key usages, see certs.ExtKeyUsages().
+}
+
 // ConfigPath returns the path to the server config.json file, on disk or in-memory.
 func (ts *Server) ConfigPath() string {
 	configsDir := ts.ConfigsDir()
@@ -132,6 +238,8 @@ func (ts *Server) SaveConfig(cfg *Config) error {
 		return err
 	}
 
+	ts.parseSettings(cfg)
+
 	cfgLog.Debug(fmt.Sprintf("Saving config to %s", configPath))
 
 	err = ts.fs.WriteFile(configPath, data, assets.FileReadPerm)
@@ -142,6 +250,175 @@ func (ts *Server) SaveConfig(cfg *Config) error {
 	return nil
 }
 
+// configSettings are the settings of the configuration used on each authentication or request,
+// parsed and validated once (when the configuration is loaded or saved) instead of on each use.
+type configSettings struct {
+	tokenExpiry      time.Duration // Zero if tokens never expire.
+	tokenGrace       time.Duration
+	authCacheTTL     time.Duration // Zero if tokens are not cached.
+	lastSeenInterval time.Duration
+	lockout          lockoutSettings
+
+	// Certificates
+	caRotationWindow    time.Duration
+	certMonitorInterval time.Duration
+	certExpiryWarnings  []time.Duration // Longest first.
+	serverRenewBefore   time.Duration
+}
+
+// loadSettings returns the parsed settings of the configuration,
+// parsing them from the current configuration if not done yet.
+func (ts *Server) loadSettings() *configSettings {
+	if settings := ts.settings.Load(); settings != nil {
+		return settings
+	}
+
+	return ts.parseSettings(ts.opts.config)
+}
+
+// parseSettings parses the settings of a configuration, and uses them from now on.
+// Invalid durations in the configuration are logged and replaced by defaults.
+func (ts *Server) parseSettings(cfg *Config) *configSettings {
+	cfgLog := ts.NamedLogger("config", "server")
+
+	duration := func(name, value string, def time.Duration, allowZero bool) time.Duration {
+		if value == "" {
+			return def
+		}
+
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 || (parsed == 0 && !allowZero) {
+			cfgLog.Warn(fmt.Sprintf("Invalid %s %q, using %s", name, value, def))
+			return def
+		}
+
+		return parsed
+	}
+
+	users, lockout, certificates := cfg.Users, cfg.Lockout, cfg.Certificates
+
+	settings := &configSettings{
+		tokenExpiry:      duration("token expiry", users.TokenExpiry, 0, true),
+		tokenGrace:       duration("token grace period", users.TokenGracePeriod, defaultTokenGracePeriod, true),
+		authCacheTTL:     duration("authentication cache TTL", users.AuthCacheTTL, defaultAuthCacheTTL, true),
+		lastSeenInterval: duration("last seen interval", users.LastSeenInterval, defaultLastSeenInterval, false),
+		lockout: lockoutSettings{
+			maxFailures: lockout.MaxFailures,
+			window:      duration("lockout window", lockout.Window, defaultLockoutWindow, true),
+			backoff:     duration("lockout backoff", lockout.Backoff, defaultLockoutBackoff, true),
+			maxBackoff:  duration("lockout max backoff", lockout.MaxBackoff, defaultLockoutMaxBackoff, true),
+			banDuration: duration("lockout ban duration", lockout.BanDuration, defaultLockoutBanDuration, true),
+		},
+		caRotationWindow:    duration("CA rotation window", certificates.RotationWindow, defaultCARotationWindow, false),
+		certMonitorInterval: duration("certificates monitor interval", certificates.MonitorInterval, defaultCertMonitorInterval, false),
+		serverRenewBefore:   duration("certificates server renewal", certificates.RenewServerBefore, defaultServerCertRenewal, false),
+		certExpiryWarnings:  slices.Clone(defaultCertExpiryWarnings),
+	}
+
+	if certificates.ExpiryWarnings != nil {
+		settings.certExpiryWarnings = nil
+
+		for _, value := range certificates.ExpiryWarnings {
+			if warning := duration("certificates expiry warning", value, 0, false); warning > 0 {
+				settings.certExpiryWarnings = append(settings.certExpiryWarnings, warning)
+			}
+		}
+
+		slices.Sort(settings.certExpiryWarnings)
+		slices.Reverse(settings.certExpiryWarnings)
+	}
+
+	ts.settings.Store(settings)
+
+	return settings
+}
+
+// tokenLifetimes returns the validity of newly issued user tokens (zero if they
+// never expire), and the grace period during which a rotated token still works.
+func (ts *Server) tokenLifetimes() (expiry, grace time.Duration) {
+	settings := ts.loadSettings()
+	return settings.tokenExpiry, settings.tokenGrace
+}
+
+// authCacheSettings returns how long authenticated tokens are cached (zero if they are
+// not), and the interval at which the last seen times of users are written to database.
+func (ts *Server) authCacheSettings() (ttl, interval time.Duration) {
+	settings := ts.loadSettings()
+	return settings.authCacheTTL, settings.lastSeenInterval
+}
+
+// lockoutSettings returns the brute-force protection settings of the teamserver.
+func (ts *Server) lockoutSettings() lockoutSettings {
+	return ts.loadSettings().lockout
+}
+
+// caRotationWindow returns how long the previous users CA is trusted after a rotation.
+func (ts *Server) caRotationWindow() time.Duration {
+	return ts.loadSettings().caRotationWindow
+}
+
+// certMonitorSettings returns the interval at which certificates expiry is checked, the times
+// before expiry at which they are reported (longest first), and the time before expiry at which
+// the server certificate is renewed.
+func (ts *Server) certMonitorSettings() (interval time.Duration, warnings []time.Duration, renewBefore time.Duration) {
+	settings := ts.loadSettings()
+	return settings.certMonitorInterval, settings.certExpiryWarnings, settings.serverRenewBefore
+}
+
+// certificateProfiles returns the certificate profiles of the configuration, then of
+// the WithCertificateProfiles() options, which take precedence. Contrary to other
+// settings, invalid profiles are errors: certificates must be issued as required.
+func (ts *Server) certificateProfiles() (certs.Profiles, error) {
+	entries := append(slices.Clone(ts.opts.config.Certificates.Profiles), ts.opts.certProfiles...)
+	profiles := make(certs.Profiles, len(entries))
+
+	duration := func(name, value string) (time.Duration, error) {
+		if value == "" {
+			return 0, nil
+		}
+
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+
+		return parsed, nil
+	}
+
+	for _, entry := range entries {
+		key := entry.Purpose
+		if entry.CA != "" {
+			key = entry.CA + "/" + entry.Purpose
+		}
+
+		validity, err := duration("validity", entry.Validity)
+		if err != nil {
+			return nil, fmt.Errorf("certificate profile '%s': %w", key, err)
+		}
+
+		backdate, err := duration("backdate", entry.Backdate)
+		if err != nil {
+			return nil, fmt.Errorf("certificate profile '%s': %w", key, err)
+		}
+
+		if entry.Backdate == "" {
+			backdate = certs.DefaultProfile(entry.Purpose).Backdate
+		}
+
+		profiles[key] = certs.Profile{
+			Algorithm:   entry.Algorithm,
+			Curve:       entry.Curve,
+			RSABits:     entry.RSABits,
+			Validity:    validity,
+			Backdate:    backdate,
+			KeyUsage:    entry.KeyUsage,
+			ExtKeyUsage: entry.ExtKeyUsage,
+		}
+	}
+
+	return profiles.Validate()
+}
+
 func getDefaultServerConfig() *Config {
 	return &Config{
 		DaemonMode: struct {
@@ -158,6 +435,50 @@ func getDefaultServerConfig() *Config {
 		}{
 			Level: int(slog.LevelInfo),
 		},
+		Users: struct {
+			TokenExpiry      string `json:"token_expiry"`
+			TokenGracePeriod string `json:"token_grace_period"`
+			AuthCacheTTL     string `json:"auth_cache_ttl"`
+			LastSeenInterval string `json:"last_seen_interval"`
+		}{
+			TokenGracePeriod: defaultTokenGracePeriod.String(),
+			AuthCacheTTL:     defaultAuthCacheTTL.String(),
+			LastSeenInterval: defaultLastSeenInterval.String(),
+		},
+		Lockout: struct {
+			MaxFailures int    `json:"max_failures"`
+			Window      string `json:"window"`
+			Backoff     string `json:"backoff"`
+			MaxBackoff  string `json:"max_backoff"`
+			BanDuration string `json:"ban_duration"`
+		}{
+			MaxFailures: defaultLockoutMaxFailures,
+			Window:      defaultLockoutWindow.String(),
+			Backoff:     defaultLockoutBackoff.String(),
+			MaxBackoff:  defaultLockoutMaxBackoff.String(),
+			BanDuration: defaultLockoutBanDuration.String(),
+		},
+		Certificates: struct {
+			Profiles              []CertificateProfile `json:"profiles"`
+			RotationWindow        string               `json:"rotation_window"`
+			MonitorInterval       string               `json:"monitor_interval"`
+			ExpiryWarnings        []string             `json:"expiry_warnings"`
+			RenewServerBefore     string               `json:"renew_server_before"`
+			ServerNames           []string             `json:"server_names"`
+			CAPassphrase          string               `json:"ca_passphrase,omitempty"`
+			CAPassphraseFile      string               `json:"ca_passphrase_file"`
+			SecretStore           string               `json:"secret_store"`
+			SecretsPassphrase     string               `json:"secrets_passphrase,omitempty"`
+			SecretsPassphraseFile string               `json:"secrets_passphrase_file"`
+		}{
+			Profiles:          []CertificateProfile{},
+			RotationWindow:    defaultCARotationWindow.String(),
+			MonitorInterval:   defaultCertMonitorInterval.String(),
+			ExpiryWarnings:    durationStrings(defaultCertExpiryWarnings),
+			RenewServerBefore: defaultServerCertRenewal.String(),
+			ServerNames:       []string{},
+			SecretStore:       SecretStoreFilesystem,
+		},
 		Listeners: []struct {
 			Name string `json:"name"`
 			Host string `json:"host"`
@@ -167,10 +488,23 @@ func getDefaultServerConfig() *Config {
 	}
 }
 
-func getRandomID() string {
-	seededRand := insecureRand.New(insecureRand.NewSource(time.Now().UnixNano()))
+// getRandomID returns a random ID (of sessions, listeners...), read from the secure
+// random source: IDs generated at the same time must be different all the same.
+func getRandomID() (string, error) {
 	buf := make([]byte, tokenLength)
-	seededRand.Read(buf)
 
-	return hex.EncodeToString(buf)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrSecureRandFailed, err)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+func durationStrings(durations []time.Duration) []string {
+	values := make([]string, len(durations))
+	for i, duration := range durations {
+		values[i] = duration.String()
+	}
+
+	return values
 }
@@ -21,6 +21,7 @@ package server
 import (
 	"runtime"
 	"sync"
+	"sync/atomic"
 
 	"gorm.io/gorm"
 
@@ -66,21 +67,42 @@ type Server struct {
 	fs       *assets.FS // Server filesystem, on-disk or embedded
 	initOpts sync.Once  // Some options can only be set once when creating the server.
 
+	// Settings of the configuration, parsed when it is loaded or saved.
+	settings atomic.Pointer[configSettings]
+
 	// Logging
 	logger *log.Logger // Console (stdout/stderr) and optional file logging.
 
 	// Users
-	userTokens *sync.Map      // Refreshed entirely when a user is kicked.
-	certs      *certs.Manager // Manages all the certificate infrastructure.
-	certsInit  sync.Once      // The certificate infrastructure is initialized once, lazily.
-	db         *gorm.DB       // Stores certificates and users data.
-	dbInit     sync.Once      // A single database can be used in a teamserver lifetime.
+	authCache   *authCache     // Authenticated tokens, invalidated per user or credential.
+	lastSeen    *lastSeen      // Pending LastSeen updates, written in batches.
+	sessions    *sessions      // All live teamclient sessions, across handlers.
+	lockout     *lockout       // Failed authentications and bans, per address and credential.
+	certs       *certs.Manager // Manages all the certificate infrastructure.
+	certsInit   sync.Once      // The certificate infrastructure is initialized once, lazily.
+	certsErr    error          // Error of the initialization, returned by all later uses.
+	usersTLS    *usersTLS      // Mutual TLS configurations of the current users CA.
+	tlsMutex    sync.Mutex     // Guards the users TLS configurations.
+	certMonitor *certMonitor   // Checks the expiry of certificates, and renews the server one.
+	db          *gorm.DB       // Stores certificates and users data.
+	dbOpen      sync.Once      // A single database can be used in a teamserver lifetime.
+	dbOpenErr   error          // Error of the connection, returned by all later uses.
+	dbInit      sync.Once      // The database is migrated once, when first used.
+	dbErr       error          // Error of the migrations, returned by all later uses.
+	dbKeyRef    bool           // The database key was resolved from the reference of the database config.
+
+	// Secrets prompted for (see secret references), by name.
+	prompted    map[string]string
+	promptMutex sync.Mutex
 
 	// Handlers (transport stacks) and job control
 	initServe sync.Once          // Some options can only have an effect at first start.
 	self      Handler            // The default handler (transport stack) used by the teamserver.
 	handlers  map[string]Handler // Other handlers available by name.
 	jobs      *jobs              // Listener (bind) job control
+
+	// Events
+	events *events // Subscribers to teamserver events.
 }
 
 // New creates a new teamserver for the provided application name.
@@ -101,11 +123,16 @@ type Server struct {
 //     of teamserver can be recorded and watched out in various places.
 func New(application string, options ...Options) (*Server, error) {
 	server := &Server{
-		name:       application,
-		opts:       newDefaultOpts(),
-		userTokens: &sync.Map{},
-		jobs:       newJobs(),
-		handlers:   make(map[string]Handler),
+		name:        application,
+		opts:        newDefaultOpts(),
+		authCache:   newAuthCache(),
+		lastSeen:    newLastSeen(),
+		sessions:    newSessions(),
+		lockout:     newLockout(),
+		certMonitor: newCertMonitor(),
+		events:      newEvents(),
+		jobs:        newJobs(),
+		handlers:    make(map[string]Handler),
 	}
 
 	server.apply(options...)
@@ -191,6 +218,9 @@ func (ts *Server) Users() ([]team.User, error) {
 		return nil, ts.errorf("%w: %w", ErrDatabase, err)
 	}
 
+	// Write pending last seen times first.
+	ts.flushLastSeen(false)
+
 	usersDB := []*db.User{}
 	err := ts.Database().Find(&usersDB).Error
 
@@ -204,11 +234,10 @@ func (ts *Server) Users() ([]team.User, error) {
 		users[i] = team.User{
 			Name:     user.Name,
 			LastSeen: user.LastSeen,
+			Clients:  ts.sessions.count(user.Name),
 		}
 
-		if _, ok := ts.userTokens.Load(user.Token); ok {
-			users[i].Online = true
-		}
+		users[i].Online = users[i].Clients > 0
 	}
 
 	return users, nil
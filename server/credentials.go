@@ -0,0 +1,332 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/reeflective/team/client"
+	"github.com/reeflective/team/internal/certs"
+	"github.com/reeflective/team/internal/db"
+)
+
+// DefaultCredential is the label of the credential issued to users when they are created.
+const DefaultCredential = db.DefaultCredential
+
+// Credential is a set of credentials issued to a teamserver user, usually for one of
+// its devices (a laptop, a workstation...): an API token, and the client certificate
+// to which this token is bound. Each credential has its own client configuration, and
+// can be revoked without affecting the other credentials (devices) of the user.
+//
+// Users are created with a credential labeled "default" (see server.UserCreate()),
+// and any number of others can be added with server.UserCredentialAdd().
+type Credential struct {
+	User      string    // Name of the user owning the credential.
+	Label     string    // Label of the credential, unique for its user.
+	CreatedAt time.Time // Time at which the credential was issued.
+	LastUsed  time.Time // Last time the credential token was used.
+	ExpiresAt time.Time // Expiry of the API token (zero if it never expires).
+	Serial    string    // Serial number of the client certificate.
+}
+
+// UserCredentials returns all the credentials of a user, oldest first.
+func (ts *Server) UserCredentials(name string) ([]Credential, error) {
+	if err := ts.initDatabase(); err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	// Write pending last used times first.
+	ts.flushLastSeen(false)
+
+	credsDB := []*db.Credential{}
+
+	err := ts.Database().Where(&db.Credential{UserName: name}).
+		Order("created_at").
+		Find(&credsDB).Error
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	creds := make([]Credential, len(credsDB))
+	for i, cred := range credsDB {
+		creds[i] = credentialFrom(cred)
+	}
+
+	return creds, nil
+}
+
+// UserCredentialAdd issues a new credential to an existing user, and returns its client
+// configuration. The user keeps all its other credentials, so this is how a user can be
+// given one configuration per device. The label must be unique among the user credentials.
+func (ts *Server) UserCredentialAdd(name, label, lhost string, lport uint16) (*client.Config, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	if label == "" || !namePattern.MatchString(label) {
+		return nil, ts.errorf("%w: invalid credential label '%s' (alphanumerics only)", ErrUserConfig, label)
+	}
+
+	if lhost == "" {
+		return nil, ts.errorf("%w: invalid team server host (empty)", ErrUserConfig)
+	}
+
+	if lport == blankPort {
+		lport = uint16(ts.opts.config.DaemonMode.Port)
+	}
+
+	err := ts.Database().Where(&db.User{Name: name}).First(&db.User{}).Error
+	if err != nil {
+		return nil, ts.errorf("%w: no user %s: %w", ErrUserConfig, name, err)
+	}
+
+	if _, err := ts.credentialByLabel(name, label); err == nil {
+		return nil, ts.errorf("%w: user %s already has a credential '%s'", ErrUserConfig, name, label)
+	}
+
+	config, err := ts.newCredential(name, label, lhost, lport)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.audit(AuditCredentialAdd, name, "credential "+label)
+
+	return config, nil
+}
+
+// UserCredentialRevoke revokes a single credential of a user: its token is refused and its
+// certificate revoked, and the sessions established with it are closed. The user and its
+// other credentials are not affected. The default credential can be revoked as well, and
+// issued again by creating the user again (server.UserCreate()).
+func (ts *Server) UserCredentialRevoke(name, label string) error {
+	if err := ts.initCerts(); err != nil {
+		return err
+	}
+
+	if _, err := ts.credentialByLabel(name, label); err != nil {
+		return ts.errorf("%w: user %s has no credential '%s': %w", ErrUserConfig, name, label, err)
+	}
+
+	if err := ts.credentialDelete(name, label, certs.ReasonCessationOfOperation); err != nil {
+		return err
+	}
+
+	ts.audit(AuditCredentialRevoke, name, "credential "+label)
+
+	return nil
+}
+
+// TokenCredential returns the credential to which a raw API token belongs,
+// without authenticating it: the token might be expired, for instance.
+// Handlers can use it to know which credential (device) a client is using.
+func (ts *Server) TokenCredential(rawToken string) (*Credential, error) {
+	if err := ts.initDatabase(); err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	credDB, err := ts.credentialByToken(hashToken(rawToken))
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrUnauthenticated, err)
+	}
+
+	cred := credentialFrom(credDB)
+
+	return &cred, nil
+}
+
+// newCredential generates a token and a client certificate for a user
+// credential, saves them and returns the corresponding client config.
+func (ts *Server) newCredential(name, label, lhost string, lport uint16) (*client.Config, error) {
+	publicKey, privateKey, err := ts.certs.UserClientGenerateCredentialCertificate(name, credentialCertLabel(label))
+	if err != nil {
+		return nil, ts.errorf("%w: failed to generate certificate %w", ErrCertificate, err)
+	}
+
+	rawToken, err := ts.saveCredential(name, label, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	caChainPEM, certChainPEM := ts.usersCertificatesPEM(publicKey)
+	config := client.Config{
+		User:          name,
+		Token:         rawToken,
+		Host:          lhost,
+		Port:          int(lport),
+		CACertificate: caChainPEM,
+		PrivateKey:    string(privateKey),
+		Certificate:   certChainPEM,
+	}
+
+	return &config, nil
+}
+
+// saveCredential saves a user credential bound to the given client
+// certificate, and returns the raw API token issued with it.
+func (ts *Server) saveCredential(name, label string, certPEM []byte) (string, error) {
+	return ts.createCredential(ts.Database(), name, label, certPEM)
+}
+
+// createCredential creates a user credential bound to the given client certificate with
+// a database session (eg. a transaction), and returns the raw API token issued with it.
+func (ts *Server) createCredential(database *gorm.DB, name, label string, certPEM []byte) (string, error) {
+	rawToken, err := ts.newUserToken()
+	if err != nil {
+		return "", ts.errorf("%w: %w", ErrUserConfig, err)
+	}
+
+	cert, err := certs.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return "", ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	err = database.Create(&db.Credential{
+		UserName:          name,
+		Label:             label,
+		Token:             hashToken(rawToken),
+		TokenExpiresAt:    ts.newTokenExpiry(),
+		CertificateSerial: certs.FormatSerial(cert.SerialNumber),
+	}).Error
+	if err != nil {
+		return "", ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	return rawToken, nil
+}
+
+// credentialDelete removes a user credential, revokes its certificate
+// and closes the sessions established with it. No-op if not found.
+func (ts *Server) credentialDelete(name, label string, reason int) error {
+	err := ts.Database().Where(&db.Credential{UserName: name, Label: label}).Delete(&db.Credential{}).Error
+	if err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	// Clear the cached tokens of the credential so
+	// that all requests made with it are now refused.
+	ts.authCache.invalidateCredential(name, label)
+
+	if err := ts.certs.UserClientRevokeCredentialCertificate(name, credentialCertLabel(label), reason); err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	ts.kickCredentialSessions(name, label)
+
+	if err := ts.certs.UserClientRemoveCredentialCertificate(name, credentialCertLabel(label)); err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	return nil
+}
+
+// credentialByLabel - Select a user credential by label.
+func (ts *Server) credentialByLabel(name, label string) (*db.Credential, error) {
+	cred := &db.Credential{}
+	err := ts.Database().Where(&db.Credential{UserName: name, Label: label}).First(cred).Error
+
+	return cred, err
+}
+
+// credentialByToken - Select a credential by token value, including previous
+// tokens during their grace period.
+func (ts *Server) credentialByToken(value string) (*db.Credential, error) {
+	if len(value) < 1 {
+		return nil, db.ErrRecordNotFound
+	}
+
+	// Tokens are looked up with struct conditions, so that their
+	// values are encrypted like the columns when the database is.
+	database := ts.Database()
+	previous := database.Where(&db.Credential{PreviousToken: value}).
+		Where("previous_token_expires_at > ?", time.Now())
+
+	cred := &db.Credential{}
+	err := database.Where(&db.Credential{Token: value}).Or(previous).First(cred).Error
+
+	return cred, err
+}
+
+// credentialSerial returns the serial number of the client certificate of a credential.
+// Credentials migrated from older teamservers do not have it yet, so it is saved once found.
+func (ts *Server) credentialSerial(cred *db.Credential) (string, error) {
+	if cred.CertificateSerial != "" {
+		return cred.CertificateSerial, nil
+	}
+
+	if err := ts.initCerts(); err != nil {
+		return "", err
+	}
+
+	certPEM, _, err := ts.certs.UserClientGetCredentialCertificate(cred.UserName, credentialCertLabel(cred.Label))
+	if err != nil {
+		return "", err
+	}
+
+	cert, err := certs.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrCertificate, err)
+	}
+
+	cred.CertificateSerial = certs.FormatSerial(cert.SerialNumber)
+
+	err = ts.Database().Model(cred).Update("CertificateSerial", cred.CertificateSerial).Error
+	if err != nil && !errors.Is(err, db.ErrRecordNotFound) {
+		return "", err
+	}
+
+	return cred.CertificateSerial, nil
+}
+
+// kickCredentialSessions closes all live sessions established with a user credential.
+func (ts *Server) kickCredentialSessions(name, label string) {
+	for _, sess := range ts.Sessions() {
+		if sess.User != name || sess.Credential != label {
+			continue
+		}
+
+		if err := ts.kickSession(sess.ID); err != nil {
+			ts.log().Warn(fmt.Sprintf("Failed to kick session %s: %s", formatSmallID(sess.ID), err))
+		}
+	}
+}
+
+// credentialCertLabel returns the label under which the certificate of a credential is
+// stored: default credentials use the certificate that users had before having several.
+func credentialCertLabel(label string) string {
+	if label == DefaultCredential {
+		return ""
+	}
+
+	return label
+}
+
+func credentialFrom(cred *db.Credential) Credential {
+	return Credential{
+		User:      cred.UserName,
+		Label:     cred.Label,
+		CreatedAt: cred.CreatedAt,
+		LastUsed:  cred.LastUsed,
+		ExpiresAt: cred.TokenExpiresAt,
+		Serial:    cred.CertificateSerial,
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/reeflective/team/internal/certs"
+)
+
+// TestUserCredentials checks that a user can have several credentials, each bound
+// to its own certificate, and that revoking one leaves the others working.
+func TestUserCredentials(t *testing.T) {
+	ts := newTestServer(t)
+
+	def, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	laptop, err := ts.UserCredentialAdd("alice", "laptop", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCredentialAdd: %v", err)
+	}
+
+	if _, err := ts.UserCredentialAdd("alice", "laptop", "localhost", 31337); !errors.Is(err, ErrUserConfig) {
+		t.Fatalf("duplicate credential label should be refused, got %v", err)
+	}
+
+	if _, err := ts.UserCredentialAdd("nobody", "laptop", "localhost", 31337); !errors.Is(err, ErrUserConfig) {
+		t.Fatalf("credential of an unknown user should be refused, got %v", err)
+	}
+
+	creds, err := ts.UserCredentials("alice")
+	if err != nil || len(creds) != 2 {
+		t.Fatalf("expected 2 credentials, got %d (%v)", len(creds), err)
+	}
+
+	if creds[0].Label != DefaultCredential || creds[1].Label != "laptop" || creds[1].Serial == "" {
+		t.Fatalf("unexpected credentials: %+v", creds)
+	}
+
+	defCert, _ := certs.ParseCertificatePEM([]byte(def.Certificate))
+	laptopCert, _ := certs.ParseCertificatePEM([]byte(laptop.Certificate))
+
+	if _, err := ts.AuthenticatePeer(laptop.Token, laptopCert); err != nil {
+		t.Fatalf("laptop credential refused: %v", err)
+	}
+
+	if _, err := ts.AuthenticatePeer(laptop.Token, defCert); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("token used with the certificate of another credential should be refused, got %v", err)
+	}
+
+	// Re-creating the user only replaces its default credential.
+	recreated, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.Authenticate(def.Token); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("replaced default token should be refused, got %v", err)
+	}
+
+	if _, err := ts.Authenticate(laptop.Token); err != nil {
+		t.Fatalf("laptop token should survive the user re-creation: %v", err)
+	}
+
+	// Revoking the laptop credential leaves the default one alone.
+	conn := &closer{}
+	addSession(t, ts, Session{User: "alice", Credential: "laptop", Handler: "test"}, conn)
+	addSession(t, ts, Session{User: "alice", Credential: DefaultCredential, Handler: "test"}, conn)
+
+	if err := ts.UserCredentialRevoke("alice", "laptop"); err != nil {
+		t.Fatalf("UserCredentialRevoke: %v", err)
+	}
+
+	if _, err := ts.Authenticate(laptop.Token); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("revoked credential token should be refused, got %v", err)
+	}
+
+	if revoked, _ := ts.certs.IsRevoked(laptopCert.SerialNumber); !revoked {
+		t.Fatal("revoked credential certificate should be in the revocation list")
+	}
+
+	if _, err := ts.Authenticate(recreated.Token); err != nil {
+		t.Fatalf("default credential should keep working: %v", err)
+	}
+
+	if conn.closed != 1 || len(ts.Sessions()) != 1 {
+		t.Fatalf("only the laptop session should be kicked (closed %d, left %d)", conn.closed, len(ts.Sessions()))
+	}
+
+	if err := ts.UserCredentialRevoke("alice", "laptop"); !errors.Is(err, ErrUserConfig) {
+		t.Fatalf("revoking an unknown credential should fail, got %v", err)
+	}
+}
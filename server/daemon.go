@@ -0,0 +1,78 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// daemonPIDFile holds the process ID of the teamserver daemon, while it runs.
+const daemonPIDFile = "teamserver.pid"
+
+// writeDaemonPID records the process of the teamserver daemon, so that other teamserver
+// processes (eg. its command-line) can refuse to change the files it is using.
+func (ts *Server) writeDaemonPID() error {
+	path := filepath.Join(ts.TeamDir(), daemonPIDFile)
+	pid := strconv.Itoa(os.Getpid()) + "\n"
+
+	if err := ts.fs.WriteFile(path, []byte(pid), 0o600); err != nil {
+		return ts.errorf("%w: failed to write daemon PID file: %w", ErrTeamServer, err)
+	}
+
+	return nil
+}
+
+// removeDaemonPID removes the PID file of the daemon, if it is the one of this process.
+func (ts *Server) removeDaemonPID() {
+	if pid, _ := ts.daemonPID(); pid == os.Getpid() {
+		ts.fs.Remove(filepath.Join(ts.TeamDir(), daemonPIDFile))
+	}
+}
+
+// daemonPID returns the process ID of the teamserver daemon, or 0 if none is recorded.
+func (ts *Server) daemonPID() (int, error) {
+	data, err := ts.fs.ReadFile(filepath.Join(ts.TeamDir(), daemonPIDFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// checkNoDaemon returns ErrDaemonRunning if a teamserver daemon is running in another
+// process. PID files left by daemons which did not exit cleanly are ignored.
+func (ts *Server) checkNoDaemon() error {
+	pid, err := ts.daemonPID()
+	if err != nil {
+		return ts.errorf("%w: invalid daemon PID file: %w", ErrTeamServer, err)
+	}
+
+	if pid == 0 || pid == os.Getpid() || !processRunning(pid) {
+		return nil
+	}
+
+	return fmt.Errorf("%w (pid %d): stop it first", ErrDaemonRunning, pid)
+}
@@ -161,32 +161,293 @@ func (ts *Server) getDefaultDatabaseConfig() *db.Config {
 	return cfg
 }
 
-// initDatabase should be called once when a teamserver is created.
-func (ts *Server) initDatabase() (err error) {
+// initDatabase should be called once when a teamserver is created: it opens the
+// database, applies all pending migrations (refusing a more recent schema) and, with
+// a database key, enables the encryption of its sensitive columns.
+func (ts *Server) initDatabase() error {
 	ts.dbInit.Do(func() {
-		dbLogger := ts.NamedLogger("database", "database")
+		if ts.dbErr = ts.openDatabase(); ts.dbErr != nil {
+			return
+		}
+
+		if ts.dbErr = db.Migrate(ts.db); ts.dbErr != nil || ts.opts.dbKey == "" {
+			return
+		}
+
+		ts.db, ts.dbErr = db.EnableEncryption(ts.db, ts.opts.dbKey)
+	})
 
+	return ts.dbErr
+}
+
+// openDatabase connects to the database without applying any migration, either
+// to use it with server.initDatabase() or to manage its migrations themselves.
+func (ts *Server) openDatabase() error {
+	ts.dbOpen.Do(func() {
 		if ts.db != nil {
-			err = ts.db.AutoMigrate(db.Schema()...)
+			if len(ts.opts.models) > 0 {
+				ts.dbOpenErr = db.RegisterModels(ts.db, ts.opts.models...)
+			}
+
 			return
 		}
 
-		ts.opts.dbConfig, err = ts.getDatabaseConfig()
-		if err != nil {
+		ts.opts.dbConfig, ts.dbOpenErr = ts.getDatabaseConfig()
+		if ts.dbOpenErr != nil {
 			return
 		}
 
+		// The key given with the options takes precedence over the reference of the config.
+		if ts.opts.dbKey == "" && ts.opts.dbConfig.EncryptionKeyRef != "" {
+			ts.opts.dbKey, ts.dbOpenErr = ts.resolveSecret("Database key", ts.opts.dbConfig.EncryptionKeyRef, true)
+			if ts.dbOpenErr != nil {
+				return
+			}
+
+			ts.dbKeyRef = true
+		}
+
 		// Apply an out-of-band encryption key (never persisted to the config
 		// file) so the on-disk SQLite database is encrypted at rest.
 		if ts.opts.dbKey != "" {
 			ts.opts.dbConfig.EncryptionKey = ts.opts.dbKey
 		}
 
-		ts.db, err = db.NewClient(ts.opts.dbConfig, dbLogger)
-		if err != nil {
+		if ts.opts.tablePrefix != "" {
+			ts.opts.dbConfig.TablePrefix = ts.opts.tablePrefix
+		}
+
+		ts.opts.dbConfig.Models = ts.opts.models
+
+		// The password is resolved for the connection only, and never saved with the config.
+		config := *ts.opts.dbConfig
+
+		config.Password, ts.dbOpenErr = ts.resolveSecret("Database password", config.Password, false)
+		if ts.dbOpenErr != nil {
 			return
 		}
+
+		ts.db, ts.dbOpenErr = db.Open(&config, ts.NamedLogger("database", "database"))
 	})
 
-	return err
+	return ts.dbOpenErr
+}
+
+// DatabaseRotateKey generates a new data key for the encrypted columns of the database
+// (private keys, token hashes and invitation codes), encrypts all their values again with
+// it and deletes the previous one. It returns the number of values encrypted again, and
+// fails if the teamserver has no database key (see server.WithDatabaseKey).
+func (ts *Server) DatabaseRotateKey() (int, error) {
+	if err := ts.initDatabase(); err != nil {
+		return 0, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	if !db.EncryptionEnabled(ts.db) {
+		return 0, ts.errorf("%w: %w: no database key", ErrDatabase, db.ErrEncryptionKey)
+	}
+
+	count, err := db.RotateEncryptionKey(ts.db)
+	if err != nil {
+		return 0, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	ts.audit(AuditDatabaseRotateKey, ts.db.Dialector.Name(), fmt.Sprintf("%d values encrypted again", count))
+
+	return count, nil
+}
+
+// DatabaseEncrypt encrypts the on-disk SQLite database of the teamserver with a key (and the
+// sensitive columns, as server.WithDatabaseKey does), which must be given to the teamserver on
+// its next starts. The database file is replaced atomically with an encrypted copy of it, once
+// verified, and the teamserver uses it right away. It fails if the database is already encrypted,
+// or with ErrDaemonRunning if a teamserver daemon is running in another process (as for
+// server.DatabaseDecrypt() and server.DatabaseRekey()).
+func (ts *Server) DatabaseEncrypt(key string) error {
+	if ts.opts.dbKey != "" {
+		return ts.errorf("%w: %w: database already encrypted (change its key instead)", ErrDatabase, db.ErrRekey)
+	}
+
+	if key == "" {
+		return ts.errorf("%w: %w: empty database key", ErrDatabase, db.ErrRekey)
+	}
+
+	return ts.rekeyDatabase(key, "encrypted")
+}
+
+// DatabaseDecrypt stores the on-disk SQLite database of the teamserver, and its sensitive
+// columns, in cleartext: the teamserver then needs no database key anymore. The database
+// file is replaced atomically with a decrypted copy of it, once verified.
+func (ts *Server) DatabaseDecrypt() error {
+	if ts.opts.dbKey == "" {
+		return ts.errorf("%w: %w: database not encrypted", ErrDatabase, db.ErrRekey)
+	}
+
+	return ts.rekeyDatabase("", "decrypted")
+}
+
+// DatabaseRekey encrypts the on-disk SQLite database of the teamserver (and the data keys
+// of its sensitive columns) with a new key, which must be given to the teamserver on its
+// next starts instead of the current one. The database file is replaced atomically with
+// a copy encrypted with the new key, once verified, and the teamserver uses it right away.
+// If the key is given with a reference of the database configuration, what it refers to
+// (environment variable or file) must be updated with the new key.
+func (ts *Server) DatabaseRekey(key string) error {
+	if ts.opts.dbKey == "" {
+		return ts.errorf("%w: %w: database not encrypted", ErrDatabase, db.ErrRekey)
+	}
+
+	if key == "" {
+		return ts.errorf("%w: %w: empty database key", ErrDatabase, db.ErrRekey)
+	}
+
+	return ts.rekeyDatabase(key, "key changed")
+}
+
+// rekeyDatabase replaces the database file with a copy encrypted with a new key (or in
+// cleartext without), and uses it from now on. Databases passed with server.WithDatabase
+// are not managed by the teamserver, and cannot be re-keyed. The database of a daemon
+// running in another process cannot be either: it would keep using the replaced file.
+func (ts *Server) rekeyDatabase(key, details string) error {
+	if err := ts.checkNoDaemon(); err != nil {
+		return ts.errorf("%w: %w: %w", ErrDatabase, db.ErrRekey, err)
+	}
+
+	if err := ts.initDatabase(); err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	if ts.opts.db != nil {
+		return ts.errorf("%w: %w: database not managed by the teamserver", ErrDatabase, db.ErrRekey)
+	}
+
+	dbClient, err := db.Rekey(ts.db, ts.opts.dbConfig, key, ts.NamedLogger("database", "database"))
+	if err != nil && dbClient != nil {
+		// The database file could not be replaced, and was opened again unchanged.
+		ts.db = dbClient
+		if ts.certs != nil {
+			ts.certsErr = ts.newCertificateManager()
+		}
+	}
+
+	if err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	ts.db = dbClient
+	ts.opts.dbKey = key
+	ts.opts.dbConfig.EncryptionKey = key
+
+	// A decrypted database needs no key: its reference would fail the next starts.
+	if key == "" && ts.opts.dbConfig.EncryptionKeyRef != "" {
+		ts.opts.dbConfig.EncryptionKeyRef = ""
+		ts.dbKeyRef = false
+
+		if err := ts.saveDatabaseConfig(ts.opts.dbConfig); err != nil {
+			return ts.errorf("%w: %w", ErrDatabaseConfig, err)
+		}
+	}
+
+	// The certificate infrastructure (and its secret store) used the previous client.
+	if ts.certs != nil {
+		ts.certsErr = ts.newCertificateManager()
+	}
+
+	ts.audit(AuditDatabaseRekey, ts.opts.dbConfig.Database, details)
+
+	return ts.certsErr
+}
+
+// DatabaseMigrateTo copies all the tables of the teamserver database to another database
+// backend, whatever its dialect (eg. from the default SQLite file to PostgreSQL), and returns
+// the number of rows copied. The target database is migrated first, and must be empty. Once
+// the number of rows of all tables is verified, the database configuration file is rewritten
+// with the target backend, which the teamserver uses from now on. The previous database is
+// left untouched. With a database key, the sensitive columns of the target are encrypted
+// with it, as is its file if it is an SQLite one. The tables of the application models
+// (see WithModels) are copied as well, and named with the table prefix of the target
+// configuration, or the one of WithTablePrefix.
+func (ts *Server) DatabaseMigrateTo(config *db.Config) (int64, error) {
+	err := ts.initDatabase()
+	if err != nil {
+		return 0, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	if ts.opts.db != nil {
+		return 0, ts.errorf("%w: %w: database not managed by the teamserver", ErrDatabase, db.ErrCopy)
+	}
+
+	target := *config
+	target.EncryptionKey = ts.opts.dbKey
+	target.Models = ts.opts.models
+
+	if target.EncryptionKeyRef == "" {
+		target.EncryptionKeyRef = ts.opts.dbConfig.EncryptionKeyRef
+	}
+
+	if ts.opts.tablePrefix != "" {
+		target.TablePrefix = ts.opts.tablePrefix
+	}
+
+	if target.MaxIdleConns < 1 {
+		target.MaxIdleConns = ts.opts.dbConfig.MaxIdleConns
+	}
+
+	if target.MaxOpenConns < 1 {
+		target.MaxOpenConns = ts.opts.dbConfig.MaxOpenConns
+	}
+
+	if target.LogLevel == "" {
+		target.LogLevel = ts.opts.dbConfig.LogLevel
+	}
+
+	if target.Dialect == db.Sqlite && (target.Database == "" || target.Database == ts.opts.dbConfig.Database) {
+		return 0, ts.errorf("%w: %w: target SQLite database must be another file", ErrDatabaseConfig, db.ErrCopy)
+	}
+
+	// The target config is saved: its password must be a secret reference.
+	resolved := target
+
+	resolved.Password, err = ts.resolveSecret("Database password", target.Password, true)
+	if err != nil {
+		return 0, ts.errorf("%w: %w", ErrDatabaseConfig, err)
+	}
+
+	dbClient, err := db.NewClient(&resolved, ts.NamedLogger("database", "database"))
+	if err != nil {
+		return 0, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	count, err := db.Copy(ts.db, dbClient, db.Models(ts.db))
+	if err == nil {
+		err = ts.saveDatabaseConfig(&target)
+	}
+
+	if err != nil {
+		if sqlDB, dberr := dbClient.DB(); dberr == nil {
+			sqlDB.Close()
+		}
+
+		return 0, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	if sqlDB, err := ts.db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	ts.db = dbClient
+	ts.opts.dbConfig = &target
+
+	// The certificate infrastructure (and its secret store) used the previous client.
+	if ts.certs != nil {
+		ts.certsErr = ts.newCertificateManager()
+	}
+
+	backend := fmt.Sprintf("%s %s", target.Dialect, target.Database)
+	if target.Host != "" {
+		backend += fmt.Sprintf(" (%s:%d)", target.Host, target.Port)
+	}
+
+	ts.audit(AuditDatabaseMigrateTo, backend, fmt.Sprintf("%d rows copied", count))
+
+	return count, ts.certsErr
 }
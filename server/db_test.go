@@ -0,0 +1,185 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// TestDatabaseColumnEncryption checks that with a database key, token hashes are stored
+// encrypted, still looked up (including rotated tokens), and encrypted again when the
+// data key is rotated.
+func TestDatabaseColumnEncryption(t *testing.T) {
+	ts, err := New("fields", WithInMemory(), WithDatabaseKey("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if err := ts.init(); err != nil {
+		t.Fatalf("server.init: %v", err)
+	}
+
+	user, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	storedTokens := func() []string {
+		var tokens []string
+		if err := ts.db.Raw("SELECT token FROM credentials").Scan(&tokens).Error; err != nil {
+			t.Fatalf("reading raw tokens: %v", err)
+		}
+
+		return tokens
+	}
+
+	before := storedTokens()
+	if len(before) != 1 || !strings.HasPrefix(before[0], "enc:1:") {
+		t.Fatalf("token hash should be stored encrypted, got %v", before)
+	}
+
+	rotated, _, err := ts.UserRotateToken("alice", DefaultCredential)
+	if err != nil {
+		t.Fatalf("UserRotateToken: %v", err)
+	}
+
+	if _, err := ts.credentialByToken(hashToken(user.Token)); err != nil {
+		t.Fatalf("previous token should be found during its grace period: %v", err)
+	}
+
+	count, err := ts.DatabaseRotateKey()
+	if err != nil || count < 2 {
+		t.Fatalf("DatabaseRotateKey: %d values encrypted again (%v)", count, err)
+	}
+
+	after := storedTokens()
+	if len(after) != 1 || !strings.HasPrefix(after[0], "enc:2:") {
+		t.Fatalf("token hash should be encrypted with the new data key, got %v", after)
+	}
+
+	if _, err := ts.credentialByToken(hashToken(rotated)); err != nil {
+		t.Fatalf("token should be found after the data key rotation: %v", err)
+	}
+
+	if _, err := newTestServer(t).DatabaseRotateKey(); !errors.Is(err, db.ErrEncryptionKey) {
+		t.Fatalf("rotating without a database key should fail with ErrEncryptionKey, got %v", err)
+	}
+}
+
+// TestDatabaseMigrateTo moves the database of a teamserver to another backend (a second
+// SQLite file, standing for PostgreSQL or MySQL), which the teamserver uses afterwards.
+func TestDatabaseMigrateTo(t *testing.T) {
+	ts, err := New("migrateto", WithInMemory(), WithDatabaseKey("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if err := ts.init(); err != nil {
+		t.Fatalf("server.init: %v", err)
+	}
+
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	target := &db.Config{Dialect: db.Sqlite, Database: filepath.Join(t.TempDir(), "target.db")}
+
+	count, err := ts.DatabaseMigrateTo(target)
+	if err != nil || count == 0 {
+		t.Fatalf("DatabaseMigrateTo = %d rows, %v", count, err)
+	}
+
+	if config := ts.DatabaseConfig(); config.Database != target.Database {
+		t.Fatalf("database config not switched to the target: %s", config.Database)
+	}
+
+	if _, err := ts.UserCreate("bob", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate after DatabaseMigrateTo: %v", err)
+	}
+
+	target.EncryptionKey = "correct horse battery staple"
+
+	moved, err := db.NewClient(target, ts.NamedLogger("database", "test"))
+	if err != nil {
+		t.Fatalf("open target database: %v", err)
+	}
+
+	var users []db.User
+	if err := moved.Find(&users).Error; err != nil || len(users) != 2 {
+		t.Fatalf("target database has %d users (%v), want 2", len(users), err)
+	}
+
+	if _, err := ts.DatabaseMigrateTo(target); !errors.Is(err, db.ErrCopy) {
+		t.Fatalf("DatabaseMigrateTo(current database) = %v, want ErrCopy", err)
+	}
+
+	events, err := ts.AuditEvents(AuditFilter{Action: AuditDatabaseMigrateTo})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("AuditEvents(migrate_to) = %d events, %v", len(events), err)
+	}
+}
+
+type todoItem struct {
+	ID   uint
+	Text string `gorm:"serializer:encrypted"`
+}
+
+// TestDatabaseModels checks that the models of the application share the teamserver
+// database, with prefixed tables, and are copied when the database is moved.
+func TestDatabaseModels(t *testing.T) {
+	ts, err := New("models", WithInMemory(), WithModels(&todoItem{}), WithTablePrefix("app_"),
+		WithDatabaseKey("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if err := ts.init(); err != nil {
+		t.Fatalf("server.init: %v", err)
+	}
+
+	if err := ts.Database().Create(&todoItem{Text: "todo"}).Error; err != nil {
+		t.Fatalf("Create(item): %v", err)
+	}
+
+	for _, table := range []string{"app_todo_items", "app_users"} {
+		if !ts.Database().Migrator().HasTable(table) {
+			t.Fatalf("table %s not created", table)
+		}
+	}
+
+	target := &db.Config{Dialect: db.Sqlite, Database: filepath.Join(t.TempDir(), "target.db")}
+
+	if _, err := ts.DatabaseMigrateTo(target); err != nil {
+		t.Fatalf("DatabaseMigrateTo: %v", err)
+	}
+
+	var items []todoItem
+	if err := ts.Database().Find(&items).Error; err != nil || len(items) != 1 || items[0].Text != "todo" {
+		t.Fatalf("items after DatabaseMigrateTo = %v (%v)", items, err)
+	}
+
+	if !ts.Database().Migrator().HasTable("app_todo_items") {
+		t.Fatal("table prefix not kept by DatabaseMigrateTo")
+	}
+}
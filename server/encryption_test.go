@@ -22,10 +22,16 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+
+	"github.com/reeflective/team/internal/db"
 )
 
 // TestDatabaseEncryptionAtRest exercises the public WithDatabaseKey option end
@@ -83,3 +89,191 @@ func TestDatabaseEncryptionAtRest(t *testing.T) {
 		t.Fatal("database is not encrypted: user name found in cleartext on disk")
 	}
 }
+
+// TestDatabaseRekey encrypts the database file of a running teamserver, changes its key
+// and decrypts it, checking that the teamserver keeps using it, and that it opens with the
+// new key only.
+func TestDatabaseRekey(t *testing.T) {
+	home := t.TempDir()
+	discard := slog.NewTextHandler(io.Discard, nil)
+
+	ts, err := New("rekey", WithHomeDirectory(home), WithLogger(discard))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	if err := ts.init(); err != nil {
+		t.Fatalf("server.init: %v", err)
+	}
+
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if err := ts.DatabaseDecrypt(); !errors.Is(err, db.ErrRekey) {
+		t.Fatalf("DatabaseDecrypt(cleartext) = %v, want ErrRekey", err)
+	}
+	if err := ts.DatabaseRekey("key"); !errors.Is(err, db.ErrRekey) {
+		t.Fatalf("DatabaseRekey(cleartext) = %v, want ErrRekey", err)
+	}
+
+	if err := ts.DatabaseEncrypt("first key"); err != nil {
+		t.Fatalf("DatabaseEncrypt: %v", err)
+	}
+	if err := ts.DatabaseEncrypt("first key"); !errors.Is(err, db.ErrRekey) {
+		t.Fatalf("DatabaseEncrypt(encrypted) = %v, want ErrRekey", err)
+	}
+
+	raw, err := os.ReadFile(ts.opts.dbConfig.Database)
+	if err != nil {
+		t.Fatalf("read database file: %v", err)
+	}
+	if bytes.HasPrefix(raw, []byte("SQLite format 3")) || bytes.Contains(raw, []byte("alice")) {
+		t.Fatal("database is not encrypted")
+	}
+
+	if _, err := ts.UserCreate("bob", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate after DatabaseEncrypt: %v", err)
+	}
+
+	if err := ts.DatabaseRekey("second key"); err != nil {
+		t.Fatalf("DatabaseRekey: %v", err)
+	}
+
+	for key, opens := range map[string]bool{"first key": false, "second key": true} {
+		other, err := New("rekey", WithHomeDirectory(home), WithLogger(discard), WithDatabaseKey(key))
+		if err != nil {
+			t.Fatalf("server.New: %v", err)
+		}
+
+		users, err := other.Users()
+		if opens && (err != nil || len(users) != 2) {
+			t.Fatalf("Users(%q) = %d users, %v", key, len(users), err)
+		} else if !opens && err == nil {
+			t.Fatalf("database opened with the previous key %q", key)
+		}
+	}
+
+	if err := ts.DatabaseDecrypt(); err != nil {
+		t.Fatalf("DatabaseDecrypt: %v", err)
+	}
+
+	raw, err = os.ReadFile(ts.opts.dbConfig.Database)
+	if err != nil {
+		t.Fatalf("read database file: %v", err)
+	}
+	if !bytes.HasPrefix(raw, []byte("SQLite format 3")) {
+		t.Fatal("database is still encrypted")
+	}
+
+	events, err := ts.AuditEvents(AuditFilter{Action: AuditDatabaseRekey})
+	if err != nil || len(events) != 3 {
+		t.Fatalf("AuditEvents(rekey) = %d events, %v", len(events), err)
+	}
+}
+
+// TestDatabaseRekeyDaemon checks that the database file of a teamserver daemon running
+// in another process is not replaced, but that stale daemon PID files are ignored.
+func TestDatabaseRekeyDaemon(t *testing.T) {
+	home := t.TempDir()
+
+	ts, err := New("rekey", WithHomeDirectory(home), WithLogger(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	if err := ts.init(); err != nil {
+		t.Fatalf("server.init: %v", err)
+	}
+
+	pidFile := filepath.Join(ts.TeamDir(), daemonPIDFile)
+
+	// The parent process of the test stands for a running daemon.
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getppid())), 0o600); err != nil {
+		t.Fatalf("write PID file: %v", err)
+	}
+
+	if err := ts.DatabaseEncrypt("key"); !errors.Is(err, ErrDaemonRunning) || !errors.Is(err, db.ErrRekey) {
+		t.Fatalf("DatabaseEncrypt(daemon running) = %v, want ErrDaemonRunning", err)
+	}
+
+	// A daemon which did not exit cleanly.
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(math.MaxInt32)), 0o600); err != nil {
+		t.Fatalf("write PID file: %v", err)
+	}
+
+	if err := ts.DatabaseEncrypt("key"); err != nil {
+		t.Fatalf("DatabaseEncrypt(stale PID file) = %v", err)
+	}
+
+	if err := ts.writeDaemonPID(); err != nil {
+		t.Fatalf("writeDaemonPID: %v", err)
+	}
+
+	if err := ts.DatabaseRekey("new key"); err != nil {
+		t.Fatalf("DatabaseRekey(from the daemon) = %v", err)
+	}
+
+	ts.removeDaemonPID()
+
+	if _, err := os.Stat(pidFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("daemon PID file should be removed, got %v", err)
+	}
+}
+
+// TestDatabaseKeyReference opens an encrypted database with the key reference of its
+// configuration file, and checks that decrypting it removes the reference.
+func TestDatabaseKeyReference(t *testing.T) {
+	const key = "referenced key"
+
+	home := t.TempDir()
+	discard := slog.NewTextHandler(io.Discard, nil)
+
+	ts, err := New("keyref", WithHomeDirectory(home), WithLogger(discard), WithDatabaseKey(key))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	ts.opts.dbConfig.EncryptionKeyRef = "env:TEAM_TEST_DB_KEY"
+	if err := ts.saveDatabaseConfig(ts.opts.dbConfig); err != nil {
+		t.Fatalf("saveDatabaseConfig: %v", err)
+	}
+
+	data, err := os.ReadFile(ts.dbConfigPath())
+	if err != nil {
+		t.Fatalf("read database config: %v", err)
+	}
+	if bytes.Contains(data, []byte(key)) {
+		t.Fatal("database key written to the config file")
+	}
+
+	if sqlDB, err := ts.db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	unset, err := New("keyref", WithHomeDirectory(home), WithLogger(discard))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	if _, err := unset.Users(); !errors.Is(err, ErrSecretReference) {
+		t.Fatalf("Users(unset reference) = %v, want ErrSecretReference", err)
+	}
+
+	t.Setenv("TEAM_TEST_DB_KEY", key)
+
+	other, err := New("keyref", WithHomeDirectory(home), WithLogger(discard))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	if users, err := other.Users(); err != nil || len(users) != 1 {
+		t.Fatalf("Users(referenced key) = %d users, %v", len(users), err)
+	}
+
+	if err := other.DatabaseDecrypt(); err != nil {
+		t.Fatalf("DatabaseDecrypt: %v", err)
+	}
+	if ref := other.DatabaseConfig().EncryptionKeyRef; ref != "" {
+		t.Fatalf("key reference %q kept after decryption", ref)
+	}
+}
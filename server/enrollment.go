@@ -0,0 +1,211 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/reeflective/team/client"
+	"github.com/reeflective/team/internal/certs"
+	"github.com/reeflective/team/internal/db"
+)
+
+const (
+	// DefaultInvitationValidity is the validity of invitation codes, when none is given.
+	DefaultInvitationValidity = time.Hour
+
+	// invitationFingerprintLen is the length of the users CA fingerprint in invitation codes.
+	invitationFingerprintLen = 32
+)
+
+// Invitation is a single-use, short-lived code with which a teamclient can enroll for a user
+// credential. Unlike with server.UserCreate(), the teamclient generates its own private key,
+// which thus never leaves it: it only sends a certificate request (see server.UserEnroll()).
+//
+// The code also contains the fingerprint of the users CA, with which enrolling teamclients
+// authenticate the teamserver, since they do not have its CA certificate yet.
+type Invitation struct {
+	Code       string    // Code to hand over to the user.
+	User       string    // Name of the user to enroll.
+	Credential string    // Label of the credential to issue.
+	ExpiresAt  time.Time // Time after which the code cannot be used.
+}
+
+// UserInvite creates an invitation code for a user to enroll a teamclient with, valid once
+// and for the given duration (or server.DefaultInvitationValidity, if zero). The user does
+// not need to exist yet, and is created when enrolling.
+//
+// The label is the one of the credential to issue: if empty, the default credential is
+// issued, replacing the current one of the user, if any. Otherwise, it must not exist.
+func (ts *Server) UserInvite(name, label string, validFor time.Duration) (*Invitation, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	if name == "" || !namePattern.MatchString(name) {
+		return nil, ts.errorf("%w: invalid user name (alphanumerics only)", ErrUserConfig)
+	}
+
+	if label == "" {
+		label = DefaultCredential
+	}
+
+	if !namePattern.MatchString(label) {
+		return nil, ts.errorf("%w: invalid credential label '%s' (alphanumerics only)", ErrUserConfig, label)
+	}
+
+	if _, err := ts.credentialByLabel(name, label); err == nil && label != DefaultCredential {
+		return nil, ts.errorf("%w: user %s already has a credential '%s'", ErrUserConfig, name, label)
+	}
+
+	if validFor <= 0 {
+		validFor = DefaultInvitationValidity
+	}
+
+	caCert, _, err := ts.certs.GetUsersCA()
+	if err != nil {
+		return nil, ts.errorf("%w: failed to get users certificate authority: %w", ErrCertificate, err)
+	}
+
+	secret, err := ts.newUserToken()
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrUserConfig, err)
+	}
+
+	invitation := &Invitation{
+		Code:       fmt.Sprintf("%s.%s", secret, certs.Fingerprint(caCert.Raw)[:invitationFingerprintLen]),
+		User:       name,
+		Credential: label,
+		ExpiresAt:  time.Now().Add(validFor),
+	}
+
+	// Forget about expired invitations, which cannot be used anyway.
+	ts.Database().Where("expires_at < ?", time.Now()).Delete(&db.Invitation{})
+
+	err = ts.Database().Create(&db.Invitation{
+		UserName:  name,
+		Label:     label,
+		Code:      hashToken(invitation.Code),
+		ExpiresAt: invitation.ExpiresAt,
+	}).Error
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	ts.NamedLogger("server", "enroll").Info(fmt.Sprintf("Invited user %s to enroll credential '%s' (expires %s)",
+		name, label, invitation.ExpiresAt.Format(time.RFC1123)))
+
+	ts.audit(AuditUserInvite, name, "credential "+label)
+
+	return invitation, nil
+}
+
+// UserEnroll consumes an invitation code, and signs the PEM-encoded certificate request
+// of the teamclient with the users CA. It returns a client configuration with the signed
+// certificate, the users CA and an API token, but without private key, host and port:
+// the teamclient completes it itself.
+//
+// Handlers serve this call to clients that have no client certificate yet: the Mutual
+// TLS configuration returned by server.UsersTLSConfig() does not require one when the
+// client asks for the certs.EnrollmentServerName (see client.NewEnrollmentTLSConfig()).
+func (ts *Server) UserEnroll(code string, csrPEM []byte) (*client.Config, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	invitation := &db.Invitation{}
+
+	err := ts.Database().Where(&db.Invitation{Code: hashToken(code)}).
+		Where("expires_at > ?", time.Now()).
+		First(invitation).Error
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrUnauthenticated, ErrInvalidInvitation)
+	}
+
+	name, label := invitation.UserName, invitation.Label
+
+	if _, err = ts.credentialByLabel(name, label); err == nil && label != DefaultCredential {
+		return nil, ts.errorf("%w: user %s already has a credential '%s'", ErrUserConfig, name, label)
+	}
+
+	// The request is signed before anything changes, and the invitation is consumed in the
+	// same transaction as the credential is saved (superseding the default one, if any):
+	// an invalid request neither burns the invitation nor locks the user out.
+	var (
+		rawToken  string
+		enrollErr error
+	)
+
+	certPEM, err := ts.certs.UserClientEnrollCredentialCSR(name, credentialCertLabel(label), csrPEM,
+		func(tx *gorm.DB, certPEM []byte) error {
+			rawToken, enrollErr = ts.consumeInvitation(tx, invitation, certPEM)
+			return enrollErr
+		})
+	if enrollErr != nil {
+		return nil, enrollErr
+	} else if err != nil {
+		return nil, ts.errorf("%w: failed to sign certificate request: %w", ErrCertificate, err)
+	}
+
+	// The sessions of a superseded credential are closed, and its tokens refused.
+	ts.authCache.invalidateCredential(name, label)
+	ts.kickCredentialSessions(name, label)
+
+	ts.NamedLogger("server", "enroll").Info(fmt.Sprintf("Enrolled credential '%s' of user %s", label, name))
+	ts.auditAs(name, AuditOriginRPC, AuditUserEnroll, name, "credential "+label)
+
+	caChainPEM, certChainPEM := ts.usersCertificatesPEM(certPEM)
+
+	return &client.Config{
+		User:          name,
+		Token:         rawToken,
+		CACertificate: caChainPEM,
+		Certificate:   certChainPEM,
+	}, nil
+}
+
+// consumeInvitation deletes an invitation and saves the credential enrolled with it (replacing
+// the current one with the same label, if any) in a transaction. Returns the raw API token.
+func (ts *Server) consumeInvitation(tx *gorm.DB, invitation *db.Invitation, certPEM []byte) (string, error) {
+	name, label := invitation.UserName, invitation.Label
+
+	// Only the first of concurrent enrollments can delete the invitation.
+	result := tx.Delete(invitation)
+	if result.Error != nil {
+		return "", ts.errorf("%w: %w", ErrDatabase, result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return "", ts.errorf("%w: %w", ErrUnauthenticated, ErrInvalidInvitation)
+	}
+
+	if err := tx.Where(&db.User{Name: name}).FirstOrCreate(&db.User{Name: name}).Error; err != nil {
+		return "", ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	err := tx.Where(&db.Credential{UserName: name, Label: label}).Delete(&db.Credential{}).Error
+	if err != nil {
+		return "", ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	return ts.createCredential(tx, name, label, certPEM)
+}
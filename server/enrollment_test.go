@@ -0,0 +1,138 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/reeflective/team/internal/certs"
+	"github.com/reeflective/team/internal/db"
+)
+
+// TestUserEnroll checks that an invitation code can be exchanged once, before
+// it expires, for a certificate signed over the key of the enrolling client.
+func TestUserEnroll(t *testing.T) {
+	ts := newTestServer(t)
+
+	invitation, err := ts.UserInvite("alice", "laptop", time.Minute)
+	if err != nil {
+		t.Fatalf("UserInvite: %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	config, err := ts.UserEnroll(invitation.Code, csrPEM)
+	if err != nil {
+		t.Fatalf("UserEnroll: %v", err)
+	}
+
+	cert, err := certs.ParseCertificatePEM([]byte(config.Certificate))
+	if err != nil {
+		t.Fatalf("enrolled certificate: %v", err)
+	}
+
+	if !key.PublicKey.Equal(cert.PublicKey) || cert.Subject.CommonName != "alice" {
+		t.Fatalf("certificate not issued for the enrolling key of alice: %s", cert.Subject.CommonName)
+	}
+
+	if _, err := ts.AuthenticatePeer(config.Token, cert); err != nil {
+		t.Fatalf("enrolled credential refused: %v", err)
+	}
+
+	if cred, err := ts.TokenCredential(config.Token); err != nil || cred.Label != "laptop" {
+		t.Fatalf("enrolled token should belong to credential 'laptop', got %+v (%v)", cred, err)
+	}
+
+	if _, err := ts.UserEnroll(invitation.Code, csrPEM); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("invitation code should only be usable once, got %v", err)
+	}
+
+	if _, err := ts.UserInvite("alice", "laptop", time.Minute); !errors.Is(err, ErrUserConfig) {
+		t.Fatalf("invitation for an existing credential should be refused, got %v", err)
+	}
+
+	expired, err := ts.UserInvite("bob", "", time.Minute)
+	if err != nil {
+		t.Fatalf("UserInvite: %v", err)
+	}
+
+	ts.Database().Model(&db.Invitation{}).Where("user_name = ?", "bob").Update("expires_at", time.Now().Add(-time.Second))
+
+	if _, err := ts.UserEnroll(expired.Code, csrPEM); !errors.Is(err, ErrInvalidInvitation) {
+		t.Fatalf("expired invitation code should be refused, got %v", err)
+	}
+}
+
+// TestUserEnrollInvalidRequest checks that an invalid certificate request neither consumes
+// the invitation nor revokes the default credential it would supersede.
+func TestUserEnrollInvalidRequest(t *testing.T) {
+	ts := newTestServer(t)
+
+	current, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	invitation, err := ts.UserInvite("alice", "", time.Minute)
+	if err != nil {
+		t.Fatalf("UserInvite: %v", err)
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, rsaKey)
+
+	for _, csrPEM := range [][]byte{
+		[]byte("not a certificate request"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: rsaDER}),
+	} {
+		if _, err := ts.UserEnroll(invitation.Code, csrPEM); !errors.Is(err, ErrCertificate) {
+			t.Fatalf("invalid certificate request should fail with ErrCertificate, got %v", err)
+		}
+	}
+
+	if _, err := ts.TokenCredential(current.Token); err != nil {
+		t.Fatalf("current credential should be kept after a failed enrollment: %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+
+	config, err := ts.UserEnroll(invitation.Code, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+	if err != nil {
+		t.Fatalf("invitation should still be usable after a failed enrollment: %v", err)
+	}
+
+	if _, err := ts.TokenCredential(current.Token); err == nil {
+		t.Fatal("superseded credential should be refused")
+	}
+
+	if _, err := ts.TokenCredential(config.Token); err != nil {
+		t.Fatalf("enrolled credential refused: %v", err)
+	}
+}
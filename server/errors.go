@@ -18,7 +18,11 @@ package server
    along with this program.  If not, see <https://www.gnu.org/licenses/>.
 */
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/reeflective/team/internal/certs"
+)
 
 var (
 	//
@@ -56,9 +60,37 @@ var (
 	// ErrTeamServer is an error raised by the teamserver core code.
 	ErrTeamServer = errors.New("teamserver")
 
+	// ErrDaemonRunning indicates that an operation needing exclusive access to the
+	// teamserver files was refused, because a teamserver daemon is using them.
+	ErrDaemonRunning = errors.New("a teamserver daemon is running")
+
 	// ErrCertificate is an error related to the certificate infrastructure.
 	ErrCertificate = errors.New("certificates")
 
+	// ErrCALocked indicates that the private key of the users CA is encrypted, and that the
+	// teamserver was not given its passphrase: it cannot issue certificates until unlocked.
+	ErrCALocked = certs.ErrCAKeyLocked
+
+	// ErrCAPassphrase indicates an incorrect (or missing) passphrase for an encrypted CA private key.
+	ErrCAPassphrase = certs.ErrPassphrase
+
+	// ErrExportPassphrase indicates that the users CA private key cannot be exported without
+	// a passphrase to encrypt it with: neither given, nor set as the CA passphrase.
+	ErrExportPassphrase = certs.ErrExportPassphrase
+
+	// ErrSecretReference indicates that a secret reference of the configurations (eg. "env:NAME")
+	// cannot be resolved, or that a cleartext value was given where only a reference is accepted.
+	ErrSecretReference = errors.New("secret reference")
+
+	// ErrSecretStore is an error related to the store of the private keys of the teamserver.
+	ErrSecretStore = errors.New("secret store")
+
+	// ErrBackup is an error related to the backup archives of the teamserver state.
+	ErrBackup = errors.New("backup")
+
+	// ErrCertificateRevoked indicates that a peer presented a certificate revoked by the teamserver.
+	ErrCertificateRevoked = errors.New("certificate revoked")
+
 	// ErrUserConfig is an error related to users (teamclients) configuration files.
 	ErrUserConfig = errors.New("user configuration")
 
@@ -66,6 +98,28 @@ var (
 	// whether at connection time, or when requesting server-side features/info.
 	ErrUnauthenticated = errors.New("User authentication failure")
 
+	// ErrTokenExpired indicates that a user API token has expired (or that the grace
+	// period of a rotated token is over), and that a new one must be obtained.
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrBanned indicates that a remote address or credential is temporarily banned
+	// by the teamserver, after too many failed authentication attempts.
+	ErrBanned = errors.New("banned after too many authentication failures")
+
+	// ErrAuthBackoff indicates that an authentication attempt was made too soon after
+	// a failed one, from the same remote address or with the same credential.
+	ErrAuthBackoff = errors.New("too many authentication failures, retry later")
+
+	// ErrBanNotFound indicates that no ban exists for a given address or credential.
+	ErrBanNotFound = errors.New("no ban found")
+
+	// ErrInvalidInvitation indicates that an enrollment invitation code is unknown,
+	// expired or has already been used.
+	ErrInvalidInvitation = errors.New("invalid invitation code")
+
+	// ErrSessionNotFound indicates that no live teamclient session exists with a given ID.
+	ErrSessionNotFound = errors.New("no session exists with ID")
+
 	//
 	// Listener errors.
 	//
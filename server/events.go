@@ -0,0 +1,102 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"sync"
+	"time"
+)
+
+// eventBufferSize is the number of events buffered for each subscriber:
+// events published while the buffer of a subscriber is full are dropped.
+const eventBufferSize = 64
+
+// Types of events published by the teamserver.
+const (
+	EventBanned   = "auth.banned"   // A remote address or credential has been banned.
+	EventUnbanned = "auth.unbanned" // A ban has been lifted (or has expired).
+
+	EventCertExpiring = "cert.expiring" // A certificate expires within one of the configured warnings.
+	EventCertExpired  = "cert.expired"  // A certificate has expired.
+	EventCertRenewed  = "cert.renewed"  // The server certificate has been renewed before its expiry.
+)
+
+// Event is a notable teamserver event, published to subscribers (see Server.Subscribe).
+type Event struct {
+	Type    string    // Type of the event (eg. server.EventBanned).
+	Time    time.Time // Time at which the event occurred.
+	Subject string    // What the event is about (eg. a banned remote address).
+	Message string    // Human-readable description of the event.
+}
+
+// events dispatches teamserver events to their subscribers.
+type events struct {
+	subscribers map[int]chan Event
+	next        int
+	mutex       sync.Mutex
+}
+
+func newEvents() *events {
+	return &events{
+		subscribers: make(map[int]chan Event),
+	}
+}
+
+// Subscribe returns a channel on which all subsequent teamserver events are sent,
+// and a function to call for unsubscribing, which closes the channel. Subscribers
+// should consume events promptly: those that do not fit in their buffer are dropped.
+func (ts *Server) Subscribe() (<-chan Event, func()) {
+	ts.events.mutex.Lock()
+	defer ts.events.mutex.Unlock()
+
+	id := ts.events.next
+	ts.events.next++
+
+	events := make(chan Event, eventBufferSize)
+	ts.events.subscribers[id] = events
+
+	unsubscribe := func() {
+		ts.events.mutex.Lock()
+		defer ts.events.mutex.Unlock()
+
+		if _, found := ts.events.subscribers[id]; found {
+			delete(ts.events.subscribers, id)
+			close(events)
+		}
+	}
+
+	return events, unsubscribe
+}
+
+// publish sends an event to all subscribers, without blocking.
+func (ts *Server) publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	ts.events.mutex.Lock()
+	defer ts.events.mutex.Unlock()
+
+	for _, subscriber := range ts.events.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
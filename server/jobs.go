@@ -32,6 +32,7 @@ type job struct {
 	Description string
 	kill        chan bool
 	Persistent  bool
+	host        string // Host the listener is bound to, if any.
 }
 
 // jobs - Holds refs to all active jobs.
@@ -83,6 +84,11 @@ func (ts *Server) Listeners() []*job {
 // This function does not start the given listener, and you must call the server
 // ServeAddr(name, host, port) function for this.
 func (ts *Server) ListenerAdd(name, host string, port uint16) error {
+	id, err := getRandomID()
+	if err != nil {
+		return ts.errorf("%w: %w", ErrListener, err)
+	}
+
 	listener := struct {
 		Name string `json:"name"`
 		Host string `json:"host"`
@@ -92,7 +98,7 @@ func (ts *Server) ListenerAdd(name, host string, port uint16) error {
 		Name: name,
 		Host: host,
 		Port: port,
-		ID:   getRandomID(),
+		ID:   id,
 	}
 
 	if listener.Name == "" && ts.self != nil {
@@ -101,7 +107,13 @@ func (ts *Server) ListenerAdd(name, host string, port uint16) error {
 
 	ts.opts.config.Listeners = append(ts.opts.config.Listeners, listener)
 
-	return ts.SaveConfig(ts.opts.config)
+	if err := ts.SaveConfig(ts.opts.config); err != nil {
+		return err
+	}
+
+	ts.audit(AuditListenerAdd, listener.ID, fmt.Sprintf("%s %s:%d", listener.Name, host, port))
+
+	return nil
 }
 
 // ListenerRemove removes a server listener job from the configuration.
@@ -128,6 +140,8 @@ func (ts *Server) ListenerRemove(listenerID string) {
 	}
 
 	ts.opts.config.Listeners = listeners
+
+	ts.audit(AuditListenerRemove, listenerID, "")
 }
 
 // ListenerClose closes/stops an active teamserver listener by ID.
@@ -141,6 +155,8 @@ func (ts *Server) ListenerClose(id string) error {
 
 	listener.kill <- true
 
+	ts.audit(AuditListenerStop, id, "")
+
 	return nil
 }
 
@@ -193,10 +209,6 @@ func (ts *Server) ListenerStartPersistents() error {
 func (ts *Server) addListenerJob(listenerID, name, host string, port int, ln net.Listener) {
 	log := ts.NamedLogger("teamserver", "listeners")
 
-	if listenerID == "" {
-		listenerID = getRandomID()
-	}
-
 	laddr := host
 	if port != 0 {
 		laddr = fmt.Sprintf("%s:%d", laddr, port)
@@ -211,6 +223,7 @@ func (ts *Server) addListenerJob(listenerID, name, host string, port int, ln net
 		Name:        name,
 		Description: laddr,
 		kill:        make(chan bool),
+		host:        host,
 	}
 
 	go func() {
@@ -221,6 +234,9 @@ func (ts *Server) addListenerJob(listenerID, name, host string, port int, ln net
 		ln.Close()
 
 		ts.jobs.active.LoadAndDelete(listener.ID)
+
+		// Certificates need not be checked anymore once the last listener is closed.
+		ts.stopCertMonitor()
 	}()
 
 	ts.jobs.active.Store(listener.ID, listener)
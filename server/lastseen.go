@@ -0,0 +1,151 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// lastSeen coalesces the LastSeen (users) and LastUsed (credentials) updates
+// of authenticated requests, which are written to the database in batches by
+// a background flusher instead of once per request.
+type lastSeen struct {
+	pending  map[lastSeenKey]time.Time
+	flushing bool // The background flusher is running.
+	mutex    sync.Mutex
+	write    sync.Mutex // Serializes batches, so that older ones never win.
+}
+
+// lastSeenKey identifies a user credential with pending updates.
+type lastSeenKey struct {
+	user       string
+	credential string
+}
+
+func newLastSeen() *lastSeen {
+	return &lastSeen{
+		pending: make(map[lastSeenKey]time.Time),
+	}
+}
+
+// recordLastSeen queues an update of the last time a user credential was used (or only
+// the user, if authenticated without teamserver credential), and starts the background
+// flusher if it is not running. The flusher exits as soon as it has nothing left to
+// write, so idle teamservers have none running.
+func (ts *Server) recordLastSeen(user, credential string) {
+	key := lastSeenKey{user: user, credential: credential}
+
+	ts.lastSeen.mutex.Lock()
+	defer ts.lastSeen.mutex.Unlock()
+
+	ts.lastSeen.pending[key] = time.Now().Round(1 * time.Second)
+
+	if !ts.lastSeen.flushing {
+		ts.lastSeen.flushing = true
+		go ts.lastSeenFlusher()
+	}
+}
+
+// lastSeenFlusher periodically writes pending updates, until there are none.
+func (ts *Server) lastSeenFlusher() {
+	_, interval := ts.authCacheSettings()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if written := ts.flushLastSeen(true); written == 0 {
+			return
+		}
+	}
+}
+
+// flushLastSeen writes all pending updates in a single transaction, and returns how many
+// credentials were updated (or kept for the next batch, if the transaction failed). When
+// called from the flusher and there is nothing to write, the flusher is marked as stopped
+// (under the same lock) before returning.
+func (ts *Server) flushLastSeen(flusher bool) int {
+	ts.lastSeen.write.Lock()
+	defer ts.lastSeen.write.Unlock()
+
+	ts.lastSeen.mutex.Lock()
+	pending := ts.lastSeen.pending
+	ts.lastSeen.pending = make(map[lastSeenKey]time.Time)
+
+	if len(pending) == 0 && flusher {
+		ts.lastSeen.flushing = false
+	}
+	ts.lastSeen.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return 0
+	}
+
+	// Users with several credentials in use are updated once.
+	users := make(map[string]time.Time)
+
+	for key, seen := range pending {
+		if seen.After(users[key.user]) {
+			users[key.user] = seen
+		}
+	}
+
+	err := ts.Database().Transaction(func(tx *gorm.DB) error {
+		for name, seen := range users {
+			if err := tx.Model(&db.User{}).Where("name", name).Update("LastSeen", seen).Error; err != nil {
+				return err
+			}
+		}
+
+		for key, seen := range pending {
+			if key.credential == "" {
+				continue
+			}
+
+			err := tx.Model(&db.Credential{}).
+				Where(&db.Credential{UserName: key.user, Label: key.credential}).
+				Update("LastUsed", seen).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		ts.NamedLogger("server", "auth").Warn(fmt.Sprintf("Failed to update users last seen times: %s", err))
+
+		// Keep the updates for the next batch, unless more recent ones were queued since.
+		ts.lastSeen.mutex.Lock()
+		for key, seen := range pending {
+			if queued, found := ts.lastSeen.pending[key]; !found || queued.Before(seen) {
+				ts.lastSeen.pending[key] = seen
+			}
+		}
+		ts.lastSeen.mutex.Unlock()
+	}
+
+	return len(pending)
+}
@@ -0,0 +1,277 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/reeflective/team"
+	"github.com/reeflective/team/internal/certs"
+)
+
+// lockoutPruneSize is the number of tracked subjects above which stale records are pruned.
+const lockoutPruneSize = 4096
+
+// Kinds of subjects tracked for failed authentications, and banned.
+const (
+	BanAddress    = "address"    // A remote client address (IP).
+	BanCredential = "credential" // A client certificate, as "<user>/<serial>".
+)
+
+// Ban is a remote address or credential temporarily refused by the teamserver,
+// after too many failed authentication attempts. See the Lockout section of the
+// teamserver Config for the thresholds, and Server.Unban() to lift a ban.
+type Ban struct {
+	Subject   string    // Banned remote address, or credential ("<user>/<certificate serial>").
+	Kind      string    // Kind of subject (server.BanAddress or server.BanCredential).
+	Failures  int       // Number of failed attempts which caused the ban.
+	BannedAt  time.Time // Time at which the subject was banned.
+	ExpiresAt time.Time // Time at which the ban is lifted.
+}
+
+// lockoutSettings are the brute-force protection settings of the teamserver Config.
+type lockoutSettings struct {
+	maxFailures int
+	window      time.Duration
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	banDuration time.Duration
+}
+
+// lockout tracks failed authentications and bans, by subject.
+type lockout struct {
+	entries map[string]*lockoutEntry
+	mutex   sync.Mutex
+}
+
+// lockoutEntry is the failure record of a subject, banned or not.
+type lockoutEntry struct {
+	kind        string
+	failures    int
+	lastFailure time.Time
+	bannedAt    time.Time
+	bannedUntil time.Time
+}
+
+func newLockout() *lockout {
+	return &lockout{
+		entries: make(map[string]*lockoutEntry),
+	}
+}
+
+// Bans returns the remote addresses and credentials currently banned, oldest first.
+func (ts *Server) Bans() []Ban {
+	ts.lockout.mutex.Lock()
+	defer ts.lockout.mutex.Unlock()
+
+	now := time.Now()
+	bans := []Ban{}
+
+	for subject, entry := range ts.lockout.entries {
+		if entry.bannedUntil.IsZero() {
+			continue
+		}
+
+		if now.After(entry.bannedUntil) {
+			ts.lockoutExpire(subject)
+			continue
+		}
+
+		bans = append(bans, Ban{
+			Subject:   subject,
+			Kind:      entry.kind,
+			Failures:  entry.failures,
+			BannedAt:  entry.bannedAt,
+			ExpiresAt: entry.bannedUntil,
+		})
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].BannedAt.Before(bans[j].BannedAt)
+	})
+
+	return bans
+}
+
+// Unban lifts the ban of a remote address or credential (as listed by Server.Bans()),
+// and forgets its failed authentication attempts. Returns an ErrBanNotFound if the
+// subject is not banned.
+func (ts *Server) Unban(subject string) error {
+	ts.lockout.mutex.Lock()
+
+	entry, found := ts.lockout.entries[subject]
+	if !found || entry.bannedUntil.IsZero() || time.Now().After(entry.bannedUntil) {
+		ts.lockout.mutex.Unlock()
+		return ts.errorf("%w: %s", ErrBanNotFound, subject)
+	}
+
+	delete(ts.lockout.entries, subject)
+
+	message := fmt.Sprintf("Unbanned %s %s", entry.kind, subject)
+	ts.NamedLogger("server", "lockout").Warn(message)
+	ts.publish(Event{Type: EventUnbanned, Subject: subject, Message: message})
+
+	ts.lockout.mutex.Unlock()
+
+	ts.audit(AuditUnban, subject, entry.kind)
+
+	return nil
+}
+
+// lockoutSubjects returns the subjects whose failed authentications are tracked
+// for the given credentials: their remote address, and client certificate.
+func lockoutSubjects(creds team.Credentials) map[string]string {
+	subjects := make(map[string]string)
+
+	if creds.RemoteAddr != "" {
+		host, _, err := net.SplitHostPort(creds.RemoteAddr)
+		if err != nil {
+			host = creds.RemoteAddr
+		}
+
+		subjects[host] = BanAddress
+	}
+
+	if creds.Certificate != nil {
+		subject := creds.Certificate.Subject.CommonName + "/" + certs.FormatSerial(creds.Certificate.SerialNumber)
+		subjects[subject] = BanCredential
+	}
+
+	return subjects
+}
+
+// lockoutCheck refuses authentication attempts of banned subjects, or made during the backoff
+// delay following a failed one. Records whose window or ban has elapsed are forgotten.
+func (ts *Server) lockoutCheck(subjects map[string]string, settings lockoutSettings) error {
+	ts.lockout.mutex.Lock()
+	defer ts.lockout.mutex.Unlock()
+
+	now := time.Now()
+
+	for subject := range subjects {
+		entry, found := ts.lockout.entries[subject]
+		if !found {
+			continue
+		}
+
+		switch {
+		case !entry.bannedUntil.IsZero() && now.After(entry.bannedUntil):
+			ts.lockoutExpire(subject)
+
+		case !entry.bannedUntil.IsZero():
+			return fmt.Errorf("%w: %w: %s %s (until %s)", ErrUnauthenticated, ErrBanned,
+				entry.kind, subject, entry.bannedUntil.Format(time.RFC1123))
+
+		case now.Sub(entry.lastFailure) > settings.window:
+			delete(ts.lockout.entries, subject)
+
+		case now.Before(entry.lastFailure.Add(settings.backoffAfter(entry.failures))):
+			return fmt.Errorf("%w: %w: %s %s", ErrUnauthenticated, ErrAuthBackoff, entry.kind, subject)
+		}
+	}
+
+	return nil
+}
+
+// lockoutFailure records a failed authentication for the subjects, and bans those
+// having reached the maximum number of failures.
+func (ts *Server) lockoutFailure(subjects map[string]string, settings lockoutSettings) {
+	ts.lockout.mutex.Lock()
+	defer ts.lockout.mutex.Unlock()
+
+	now := time.Now()
+
+	if len(ts.lockout.entries) >= lockoutPruneSize {
+		for subject, entry := range ts.lockout.entries {
+			if entry.bannedUntil.IsZero() && now.Sub(entry.lastFailure) > settings.window {
+				delete(ts.lockout.entries, subject)
+			}
+		}
+	}
+
+	for subject, kind := range subjects {
+		entry, found := ts.lockout.entries[subject]
+		if !found {
+			entry = &lockoutEntry{kind: kind}
+			ts.lockout.entries[subject] = entry
+		}
+
+		entry.failures++
+		entry.lastFailure = now
+
+		if entry.failures < settings.maxFailures || !entry.bannedUntil.IsZero() {
+			continue
+		}
+
+		entry.bannedAt = now
+		entry.bannedUntil = now.Add(settings.banDuration)
+
+		message := fmt.Sprintf("Banned %s %s until %s, after %d failed authentications",
+			kind, subject, entry.bannedUntil.Format(time.RFC1123), entry.failures)
+		ts.NamedLogger("server", "lockout").Warn(message)
+		ts.publish(Event{Type: EventBanned, Subject: subject, Message: message})
+	}
+}
+
+// lockoutSuccess forgets the failed authentications of the credential subjects, which are not banned.
+// Those of the remote address expire with the lockout window instead: it can be shared by other
+// clients (eg. behind a NAT), and a valid credential must not reset the attempts made from it.
+func (ts *Server) lockoutSuccess(subjects map[string]string) {
+	ts.lockout.mutex.Lock()
+	defer ts.lockout.mutex.Unlock()
+
+	for subject, kind := range subjects {
+		if kind != BanCredential {
+			continue
+		}
+
+		if entry, found := ts.lockout.entries[subject]; found && entry.bannedUntil.IsZero() {
+			delete(ts.lockout.entries, subject)
+		}
+	}
+}
+
+// lockoutExpire forgets a subject whose ban has elapsed. The lockout must be locked.
+func (ts *Server) lockoutExpire(subject string) {
+	entry := ts.lockout.entries[subject]
+	delete(ts.lockout.entries, subject)
+
+	message := fmt.Sprintf("Ban of %s %s expired", entry.kind, subject)
+	ts.NamedLogger("server", "lockout").Info(message)
+	ts.publish(Event{Type: EventUnbanned, Subject: subject, Message: message})
+}
+
+// backoffAfter returns the delay during which attempts are refused after a number of failures.
+func (s lockoutSettings) backoffAfter(failures int) time.Duration {
+	backoff := s.backoff
+
+	for i := 1; i < failures && backoff < s.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > s.maxBackoff {
+		backoff = s.maxBackoff
+	}
+
+	return backoff
+}
@@ -0,0 +1,160 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/reeflective/team"
+)
+
+// TestLockoutBan checks that an address is banned after too many failed authentications,
+// that other addresses are not affected, and that lifting the ban is published.
+func TestLockoutBan(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Lockout.MaxFailures = 3
+	ts.opts.config.Lockout.Backoff = "0s"
+	saveTestConfig(t, ts)
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	events, unsubscribe := ts.Subscribe()
+	defer unsubscribe()
+
+	attacker := team.Credentials{Token: "guess", RemoteAddr: "203.0.113.7:4242"}
+
+	for range 3 {
+		if _, err := ts.Authenticator().Authenticate(attacker); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("invalid token should be refused, got %v", err)
+		}
+	}
+
+	if event := <-events; event.Type != EventBanned || event.Subject != "203.0.113.7" {
+		t.Fatalf("expected a ban event for the address, got %+v", event)
+	}
+
+	attacker.Token = config.Token
+	if _, err := ts.Authenticator().Authenticate(attacker); !errors.Is(err, ErrBanned) {
+		t.Fatalf("banned address should be refused, even with a valid token, got %v", err)
+	}
+
+	legit := team.Credentials{Token: config.Token, RemoteAddr: "198.51.100.1:4242"}
+	if _, err := ts.Authenticator().Authenticate(legit); err != nil {
+		t.Fatalf("other addresses should not be banned: %v", err)
+	}
+
+	if bans := ts.Bans(); len(bans) != 1 || bans[0].Kind != BanAddress || bans[0].Failures != 3 {
+		t.Fatalf("unexpected bans: %+v", bans)
+	}
+
+	if err := ts.Unban("203.0.113.7"); err != nil {
+		t.Fatalf("Unban: %v", err)
+	}
+
+	if event := <-events; event.Type != EventUnbanned {
+		t.Fatalf("expected an unban event, got %+v", event)
+	}
+
+	if _, err := ts.Authenticator().Authenticate(attacker); err != nil {
+		t.Fatalf("unbanned address refused: %v", err)
+	}
+
+	if err := ts.Unban("203.0.113.7"); !errors.Is(err, ErrBanNotFound) {
+		t.Fatalf("unbanning twice should fail, got %v", err)
+	}
+}
+
+// TestLockoutBackoff checks that attempts made during the backoff delay are refused.
+func TestLockoutBackoff(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Lockout.Backoff = "1h"
+	saveTestConfig(t, ts)
+
+	creds := team.Credentials{Token: "guess", RemoteAddr: "203.0.113.7:4242"}
+
+	if _, err := ts.Authenticator().Authenticate(creds); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("invalid token should be refused, got %v", err)
+	}
+
+	if _, err := ts.Authenticator().Authenticate(creds); !errors.Is(err, ErrAuthBackoff) {
+		t.Fatalf("attempt during backoff should be throttled, got %v", err)
+	}
+
+	if len(ts.Bans()) != 0 {
+		t.Fatal("throttled address should not be banned yet")
+	}
+}
+
+// TestLockoutSettingsParsedOnce checks that the lockout settings are parsed when the
+// configuration is saved, invalid durations being replaced by defaults, and not on each use.
+func TestLockoutSettingsParsedOnce(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Lockout.Window = "invalid"
+	saveTestConfig(t, ts)
+
+	settings := ts.lockoutSettings()
+	if settings.window != defaultLockoutWindow {
+		t.Fatalf("invalid lockout window should be replaced by %s, got %s", defaultLockoutWindow, settings.window)
+	}
+
+	ts.opts.config.Lockout.Window = "1s"
+
+	if ts.lockoutSettings().window != defaultLockoutWindow {
+		t.Fatal("lockout settings should only be parsed when the configuration is saved")
+	}
+}
+
+// TestLockoutSuccessKeepsAddress checks that a successful authentication does not forget the
+// failures of its remote address, which other clients (eg. behind a NAT) might have made.
+func TestLockoutSuccessKeepsAddress(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Lockout.MaxFailures = 3
+	ts.opts.config.Lockout.Backoff = "0s"
+	saveTestConfig(t, ts)
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	attacker := team.Credentials{Token: "guess", RemoteAddr: "203.0.113.7:4242"}
+	valid := team.Credentials{Token: config.Token, RemoteAddr: attacker.RemoteAddr}
+
+	for range 2 {
+		if _, err := ts.Authenticator().Authenticate(attacker); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("invalid token should be refused, got %v", err)
+		}
+
+		if _, err := ts.Authenticator().Authenticate(valid); err != nil {
+			t.Fatalf("valid token refused: %v", err)
+		}
+	}
+
+	if _, err := ts.Authenticator().Authenticate(attacker); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("invalid token should be refused, got %v", err)
+	}
+
+	if bans := ts.Bans(); len(bans) != 1 || bans[0].Subject != "203.0.113.7" {
+		t.Fatalf("address should be banned after its failures, despite valid authentications: %+v", bans)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// DatabaseMigration is a versioned migration of the teamserver database schema.
+type DatabaseMigration struct {
+	Version     uint      `json:"version"`
+	Description string    `json:"description"`
+	Applied     bool      `json:"applied"`
+	AppliedAt   time.Time `json:"applied_at"`
+	Known       bool      `json:"known"`      // False if applied by a more recent teamserver.
+	Reversible  bool      `json:"reversible"` // The migration can be rolled back.
+}
+
+// DatabaseMigrations returns the migrations of the teamserver database, applied or pending,
+// and the ones applied by a more recent teamserver, if any. Unlike other calls using the
+// database, the migration calls do not apply its pending migrations beforehand.
+func (ts *Server) DatabaseMigrations() ([]DatabaseMigration, error) {
+	if err := ts.openDatabase(); err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	status, err := db.MigrationsStatus(ts.db)
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	migrations := make([]DatabaseMigration, len(status))
+	for i, migration := range status {
+		migrations[i] = DatabaseMigration(migration)
+	}
+
+	return migrations, nil
+}
+
+// DatabaseMigrate applies the pending migrations of the teamserver database up to
+// a version (all of them with 0), and returns them. It fails if the database schema
+// is more recent than the teamserver.
+func (ts *Server) DatabaseMigrate(version uint) ([]DatabaseMigration, error) {
+	if err := ts.openDatabase(); err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	if version == 0 {
+		version = db.LatestVersion()
+	}
+
+	applied, err := db.MigrateTo(ts.db, version)
+
+	migrations := databaseMigrations(applied, true)
+	if len(migrations) > 0 {
+		ts.auditMigration(AuditDatabaseMigrate, fmt.Sprintf("%d migrations applied, up to version %d", len(migrations), migrations[len(migrations)-1].Version))
+	}
+
+	if err != nil {
+		return migrations, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	return migrations, nil
+}
+
+// DatabaseRollback rolls back the migrations of the teamserver database applied after
+// a version, most recent first, and returns them. The first version, which creates the
+// teamserver tables, cannot be rolled back. Since any other call using the database applies
+// its pending migrations, this should be used just before running an older teamserver version.
+func (ts *Server) DatabaseRollback(version uint) ([]DatabaseMigration, error) {
+	if err := ts.openDatabase(); err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	rolledBack, err := db.Rollback(ts.db, version)
+
+	migrations := databaseMigrations(rolledBack, false)
+	if len(migrations) > 0 {
+		ts.auditMigration(AuditDatabaseRollback, fmt.Sprintf("%d migrations rolled back, to version %d", len(migrations), version))
+	}
+
+	if err != nil {
+		return migrations, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	return migrations, nil
+}
+
+// auditMigration records a migration action, if the audit trail has not been rolled back.
+// Unlike server.Audit(), it does not apply the pending migrations of the database.
+func (ts *Server) auditMigration(action, details string) {
+	if !ts.db.Migrator().HasTable(&db.AuditEvent{}) {
+		return
+	}
+
+	err := ts.db.Create(&db.AuditEvent{
+		Actor:   localActor(),
+		Origin:  AuditOriginCLI,
+		Action:  action,
+		Target:  ts.db.Dialector.Name(),
+		Details: details,
+	}).Error
+	if err != nil {
+		ts.log().Error(fmt.Sprintf("failed to record audit event: %s", err))
+	}
+}
+
+func databaseMigrations(migrations []db.Migration, applied bool) []DatabaseMigration {
+	infos := make([]DatabaseMigration, len(migrations))
+
+	for i, migration := range migrations {
+		infos[i] = DatabaseMigration{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Applied:     applied,
+			Known:       true,
+			Reversible:  migration.Down != nil,
+		}
+
+		if applied {
+			infos[i].AppliedAt = time.Now()
+		}
+	}
+
+	return infos
+}
@@ -0,0 +1,63 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"testing"
+)
+
+// TestDatabaseMigrations checks that migrations can be rolled back and applied
+// again by a teamserver, and that these actions are audited.
+func TestDatabaseMigrations(t *testing.T) {
+	ts := newTestServer(t)
+
+	migrations, err := ts.DatabaseMigrations()
+	if err != nil || len(migrations) < 2 {
+		t.Fatalf("DatabaseMigrations: %+v (%v)", migrations, err)
+	}
+
+	for _, migration := range migrations {
+		if !migration.Applied || !migration.Known {
+			t.Fatalf("migration %d should have been applied when creating the teamserver", migration.Version)
+		}
+	}
+
+	rolledBack, err := ts.DatabaseRollback(1)
+	if err != nil || len(rolledBack) != len(migrations)-1 || rolledBack[0].Version != migrations[len(migrations)-1].Version {
+		t.Fatalf("DatabaseRollback(1): %+v (%v)", rolledBack, err)
+	}
+
+	if migrations, _ = ts.DatabaseMigrations(); migrations[1].Applied {
+		t.Fatal("rolled back migration should be pending")
+	}
+
+	applied, err := ts.DatabaseMigrate(0)
+	if err != nil || len(applied) != len(rolledBack) {
+		t.Fatalf("DatabaseMigrate(0): %+v (%v)", applied, err)
+	}
+
+	if applied, err := ts.DatabaseMigrate(0); err != nil || len(applied) != 0 {
+		t.Fatalf("no migrations should be pending: %+v (%v)", applied, err)
+	}
+
+	events, err := ts.AuditEvents(AuditFilter{Action: "database"})
+	if err != nil || len(events) != 2 || events[0].Action != AuditDatabaseRollback || events[1].Action != AuditDatabaseMigrate {
+		t.Fatalf("migration actions should be audited: %+v (%v)", events, err)
+	}
+}
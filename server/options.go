@@ -25,6 +25,7 @@ import (
 
 	"gorm.io/gorm"
 
+	"github.com/reeflective/team"
 	"github.com/reeflective/team/internal/assets"
 	"github.com/reeflective/team/internal/db"
 	"github.com/reeflective/team/log"
@@ -54,11 +55,21 @@ type opts struct {
 	config       *Config
 	dbConfig     *db.Config
 	dbKey        string
+	tablePrefix  string
+	models       []any
+	caPassphrase string
+	secretStore  string
+	secrets      SecretStore
+	secretsKey   string
+	backupKey    string
 	db           *gorm.DB
 	logger       slog.Handler
 	consoleStyle func(*log.ConsoleOptions)
 	logFormat    log.Format
 	handlers     []Handler
+
+	authenticators []team.Authenticator
+	certProfiles   []CertificateProfile
 }
 
 // default in-memory configuration, ready to run.
@@ -173,11 +184,19 @@ func WithDatabaseConfig(config *db.Config) Options {
 //
 // The key is never written to disk (in particular, it is not stored in the
 // database configuration file): the application is responsible for sourcing it
-// securely (environment variable, prompt, KMS, ...) on each start.
+// securely (environment variable, prompt, KMS, ...) on each start. Without this
+// option, the key can be given with the "encryption_key" reference of the database
+// configuration file ("env:NAME", "file:/path" or "prompt"), never in cleartext.
+// An existing database file is encrypted, decrypted or re-keyed with
+// server.DatabaseEncrypt(), server.DatabaseDecrypt() and server.DatabaseRekey().
 //
-// This option has no effect on in-memory databases (nothing is persisted), on
-// user-provided backends passed with WithDatabase, or on the cgo_sqlite build
-// (whose SQLite engine does not provide the adiantum VFS).
+// Whatever the backend (including PostgreSQL, MySQL, in-memory databases and the
+// ones passed with WithDatabase), the key also encrypts the sensitive columns of the
+// database (private keys, token hashes and invitation codes), with a data key stored
+// encrypted with it: existing values are encrypted when the teamserver starts, and
+// the data key can be rotated with server.DatabaseRotateKey(). The file encryption
+// has no effect on in-memory databases (nothing is persisted), on user-provided
+// backends, or on the cgo_sqlite build (whose SQLite engine has no adiantum VFS).
 //
 // This option can only be used once, and must be passed to server.New().
 func WithDatabaseKey(key string) Options {
@@ -186,6 +205,93 @@ func WithDatabaseKey(key string) Options {
 	}
 }
 
+// WithModels registers models of the application with the teamserver database, so that
+// applications do not need a database of their own: their tables are created and updated
+// (gorm AutoMigrate) once the teamserver migrations are applied, and their rows are part
+// of backups and of the copies made by server.DatabaseMigrateTo(). Their string or bytes
+// fields tagged with `gorm:"serializer:encrypted"` (or "encrypted_lookup", for columns
+// used in struct conditions) are encrypted with the database key (see WithDatabaseKey).
+// Use server.Database() to query them. Models whose table is one of the teamserver ones
+// (eg. a "users" table), or the one of another model, fail to open the database.
+//
+// This option can be used multiple times, and must be passed to server.New().
+func WithModels(models ...any) Options {
+	return func(opts *opts) {
+		opts.models = append(opts.models, models...)
+	}
+}
+
+// WithTablePrefix prefixes the names of all tables of the teamserver database (the teamserver
+// ones and the application ones, see WithModels), so that several applications can share
+// the same database (eg. PostgreSQL) without colliding. It takes precedence over the
+// table_prefix of the database configuration, and has no effect on the databases passed
+// with WithDatabase, whose table names are up to their gorm configuration. Setting it on
+// an existing database gives a new, empty set of tables: use server.DatabaseMigrateTo().
+//
+// This option can only be used once, and must be passed to server.New().
+func WithTablePrefix(prefix string) Options {
+	return func(opts *opts) {
+		opts.tablePrefix = prefix
+	}
+}
+
+// WithCAPassphrase sets the passphrase with which the private key of the users CA is stored
+// encrypted, and unlocked when the teamserver starts. It takes precedence over the environment
+// variable APP_CA_PASSPHRASE and the certificates.ca_passphrase_file of the configuration.
+// Without any of them, a CA key already encrypted must be unlocked with server.UsersUnlockCA().
+//
+// This option can only be used once, and must be passed to server.New().
+func WithCAPassphrase(passphrase string) Options {
+	return func(opts *opts) {
+		opts.caPassphrase = passphrase
+	}
+}
+
+// WithSecretStore sets the built-in store in which the teamserver keeps all private keys
+// (of the users CA and of the certificates it issued): server.SecretStoreFilesystem (the
+// default), server.SecretStoreDatabase or server.SecretStoreEncryptedFile. It takes
+// precedence over the certificates.secret_store of the configuration. Keys already
+// stored elsewhere must be moved with server.SecretsMigrate().
+//
+// This option can only be used once, and must be passed to server.New().
+func WithSecretStore(name string) Options {
+	return func(opts *opts) {
+		opts.secretStore = name
+	}
+}
+
+// WithCustomSecretStore sets a store of your own in which the teamserver keeps all private
+// keys, instead of its built-in ones (eg. a KMS or a secrets manager).
+//
+// This option can only be used once, and must be passed to server.New().
+func WithCustomSecretStore(store SecretStore) Options {
+	return func(opts *opts) {
+		opts.secrets = store
+	}
+}
+
+// WithSecretsPassphrase sets the passphrase of the encrypted-file secret store. It takes
+// precedence over the environment variable APP_SECRETS_PASSPHRASE, and the
+// certificates.secrets_passphrase_file of the configuration.
+//
+// This option can only be used once, and must be passed to server.New().
+func WithSecretsPassphrase(passphrase string) Options {
+	return func(opts *opts) {
+		opts.secretsKey = passphrase
+	}
+}
+
+// WithBackupPassphrase sets the passphrase with which backup archives are encrypted (and
+// decrypted when restored). It takes precedence over the environment variable
+// APP_BACKUP_PASSPHRASE, and the backup.passphrase_file of the configuration.
+//
+// This option can only be used once, and must be passed to server.New().
+func WithBackupPassphrase(passphrase string) Options {
+	return func(opts *opts) {
+		opts.backupKey = passphrase
+	}
+}
+
 // WithHomeDirectory sets the default path (~/.app/) of the application directory.
 // This path can still be overridden at the user-level with the env var APP_ROOT_DIR.
 //
@@ -301,6 +407,36 @@ func WithHandler(ln Handler) Options {
 	}
 }
 
+// WithAuthenticators adds authenticators for external identity sources (eg. signed JWTs,
+// a directory) to the teamserver. Credentials which are not tokens issued by the teamserver
+// are verified by these authenticators, in order: each of them either authenticates the
+// credentials, refuses them, or does not know them (team.ErrUnknownCredentials), in which
+// case the next one is consulted. See the team/authenticators packages for implementations.
+//
+// Users authenticated this way still connect over Mutual TLS: their client certificate
+// must have been issued to them, for instance with server.UserInvite().
+//
+// This option can be used multiple times, authenticators being added after previous ones.
+func WithAuthenticators(authenticators ...team.Authenticator) Options {
+	return func(opts *opts) {
+		opts.authenticators = append(opts.authenticators, authenticators...)
+	}
+}
+
+// WithCertificateProfiles sets how the teamserver issues certificates of given CAs and
+// purposes (see CertificateProfile), instead of the default ECDSA keys valid 3 years.
+// These profiles take precedence over the ones of the teamserver configuration file,
+// and certificates already issued are not modified. Invalid profiles will make the
+// certificate infrastructure (and thus most of the teamserver) fail to initialize.
+//
+// This option can be used multiple times, profiles being added after previous ones,
+// but it must be passed before any certificate is issued (eg. to server.New()).
+func WithCertificateProfiles(profiles ...CertificateProfile) Options {
+	return func(opts *opts) {
+		opts.certProfiles = append(opts.certProfiles, profiles...)
+	}
+}
+
 // WithContinueOnError sets the server behavior when starting persistent listeners
 // (either automatically when calling teamserver.ServeDaemon(), or when using
 // teamserver.StartPersistentListeners()).
@@ -0,0 +1,163 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// UsersCALocked reports whether the private key of the users CA is encrypted, without the
+// teamserver having its passphrase: it cannot issue certificates, and thus serve teamclients,
+// until unlocked with server.UsersUnlockCA() (eg. from a prompt, when starting the daemon).
+func (ts *Server) UsersCALocked() bool {
+	if err := ts.initCerts(); err != nil {
+		return false
+	}
+
+	return ts.certs.UsersCALocked()
+}
+
+// UsersUnlockCA decrypts the private key of the users CA with its passphrase,
+// which is also used from now on to encrypt the CA keys saved by the teamserver.
+func (ts *Server) UsersUnlockCA(passphrase string) error {
+	if err := ts.initCerts(); err != nil {
+		return err
+	}
+
+	if err := ts.certs.UnlockUsersCA([]byte(passphrase)); err != nil {
+		return ts.errorf("%w: failed to unlock users CA: %w", ErrCertificate, err)
+	}
+
+	ts.NamedLogger("certs", "ca").Info("Unlocked users CA private key")
+
+	return nil
+}
+
+// UsersSetCAPassphrase encrypts the private key of the users CA with a new passphrase, or stores
+// it in cleartext if empty. The passphrase must then be given to the teamserver when it starts,
+// with the server.WithCAPassphrase() option, the APP_CA_PASSPHRASE environment variable, the
+// certificates.ca_passphrase_file of the configuration, or server.UsersUnlockCA().
+func (ts *Server) UsersSetCAPassphrase(passphrase string) error {
+	if err := ts.initCerts(); err != nil {
+		return err
+	}
+
+	if err := ts.certs.SetUsersCAPassphrase([]byte(passphrase)); err != nil {
+		return ts.errorf("%w: failed to encrypt users CA private key: %w", ErrCertificate, err)
+	}
+
+	details := "encrypted"
+	if passphrase == "" {
+		details = "cleartext"
+	}
+
+	ts.audit(AuditCAPassphrase, "users", details)
+
+	return nil
+}
+
+// UsersExportCA returns the PEM-encoded users CA certificate (followed by its issuers if any),
+// and its private key encrypted with a passphrase, or with the CA passphrase if empty, so that
+// the CA can be imported by another teamserver with server.UsersImportCA(). The key is never
+// exported in cleartext: without any passphrase, ErrExportPassphrase is returned.
+func (ts *Server) UsersExportCA(passphrase string) (certPEM, keyPEM []byte, err error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err = ts.certs.ExportUsersCAKey([]byte(passphrase))
+	if err != nil {
+		return nil, nil, ts.errorf("%w: failed to export users CA private key: %w", ErrCertificate, err)
+	}
+
+	certPEM, err = ts.certs.UsersCAChainPEM()
+	if err != nil {
+		return nil, nil, ts.errorf("%w: failed to get users CA chain: %w", ErrCertificate, err)
+	}
+
+	ts.audit(AuditCAExport, "users", "encrypted")
+
+	return certPEM, keyPEM, nil
+}
+
+// caPassphrase returns the passphrase of the users CA private key given with server.WithCAPassphrase(),
+// or else in the APP_CA_PASSPHRASE environment variable, or else with the certificates.ca_passphrase
+// secret reference or the certificates.ca_passphrase_file of the configuration (the trailing newline
+// is ignored). Without any, the key is stored in cleartext, but a configured passphrase that cannot
+// be read is an error: the key is then neither generated nor read.
+func (ts *Server) caPassphrase() ([]byte, error) {
+	certificates := ts.opts.config.Certificates
+
+	return ts.readPassphrase("CA passphrase", ts.opts.caPassphrase, "CA_PASSPHRASE",
+		certificates.CAPassphrase, certificates.CAPassphraseFile)
+}
+
+// readPassphrase returns a passphrase given as an option, or else in the APP_<env> environment
+// variable, or else with a secret reference (see server.SecretSources), or else in a file (the
+// trailing newline is ignored), or nil if none of them has one. A passphrase configured with a
+// reference or a file that cannot be read (or is empty) is an error, never a missing passphrase.
+func (ts *Server) readPassphrase(name, option, env, ref, path string) ([]byte, error) {
+	if option != "" {
+		return []byte(option), nil
+	}
+
+	if passphrase := os.Getenv(strings.ToUpper(ts.Name()) + "_" + env); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	if ref != "" {
+		passphrase, err := ts.resolveSecret(name, ref, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(passphrase), nil
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	passphrase, err := ts.resolveSecret(name, secretFilePrefix+path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(passphrase), nil
+}
+
+// passphraseSource describes where readPassphrase would read a passphrase from, without reading it.
+func (ts *Server) passphraseSource(option, env, ref, path string) string {
+	variable := strings.ToUpper(ts.Name()) + "_" + env
+
+	switch {
+	case option != "":
+		return "option"
+	case os.Getenv(variable) != "":
+		return fmt.Sprintf("environment variable %s", variable)
+	case ref != "":
+		return secretSource(ref)
+	case path != "":
+		return secretSource(secretFilePrefix + path)
+	default:
+		return "none"
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// TestUsersCAPassphrase checks that the users CA private key is stored encrypted with the
+// CA passphrase, and that exported keys are encrypted and imported with their passphrase.
+func TestUsersCAPassphrase(t *testing.T) {
+	ts, err := New("passphrase", WithInMemory(), WithCAPassphrase("secret"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if ts.UsersCALocked() {
+		t.Fatal("users CA should be unlocked with the passphrase option")
+	}
+
+	_, storedKey, err := ts.certs.GetUsersCAPEM()
+	if err != nil {
+		t.Fatalf("GetUsersCAPEM: %v", err)
+	}
+
+	if !bytes.Contains(storedKey, []byte("ENCRYPTED PRIVATE KEY")) {
+		t.Fatal("users CA private key should be stored encrypted")
+	}
+
+	if _, caKey, err := ts.UsersGetCA(); err != nil || !bytes.Contains(caKey, []byte("ENCRYPTED PRIVATE KEY")) {
+		t.Fatalf("UsersGetCA should return the key encrypted with the CA passphrase (err: %v)", err)
+	}
+
+	if err := ts.UsersUnlockCA("wrong"); !errors.Is(err, ErrCAPassphrase) {
+		t.Fatalf("unlocking with a wrong passphrase should fail with ErrCAPassphrase, got %v", err)
+	}
+
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	certPEM, keyPEM, err := ts.UsersExportCA("export")
+	if err != nil {
+		t.Fatalf("UsersExportCA: %v", err)
+	}
+
+	other := newTestServer(t)
+
+	if _, _, err := other.UsersExportCA(""); !errors.Is(err, ErrExportPassphrase) {
+		t.Fatalf("exporting without any passphrase should fail with ErrExportPassphrase, got %v", err)
+	}
+
+	if _, _, err := other.UsersGetCA(); !errors.Is(err, ErrExportPassphrase) {
+		t.Fatalf("UsersGetCA without any passphrase should fail with ErrExportPassphrase, got %v", err)
+	}
+
+	if err := other.UsersImportCA(certPEM, keyPEM, ""); !errors.Is(err, ErrCAPassphrase) {
+		t.Fatalf("importing without the export passphrase should fail with ErrCAPassphrase, got %v", err)
+	}
+
+	if err := other.UsersImportCA(certPEM, keyPEM, "export"); err != nil {
+		t.Fatalf("UsersImportCA: %v", err)
+	}
+
+	if _, err := other.UserCreate("bob", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate with the imported CA: %v", err)
+	}
+}
+
+// TestUsersCAPassphraseUnreadable checks that the certificate infrastructure fails to
+// initialize when the configured CA passphrase cannot be read, instead of generating
+// a users CA with a cleartext private key.
+func TestUsersCAPassphraseUnreadable(t *testing.T) {
+	ts, err := New("passphrase", WithInMemory())
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	ts.opts.config.Certificates.CAPassphraseFile = filepath.Join(t.TempDir(), "missing")
+	saveTestConfig(t, ts)
+
+	if err := ts.initCerts(); !errors.Is(err, ErrCertificate) || !errors.Is(err, ErrSecretReference) {
+		t.Fatalf("unreadable CA passphrase file should fail with ErrSecretReference, got %v", err)
+	}
+
+	if ts.certs != nil {
+		t.Fatal("no users CA should be generated without its configured passphrase")
+	}
+}
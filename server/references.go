@@ -0,0 +1,185 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+
+	"github.com/reeflective/team/internal/db"
+)
+
+// Secrets of the configurations (database password and key, passphrases) can be given as
+// references, resolved when the teamserver needs them and never written back in cleartext:
+//   - "env:NAME" is the value of the environment variable NAME.
+//   - "file:/path" is the content of a file, without its trailing newline.
+//   - "prompt" is asked (once) on the terminal of the teamserver process.
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+	secretPrompt     = "prompt"
+)
+
+// SecretSource describes where a secret of the teamserver comes from, without its value.
+type SecretSource struct {
+	Name   string `json:"name"`   // Secret, eg. "Database password".
+	Source string `json:"source"` // Where its value comes from, or "none".
+}
+
+// SecretSources returns where each secret of the teamserver comes from (options, environment
+// variables, secret references of the configurations, or cleartext values of them), without
+// reading them: in particular, secrets to prompt for are not prompted for.
+func (ts *Server) SecretSources() []SecretSource {
+	dbConfig := ts.DatabaseConfig()
+	if dbConfig == nil {
+		dbConfig = ts.opts.dbConfig
+	}
+
+	config := ts.GetConfig()
+
+	dbKey := secretSource(dbConfig.EncryptionKeyRef)
+	if ts.opts.dbKey != "" && !ts.dbKeyRef {
+		dbKey = "option"
+	}
+
+	sources := []SecretSource{
+		{Name: "Database key", Source: dbKey},
+		{Name: "CA passphrase", Source: ts.passphraseSource(ts.opts.caPassphrase, "CA_PASSPHRASE",
+			config.Certificates.CAPassphrase, config.Certificates.CAPassphraseFile)},
+		{Name: "Secrets passphrase", Source: ts.passphraseSource(ts.opts.secretsKey, "SECRETS_PASSPHRASE",
+			config.Certificates.SecretsPassphrase, config.Certificates.SecretsPassphraseFile)},
+		{Name: "Backup passphrase", Source: ts.passphraseSource(ts.opts.backupKey, "BACKUP_PASSPHRASE",
+			config.Backup.Passphrase, config.Backup.PassphraseFile)},
+	}
+
+	if ts.opts.db == nil && dbConfig.Dialect != db.Sqlite {
+		password := SecretSource{Name: "Database password", Source: secretSource(dbConfig.Password)}
+		sources = append([]SecretSource{password}, sources...)
+	}
+
+	return sources
+}
+
+// resolveSecret returns the value of a secret reference, prompted for with its name if needed.
+// Values which are not references are returned as is, unless a reference is required.
+func (ts *Server) resolveSecret(name, value string, required bool) (string, error) {
+	switch {
+	case value == "":
+		return "", nil
+
+	case strings.HasPrefix(value, secretEnvPrefix):
+		variable := strings.TrimPrefix(value, secretEnvPrefix)
+
+		secret, found := os.LookupEnv(variable)
+		if !found || secret == "" {
+			return "", fmt.Errorf("%w: %s: environment variable %s is not set", ErrSecretReference, name, variable)
+		}
+
+		return secret, nil
+
+	case strings.HasPrefix(value, secretFilePrefix):
+		data, err := os.ReadFile(strings.TrimPrefix(value, secretFilePrefix))
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %w", ErrSecretReference, name, err)
+		}
+
+		secret := strings.TrimRight(string(data), "\r\n")
+		if secret == "" {
+			return "", fmt.Errorf("%w: %s: empty file", ErrSecretReference, name)
+		}
+
+		return secret, nil
+
+	case value == secretPrompt:
+		return ts.promptSecret(name)
+
+	case required:
+		return "", fmt.Errorf("%w: %s must be a secret reference (%s, %s or %s), not a cleartext value",
+			ErrSecretReference, name, secretEnvPrefix+"NAME", secretFilePrefix+"/path", secretPrompt)
+
+	default:
+		return value, nil
+	}
+}
+
+// isSecretReference returns true if a value is a secret reference, rather than a cleartext secret.
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretEnvPrefix) ||
+		strings.HasPrefix(value, secretFilePrefix) ||
+		value == secretPrompt
+}
+
+// promptSecret prompts for a secret on the terminal, once for the lifetime of the teamserver.
+func (ts *Server) promptSecret(name string) (string, error) {
+	ts.promptMutex.Lock()
+	defer ts.promptMutex.Unlock()
+
+	if secret, found := ts.prompted[name]; found {
+		return secret, nil
+	}
+
+	var secret string
+
+	err := survey.AskOne(&survey.Password{Message: name + ":"}, &secret, survey.WithValidator(survey.Required))
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrSecretReference, name, err)
+	}
+
+	if ts.prompted == nil {
+		ts.prompted = make(map[string]string)
+	}
+
+	ts.prompted[name] = secret
+
+	return secret, nil
+}
+
+// secretSource describes where the value of a secret reference comes from, without reading it.
+func secretSource(value string) string {
+	switch {
+	case value == "":
+		return "none"
+
+	case strings.HasPrefix(value, secretEnvPrefix):
+		variable := strings.TrimPrefix(value, secretEnvPrefix)
+		if _, found := os.LookupEnv(variable); !found {
+			return fmt.Sprintf("environment variable %s (not set)", variable)
+		}
+
+		return fmt.Sprintf("environment variable %s", variable)
+
+	case strings.HasPrefix(value, secretFilePrefix):
+		path := strings.TrimPrefix(value, secretFilePrefix)
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Sprintf("file %s (unreadable)", filepath.Clean(path))
+		}
+
+		return fmt.Sprintf("file %s", filepath.Clean(path))
+
+	case value == secretPrompt:
+		return "prompt"
+
+	default:
+		return "configuration (cleartext)"
+	}
+}
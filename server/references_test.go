@@ -0,0 +1,87 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestResolveSecret checks the resolution of environment and file references, and
+// that cleartext values are refused where a reference is required.
+func TestResolveSecret(t *testing.T) {
+	ts := newTestServer(t)
+
+	t.Setenv("TEAM_TEST_SECRET", "from env")
+
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from file\n"), 0o600); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+
+	for value, want := range map[string]string{
+		"":                     "",
+		"env:TEAM_TEST_SECRET": "from env",
+		"file:" + path:         "from file",
+		"cleartext":            "cleartext",
+	} {
+		secret, err := ts.resolveSecret("Test secret", value, false)
+		if err != nil || secret != want {
+			t.Fatalf("resolveSecret(%q) = %q, %v, want %q", value, secret, err, want)
+		}
+	}
+
+	for _, value := range []string{"env:TEAM_TEST_UNSET", "file:" + path + ".missing"} {
+		if _, err := ts.resolveSecret("Test secret", value, false); !errors.Is(err, ErrSecretReference) {
+			t.Fatalf("resolveSecret(%q) = %v, want ErrSecretReference", value, err)
+		}
+	}
+
+	if _, err := ts.resolveSecret("Test secret", "cleartext", true); !errors.Is(err, ErrSecretReference) {
+		t.Fatalf("resolveSecret(cleartext, required) = %v, want ErrSecretReference", err)
+	}
+}
+
+// TestSecretSources checks that the sources of the secrets are reported without their values.
+func TestSecretSources(t *testing.T) {
+	t.Setenv("TEAM_TEST_BACKUP", "backup secret")
+
+	ts := newTestServer(t)
+	ts.opts.config.Backup.Passphrase = "env:TEAM_TEST_BACKUP"
+
+	sources := make(map[string]string)
+	for _, secret := range ts.SecretSources() {
+		if strings.Contains(secret.Source, "backup secret") {
+			t.Fatalf("secret %s reported with its value", secret.Name)
+		}
+
+		sources[secret.Name] = secret.Source
+	}
+
+	if got := sources["Backup passphrase"]; got != "environment variable TEAM_TEST_BACKUP" {
+		t.Fatalf("Backup passphrase source = %q", got)
+	}
+
+	if got := sources["Database key"]; got != "none" {
+		t.Fatalf("Database key source = %q, want none", got)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/reeflective/team/internal/certs"
+)
+
+// RevokedCertificate is a user certificate revoked by the teamserver.
+// Revoked certificates are refused during the Mutual TLS handshake
+// (see server.UsersTLSConfig()), and are listed in the users CRL.
+type RevokedCertificate struct {
+	Serial     string    // Hex-encoded serial number of the certificate.
+	CommonName string    // Common name of the certificate, if known.
+	Reason     string    // RFC 5280 revocation reason name.
+	RevokedAt  time.Time // Time of revocation.
+}
+
+// UserRevoke revokes all client certificates issued to a user, and closes all of
+// its live sessions. The user is NOT deleted: its certificate is merely refused
+// from now on, so creating the user again (server.UserCreate()) issues it a new,
+// valid one. Use server.UserDelete() to remove the user for good.
+//
+// The reason is a RFC 5280 revocation reason name (eg. "keyCompromise"),
+// and an empty reason is recorded as "unspecified".
+func (ts *Server) UserRevoke(name, reason string) error {
+	if err := ts.initCerts(); err != nil {
+		return err
+	}
+
+	code, err := certs.ParseReason(reason)
+	if err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	if err := ts.certs.UserClientRevokeCertificates(name, code); err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	ts.kickUserSessions(name)
+
+	ts.audit(AuditUserRevoke, name, "reason "+certs.ReasonName(code))
+
+	return nil
+}
+
+// UsersRevokeSerial revokes a user certificate by its (hexadecimal) serial number.
+// This is useful when the certificate is not known to the teamserver database anymore,
+// like certificates of deleted users, or issued by another teamserver with the same CA.
+func (ts *Server) UsersRevokeSerial(serial, reason string) error {
+	if err := ts.initCerts(); err != nil {
+		return err
+	}
+
+	code, err := certs.ParseReason(reason)
+	if err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	if err := ts.certs.UserRevokeSerial(serial, code); err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	ts.audit(AuditCertRevoke, serial, "reason "+certs.ReasonName(code))
+
+	return nil
+}
+
+// UsersRevoked returns all revoked user certificates, oldest first.
+func (ts *Server) UsersRevoked() ([]RevokedCertificate, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	revokedDB, err := ts.certs.UserRevokedCertificates()
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	revoked := make([]RevokedCertificate, len(revokedDB))
+
+	for i, rev := range revokedDB {
+		revoked[i] = RevokedCertificate{
+			Serial:     rev.SerialNumber,
+			CommonName: rev.CommonName,
+			Reason:     certs.ReasonName(rev.Reason),
+			RevokedAt:  rev.RevokedAt,
+		}
+	}
+
+	return revoked, nil
+}
+
+// UsersGetCRL returns a PEM-encoded X.509 certificate revocation list,
+// signed by the users CA and containing all revoked user certificates.
+func (ts *Server) UsersGetCRL() ([]byte, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	crl, err := ts.certs.GetUsersCRLPEM()
+	if err != nil {
+		return nil, ts.errorf("%w: failed to generate CRL: %w", ErrCertificate, err)
+	}
+
+	return crl, nil
+}
+
+// verifyPeerCertificate returns a tls.Config.VerifyPeerCertificate function refusing client
+// certificates not issued by one of the given CAs (the users CA, and the previous one during
+// a rotation), or revoked. It is called after the standard chain verification, which anchors
+// at the root when the users CA is an intermediate of an external PKI: certificates issued by
+// the other CAs of this PKI are valid against the root, but must not be trusted as teamclients.
+func (ts *Server) verifyPeerCertificate(issuers ...*x509.Certificate) func([][]byte, [][]*x509.Certificate) error {
+	log := ts.NamedLogger("certs", "mtls")
+
+	issuers = slices.DeleteFunc(issuers, func(issuer *x509.Certificate) bool { return issuer == nil })
+
+	issuedBy := func(chain []*x509.Certificate) bool {
+		for _, cert := range chain[min(1, len(chain)):] {
+			for _, issuer := range issuers {
+				// Also matches the cross-signed certificate of a CA.
+				if bytes.Equal(cert.RawSubject, issuer.RawSubject) &&
+					bytes.Equal(cert.RawSubjectPublicKeyInfo, issuer.RawSubjectPublicKeyInfo) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return nil
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return ts.errorWith(log, "%w: failed to parse peer certificate: %w", ErrCertificate, err)
+		}
+
+		if !slices.ContainsFunc(verifiedChains, issuedBy) {
+			return ts.errorWith(log, "%w: %s (serial %s) is not issued by the users CA", ErrCertificate,
+				cert.Subject.CommonName, certs.FormatSerial(cert.SerialNumber))
+		}
+
+		revoked, err := ts.certs.IsRevoked(cert.SerialNumber)
+		if err != nil {
+			return ts.errorWith(log, "%w: failed to check certificate revocation: %w", ErrDatabase, err)
+		}
+
+		if revoked {
+			return ts.errorWith(log, "%w: %s (serial %s)", ErrCertificateRevoked,
+				cert.Subject.CommonName, certs.FormatSerial(cert.SerialNumber))
+		}
+
+		return nil
+	}
+}
+
+// kickUserSessions closes all live sessions of a user.
+func (ts *Server) kickUserSessions(name string) {
+	for _, sess := range ts.Sessions() {
+		if sess.User != name {
+			continue
+		}
+
+		if err := ts.kickSession(sess.ID); err != nil {
+			ts.log().Warn(fmt.Sprintf("Failed to kick session %s: %s", formatSmallID(sess.ID), err))
+		}
+	}
+}
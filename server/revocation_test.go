@@ -0,0 +1,111 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestRevokedCertificateRefused checks that the users Mutual TLS configuration
+// refuses revoked client certificates, whether revoked explicitly, superseded
+// by re-creating the user, or revoked when deleting the user.
+func TestRevokedCertificateRefused(t *testing.T) {
+	ts := newTestServer(t)
+
+	tlsConfig, err := ts.UsersTLSConfig()
+	if err != nil {
+		t.Fatalf("UsersTLSConfig: %v", err)
+	}
+
+	if tlsConfig.VerifyPeerCertificate == nil {
+		t.Fatal("UsersTLSConfig must verify peer certificates revocation")
+	}
+
+	verify := func(certPEM string) error {
+		return verifyPeer(t, tlsConfig, certPEM)
+	}
+
+	first, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if err := verify(first.Certificate); err != nil {
+		t.Fatalf("valid certificate refused: %v", err)
+	}
+
+	// Re-creating the user supersedes its previous certificate.
+	second, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if err := verify(first.Certificate); !errors.Is(err, ErrCertificateRevoked) {
+		t.Fatalf("superseded certificate should be revoked, got %v", err)
+	}
+
+	if err := verify(second.Certificate); err != nil {
+		t.Fatalf("new certificate refused: %v", err)
+	}
+
+	if err := ts.UserRevoke("alice", "keyCompromise"); err != nil {
+		t.Fatalf("UserRevoke: %v", err)
+	}
+
+	if err := verify(second.Certificate); !errors.Is(err, ErrCertificateRevoked) {
+		t.Fatalf("revoked certificate should be refused, got %v", err)
+	}
+
+	// Deleting a user revokes its certificate as well.
+	bob, err := ts.UserCreate("bob", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if err := ts.UserDelete("bob"); err != nil {
+		t.Fatalf("UserDelete: %v", err)
+	}
+
+	if err := verify(bob.Certificate); !errors.Is(err, ErrCertificateRevoked) {
+		t.Fatalf("deleted user certificate should be refused, got %v", err)
+	}
+
+	revoked, err := ts.UsersRevoked()
+	if err != nil {
+		t.Fatalf("UsersRevoked: %v", err)
+	}
+
+	reasons := make(map[string]string)
+	for _, rev := range revoked {
+		reasons[rev.Serial] = rev.Reason
+	}
+
+	if len(revoked) != 3 || len(reasons) != 3 {
+		t.Fatalf("expected 3 distinct revoked certificates, got %+v", revoked)
+	}
+
+	if _, err := ts.UsersGetCRL(); err != nil {
+		t.Fatalf("UsersGetCRL: %v", err)
+	}
+
+	if err := ts.UserRevoke("alice", "notAReason"); !errors.Is(err, ErrCertificate) {
+		t.Fatalf("invalid reason should fail with ErrCertificate, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/reeflective/team/client"
+	"github.com/reeflective/team/internal/certs"
+	"github.com/reeflective/team/internal/db"
+)
+
+// CARotation is a users CA rotation in progress (see server.UsersRotateCA()).
+type CARotation struct {
+	Previous string    // SHA-256 fingerprint of the previous CA, still trusted.
+	Current  string    // SHA-256 fingerprint of the new CA, issuing all certificates.
+	Ends     time.Time // End of the window, after which the previous CA is retired.
+}
+
+// usersTLS holds the Mutual TLS configurations of the current users CA, which are
+// rebuilt when the CA changes (rotation, import, or end of the rotation window).
+type usersTLS struct {
+	mtls     *tls.Config // Teamclients with a client certificate.
+	enroll   *tls.Config // Enrolling teamclients, without one.
+	retireAt time.Time   // End of the CA rotation window in progress, if any.
+	names    []string    // Names the server certificate was loaded for.
+}
+
+// UsersRotateCA replaces the users CA with a new one, issuing all certificates from now on.
+// During the rotation window (or the teamserver config certificates.rotation_window, if zero),
+// the previous CA is still trusted: teamclients can connect with their current credentials,
+// and fetch a certificate issued by the new CA (see client.Client.RenewCertificate()).
+// The server certificate is reissued, and sent along the new CA cross-signed by the previous
+// one, which teamclients trusting only the previous CA can thus verify. When the window ends,
+// the previous CA is retired, and teamclients not renewed by then cannot connect anymore.
+//
+// Only one rotation can be in progress at a time.
+func (ts *Server) UsersRotateCA(window time.Duration) (*CARotation, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	if window <= 0 {
+		window = ts.caRotationWindow()
+	}
+
+	if err := ts.certs.RotateUsersCA(window); err != nil {
+		return nil, ts.errorf("%w: failed to rotate users CA: %w", ErrCertificate, err)
+	}
+
+	if err := ts.generateServerCertificate(); err != nil {
+		return nil, ts.errorf("%w: failed to reissue server certificate: %w", ErrCertificate, err)
+	}
+
+	ts.resetUsersTLS()
+
+	// Not retired here, even if the window is already over.
+	previous, cross, err := ts.certs.UsersCARotation()
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	rotation, err := ts.newCARotation(previous, cross)
+	if err != nil {
+		return nil, err
+	}
+
+	ts.NamedLogger("certs", "ca").Info(fmt.Sprintf("Rotated users CA, previous CA trusted until %s",
+		rotation.Ends.Format(time.RFC1123)))
+
+	ts.audit(AuditCARotate, "users", "until "+rotation.Ends.Format(time.RFC3339))
+
+	return rotation, nil
+}
+
+// UsersCARotation returns the users CA rotation in progress, or nil if there is none.
+func (ts *Server) UsersCARotation() (*CARotation, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	previous, cross, err := ts.usersCARotation()
+	if err != nil {
+		return nil, ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	if cross == nil {
+		return nil, nil
+	}
+
+	return ts.newCARotation(previous, cross)
+}
+
+// newCARotation returns the rotation from the previous users CA to the current one.
+func (ts *Server) newCARotation(previous, cross *x509.Certificate) (*CARotation, error) {
+	current, _, err := ts.certs.GetUsersCA()
+	if err != nil {
+		return nil, ts.errorf("%w: failed to get users certificate authority: %w", ErrCertificate, err)
+	}
+
+	return &CARotation{
+		Previous: certs.Fingerprint(previous.Raw),
+		Current:  certs.Fingerprint(current.Raw),
+		Ends:     cross.NotAfter,
+	}, nil
+}
+
+// UserRenewCertificate signs the PEM-encoded certificate request of a teamclient with the
+// current users CA, for the credential of its API token, typically during a CA rotation.
+// The previous certificate of the credential is revoked: the teamclient must reconnect
+// with the new one. It returns a client configuration with the new certificate and users
+// CA, without token, private key, host and port: the teamclient completes it itself.
+func (ts *Server) UserRenewCertificate(rawToken string, csrPEM []byte) (*client.Config, error) {
+	if err := ts.initCerts(); err != nil {
+		return nil, err
+	}
+
+	cred, err := ts.TokenCredential(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token is bound to the new certificate along its replacement.
+	certPEM, err := ts.certs.UserClientRenewCredentialCSR(cred.User, credentialCertLabel(cred.Label), csrPEM,
+		func(tx *gorm.DB, certPEM []byte) error {
+			cert, err := certs.ParseCertificatePEM(certPEM)
+			if err != nil {
+				return err
+			}
+
+			return tx.Model(&db.Credential{}).
+				Where(&db.Credential{UserName: cred.User, Label: cred.Label}).
+				Update("CertificateSerial", certs.FormatSerial(cert.SerialNumber)).Error
+		})
+	if err != nil {
+		return nil, ts.errorf("%w: failed to sign certificate request: %w", ErrCertificate, err)
+	}
+
+	// Cached tokens of the credential are bound to the previous certificate.
+	ts.authCache.invalidateCredential(cred.User, cred.Label)
+
+	ts.auditAs(cred.User, AuditOriginRPC, AuditCertRenew, cred.User, "credential "+cred.Label)
+
+	caChainPEM, certChainPEM := ts.usersCertificatesPEM(certPEM)
+
+	return &client.Config{
+		User:          cred.User,
+		CACertificate: caChainPEM,
+		Certificate:   certChainPEM,
+	}, nil
+}
+
+// usersCARotation returns the previous users CA and the cross-signed current one,
+// if a rotation is in progress. If its window is over, the previous CA is retired.
+func (ts *Server) usersCARotation() (previous, cross *x509.Certificate, err error) {
+	previous, cross, err = ts.certs.UsersCARotation()
+	if err != nil || cross == nil || time.Now().Before(cross.NotAfter) {
+		return previous, cross, err
+	}
+
+	if err := ts.certs.RetireUsersCA(); err != nil {
+		return nil, nil, fmt.Errorf("failed to retire previous users CA: %w", err)
+	}
+
+	ts.NamedLogger("certs", "ca").Info("End of the users CA rotation window, retired previous CA")
+	ts.audit(AuditCARetire, "users", "end of rotation window")
+
+	return nil, nil, nil
+}
+
+// loadUsersTLS builds and saves the Mutual TLS configurations of the users CA.
+func (ts *Server) loadUsersTLS() (*usersTLS, error) {
+	ts.tlsMutex.Lock()
+	defer ts.tlsMutex.Unlock()
+
+	state, err := ts.newUsersTLS()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.usersTLS = state
+
+	return state, nil
+}
+
+// resetUsersTLS discards the Mutual TLS configurations after a CA change,
+// so that they are rebuilt for the next connection.
+func (ts *Server) resetUsersTLS() {
+	ts.tlsMutex.Lock()
+	ts.usersTLS = nil
+	ts.tlsMutex.Unlock()
+}
+
+// currentUsersTLS returns the Mutual TLS configurations of the current users CA and server
+// certificate, rebuilding them if they were reset, if the CA rotation window is over, or if
+// the server names have changed (eg. a new listener), reissuing the server certificate.
+func (ts *Server) currentUsersTLS() (*usersTLS, error) {
+	ts.tlsMutex.Lock()
+	state := ts.usersTLS
+	ts.tlsMutex.Unlock()
+
+	if state == nil || (!state.retireAt.IsZero() && time.Now().After(state.retireAt)) ||
+		!slices.Equal(state.names, ts.serverCertificateNames()) {
+		return ts.loadUsersTLS()
+	}
+
+	return state, nil
+}
+
+// usersTLSForClient is the tls.Config.GetConfigForClient function of the configurations
+// returned by UsersTLSConfig(): it uses the configuration of the current users CA, and
+// the enrollment one for clients asking for the certs.EnrollmentServerName.
+func (ts *Server) usersTLSForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	state, err := ts.currentUsersTLS()
+	if err != nil {
+		return nil, err
+	}
+
+	if hello.ServerName == certs.EnrollmentServerName {
+		return state.enroll, nil
+	}
+
+	return state.mtls, nil
+}
+
+// usersServerCertificate is the tls.Config.GetCertificate function of the configurations
+// returned by UsersTLSConfig(), for listeners using them without GetConfigForClient: it
+// returns the current server certificate, so that renewals are used without a restart.
+func (ts *Server) usersServerCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	config, err := ts.usersTLSForClient(hello)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config.Certificates[0], nil
+}
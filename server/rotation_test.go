@@ -0,0 +1,254 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/reeflective/team/internal/certs"
+)
+
+// TestUsersRotateCA checks that during a users CA rotation, teamclients with a
+// certificate of the previous CA are still trusted, and can renew it with one of
+// the new CA, and that the previous CA is retired when the window is over.
+func TestUsersRotateCA(t *testing.T) {
+	ts := newTestServer(t)
+
+	alice, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	previousCA, _, err := ts.certs.GetUsersCA()
+	if err != nil {
+		t.Fatalf("GetUsersCA: %v", err)
+	}
+
+	rotation, err := ts.UsersRotateCA(time.Hour)
+	if err != nil {
+		t.Fatalf("UsersRotateCA: %v", err)
+	}
+
+	if rotation.Previous != certs.Fingerprint(previousCA.Raw) || rotation.Current == rotation.Previous {
+		t.Fatalf("unexpected rotation fingerprints: %+v", rotation)
+	}
+
+	if _, err := ts.UsersRotateCA(time.Hour); !errors.Is(err, ErrCertificate) {
+		t.Fatalf("second rotation should fail with ErrCertificate, got %v", err)
+	}
+
+	tlsConfig, err := ts.UsersTLSConfig()
+	if err != nil {
+		t.Fatalf("UsersTLSConfig: %v", err)
+	}
+
+	config, err := tlsConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetConfigForClient: %v", err)
+	}
+
+	// The server certificate is sent along the cross-signed new CA.
+	if chain := config.Certificates[0].Certificate; len(chain) != 2 {
+		t.Fatalf("server certificate chain should include the cross-signed CA, got %d certificates", len(chain))
+	}
+
+	verifyClient := func(certPEM string) error {
+		cert, err := certs.ParseCertificatePEM([]byte(certPEM))
+		if err != nil {
+			t.Fatalf("ParseCertificatePEM: %v", err)
+		}
+
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:     config.ClientCAs,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+
+		return err
+	}
+
+	if err := verifyClient(alice.Certificate); err != nil {
+		t.Fatalf("certificate of the previous CA should be trusted during the rotation: %v", err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	renewed, err := ts.UserRenewCertificate(alice.Token, csrPEM)
+	if err != nil {
+		t.Fatalf("UserRenewCertificate: %v", err)
+	}
+
+	renewedCert, err := certs.ParseCertificatePEM([]byte(renewed.Certificate))
+	if err != nil {
+		t.Fatalf("renewed certificate: %v", err)
+	}
+
+	currentCA, _, _ := ts.certs.GetUsersCA()
+	if err := renewedCert.CheckSignatureFrom(currentCA); err != nil || !key.PublicKey.Equal(renewedCert.PublicKey) {
+		t.Fatalf("renewed certificate should be issued by the new CA for the client key: %v", err)
+	}
+
+	if _, err := ts.AuthenticatePeer(alice.Token, renewedCert); err != nil {
+		t.Fatalf("renewed certificate refused: %v", err)
+	}
+
+	if err := verifyPeer(t, tlsConfig, alice.Certificate); !errors.Is(err, ErrCertificateRevoked) {
+		t.Fatalf("renewed certificate should be superseded, got %v", err)
+	}
+
+	if _, err := ts.UserRenewCertificate("garbage", csrPEM); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("renewal with an invalid token should fail with ErrUnauthenticated, got %v", err)
+	}
+
+	// Retiring the previous CA, once the window is over.
+	if err := ts.certs.RetireUsersCA(); err != nil {
+		t.Fatalf("RetireUsersCA: %v", err)
+	}
+
+	if _, err := ts.UsersRotateCA(time.Second); err != nil {
+		t.Fatalf("UsersRotateCA: %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if rotation, err := ts.UsersCARotation(); err != nil || rotation != nil {
+		t.Fatalf("rotation should be retired at the end of its window, got %+v (%v)", rotation, err)
+	}
+
+	events, _ := ts.AuditEvents(AuditFilter{Action: "ca"})
+	if len(events) != 3 || events[2].Action != AuditCARetire {
+		t.Fatalf("expected 2 rotations and a retirement in the audit trail, got %+v", events)
+	}
+}
+
+func pemBytes(t *testing.T, certPEM string) []byte {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		t.Fatal("failed to decode certificate PEM")
+	}
+
+	return block.Bytes
+}
+
+// verifyPeer verifies a client certificate as the Mutual TLS handshake does with a configuration:
+// against its client CAs first, and then with its VerifyPeerCertificate function.
+func verifyPeer(t *testing.T, tlsConfig *tls.Config, certPEM string) error {
+	t.Helper()
+
+	cert, err := x509.ParseCertificate(pemBytes(t, certPEM))
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:     tlsConfig.ClientCAs,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return err
+	}
+
+	return tlsConfig.VerifyPeerCertificate([][]byte{cert.Raw}, chains)
+}
+
+// TestUsersRotateCAHostVerification checks that during a users CA rotation, the standard TLS
+// verification of teamclients (client.WithHostVerification()) trusting the previous CA only
+// accepts the server certificate, sent along the new CA cross-signed by the previous one.
+func TestUsersRotateCAHostVerification(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Certificates.ServerNames = []string{"localhost"}
+
+	config, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.UsersRotateCA(time.Hour); err != nil {
+		t.Fatalf("UsersRotateCA: %v", err)
+	}
+
+	serverConfig, err := ts.UsersTLSConfig()
+	if err != nil {
+		t.Fatalf("UsersTLSConfig: %v", err)
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	clientCert, err := tls.X509KeyPair([]byte(config.Certificate), []byte(config.PrivateKey))
+	if err != nil {
+		t.Fatalf("client certificate: %v", err)
+	}
+
+	previousCA := x509.NewCertPool()
+	previousCA.AppendCertsFromPEM([]byte(config.CACertificate))
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      previousCA,
+		ServerName:   "localhost",
+	})
+	if err != nil {
+		t.Fatalf("server certificate refused by the standard verification: %v", err)
+	}
+	defer conn.Close()
+
+	if chains := conn.ConnectionState().VerifiedChains; len(chains) == 0 || len(chains[0]) != 3 {
+		t.Fatal("server certificate should be verified through the cross-signed new CA")
+	}
+}
+
+// TestCARotationWindowParsedOnce checks that the CA rotation window is parsed when the
+// configuration is saved, an invalid duration being replaced by the default.
+func TestCARotationWindowParsedOnce(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Certificates.RotationWindow = "invalid"
+	saveTestConfig(t, ts)
+
+	if window := ts.caRotationWindow(); window != defaultCARotationWindow {
+		t.Fatalf("invalid CA rotation window should be replaced by %s, got %s", defaultCARotationWindow, window)
+	}
+
+	ts.opts.config.Certificates.RotationWindow = "1h"
+
+	if ts.caRotationWindow() != defaultCARotationWindow {
+		t.Fatal("CA rotation window should only be parsed when the configuration is saved")
+	}
+}
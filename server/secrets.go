@@ -0,0 +1,173 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/reeflective/team/internal/certs"
+)
+
+// SecretStore stores the private material of the teamserver certificate infrastructure:
+// the private keys of the users CA and of the certificates it issued. Secrets are named
+// with slash-separated paths (eg. "ca/user.key"). Implementations must be safe for
+// concurrent use, and can be given to the teamserver with server.WithCustomSecretStore().
+type SecretStore interface {
+	// Get returns a secret, or an error wrapping ErrSecretNotFound.
+	Get(name string) ([]byte, error)
+	// Put creates or replaces a secret.
+	Put(name string, secret []byte) error
+	// Delete removes a secret, if it exists.
+	Delete(name string) error
+	// List returns the names of all secrets, sorted.
+	List() ([]string, error)
+}
+
+// ErrSecretNotFound must be returned (possibly wrapped) by secret stores getting a secret they don't have.
+var ErrSecretNotFound = certs.ErrSecretNotFound
+
+// Built-in secret stores, selected with server.WithSecretStore(), or
+// the certificates.secret_store of the teamserver configuration.
+const (
+	// SecretStoreFilesystem keeps each private key in a file of the teamserver directory.
+	SecretStoreFilesystem = "filesystem"
+	// SecretStoreDatabase keeps private keys in the teamserver database.
+	SecretStoreDatabase = "database"
+	// SecretStoreEncryptedFile keeps each private key in a file of the teamserver directory,
+	// encrypted (AES-256-GCM) with a key derived from the secrets passphrase.
+	SecretStoreEncryptedFile = "encrypted-file"
+
+	customSecretStore = "custom"
+)
+
+// SecretStores returns the names of the built-in secret stores.
+func SecretStores() []string {
+	return []string{SecretStoreFilesystem, SecretStoreDatabase, SecretStoreEncryptedFile}
+}
+
+// SecretStoreName returns the name of the secret store in which the teamserver
+// keeps its private keys, or "custom" if given with server.WithCustomSecretStore().
+func (ts *Server) SecretStoreName() string {
+	switch {
+	case ts.opts.secrets != nil:
+		return customSecretStore
+	case ts.opts.secretStore != "":
+		return ts.opts.secretStore
+	case ts.opts.config.Certificates.SecretStore != "":
+		return ts.opts.config.Certificates.SecretStore
+	default:
+		return SecretStoreFilesystem
+	}
+}
+
+// SecretsMigrate moves all private keys of the teamserver to another built-in secret store,
+// and saves it in the teamserver configuration, so that it is used from now on. If the
+// store is set with server.WithSecretStore(), the option must be changed accordingly.
+// Returns the number of secrets moved.
+func (ts *Server) SecretsMigrate(name string) (int, error) {
+	if err := ts.initCerts(); err != nil {
+		return 0, err
+	}
+
+	current := ts.SecretStoreName()
+
+	if current == customSecretStore {
+		return 0, ts.errorf("%w: the teamserver uses a custom secret store", ErrSecretStore)
+	} else if current == name {
+		return 0, ts.errorf("%w: private keys are already in the %s store", ErrSecretStore, name)
+	}
+
+	store, err := ts.newSecretStore(name)
+	if err != nil {
+		return 0, err
+	}
+
+	// The store is recorded once all keys are copied, and before they are removed from the
+	// current one: on failure, the copies are removed instead, and the current store is kept.
+	moved, err := ts.certs.MigrateSecrets(store, func() error {
+		cfg := ts.GetConfig()
+		previous := cfg.Certificates.SecretStore
+		cfg.Certificates.SecretStore = name
+
+		if err := ts.SaveConfig(cfg); err != nil {
+			cfg.Certificates.SecretStore = previous
+			return err
+		}
+
+		ts.opts.secretStore = name
+
+		return nil
+	})
+	if err != nil {
+		return moved, ts.errorf("%w: failed to move private keys: %w", ErrSecretStore, err)
+	}
+
+	ts.audit(AuditSecretsMigrate, name, fmt.Sprintf("%d secrets moved from the %s store", moved, current))
+
+	return moved, nil
+}
+
+// secretStore returns the secret store of the teamserver private keys.
+func (ts *Server) secretStore() (certs.SecretStore, error) {
+	if ts.opts.secrets != nil {
+		return ts.opts.secrets, nil
+	}
+
+	return ts.newSecretStore(ts.SecretStoreName())
+}
+
+// newSecretStore returns a built-in secret store.
+func (ts *Server) newSecretStore(name string) (certs.SecretStore, error) {
+	switch name {
+	case SecretStoreFilesystem:
+		return certs.NewFileSecretStore(ts.fs, filepath.Join(ts.TeamDir(), "secrets")), nil
+
+	case SecretStoreDatabase:
+		if err := ts.initDatabase(); err != nil {
+			return nil, ts.errorf("%w: %w", ErrDatabase, err)
+		}
+
+		return certs.NewDatabaseSecretStore(ts.Database()), nil
+
+	case SecretStoreEncryptedFile:
+		certificates := ts.opts.config.Certificates
+		passphrase, err := ts.readPassphrase("Secrets passphrase", ts.opts.secretsKey, "SECRETS_PASSPHRASE",
+			certificates.SecretsPassphrase, certificates.SecretsPassphraseFile)
+		if err != nil {
+			return nil, ts.errorf("%w: %w", ErrSecretStore, err)
+		}
+
+		if len(passphrase) == 0 {
+			return nil, ts.errorf("%w: the %s store requires a passphrase (%s_SECRETS_PASSPHRASE or certificates.secrets_passphrase_file)",
+				ErrSecretStore, name, strings.ToUpper(ts.Name()))
+		}
+
+		store, err := certs.NewEncryptedFileSecretStore(ts.fs, filepath.Join(ts.TeamDir(), "secrets.enc"), passphrase)
+		if err != nil {
+			return nil, ts.errorf("%w: %w", ErrSecretStore, err)
+		}
+
+		return store, nil
+
+	default:
+		return nil, ts.errorf("%w: unknown secret store %q (must be one of %v)", ErrSecretStore, name, SecretStores())
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestSecretsMigrate checks that the private keys of the teamserver are moved
+// between the built-in secret stores, and that the new store is saved.
+func TestSecretsMigrate(t *testing.T) {
+	ts, err := New("secrets", WithInMemory(), WithSecretsPassphrase("secret"))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	for _, store := range []string{SecretStoreDatabase, SecretStoreEncryptedFile} {
+		moved, err := ts.SecretsMigrate(store)
+		if err != nil || moved < 2 {
+			t.Fatalf("SecretsMigrate to %s moved %d secrets: %v", store, moved, err)
+		}
+
+		if ts.SecretStoreName() != store || ts.GetConfig().Certificates.SecretStore != store {
+			t.Fatalf("secret store %s was not saved", store)
+		}
+
+		if _, err := ts.UserCreate("bob-"+store, "localhost", 31337); err != nil {
+			t.Fatalf("UserCreate with the %s store: %v", store, err)
+		}
+	}
+
+	if _, err := ts.SecretsMigrate(SecretStoreEncryptedFile); !errors.Is(err, ErrSecretStore) {
+		t.Fatalf("migrating to the current store should fail with ErrSecretStore, got %v", err)
+	}
+
+	if _, err := ts.SecretsMigrate("vault"); !errors.Is(err, ErrSecretStore) {
+		t.Fatalf("migrating to an unknown store should fail with ErrSecretStore, got %v", err)
+	}
+
+	// The encrypted store cannot be used without its passphrase.
+	locked, err := New("secretsnokey", WithInMemory(), WithSecretStore(SecretStoreEncryptedFile))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if _, err := locked.UserCreate("alice", "localhost", 31337); !errors.Is(err, ErrSecretStore) {
+		t.Fatalf("using the encrypted store without passphrase should fail with ErrSecretStore, got %v", err)
+	}
+}
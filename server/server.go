@@ -139,6 +139,13 @@ func (ts *Server) ServeDaemon(host string, port uint16, opts ...Options) (err er
 		return err
 	}
 
+	// Other teamserver processes must not change the files of a running daemon.
+	if err := ts.writeDaemonPID(); err != nil {
+		log.Warn(err.Error())
+	}
+
+	defer ts.removeDaemonPID()
+
 	// Now that the main teamserver listener is started,
 	// we can start all our persistent teamserver listeners.
 	// That way, if any of them collides with our current bind,
@@ -193,11 +200,19 @@ func (ts *Server) ServeAddr(name string, host string, port uint16, opts ...Optio
 	}
 
 	// Generate the listener ID now so we can return it.
-	listenerID := getRandomID()
+	listenerID, err := getRandomID()
+	if err != nil {
+		return "", ts.errorf("%w: %w", ErrListener, err)
+	}
 
 	err = ts.serve(handler, listenerID, host, port, opts...)
+	if err != nil {
+		return listenerID, err
+	}
+
+	ts.audit(AuditListenerStart, listenerID, fmt.Sprintf("%s %s:%d", handler.Name(), host, port))
 
-	return listenerID, err
+	return listenerID, nil
 }
 
 // serve will attempt to serve a given listener/server stack to a given (host:port) address.
@@ -213,6 +228,13 @@ func (ts *Server) serve(ln Handler, ID, host string, port uint16, opts ...Option
 		return ts.errorf("%w: %w", ErrTeamServer, err)
 	}
 
+	if ID == "" {
+		ID, err = getRandomID()
+		if err != nil {
+			return ts.errorWith(log, "%w: %w", ErrListener, err)
+		}
+	}
+
 	// Let the handler initialize itself: load everything it needs from
 	// the server, configuration, fetch certificates, log stuff, etc.
 	err = ln.Init(ts)
@@ -232,6 +254,9 @@ func (ts *Server) serve(ln Handler, ID, host string, port uint16, opts ...Option
 	// The server is running, so add a job anyway.
 	ts.addListenerJob(ID, ln.Name(), host, int(port), listener)
 
+	// Check certificates for as long as there are listeners.
+	ts.startCertMonitor()
+
 	return nil
 }
 
@@ -276,16 +301,47 @@ func (ts *Server) init(opts ...Options) error {
 // It is called both from init() (the serve path) and directly from the user/
 // certificate methods (UserCreate, UsersTLSConfig, ...), so that these keep
 // working when driven from the CLI without ever starting a listener.
-func (ts *Server) initCerts() (err error) {
+// If it fails, all later calls return the same error.
+func (ts *Server) initCerts() error {
 	ts.certsInit.Do(func() {
-		if err = ts.initDatabase(); err != nil {
-			return
-		}
-
-		// Will make the code panic if unable to work properly.
-		certsLog := ts.NamedLogger("certs", "certificates")
-		ts.certs = certs.NewManager(ts.fs, ts.Database(), certsLog, ts.Name(), ts.TeamDir())
+		ts.certsErr = ts.newCertificateManager()
 	})
 
-	return err
+	return ts.certsErr
+}
+
+// newCertificateManager initializes the certificate infrastructure.
+func (ts *Server) newCertificateManager() error {
+	if err := ts.initDatabase(); err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	// Certificates are issued with the profiles of the configuration.
+	ts.opts.config = ts.GetConfig()
+
+	profiles, err := ts.certificateProfiles()
+	if err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	secrets, err := ts.secretStore()
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := ts.caPassphrase()
+	if err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	certsLog := ts.NamedLogger("certs", "certificates")
+
+	manager, err := certs.NewManager(ts.fs, ts.Database(), certsLog, ts.Name(), ts.TeamDir(), profiles, passphrase, secrets)
+	if err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	ts.certs = manager
+
+	return nil
 }
@@ -0,0 +1,214 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Session represents a single teamclient connected to one of the teamserver
+// handlers (transport stacks). A user can have any number of sessions at once
+// (one per connected client), and each session can be individually kicked.
+//
+// Sessions are registered and removed by server.Handler implementations, which
+// are the only ones to know when a client connection is actually established
+// and closed: see the Server.SessionAdd() and Server.SessionRemove() methods.
+type Session struct {
+	ID            string    // Unique ID of the session.
+	User          string    // Name of the authenticated user owning the session.
+	Credential    string    // Label of the user credential used by the client, if known.
+	Handler       string    // Name of the handler (transport stack) serving the client.
+	RemoteAddr    string    // Remote address of the connected client.
+	ConnectedAt   time.Time // Time at which the session was registered.
+	ClientVersion string    // Version of the teamclient, if the handler knows it.
+}
+
+// session is a registered session and the connection it must close when kicked.
+type session struct {
+	Session
+	conn io.Closer
+}
+
+// sessions is the registry of all live teamclient sessions.
+type sessions struct {
+	active *sync.Map
+}
+
+func newSessions() *sessions {
+	return &sessions{
+		active: &sync.Map{},
+	}
+}
+
+// Get - Get a session by ID.
+func (s *sessions) Get(id string) *session {
+	if id == "" {
+		return nil
+	}
+
+	val, ok := s.active.Load(id)
+	if ok {
+		return val.(*session)
+	}
+
+	return nil
+}
+
+// count returns the number of live sessions for a given user.
+func (s *sessions) count(user string) int {
+	var count int
+
+	s.active.Range(func(_, value any) bool {
+		if value.(*session).User == user {
+			count++
+		}
+
+		return true
+	})
+
+	return count
+}
+
+// SessionAdd registers a new live session for an authenticated teamclient connection.
+// The conn parameter is the connection (or any other resource) that must be closed for
+// the client to be effectively disconnected when the session is kicked: it should not
+// be nil, otherwise the session cannot be kicked.
+// The session ID and connection time are filled by the teamserver, and the ID returned,
+// or an ErrSecureRandFailed error if no ID can be generated (the session is not added).
+//
+// This is to be used by server.Handler implementations, once they have authenticated
+// a client connection. Handlers must call SessionRemove() when the connection closes.
+func (ts *Server) SessionAdd(sess Session, conn io.Closer) (string, error) {
+	log := ts.NamedLogger("server", "sessions")
+
+	sess.ConnectedAt = time.Now()
+
+	// A session must never replace another one, which could not be listed or kicked anymore.
+	for {
+		id, err := getRandomID()
+		if err != nil {
+			return "", ts.errorWith(log, "%w", err)
+		}
+
+		sess.ID = id
+
+		_, exists := ts.sessions.active.LoadOrStore(sess.ID, &session{
+			Session: sess,
+			conn:    conn,
+		})
+		if !exists {
+			break
+		}
+	}
+
+	log.Info(fmt.Sprintf("User %s connected from %s (%s, session %s)",
+		sess.User, sess.RemoteAddr, sess.Handler, formatSmallID(sess.ID)))
+
+	return sess.ID, nil
+}
+
+// SessionRemove unregisters a session, without closing its connection.
+// Handlers call it when a client connection is closed, for whatever reason.
+// Removing an unknown (or already removed) session is a no-op.
+func (ts *Server) SessionRemove(id string) {
+	log := ts.NamedLogger("server", "sessions")
+
+	val, found := ts.sessions.active.LoadAndDelete(id)
+	if !found {
+		return
+	}
+
+	sess := val.(*session)
+	log.Info(fmt.Sprintf("User %s disconnected from %s (session %s)",
+		sess.User, sess.RemoteAddr, formatSmallID(sess.ID)))
+}
+
+// Sessions returns all live teamclient sessions, ordered by connection time.
+func (ts *Server) Sessions() []Session {
+	all := []Session{}
+
+	ts.sessions.active.Range(func(_, value any) bool {
+		all = append(all, value.(*session).Session)
+		return true
+	})
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].ConnectedAt.Before(all[j].ConnectedAt)
+	})
+
+	return all
+}
+
+// KickSession terminates a live session by ID: the connection of the teamclient
+// is closed and the session is removed from the registry. The user is NOT deleted
+// and its credentials remain valid, so the client is free to reconnect: use the
+// server.UserDelete() method to permanently revoke a user.
+//
+// Returns an ErrSessionNotFound if no session exists with this ID.
+func (ts *Server) KickSession(id string) error {
+	sess := ts.sessions.Get(id)
+	if sess == nil {
+		return ts.errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	if err := ts.kickSession(id); err != nil {
+		return err
+	}
+
+	ts.audit(AuditSessionKick, sess.User, "session "+formatSmallID(sess.ID))
+
+	return nil
+}
+
+// kickSession closes a live session, without recording it in the audit
+// trail: the action kicking it (eg. deleting its user) is recorded instead.
+func (ts *Server) kickSession(id string) error {
+	sess := ts.sessions.Get(id)
+	if sess == nil {
+		return ts.errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	log := ts.NamedLogger("server", "sessions")
+	log.Warn(fmt.Sprintf("Kicking user %s session %s (%s)", sess.User, formatSmallID(sess.ID), sess.RemoteAddr))
+
+	ts.SessionRemove(sess.ID)
+
+	if sess.conn == nil {
+		return nil
+	}
+
+	if err := sess.conn.Close(); err != nil {
+		return ts.errorWith(log, "%w: failed to close session connection: %w", ErrTeamServer, err)
+	}
+
+	return nil
+}
+
+// formatSmallID returns a smallened ID for logging.
+func formatSmallID(id string) string {
+	if len(id) <= 8 {
+		return id
+	}
+
+	return id[:8]
+}
@@ -0,0 +1,150 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// closer counts how many times a session connection was closed.
+type closer struct {
+	closed int
+}
+
+func (c *closer) Close() error {
+	c.closed++
+	return nil
+}
+
+// TestSessionsRegistry checks that sessions registered by handlers are listed,
+// counted per user in Users(), and removed without closing their connection.
+func TestSessionsRegistry(t *testing.T) {
+	ts := newTestServer(t)
+
+	if _, err := ts.UserCreate("alice", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.UserCreate("bob", "localhost", 31337); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	conn := &closer{}
+	first := addSession(t, ts, Session{User: "alice", Handler: "test", RemoteAddr: "10.0.0.1:5000", ClientVersion: "v1.0.0"}, conn)
+	second := addSession(t, ts, Session{User: "alice", Handler: "test", RemoteAddr: "10.0.0.2:5000"}, conn)
+
+	if first == "" || first == second {
+		t.Fatalf("expected distinct session IDs, got %q and %q", first, second)
+	}
+
+	sessions := ts.Sessions()
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+
+	if sessions[0].ID != first || sessions[0].ClientVersion != "v1.0.0" || sessions[0].ConnectedAt.IsZero() {
+		t.Fatalf("unexpected first session: %+v", sessions[0])
+	}
+
+	users, err := ts.Users()
+	if err != nil {
+		t.Fatalf("Users: %v", err)
+	}
+
+	for _, user := range users {
+		switch user.Name {
+		case "alice":
+			if !user.Online || user.Clients != 2 {
+				t.Fatalf("alice should be online with 2 clients, got %+v", user)
+			}
+		case "bob":
+			if user.Online || user.Clients != 0 {
+				t.Fatalf("bob should be offline with no clients, got %+v", user)
+			}
+		}
+	}
+
+	ts.SessionRemove(first)
+	ts.SessionRemove(first) // No-op
+
+	if len(ts.Sessions()) != 1 {
+		t.Fatalf("expected 1 session after removal, got %d", len(ts.Sessions()))
+	}
+
+	if conn.closed != 0 {
+		t.Fatal("removing a session must not close its connection")
+	}
+}
+
+// TestKickSession checks that kicking a session closes its connection and
+// removes it, and that unknown sessions are reported as such.
+// addSession registers a session, failing the test if it cannot be.
+func addSession(t *testing.T, ts *Server, sess Session, conn io.Closer) string {
+	t.Helper()
+
+	id, err := ts.SessionAdd(sess, conn)
+	if err != nil {
+		t.Fatalf("SessionAdd: %v", err)
+	}
+
+	return id
+}
+
+func TestKickSession(t *testing.T) {
+	ts := newTestServer(t)
+
+	conn := &closer{}
+	id := addSession(t, ts, Session{User: "alice", Handler: "test", RemoteAddr: "10.0.0.1:5000"}, conn)
+
+	if err := ts.KickSession(id); err != nil {
+		t.Fatalf("KickSession: %v", err)
+	}
+
+	if conn.closed != 1 {
+		t.Fatalf("kicked session connection should be closed once, got %d", conn.closed)
+	}
+
+	if len(ts.Sessions()) != 0 {
+		t.Fatal("kicked session should be removed from the registry")
+	}
+
+	if err := ts.KickSession(id); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("kicking an unknown session should return ErrSessionNotFound, got %v", err)
+	}
+}
+
+// TestSessionIDsUnique checks that sessions registered at the same time all get their own ID,
+// so that none of them replaces another one.
+func TestSessionIDsUnique(t *testing.T) {
+	ts := newTestServer(t)
+
+	const count = 1000
+
+	ids := make(map[string]bool, count)
+
+	for range count {
+		ids[addSession(t, ts, Session{User: "alice", Handler: "test"}, &closer{})] = true
+	}
+
+	if len(ids) != count || len(ts.Sessions()) != count {
+		t.Fatalf("%d sessions registered, with %d IDs and %d listed", count, len(ids), len(ts.Sessions()))
+	}
+}
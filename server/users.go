@@ -19,6 +19,7 @@ package server
 */
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
@@ -27,7 +28,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
-	"sync"
+	"slices"
 	"time"
 
 	"github.com/reeflective/team"
@@ -44,12 +45,17 @@ var namePattern = regexp.MustCompile("^[a-zA-Z0-9_-]*$") // Only allow alphanume
 // Certificate files and the API authentication token are saved into the teamserver database,
 // conformingly to its configured backend/filesystem (can be in-memory or on filesystem).
 //
+// The returned configuration is the user "default" credential. Creating a user that already
+// exists replaces its default credential only: the previous default certificate is revoked,
+// and the sessions established with it are closed, but all the other credentials of the user
+// (see server.UserCredentialAdd()) are kept.
+//
 // The teamserver stores only the identity and its credentials: it has no notion of
 // user permissions/roles. Applications that need per-user authorization own that model
 // themselves (a separate table keyed by name), and enforce it in their own middleware.
 func (ts *Server) UserCreate(name string, lhost string, lport uint16) (*client.Config, error) {
 	if err := ts.initCerts(); err != nil {
-		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+		return nil, err
 	}
 
 	if !namePattern.MatchString(name) {
@@ -68,59 +74,38 @@ func (ts *Server) UserCreate(name string, lhost string, lport uint16) (*client.C
 		lport = uint16(ts.opts.config.DaemonMode.Port)
 	}
 
-	rawToken, err := ts.newUserToken()
-	if err != nil {
-		return nil, ts.errorf("%w: %w", ErrUserConfig, err)
-	}
-
 	// Enforce one identity per name. A teamserver user is keyed by its Name (the
 	// application authorization model resolves permissions against it), so a user
-	// name must map to exactly one record. Re-creating an existing user therefore
-	// ROTATES its credentials in place rather than inserting a duplicate row:
-	// delete any user(s) currently holding this name before saving the new one.
-	// The previously issued token/certificate for this name are thereby revoked.
-	if err = ts.Database().Where(&db.User{Name: name}).Delete(&db.User{}).Error; err != nil {
+	// name must map to exactly one record, which is kept if it already exists.
+	err := ts.Database().Where(&db.User{Name: name}).FirstOrCreate(&db.User{Name: name}).Error
+	if err != nil {
 		return nil, ts.errorf("%w: %w", ErrDatabase, err)
 	}
-	ts.userTokens = &sync.Map{}
-
-	digest := sha256.Sum256([]byte(rawToken))
-	dbuser := &db.User{
-		Name:  name,
-		Token: hex.EncodeToString(digest[:]),
-	}
 
-	err = ts.Database().Save(dbuser).Error
-	if err != nil {
-		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	// The previous default certificate is not only dropped, but revoked,
+	// since it would otherwise remain valid against the users CA.
+	if err = ts.credentialDelete(name, DefaultCredential, certs.ReasonSuperseded); err != nil {
+		return nil, err
 	}
 
-	publicKey, privateKey, err := ts.certs.UserClientGenerateCertificate(name)
+	config, err := ts.newCredential(name, DefaultCredential, lhost, lport)
 	if err != nil {
-		return nil, ts.errorf("%w: failed to generate certificate %w", ErrCertificate, err)
+		return nil, err
 	}
 
-	caCertPEM, _, _ := ts.certs.GetUsersCAPEM()
-	config := client.Config{
-		User:          name,
-		Token:         rawToken,
-		Host:          lhost,
-		Port:          int(lport),
-		CACertificate: string(caCertPEM),
-		PrivateKey:    string(privateKey),
-		Certificate:   string(publicKey),
-	}
+	ts.audit(AuditUserCreate, name, "")
 
-	return &config, nil
+	return config, nil
 }
 
-// UserDelete deletes a user and its cryptographic materials from
-// the teamserver database, clearing the API auth tokens cache.
+// UserDelete deletes a user, all its credentials and cryptographic materials
+// from the teamserver database, clearing the API auth tokens cache.
 //
 // WARN: This function has two very precise effects/consequences:
 //  1. The server-side Mutual TLS configuration obtained with server.UsersTLSConfig()
 //     will refuse all connections using the deleted user TLS credentials, returning
-//     an authentication failure.
+//     an authentication failure: the user certificates are revoked, not only deleted.
+//     All live sessions of the user are closed.
 //  2. The server.Authenticate(token) method will always return an ErrUnauthenticated
 //     error from the call, because the delete user is not in the database anymore.
 //
@@ -131,7 +116,7 @@ func (ts *Server) UserCreate(name string, lhost string, lport uint16) (*client.C
 // conformingly to its configured backend/filesystem (can be in-memory or on filesystem).
 func (ts *Server) UserDelete(name string) error {
 	if err := ts.initCerts(); err != nil {
-		return ts.errorf("%w: %w", ErrDatabase, err)
+		return err
 	}
 
 	err := ts.Database().Where(&db.User{
@@ -141,16 +126,37 @@ func (ts *Server) UserDelete(name string) error {
 		return err
 	}
 
-	// Clear the token cache so that all requests from
-	// connected clients of this user are now refused.
-	ts.userTokens = &sync.Map{}
+	err = ts.Database().Where(&db.Credential{UserName: name}).Delete(&db.Credential{}).Error
+	if err != nil {
+		return ts.errorf("%w: %w", ErrDatabase, err)
+	}
 
-	return ts.certs.UserClientRemoveCertificate(name)
+	// Clear the cached tokens of the user so that all requests
+	// from its connected clients are now refused.
+	ts.authCache.invalidateUser(name)
+
+	// Revoke the certificates, so that they are refused during
+	// the TLS handshake, and close all the user live sessions.
+	if err := ts.certs.UserClientRevokeCertificates(name, certs.ReasonCessationOfOperation); err != nil {
+		return ts.errorf("%w: %w", ErrCertificate, err)
+	}
+
+	ts.kickUserSessions(name)
+
+	if err := ts.certs.UserClientRemoveCertificates(name); err != nil {
+		return err
+	}
+
+	ts.audit(AuditUserDelete, name, "")
+
+	return nil
 }
 
 // Authenticate is the teamserver's authentication primitive: it accepts a raw
 // 128-bits long API authentication token belonging to a connected/connecting
-// teamclient, hashes it, and checks it against the teamserver users database.
+// teamclient, hashes it, and checks it against the teamserver users credentials.
+// Tokens unknown to the teamserver are then verified by the authenticators given
+// with server.WithAuthenticators(), if any, in order (see server.Authenticator()).
 //
 // On success it returns the authenticated user's identity (the registered
 // team.User: name and registry metadata, NO permissions). On failure it returns
@@ -162,72 +168,182 @@ func (ts *Server) UserDelete(name string) error {
 // into the request context from their own transport middleware. This call is the
 // single seam through which an embedding application learns "who is calling".
 //
-// This call updates the last time the user has been seen by the server.
+// This call updates the last time the user (and its credential) has been seen by the server.
+// Handlers serving clients over Mutual TLS should use server.AuthenticatePeer()
+// instead, which additionally binds the token to the user client certificate.
 func (ts *Server) Authenticate(rawToken string) (*team.User, error) {
+	return ts.authenticateCredentials(team.Credentials{Token: rawToken})
+}
+
+// UserRotateToken issues a new API authentication token for a credential of an existing user,
+// and returns it along with its expiration time (zero if the token never expires, see Config).
+// Unlike server.UserCreate(), the credential is kept as is (with its certificate): only its
+// token changes, and the previous one remains valid during the grace period set in the
+// teamserver configuration, so that connected clients can switch to the new one.
+//
+// Rotating twice within a grace period invalidates the first token immediately.
+func (ts *Server) UserRotateToken(name, label string) (string, time.Time, error) {
 	if err := ts.initDatabase(); err != nil {
-		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+		return "", time.Time{}, ts.errorf("%w: %w", ErrDatabase, err)
 	}
 
-	log := ts.NamedLogger("server", "auth")
-	log.Debug(fmt.Sprintf("Authenticating user token ..."))
+	rawToken, expiresAt, err := ts.rotateToken(name, label)
+	if err != nil {
+		return "", time.Time{}, err
+	}
 
-	// Check auth cache
-	digest := sha256.Sum256([]byte(rawToken))
-	token := hex.EncodeToString(digest[:])
+	ts.audit(AuditTokenRotate, name, "credential "+label)
 
-	userFound, ok := ts.userTokens.Load(token)
+	return rawToken, expiresAt, nil
+}
 
-	// If user is already connected or cached.
-	if ok {
-		user := userFound.(*team.User)
+// UserRefreshToken is the counterpart of server.UserRotateToken() for teamclients: it
+// rotates the token of the credential to which a raw API token belongs, and returns the
+// new one along with its expiration time. Handlers serve this call to clients refreshing
+// their own token (the token must have been authenticated first), so the rotation is
+// recorded in the audit trail as performed by the user, with a remote call.
+func (ts *Server) UserRefreshToken(rawToken string) (string, time.Time, error) {
+	cred, err := ts.TokenCredential(rawToken)
+	if err != nil {
+		return "", time.Time{}, err
+	}
 
-		log.Debug(fmt.Sprintf("Token in cache!"))
-		ts.updateLastSeen(user.Name)
+	newToken, expiresAt, err := ts.rotateToken(cred.User, cred.Label)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	ts.auditAs(cred.User, AuditOriginRPC, AuditTokenRefresh, cred.User, "credential "+cred.Label)
+
+	return newToken, expiresAt, nil
+}
+
+// rotateToken issues a new API token for a user credential, keeping the previous one valid
+// during the grace period, and returns it with its expiration time (see UserRotateToken).
+func (ts *Server) rotateToken(name, label string) (string, time.Time, error) {
+	cred, err := ts.credentialByLabel(name, label)
+	if err != nil {
+		return "", time.Time{}, ts.errorf("%w: user %s has no credential '%s': %w", ErrUserConfig, name, label, err)
+	}
 
-		return user, nil
+	rawToken, err := ts.newUserToken()
+	if err != nil {
+		return "", time.Time{}, ts.errorf("%w: %w", ErrUserConfig, err)
 	}
 
-	dbUser, err := ts.userByToken(token)
-	if err != nil || dbUser == nil {
-		return nil, ts.errorf("%w: %w", ErrUnauthenticated, err)
+	_, grace := ts.tokenLifetimes()
+	expiresAt := ts.newTokenExpiry()
+
+	// Never extend the validity of the previous token past its own expiry.
+	previousExpiresAt := time.Now().Add(grace)
+	if !cred.TokenExpiresAt.IsZero() && cred.TokenExpiresAt.Before(previousExpiresAt) {
+		previousExpiresAt = cred.TokenExpiresAt
 	}
 
-	// Transfer data to the exportable identity type (no authorization data).
-	user := &team.User{
-		Name: dbUser.Name,
+	err = ts.Database().Model(cred).Select("Token", "TokenExpiresAt", "PreviousToken", "PreviousTokenExpiresAt").
+		Updates(&db.Credential{
+			Token:                  hashToken(rawToken),
+			TokenExpiresAt:         expiresAt,
+			PreviousToken:          cred.Token,
+			PreviousTokenExpiresAt: previousExpiresAt,
+		}).Error
+	if err != nil {
+		return "", time.Time{}, ts.errorf("%w: %w", ErrDatabase, err)
 	}
-	ts.updateLastSeen(user.Name)
 
-	log.Debug(fmt.Sprintf("Valid user token for %s", user.Name))
-	ts.userTokens.Store(token, user)
+	// Cached tokens of the credential have outdated expiry times.
+	ts.authCache.invalidateCredential(name, label)
+
+	ts.NamedLogger("server", "auth").Info(fmt.Sprintf("Rotated API token of user %s (%s)", name, label))
+
+	return rawToken, expiresAt, nil
+}
+
+// AuthenticatePeer is the authentication primitive to use when the teamclient is connected
+// over Mutual TLS: in addition to the checks performed by server.Authenticate(), it requires
+// the API token to be used with the client certificate issued along with it. The peer
+// certificate common name must be the name of the token's user, and its serial number must
+// be the one of the certificate of the credential to which the token belongs.
+//
+// This prevents a token from being used with the TLS credentials of another user, with
+// those of another device of the same user, or with an older (superseded) certificate.
+// Users authenticated by other authenticators must also use a certificate bearing their name.
+// On failure, it returns a nil user and an ErrUnauthenticated (or ErrDatabase) error.
+func (ts *Server) AuthenticatePeer(rawToken string, cert *x509.Certificate) (*team.User, error) {
+	if cert == nil {
+		return nil, ts.errorWith(ts.NamedLogger("server", "auth"), "%w: no peer certificate", ErrUnauthenticated)
+	}
 
-	return user, nil
+	return ts.authenticateCredentials(team.Credentials{Token: rawToken, Certificate: cert})
 }
 
 // UsersTLSConfig returns a server-side Mutual TLS configuration struct, ready to run.
 // The configuration performs all and every verifications that the teamserver should do,
 // and peer TLS clients (teamclient.Config) are not allowed to choose any TLS parameters.
 //
+// During a users CA rotation (see server.UsersRotateCA()), both the previous and the new
+// CAs are trusted, and the server certificate is sent along the new CA cross-signed by the
+// previous one. The configuration follows CA changes made with the teamserver API (rotation,
+// import, and the end of a rotation window) for each new connection, without reloading,
+// as well as the renewals of the server certificate (see server.CheckCertificates()).
+//
 // This should be used by team/server.Handlers at the net.Listener/net.Conn level.
 // As for all errors of the teamserver API, any error returned here is defered-logged.
 func (ts *Server) UsersTLSConfig() (*tls.Config, error) {
-	log := ts.NamedLogger("certs", "mtls")
-
 	if err := ts.initCerts(); err != nil {
-		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+		return nil, err
+	}
+
+	state, err := ts.loadUsersTLS()
+	if err != nil {
+		return nil, err
 	}
 
+	tlsConfig := state.mtls.Clone()
+	tlsConfig.GetConfigForClient = ts.usersTLSForClient
+	tlsConfig.GetCertificate = ts.usersServerCertificate
+
+	return tlsConfig, nil
+}
+
+// newUsersTLS builds the Mutual TLS configurations of the current users CA.
+func (ts *Server) newUsersTLS() (*usersTLS, error) {
+	log := ts.NamedLogger("certs", "mtls")
+
 	caCertPtr, _, err := ts.certs.GetUsersCA()
 	if err != nil {
 		return nil, ts.errorWith(log, "%w: failed to get users certificate authority: %w", ErrCertificate, err)
 	}
 
+	previous, cross, err := ts.usersCARotation()
+	if err != nil {
+		return nil, ts.errorWith(log, "%w: failed to get users CA rotation: %w", ErrCertificate, err)
+	}
+
+	chain, err := ts.certs.UsersCAChain()
+	if err != nil {
+		return nil, ts.errorWith(log, "%w: failed to get users CA chain: %w", ErrCertificate, err)
+	}
+
+	// Client certificates are verified up to the root of the
+	// users CA, if it is an intermediate of an external PKI.
 	caCertPool := x509.NewCertPool()
-	caCertPool.AddCert(caCertPtr)
+	if len(chain) > 0 {
+		caCertPool.AddCert(chain[len(chain)-1])
+	} else {
+		caCertPool.AddCert(caCertPtr)
+	}
+
+	// Clients of the previous CA are still trusted until the end of the rotation.
+	if previous != nil {
+		caCertPool.AddCert(previous)
+	}
+
+	names := ts.serverCertificateNames()
 
 	_, _, err = ts.certs.UserServerGetCertificate()
 	if errors.Is(err, certs.ErrCertDoesNotExist) {
-		if _, _, err = ts.certs.UserServerGenerateCertificate(); err != nil {
+		if err = ts.generateServerCertificate(); err != nil {
 			return nil, ts.errorWith(log, "%s", err.Error())
 		}
 	}
@@ -249,11 +365,12 @@ func (ts *Server) UsersTLSConfig() (*tls.Config, error) {
 	// every remote handshake then failing with "tls: bad certificate" while the
 	// clients (whose bundles carry the NEW CA) reject the old server cert. If the
 	// cached cert no longer chains to the live CA or has expired, regenerate it
-	// and reload the key pair so the daemon self-heals on restart.
-	if !serverCertValidFor(cert, caCertPtr) {
-		log.Warn("server certificate does not match the current users CA (or expired); regenerating")
+	// and reload the key pair so the daemon self-heals on restart. The same goes
+	// when the daemon/listeners hosts or configured server names have changed.
+	if !serverCertValidFor(cert, caCertPtr, names) {
+		log.Warn("server certificate does not match the current users CA and server names (or expired); regenerating")
 
-		if _, _, err = ts.certs.UserServerGenerateCertificate(); err != nil {
+		if err = ts.generateServerCertificate(); err != nil {
 			return nil, ts.errorWith(log, "%w: failed to regenerate server certificate after CA change: %w", ErrCertificate, err)
 		}
 
@@ -268,26 +385,71 @@ func (ts *Server) UsersTLSConfig() (*tls.Config, error) {
 		}
 	}
 
-	tlsConfig := &tls.Config{
+	state := &usersTLS{names: names}
+
+	// The server certificate is sent along the users CA and its issuers
+	// but the root, so that clients can verify it up to the root.
+	if len(chain) > 0 {
+		cert.Certificate = append(cert.Certificate, caCertPtr.Raw)
+		for _, issuer := range chain[:len(chain)-1] {
+			cert.Certificate = append(cert.Certificate, issuer.Raw)
+		}
+	}
+
+	// Clients trusting only the previous CA verify the server
+	// certificate with the cross-signed certificate of the new one.
+	if cross != nil {
+		cert.Certificate = append(cert.Certificate, cross.Raw)
+		state.retireAt = cross.NotAfter
+	}
+
+	state.mtls = &tls.Config{
 		RootCAs:      caCertPool,
 		ClientAuth:   tls.RequireAndVerifyClientCert,
 		ClientCAs:    caCertPool,
 		Certificates: []tls.Certificate{cert},
 		MinVersion:   tls.VersionTLS13,
+
+		// Certificates of an external PKI are valid against its root, and
+		// revoked certificates are still valid against the users CA.
+		VerifyPeerCertificate: ts.verifyPeerCertificate(caCertPtr, previous),
 	}
 
 	if keyLogger := ts.certs.OpenTLSKeyLogFile(); keyLogger != nil {
-		tlsConfig.KeyLogWriter = ts.certs.OpenTLSKeyLogFile()
+		state.mtls.KeyLogWriter = keyLogger
 	}
 
-	return tlsConfig, nil
+	state.enroll = enrollmentTLSConfig(state.mtls, caCertPtr)
+
+	return state, nil
+}
+
+// enrollmentTLSConfig returns the TLS configuration used with clients asking for the
+// certs.EnrollmentServerName, which does not require any client certificate: those are
+// enrolling teamclients, which do not have one yet (see server.UserEnroll()).
+// The server certificate is sent along the users CA, which they authenticate with the
+// fingerprint contained in their invitation code. Token authentication still requires
+// a client certificate, so these connections can only be used for enrolling.
+func enrollmentTLSConfig(tlsConfig *tls.Config, ca *x509.Certificate) *tls.Config {
+	enrollConfig := tlsConfig.Clone()
+	enrollConfig.ClientAuth = tls.NoClientCert
+	enrollConfig.VerifyPeerCertificate = nil
+
+	cert := tlsConfig.Certificates[0]
+	if !slices.ContainsFunc(cert.Certificate, func(raw []byte) bool { return bytes.Equal(raw, ca.Raw) }) {
+		cert.Certificate = append(append([][]byte{}, cert.Certificate...), ca.Raw)
+	}
+	enrollConfig.Certificates = []tls.Certificate{cert}
+
+	return enrollConfig
 }
 
 // serverCertValidFor reports whether the leaf certificate of the given server
-// key pair is signed by ca (i.e. still chains to the current users-CA) and is
-// currently within its validity window. It is intentionally conservative: any
-// parse/verification failure returns false so the caller regenerates the cert.
-func serverCertValidFor(cert tls.Certificate, ca *x509.Certificate) bool {
+// key pair is signed by ca (i.e. still chains to the current users-CA), is
+// currently within its validity window, and is valid for all the given names.
+// It is intentionally conservative: any parse/verification failure returns
+// false so the caller regenerates the cert.
+func serverCertValidFor(cert tls.Certificate, ca *x509.Certificate, names []string) bool {
 	if ca == nil || len(cert.Certificate) == 0 {
 		return false
 	}
@@ -301,6 +463,12 @@ func serverCertValidFor(cert tls.Certificate, ca *x509.Certificate) bool {
 		return false
 	}
 
+	for _, name := range names {
+		if err := leaf.VerifyHostname(name); err != nil {
+			return false
+		}
+	}
+
 	now := time.Now()
 
 	return !now.Before(leaf.NotBefore) && !now.After(leaf.NotAfter)
@@ -308,22 +476,72 @@ func serverCertValidFor(cert tls.Certificate, ca *x509.Certificate) bool {
 
 // UsersGetCA returns the bytes of a PEM-encoded certificate authority,
 // which contains certificates of all users of this teamserver.
+// The CA certificate is followed by its issuers, if it is an intermediate.
+// The private key is encrypted with the CA passphrase, like with server.UsersExportCA():
+// without one, ErrExportPassphrase is returned.
 func (ts *Server) UsersGetCA() ([]byte, []byte, error) {
+	return ts.UsersExportCA("")
+}
+
+// UsersSaveCA accepts the public and private parts of a Certificate
+// Authority containing one or more users to add to the teamserver.
+func (ts *Server) UsersSaveCA(cert, key []byte) error {
 	if err := ts.initCerts(); err != nil {
-		return nil, nil, ts.errorf("%w: %w", ErrDatabase, err)
+		return err
+	}
+
+	if err := ts.certs.SaveUsersCA(cert, key); err != nil {
+		return ts.errorf("%w: failed to save users CA: %w", ErrCertificate, err)
 	}
 
-	return ts.certs.GetUsersCAPEM()
+	ts.resetUsersTLS()
+
+	ts.audit(AuditCAImport, "users", "")
+
+	return nil
 }
 
-// UsersSaveCA accepts the public and private parts of a Certificate
-// Authority containing one or more users to add to the teamserver.
-func (ts *Server) UsersSaveCA(cert, key []byte) {
+// UsersImportCA replaces the users CA with a PEM-encoded CA certificate and its private key,
+// after checking them. The CA certificate can be followed by its issuers, up to a self-signed
+// root: the users CA is then an intermediate of an external PKI (eg. of an organization).
+// All user and server certificates are issued by this intermediate, sent along its chain in
+// TLS handshakes and written into client configs, and client certificates are verified up to
+// the root. Certificates issued by the previous users CA are not trusted anymore: users need
+// new configs. Such a CA cannot be rotated with UsersRotateCA(): import a new one instead.
+// An encrypted private key (eg. from server.UsersExportCA()) is decrypted with the passphrase,
+// or with the CA passphrase if empty, and stored encrypted with the CA passphrase, if any.
+func (ts *Server) UsersImportCA(chainPEM, keyPEM []byte, passphrase string) error {
 	if err := ts.initCerts(); err != nil {
-		return
+		return err
+	}
+
+	if err := ts.certs.ImportUsersCA(chainPEM, keyPEM, []byte(passphrase)); err != nil {
+		return ts.errorf("%w: failed to import users CA: %w", ErrCertificate, err)
+	}
+
+	if err := ts.generateServerCertificate(); err != nil {
+		return ts.errorf("%w: failed to reissue server certificate: %w", ErrCertificate, err)
+	}
+
+	ts.resetUsersTLS()
+
+	chain, _ := ts.certs.UsersCAChain()
+	ts.audit(AuditCAImport, "users", fmt.Sprintf("%d issuers", len(chain)))
+
+	return nil
+}
+
+// usersCertificatesPEM returns, for a client configuration, the PEM-encoded users CA chain,
+// and a client certificate followed by the CA certificates to send along it, if any.
+func (ts *Server) usersCertificatesPEM(certPEM []byte) (caChainPEM, certChainPEM string) {
+	caPEM, _ := ts.certs.UsersCAChainPEM()
+
+	chainPEM, err := ts.certs.UsersCertificateChainPEM(certPEM)
+	if err != nil {
+		chainPEM = certPEM
 	}
 
-	ts.certs.SaveUsersCA(cert, key)
+	return string(caPEM), string(chainPEM)
 }
 
 // newUserToken - Generate a new user authentication token.
@@ -340,23 +558,83 @@ func (ts *Server) newUserToken() (string, error) {
 	return hex.EncodeToString(buf), nil
 }
 
-// userByToken - Select a teamserver user by token value.
-func (ts *Server) userByToken(value string) (*db.User, error) {
-	if len(value) < 1 {
-		return nil, db.ErrRecordNotFound
+// newTokenExpiry returns the expiration time of a token issued now.
+func (ts *Server) newTokenExpiry() time.Time {
+	expiry, _ := ts.tokenLifetimes()
+	if expiry == 0 {
+		return time.Time{}
 	}
 
-	user := &db.User{}
-	err := ts.Database().Where(&db.User{
-		Token: value,
-	}).First(user).Error
+	return time.Now().Add(expiry)
+}
 
-	return user, err
+// hashToken returns the hex-encoded SHA-256 digest of a raw API token.
+func hashToken(rawToken string) string {
+	digest := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(digest[:])
 }
 
-func (ts *Server) updateLastSeen(name string) {
-	lastSeen := time.Now().Round(1 * time.Second)
-	ts.Database().Model(&db.User{}).Where("name", name).Update("LastSeen", lastSeen)
+// authenticate checks a raw API token against the cache or the credentials
+// database, and returns the authenticated token with its user and credential.
+func (ts *Server) authenticate(rawToken string) (*cachedToken, error) {
+	if err := ts.initDatabase(); err != nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	log := ts.NamedLogger("server", "auth")
+	log.Debug(fmt.Sprintf("Authenticating user token ..."))
+
+	// Check auth cache
+	token := hashToken(rawToken)
+
+	// If user is already connected or cached.
+	if cached, ok := ts.authCache.get(token); ok {
+		if cached.expired() {
+			ts.authCache.delete(token)
+			return nil, ts.errorf("%w: %w (user %s)", ErrUnauthenticated, ErrTokenExpired, cached.user.Name)
+		}
+
+		log.Debug(fmt.Sprintf("Token in cache!"))
+		ts.recordLastSeen(cached.user.Name, cached.credential)
+
+		return cached, nil
+	}
+
+	cred, err := ts.credentialByToken(token)
+	if errors.Is(err, db.ErrRecordNotFound) {
+		return nil, team.ErrUnknownCredentials // Maybe one of another authenticator.
+	} else if err != nil || cred == nil {
+		return nil, ts.errorf("%w: %w", ErrDatabase, err)
+	}
+
+	// The token is either the current one, or the previous one in its grace period.
+	cached := &cachedToken{
+		user:       &team.User{Name: cred.UserName}, // No authorization data.
+		credential: cred.Label,
+		expiresAt:  cred.TokenExpiresAt,
+	}
+
+	if token != cred.Token {
+		cached.expiresAt = cred.PreviousTokenExpiresAt
+	}
+
+	if cached.expired() {
+		return nil, ts.errorf("%w: %w (user %s)", ErrUnauthenticated, ErrTokenExpired, cred.UserName)
+	}
+
+	// Not fatal here, since only peer authentication needs the certificate.
+	if cached.serial, err = ts.credentialSerial(cred); err != nil {
+		log.Warn(fmt.Sprintf("No certificate for credential '%s' of user %s: %s", cred.Label, cred.UserName, err))
+	}
+
+	ts.recordLastSeen(cached.user.Name, cached.credential)
+
+	log.Debug(fmt.Sprintf("Valid user token for %s (%s)", cached.user.Name, cached.credential))
+
+	ttl, _ := ts.authCacheSettings()
+	ts.authCache.put(token, cached, ttl)
+
+	return cached, nil
 }
 
 // func TestRootOnlyVerifyCertificate(t *testing.T) {
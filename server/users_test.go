@@ -20,14 +20,17 @@ package server
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"testing"
+	"time"
 )
 
 // newTestServer returns a fully-initialized in-memory teamserver. Calling init()
 // bootstraps the database and certificate infrastructure without needing a
 // transport handler, which is all the user-management primitives require.
-func newTestServer(t *testing.T) *Server {
+func newTestServer(t testing.TB) *Server {
 	t.Helper()
 
 	ts, err := New("usertest", WithInMemory())
@@ -42,6 +45,15 @@ func newTestServer(t *testing.T) *Server {
 	return ts
 }
 
+// saveTestConfig saves the configuration of a test server, so that its changes are applied.
+func saveTestConfig(t testing.TB, ts *Server) {
+	t.Helper()
+
+	if err := ts.SaveConfig(ts.opts.config); err != nil {
+		t.Fatalf("SaveConfig: %v", err)
+	}
+}
+
 // TestUserCreateWithoutServe is a regression guard for the nil-certificate
 // panic: creating a user through the API (as the `user` CLI command does) must
 // work without a prior Serve()/init() call. Previously UserCreate only ran
@@ -201,6 +213,53 @@ func TestUsersTLSConfig(t *testing.T) {
 	}
 }
 
+// TestCertificateProfilesOption checks that user certificates are issued with the
+// profiles passed as options, and that invalid profiles fail the initialization.
+func TestCertificateProfilesOption(t *testing.T) {
+	ts, err := New("profiles", WithInMemory(), WithCertificateProfiles(CertificateProfile{
+		CA:        "user",
+		Purpose:   "client",
+		Algorithm: "rsa",
+		RSABits:   2048,
+		Validity:  "720h",
+	}))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	cfg, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	block, _ := pem.Decode([]byte(cfg.Certificate))
+	if block == nil {
+		t.Fatal("no certificate in the user config")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	if cert.PublicKeyAlgorithm != x509.RSA || cert.NotAfter.Sub(cert.NotBefore) != 720*time.Hour {
+		t.Fatalf("certificate not issued with the profile: %s key, valid %s",
+			cert.PublicKeyAlgorithm, cert.NotAfter.Sub(cert.NotBefore))
+	}
+
+	invalid, err := New("badprofiles", WithInMemory(), WithCertificateProfiles(CertificateProfile{
+		Purpose:  "client",
+		Validity: "a month",
+	}))
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	if _, err := invalid.UserCreate("alice", "localhost", 31337); !errors.Is(err, ErrCertificate) {
+		t.Fatalf("invalid profiles should fail with ErrCertificate, got %v", err)
+	}
+}
+
 // TestAuthenticateRejectsGarbage ensures a well-formed-but-unknown token and an
 // empty token are both rejected without leaking an identity.
 func TestAuthenticateRejectsGarbage(t *testing.T) {
@@ -213,3 +272,149 @@ func TestAuthenticateRejectsGarbage(t *testing.T) {
 		}
 	}
 }
+
+// TestAuthenticatePeer checks that API tokens are bound to the client certificate
+// of their user: a token is refused with another user's certificate, with a
+// superseded certificate of the same user, or without any certificate at all.
+func TestAuthenticatePeer(t *testing.T) {
+	ts := newTestServer(t)
+
+	parse := func(certPEM string) *x509.Certificate {
+		block, _ := pem.Decode([]byte(certPEM))
+		if block == nil {
+			t.Fatal("failed to decode certificate PEM")
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+
+		return cert
+	}
+
+	alice, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	bob, err := ts.UserCreate("bob", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if user, err := ts.AuthenticatePeer(alice.Token, parse(alice.Certificate)); err != nil || user.Name != "alice" {
+		t.Fatalf("token with its own certificate refused: user=%v err=%v", user, err)
+	}
+
+	if _, err := ts.AuthenticatePeer(alice.Token, parse(bob.Certificate)); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("token with another user certificate should be refused, got %v", err)
+	}
+
+	if _, err := ts.AuthenticatePeer(alice.Token, nil); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("token without certificate should be refused, got %v", err)
+	}
+
+	// Re-creating alice issues a new certificate: the old one cannot be used with the new token.
+	rotated, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.AuthenticatePeer(rotated.Token, parse(alice.Certificate)); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("token with a superseded certificate should be refused, got %v", err)
+	}
+
+	if _, err := ts.AuthenticatePeer(rotated.Token, parse(rotated.Certificate)); err != nil {
+		t.Fatalf("rotated credentials refused: %v", err)
+	}
+}
+
+// TestUserRotateToken checks that rotating a token keeps the user, and that the
+// previous token is accepted during the grace period only.
+func TestUserRotateToken(t *testing.T) {
+	ts := newTestServer(t)
+
+	cfg, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	// Cache the first token, which must not survive its rotation.
+	if _, err := ts.Authenticate(cfg.Token); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	rotated, expiresAt, err := ts.UserRotateToken("alice", DefaultCredential)
+	if err != nil {
+		t.Fatalf("UserRotateToken: %v", err)
+	}
+
+	if rotated == cfg.Token || !expiresAt.IsZero() {
+		t.Fatalf("expected a new, non-expiring token (expires %v)", expiresAt)
+	}
+
+	for _, token := range []string{cfg.Token, rotated} {
+		if user, err := ts.Authenticate(token); err != nil || user.Name != "alice" {
+			t.Fatalf("token should be valid during the grace period: user=%v err=%v", user, err)
+		}
+	}
+
+	// Without grace period, the previous token is immediately refused.
+	ts.opts.config.Users.TokenGracePeriod = "0s"
+	saveTestConfig(t, ts)
+
+	latest, _, err := ts.UserRotateToken("alice", DefaultCredential)
+	if err != nil {
+		t.Fatalf("UserRotateToken: %v", err)
+	}
+
+	if _, err := ts.Authenticate(rotated); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("previous token should be refused without grace period, got %v", err)
+	}
+
+	if _, err := ts.Authenticate(latest); err != nil {
+		t.Fatalf("latest token refused: %v", err)
+	}
+
+	if _, _, err := ts.UserRotateToken("nobody", DefaultCredential); !errors.Is(err, ErrUserConfig) {
+		t.Fatalf("rotating the token of an unknown user should fail, got %v", err)
+	}
+}
+
+// TestTokenExpiry checks that expired tokens are refused, cached or not.
+func TestTokenExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	ts.opts.config.Users.TokenExpiry = "200ms"
+	saveTestConfig(t, ts)
+
+	cfg, err := ts.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	if _, err := ts.Authenticate(cfg.Token); err != nil {
+		t.Fatalf("fresh token refused: %v", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if _, err := ts.Authenticate(cfg.Token); !errors.Is(err, ErrTokenExpired) {
+			t.Fatalf("expired token should be refused with ErrTokenExpired, got %v", err)
+		}
+	}
+
+	token, expiresAt, err := ts.UserRotateToken("alice", DefaultCredential)
+	if err != nil {
+		t.Fatalf("UserRotateToken: %v", err)
+	}
+
+	if expiresAt.IsZero() {
+		t.Fatal("rotated token should have an expiry")
+	}
+
+	if _, err := ts.Authenticate(token); err != nil {
+		t.Fatalf("rotated token refused: %v", err)
+	}
+}
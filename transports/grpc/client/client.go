@@ -20,11 +20,13 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 
 	"github.com/reeflective/team"
@@ -67,6 +69,7 @@ type Dialer struct {
 	hooks   []func(*grpc.ClientConn) error
 	conn    *grpc.ClientConn
 	rpc     proto.TeamClient
+	token   *tokenAuth
 }
 
 // NewClient returns a gRPC teamclient dialer loaded with the provided dial
@@ -105,11 +108,12 @@ func (d *Dialer) Init(cli *client.Client) error {
 	// If the configuration has credentials, we are a remote dialer:
 	// authenticate and encrypt with Mutual TLS + per-RPC bearer token.
 	if config != nil && config.PrivateKey != "" {
-		tlsOpts, err := TLSAuthMiddleware(cli)
+		tlsOpts, token, err := tlsAuthMiddleware(cli)
 		if err != nil {
 			return err
 		}
 
+		d.token = token
 		d.options = append(d.options, tlsOpts...)
 	}
 
@@ -211,9 +215,94 @@ func (d *Dialer) VersionServer() (team.Version, error) {
 	}, nil
 }
 
+// RefreshToken implements client.TokenRefresher.RefreshToken(): it asks the
+// teamserver for a new API token via the core Team service (requires the server
+// to have been created WithCoreServices()), and uses it for all subsequent calls.
+// Dialers without Mutual TLS credentials (in-memory ones) have no token to refresh.
+func (d *Dialer) RefreshToken() (string, time.Time, error) {
+	if d.rpc == nil {
+		return "", time.Time{}, ErrNoConnection
+	}
+
+	if d.token == nil {
+		return "", time.Time{}, ErrNoTLSCredentials
+	}
+
+	res, err := d.rpc.RefreshToken(context.Background(), &proto.Empty{})
+	if err != nil {
+		return "", time.Time{}, errors.New(status.Convert(err).Message())
+	}
+
+	d.token.setToken(res.GetToken())
+
+	var expiresAt time.Time
+	if res.GetExpiresAt() != 0 {
+		expiresAt = time.Unix(res.GetExpiresAt(), 0)
+	}
+
+	return res.GetToken(), expiresAt, nil
+}
+
+// RenewCertificate implements client.CertificateRenewer.RenewCertificate(): it sends a
+// certificate request to the teamserver via the core Team service (requires the server to
+// have been created WithCoreServices()), and returns the new certificate and users CA.
+// The dialer must be initialized again with them (reconnected) to use them.
+func (d *Dialer) RenewCertificate(csrPEM []byte) (*client.Config, error) {
+	if d.rpc == nil {
+		return nil, ErrNoConnection
+	}
+
+	if d.token == nil {
+		return nil, ErrNoTLSCredentials
+	}
+
+	res, err := d.rpc.RenewCertificate(context.Background(), &proto.RenewRequest{CSR: csrPEM})
+	if err != nil {
+		return nil, errors.New(status.Convert(err).Message())
+	}
+
+	return &client.Config{
+		User:          res.GetUser(),
+		CACertificate: res.GetCACertificate(),
+		Certificate:   res.GetCertificate(),
+	}, nil
+}
+
+// Enroll implements team/client.Enroller. It dials a one-off, server-authenticated TLS
+// connection to the teamserver, and exchanges the invitation code and certificate request
+// for credentials via the Enrollment service (requires the server to have been created
+// WithCoreServices()). The dialer needs neither to be initialized nor connected.
+func (d *Dialer) Enroll(addr string, tlsConfig *tls.Config, code string, csrPEM []byte) (*client.Config, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	res, err := proto.NewEnrollmentClient(conn).Enroll(ctx, &proto.EnrollRequest{Code: code, CSR: csrPEM})
+	if err != nil {
+		return nil, errors.New(status.Convert(err).Message())
+	}
+
+	return &client.Config{
+		User:          res.GetUser(),
+		Token:         res.GetToken(),
+		CACertificate: res.GetCACertificate(),
+		Certificate:   res.GetCertificate(),
+	}, nil
+}
+
 // compile-time guarantees: the dialer is a team client.Dialer, and — because it
-// implements Users()/VersionServer() — also a team.Client backend.
+// implements Users()/VersionServer() — also a team.Client backend. It can also
+// refresh its API token (client.TokenRefresher), renew its certificate
+// (client.CertificateRenewer) and enroll (client.Enroller).
 var (
-	_ client.Dialer = (*Dialer)(nil)
-	_ team.Client   = (*Dialer)(nil)
+	_ client.Dialer             = (*Dialer)(nil)
+	_ team.Client               = (*Dialer)(nil)
+	_ client.TokenRefresher     = (*Dialer)(nil)
+	_ client.CertificateRenewer = (*Dialer)(nil)
+	_ client.Enroller           = (*Dialer)(nil)
 )
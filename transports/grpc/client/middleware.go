@@ -21,6 +21,8 @@ package client
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -34,38 +36,89 @@ var ErrNoTLSCredentials = errors.New("the teamclient has no TLS credentials to u
 
 // TLSAuthMiddleware returns the Mutual-TLS dial options for the teamclient's
 // selected remote server configuration: transport credentials built from the
-// config's CA/cert/key, plus a per-RPC bearer token carrying the config token.
+// config's CA/cert/key, plus a per-RPC bearer token carrying the config token
+// (and the teamclient version, which the server shows in its session list).
 func TLSAuthMiddleware(cli *client.Client) ([]grpc.DialOption, error) {
+	options, _, err := tlsAuthMiddleware(cli)
+	return options, err
+}
+
+// tlsAuthMiddleware returns the Mutual-TLS dial options, and the per-RPC token
+// credentials they use, so that the dialer can change the token when refreshed.
+func tlsAuthMiddleware(cli *client.Client) ([]grpc.DialOption, *tokenAuth, error) {
 	config := cli.Config()
 	if config == nil || config.PrivateKey == "" {
-		return nil, ErrNoTLSCredentials
+		return nil, nil, ErrNoTLSCredentials
 	}
 
 	tlsConfig, err := cli.NewTLSConfigFrom(config.CACertificate, config.Certificate, config.PrivateKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	token := &tokenAuth{
+		mutex:   &sync.RWMutex{},
+		token:   config.Token,
+		version: clientVersion(cli),
 	}
 
 	return []grpc.DialOption{
 		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
-		grpc.WithPerRPCCredentials(tokenAuth(config.Token)),
-	}, nil
+		grpc.WithPerRPCCredentials(token),
+	}, token, nil
 }
 
 // tokenAuth is a credentials.PerRPCCredentials that sends the teamclient's API
 // token as an "Authorization: Bearer <token>" header on every request. The
 // server's token-authentication interceptor reads it (see the server transport).
-type tokenAuth string
+// The client version, when known, is sent along in a dedicated header.
+// The token can be changed at any time, for instance when refreshed.
+type tokenAuth struct {
+	mutex   *sync.RWMutex
+	token   string
+	version string
+}
+
+// clientVersionHeader is the request header carrying the teamclient version.
+// It must match the header read by the server transport.
+const clientVersionHeader = "team-client-version"
 
 // GetRequestMetadata maps the token to the Authorization request header.
-func (t tokenAuth) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
-	return map[string]string{
-		"Authorization": "Bearer " + string(t),
-	}, nil
+func (t *tokenAuth) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	md := map[string]string{
+		"Authorization": "Bearer " + t.token,
+	}
+
+	if t.version != "" {
+		md[clientVersionHeader] = t.version
+	}
+
+	return md, nil
 }
 
 // RequireTransportSecurity always returns true: the bearer token is only ever
 // sent over the Mutual-TLS transport established above.
-func (tokenAuth) RequireTransportSecurity() bool {
+func (*tokenAuth) RequireTransportSecurity() bool {
 	return true
 }
+
+// setToken replaces the token sent with all subsequent requests.
+func (t *tokenAuth) setToken(token string) {
+	t.mutex.Lock()
+	t.token = token
+	t.mutex.Unlock()
+}
+
+// clientVersion returns the teamclient binary version as a semantic version
+// string, or an empty string if the client version information is unavailable.
+func clientVersion(cli *client.Client) string {
+	ver, err := cli.VersionClient()
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("v%d.%d.%d", ver.Major, ver.Minor, ver.Patch)
+}
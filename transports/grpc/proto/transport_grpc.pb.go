@@ -25,8 +25,10 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Team_GetVersion_FullMethodName = "/teamgrpc.Team/GetVersion"
-	Team_GetUsers_FullMethodName   = "/teamgrpc.Team/GetUsers"
+	Team_GetVersion_FullMethodName       = "/teamgrpc.Team/GetVersion"
+	Team_GetUsers_FullMethodName         = "/teamgrpc.Team/GetUsers"
+	Team_RefreshToken_FullMethodName     = "/teamgrpc.Team/RefreshToken"
+	Team_RenewCertificate_FullMethodName = "/teamgrpc.Team/RenewCertificate"
 )
 
 // TeamClient is the client API for Team service.
@@ -35,6 +37,13 @@ const (
 type TeamClient interface {
 	GetVersion(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Version, error)
 	GetUsers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Users, error)
+	// RefreshToken swaps the API token of the calling user for a new one.
+	// The previous token keeps working during the server grace period.
+	RefreshToken(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Token, error)
+	// RenewCertificate signs a certificate request for the credential of the calling
+	// user with the current users CA (eg. during a CA rotation), and revokes the
+	// previous certificate of the credential.
+	RenewCertificate(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*Credentials, error)
 }
 
 type teamClient struct {
@@ -63,12 +72,37 @@ func (c *teamClient) GetUsers(ctx context.Context, in *Empty, opts ...grpc.CallO
 	return out, nil
 }
 
+func (c *teamClient) RefreshToken(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Token, error) {
+	out := new(Token)
+	err := c.cc.Invoke(ctx, Team_RefreshToken_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *teamClient) RenewCertificate(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*Credentials, error) {
+	out := new(Credentials)
+	err := c.cc.Invoke(ctx, Team_RenewCertificate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TeamServer is the server API for Team service.
 // All implementations must embed UnimplementedTeamServer
 // for forward compatibility
 type TeamServer interface {
 	GetVersion(context.Context, *Empty) (*Version, error)
 	GetUsers(context.Context, *Empty) (*Users, error)
+	// RefreshToken swaps the API token of the calling user for a new one.
+	// The previous token keeps working during the server grace period.
+	RefreshToken(context.Context, *Empty) (*Token, error)
+	// RenewCertificate signs a certificate request for the credential of the calling
+	// user with the current users CA (eg. during a CA rotation), and revokes the
+	// previous certificate of the credential.
+	RenewCertificate(context.Context, *RenewRequest) (*Credentials, error)
 	mustEmbedUnimplementedTeamServer()
 }
 
@@ -82,6 +116,12 @@ func (UnimplementedTeamServer) GetVersion(context.Context, *Empty) (*Version, er
 func (UnimplementedTeamServer) GetUsers(context.Context, *Empty) (*Users, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetUsers not implemented")
 }
+func (UnimplementedTeamServer) RefreshToken(context.Context, *Empty) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshToken not implemented")
+}
+func (UnimplementedTeamServer) RenewCertificate(context.Context, *RenewRequest) (*Credentials, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewCertificate not implemented")
+}
 func (UnimplementedTeamServer) mustEmbedUnimplementedTeamServer() {}
 
 // UnsafeTeamServer may be embedded to opt out of forward compatibility for this service.
@@ -131,6 +171,42 @@ func _Team_GetUsers_Handler(srv interface{}, ctx context.Context, dec func(inter
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Team_RefreshToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TeamServer).RefreshToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Team_RefreshToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TeamServer).RefreshToken(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Team_RenewCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TeamServer).RenewCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Team_RenewCertificate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TeamServer).RenewCertificate(ctx, req.(*RenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Team_ServiceDesc is the grpc.ServiceDesc for Team service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -146,6 +222,104 @@ var Team_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetUsers",
 			Handler:    _Team_GetUsers_Handler,
 		},
+		{
+			MethodName: "RefreshToken",
+			Handler:    _Team_RefreshToken_Handler,
+		},
+		{
+			MethodName: "RenewCertificate",
+			Handler:    _Team_RenewCertificate_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "transport.proto",
+}
+
+const (
+	Enrollment_Enroll_FullMethodName = "/teamgrpc.Enrollment/Enroll"
+)
+
+// EnrollmentClient is the client API for Enrollment service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EnrollmentClient interface {
+	Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*Credentials, error)
+}
+
+type enrollmentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEnrollmentClient(cc grpc.ClientConnInterface) EnrollmentClient {
+	return &enrollmentClient{cc}
+}
+
+func (c *enrollmentClient) Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*Credentials, error) {
+	out := new(Credentials)
+	err := c.cc.Invoke(ctx, Enrollment_Enroll_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EnrollmentServer is the server API for Enrollment service.
+// All implementations must embed UnimplementedEnrollmentServer
+// for forward compatibility
+type EnrollmentServer interface {
+	Enroll(context.Context, *EnrollRequest) (*Credentials, error)
+	mustEmbedUnimplementedEnrollmentServer()
+}
+
+// UnimplementedEnrollmentServer must be embedded to have forward compatible implementations.
+type UnimplementedEnrollmentServer struct {
+}
+
+func (UnimplementedEnrollmentServer) Enroll(context.Context, *EnrollRequest) (*Credentials, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Enroll not implemented")
+}
+func (UnimplementedEnrollmentServer) mustEmbedUnimplementedEnrollmentServer() {}
+
+// UnsafeEnrollmentServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EnrollmentServer will
+// result in compilation errors.
+type UnsafeEnrollmentServer interface {
+	mustEmbedUnimplementedEnrollmentServer()
+}
+
+func RegisterEnrollmentServer(s grpc.ServiceRegistrar, srv EnrollmentServer) {
+	s.RegisterService(&Enrollment_ServiceDesc, srv)
+}
+
+func _Enrollment_Enroll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EnrollmentServer).Enroll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Enrollment_Enroll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EnrollmentServer).Enroll(ctx, req.(*EnrollRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Enrollment_ServiceDesc is the grpc.ServiceDesc for Enrollment service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Enrollment_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "teamgrpc.Enrollment",
+	HandlerType: (*EnrollmentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Enroll",
+			Handler:    _Enrollment_Enroll_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "transport.proto",
@@ -0,0 +1,70 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"errors"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/reeflective/team/server"
+	"github.com/reeflective/team/transports/grpc/proto"
+)
+
+// enrollServer implements the Enrollment service, with which teamclients exchange
+// an invitation code and a certificate request for their credentials. It is served
+// along the core Team service (see WithCoreServices()).
+type enrollServer struct {
+	server *server.Server
+	proto.UnimplementedEnrollmentServer
+}
+
+func newEnrollServer(s *server.Server) *enrollServer {
+	return &enrollServer{server: s}
+}
+
+// AuthFuncOverride implements grpc_auth.ServiceAuthFuncOverride: enrolling
+// teamclients have neither a token nor a client certificate yet, and are
+// authenticated by their invitation code only.
+func (es *enrollServer) AuthFuncOverride(ctx context.Context, _ string) (context.Context, error) {
+	return ctx, nil
+}
+
+// Enroll signs the certificate request of a teamclient holding an invitation code.
+func (es *enrollServer) Enroll(_ context.Context, req *proto.EnrollRequest) (*proto.Credentials, error) {
+	config, err := es.server.UserEnroll(req.GetCode(), req.GetCSR())
+	if errors.Is(err, server.ErrInvalidInvitation) {
+		return nil, status.Error(codes.Unauthenticated, "invalid invitation code")
+	} else if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return &proto.Credentials{
+		User:          config.User,
+		Token:         config.Token,
+		CACertificate: config.CACertificate,
+		Certificate:   config.Certificate,
+	}, nil
+}
+
+// compile-time guarantee that the enrollment service bypasses token authentication.
+var _ grpc_auth.ServiceAuthFuncOverride = (*enrollServer)(nil)
@@ -20,17 +20,21 @@ package server
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"runtime/debug"
 
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
 	"github.com/reeflective/team"
 	"github.com/reeflective/team/server"
+	"github.com/reeflective/team/transports/grpc/proto"
 )
 
 // ContextKey is the type of the values this transport injects into a request
@@ -74,10 +78,11 @@ func TLSAuthMiddlewareOptions(s *server.Server) ([]grpc.ServerOption, error) {
 }
 
 // logMiddlewareOptions returns logging/audit interceptors backed by the core
-// teamserver slog loggers: every request is recorded to the teamserver audit
-// log (see server.AuditLogger()). Unlike the old example transport this keeps
-// everything on slog and never touches gRPC's process-global logger (which is
-// not concurrency-safe and races running servers).
+// teamserver slog loggers: every request is recorded, with its user and status,
+// to the teamserver audit log (see server.AuditLogger()), even when refused.
+// Unlike the old example transport this keeps everything on slog and never
+// touches gRPC's process-global logger (which is not concurrency-safe and races
+// running servers).
 func (h *Handler) logMiddlewareOptions() ([]grpc.ServerOption, error) {
 	auditLog, err := h.AuditLogger()
 	if err != nil {
@@ -132,13 +137,21 @@ func serverAuthFunc(ctx context.Context) (context.Context, error) {
 	ctx = context.WithValue(ctx, Transport, "server")
 	ctx = context.WithValue(ctx, User, (*team.User)(nil))
 
+	setAuditCaller(ctx, "server")
+
 	return ctx, nil
 }
 
 // tokenAuthFunc authenticates a remote call: it extracts the Bearer token and
-// asks the core teamserver WHO is calling. The teamserver only proves identity
-// (a name); it carries no permissions. Authorization is the application's job,
-// via WithAuthorizer / the injected *team.User.
+// asks the core teamserver WHO is calling, requiring the token to be presented
+// with the Mutual TLS client certificate of the same user. The token is verified
+// by the teamserver authenticator chain (see server.Authenticator), so it can be
+// a teamserver API token, or any credential known to a pluggable authenticator.
+//
+// The teamserver only proves identity (a name); it carries no permissions.
+// Authorization is the application's job, via WithAuthorizer / the injected
+// *team.User. The first authenticated call of a connection registers it as a
+// session. Failed attempts are throttled per remote address and client certificate.
 func (h *Handler) tokenAuthFunc(ctx context.Context) (context.Context, error) {
 	log := h.NamedLogger("transport", "grpc")
 
@@ -148,18 +161,55 @@ func (h *Handler) tokenAuthFunc(ctx context.Context) (context.Context, error) {
 		return nil, status.Error(codes.Unauthenticated, "Authentication failure")
 	}
 
-	user, err := h.Authenticate(rawToken)
-	if err != nil || user == nil || user.Name == "" {
+	// Tokens must always be presented with a client certificate.
+	creds := team.Credentials{Token: rawToken, Certificate: peerCertificate(ctx)}
+	if creds.Certificate == nil {
+		log.Error("Authentication failure", "error", "no peer certificate")
+		return nil, status.Error(codes.Unauthenticated, "Authentication failure")
+	}
+
+	if client, ok := peer.FromContext(ctx); ok && client.Addr != nil {
+		creds.RemoteAddr = client.Addr.String()
+	}
+
+	// Failures are throttled by the teamserver (backoff and bans).
+	user, err := h.Authenticator().Authenticate(creds)
+	if errors.Is(err, server.ErrBanned) || errors.Is(err, server.ErrAuthBackoff) {
+		return nil, status.Error(codes.ResourceExhausted, "Too many authentication failures")
+	} else if err != nil || user == nil || user.Name == "" {
 		log.Error("Authentication failure", "error", err)
 		return nil, status.Error(codes.Unauthenticated, "Authentication failure")
 	}
 
+	setAuditCaller(ctx, user.Name)
+
+	if err := h.registerSession(ctx, user.Name, rawToken); err != nil {
+		log.Error("Session registration failure", "error", err)
+		return nil, status.Error(codes.Internal, "Session registration failure")
+	}
+
 	ctx = context.WithValue(ctx, Transport, user)
 	ctx = context.WithValue(ctx, User, user)
 
 	return ctx, nil
 }
 
+// peerCertificate returns the TLS client certificate of the peer making a call,
+// or nil if the call was not made over a Mutual TLS connection.
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	client, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	tlsInfo, ok := client.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return tlsInfo.State.PeerCertificates[0]
+}
+
 // authorizeUnaryServerInterceptor enforces the application authorization policy
 // on unary calls, using the identity resolved by tokenAuthFunc and the full RPC
 // method name as the action.
@@ -167,6 +217,11 @@ func (h *Handler) authorizeUnaryServerInterceptor() grpc.UnaryServerInterceptor
 	log := h.NamedLogger("transport", "authz")
 
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		// Services authenticating their calls themselves have no identity to authorize.
+		if _, override := info.Server.(grpc_auth.ServiceAuthFuncOverride); override {
+			return handler(ctx, req)
+		}
+
 		user, ok := ctx.Value(User).(*team.User)
 		if !ok || user == nil || user.Name == "" {
 			return nil, status.Error(codes.Unauthenticated, "Authentication failure")
@@ -187,6 +242,10 @@ func (h *Handler) authorizeStreamServerInterceptor() grpc.StreamServerIntercepto
 	log := h.NamedLogger("transport", "authz")
 
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, override := srv.(grpc_auth.ServiceAuthFuncOverride); override {
+			return handler(srv, ss)
+		}
+
 		user, ok := ss.Context().Value(User).(*team.User)
 		if !ok || user == nil || user.Name == "" {
 			return status.Error(codes.Unauthenticated, "Authentication failure")
@@ -232,20 +291,51 @@ func recoveryStreamServerInterceptor(log logger) grpc.StreamServerInterceptor {
 	}
 }
 
-// auditUnaryServerInterceptor records the raw request and method of every unary
-// call to the teamserver audit log.
+// auditUnaryServerInterceptor records the raw request, method, calling user and
+// status of every unary call to the teamserver audit log, including those refused
+// by authentication. It runs before authentication, which gives it the caller (see
+// setAuditCaller): in-memory calls are recorded with the "server" user, and
+// enrollment calls without any (the client has no identity).
 func auditUnaryServerInterceptor(auditLog logger) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		rawRequest, err := json.Marshal(req)
-		if err == nil {
+		caller := new(string)
+
+		resp, err := handler(context.WithValue(ctx, auditCaller{}, caller), req)
+
+		rawRequest, marshalErr := json.Marshal(auditedRequest(req))
+		if marshalErr == nil {
 			msg, _ := json.Marshal(struct {
 				Request string `json:"request"`
 				Method  string `json:"method"`
-			}{Request: string(rawRequest), Method: info.FullMethod})
+				User    string `json:"user,omitempty"`
+				Status  string `json:"status"`
+			}{Request: string(rawRequest), Method: info.FullMethod, User: *caller, Status: status.Code(err).String()})
 			auditLog.Info(string(msg))
 		}
 
-		return handler(ctx, req)
+		return resp, err
+	}
+}
+
+// auditedRequest returns the request recorded by the audit interceptor.
+// Invitation codes remain valid until an enrollment succeeds, so enrollment
+// requests are recorded without.
+func auditedRequest(req interface{}) interface{} {
+	if enroll, ok := req.(*proto.EnrollRequest); ok {
+		return &proto.EnrollRequest{CSR: enroll.GetCSR()}
+	}
+
+	return req
+}
+
+// auditCaller is the context key of the caller of an audited request.
+type auditCaller struct{}
+
+// setAuditCaller gives the name of the authenticated caller of a request
+// to the audit interceptor.
+func setAuditCaller(ctx context.Context, name string) {
+	if caller, ok := ctx.Value(auditCaller{}).(*string); ok {
+		*caller = name
 	}
 }
 
@@ -0,0 +1,113 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/reeflective/team/transports/grpc/proto"
+)
+
+// testLogger records the messages logged by the interceptors.
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Info(msg string, _ ...any)  { l.messages = append(l.messages, msg) }
+func (l *testLogger) Warn(msg string, _ ...any)  { l.messages = append(l.messages, msg) }
+func (l *testLogger) Error(msg string, _ ...any) { l.messages = append(l.messages, msg) }
+
+// TestAuditRefusedCalls checks that the audit interceptor records calls refused by
+// the authentication below it, and the user of the authenticated ones.
+func TestAuditRefusedCalls(t *testing.T) {
+	auditLog := &testLogger{}
+	audit := auditUnaryServerInterceptor(auditLog)
+	info := &grpc.UnaryServerInfo{FullMethod: "/team.Team/GetUsers"}
+
+	refused := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unauthenticated, "Authentication failure")
+	}
+
+	authenticated := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		setAuditCaller(ctx, "alice")
+		return "users", nil
+	}
+
+	for _, call := range []struct {
+		handler grpc.UnaryHandler
+		user    string
+		status  codes.Code
+	}{
+		{handler: refused, status: codes.Unauthenticated},
+		{handler: authenticated, user: "alice", status: codes.OK},
+	} {
+		auditLog.messages = nil
+
+		_, err := audit(context.Background(), struct{}{}, info, call.handler)
+		if status.Code(err) != call.status {
+			t.Fatalf("interceptor should return the handler status %s, got %v", call.status, err)
+		}
+
+		if len(auditLog.messages) != 1 {
+			t.Fatalf("expected one audit record, got %d", len(auditLog.messages))
+		}
+
+		var record struct {
+			Method string `json:"method"`
+			User   string `json:"user"`
+			Status string `json:"status"`
+		}
+
+		if err := json.Unmarshal([]byte(auditLog.messages[0]), &record); err != nil {
+			t.Fatalf("invalid audit record: %v", err)
+		}
+
+		if record.Method != info.FullMethod || record.User != call.user || record.Status != call.status.String() {
+			t.Fatalf("unexpected audit record %+v", record)
+		}
+	}
+}
+
+// TestAuditEnrollmentCode checks that invitation codes are not recorded in the audit log.
+func TestAuditEnrollmentCode(t *testing.T) {
+	auditLog := &testLogger{}
+	audit := auditUnaryServerInterceptor(auditLog)
+	info := &grpc.UnaryServerInfo{FullMethod: "/team.Enrollment/Enroll"}
+
+	req := &proto.EnrollRequest{Code: "invitation-secret", CSR: []byte("request")}
+
+	refused := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unauthenticated, "invalid invitation code")
+	}
+
+	if _, err := audit(context.Background(), req, info, refused); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("interceptor should return the handler error, got %v", err)
+	}
+
+	if len(auditLog.messages) != 1 || strings.Contains(auditLog.messages[0], req.Code) {
+		t.Fatalf("enrollment should be recorded without its invitation code, got %v", auditLog.messages)
+	}
+}
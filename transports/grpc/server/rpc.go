@@ -20,12 +20,18 @@ package server
 
 import (
 	"context"
+	"errors"
 
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/reeflective/team"
 	"github.com/reeflective/team/server"
 	"github.com/reeflective/team/transports/grpc/proto"
 )
 
-// rpcServer implements the core teamserver Team service (users/version) on top
+// rpcServer implements the core teamserver Team service (users/version/token) on top
 // of the team/server.Server core. It is registered when the handler is created
 // with WithCoreServices().
 type rpcServer struct {
@@ -69,3 +75,63 @@ func (ts *rpcServer) GetUsers(context.Context, *proto.Empty) (*proto.Users, erro
 
 	return &proto.Users{Users: userspb}, err
 }
+
+// RefreshToken rotates the API token of the credential used by the calling user, and
+// returns the new one. The other credentials (devices) of the user are not affected.
+// In-memory clients are not authenticated with a token, and cannot refresh one.
+func (ts *rpcServer) RefreshToken(ctx context.Context, _ *proto.Empty) (*proto.Token, error) {
+	user, ok := ctx.Value(User).(*team.User)
+	if !ok || user == nil || user.Name == "" {
+		return nil, status.Error(codes.FailedPrecondition, "the client is not authenticated with a token")
+	}
+
+	rawToken, err := grpc_auth.AuthFromMD(ctx, "Bearer")
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "the client is not authenticated with a token")
+	}
+
+	// The token has been authenticated for this call, so it belongs to the user.
+	token, expiresAt, err := ts.server.UserRefreshToken(rawToken)
+	if errors.Is(err, server.ErrUnauthenticated) {
+		return nil, status.Error(codes.FailedPrecondition, "no credential for the client token")
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, "failed to rotate token")
+	}
+
+	tokenpb := &proto.Token{Token: token}
+	if !expiresAt.IsZero() {
+		tokenpb.ExpiresAt = expiresAt.Unix()
+	}
+
+	return tokenpb, nil
+}
+
+// RenewCertificate signs the certificate request of the calling user for the credential
+// of its token with the current users CA, and returns the new certificate and users CA.
+// In-memory clients are not authenticated with a token, and have no certificate to renew.
+func (ts *rpcServer) RenewCertificate(ctx context.Context, req *proto.RenewRequest) (*proto.Credentials, error) {
+	user, ok := ctx.Value(User).(*team.User)
+	if !ok || user == nil || user.Name == "" {
+		return nil, status.Error(codes.FailedPrecondition, "the client is not authenticated with a token")
+	}
+
+	rawToken, err := grpc_auth.AuthFromMD(ctx, "Bearer")
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "the client is not authenticated with a token")
+	}
+
+	config, err := ts.server.UserRenewCertificate(rawToken, req.GetCSR())
+	if errors.Is(err, server.ErrUnauthenticated) {
+		return nil, status.Error(codes.FailedPrecondition, "no credential for the client token")
+	} else if errors.Is(err, server.ErrCertificate) {
+		return nil, status.Error(codes.InvalidArgument, "invalid certificate request")
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, "failed to renew certificate")
+	}
+
+	return &proto.Credentials{
+		User:          config.User,
+		CACertificate: config.CACertificate,
+		Certificate:   config.Certificate,
+	}, nil
+}
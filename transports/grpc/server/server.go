@@ -56,8 +56,14 @@ const (
 //   - panic recovery (a handler panic becomes codes.Internal, not a crash),
 //   - audit logging of every request through the core AuditLogger(),
 //   - Mutual-TLS transport credentials for remote listeners,
-//   - token AUTHENTICATION for remote listeners (core Server.Authenticate),
-//     injecting the resolved *team.User into the request context.
+//   - token AUTHENTICATION for remote listeners, bound to the client certificate
+//     of the same user (core Server.AuthenticatePeer),
+//     injecting the resolved *team.User into the request context,
+//   - a live teamserver session for every authenticated remote connection,
+//     removed when the connection closes (see server.Sessions/KickSession).
+//
+// Services implementing grpc_auth.ServiceAuthFuncOverride (such as the built-in
+// Enrollment one) authenticate their calls themselves, and are not authorized.
 //
 // It deliberately ships NO application services and NO authorization policy.
 // Applications compose those in via:
@@ -129,9 +135,11 @@ func (h *Handler) PostServe(hooks ...func(*grpc.Server) error) {
 	h.hooks = append(h.hooks, hooks...)
 }
 
-// WithCoreServices registers the built-in teamserver Team service (users and
-// version) on the served gRPC server, so a connected teamclient can query them
-// remotely (e.g. the `teamserver client users` / version commands). It is
+// WithCoreServices registers the built-in teamserver Team service (users,
+// version and token refresh) on the served gRPC server, so a connected teamclient
+// can query them remotely (e.g. the `teamserver client users` / version commands),
+// and the Enrollment service, with which teamclients holding an invitation code
+// (see server.UserInvite) obtain their credentials without a token. It is
 // opt-in: applications that expose their own users/version RPC (as Sliver does)
 // leave it off. The transport's client dialer answers Users()/VersionServer()
 // against this service.
@@ -160,7 +168,8 @@ func (h *Handler) Name() string {
 func (h *Handler) Init(serv *server.Server) (err error) {
 	h.Server = serv
 
-	// Logging/audit middleware (uses the core slog loggers).
+	// Logging/audit middleware (uses the core slog loggers), before
+	// authentication so that refused requests are recorded as well.
 	logOptions, err := h.logMiddlewareOptions()
 	if err != nil {
 		return err
@@ -230,11 +239,19 @@ func (h *Handler) Close() error {
 func (h *Handler) ServeOn(ln net.Listener) {
 	rpcLog := h.NamedLogger("transport", "grpc")
 
-	grpcServer := grpc.NewServer(h.options...)
+	// Track client connections, so that authenticated ones are
+	// registered as teamserver sessions, and can be kicked.
+	tracker := newSessionTracker(h, ln)
+
+	options := append([]grpc.ServerOption{}, h.options...)
+	options = append(options, grpc.StatsHandler(tracker))
+
+	grpcServer := grpc.NewServer(options...)
 
-	// The built-in teamserver Team service (users/version), when enabled.
+	// The built-in teamserver Team and Enrollment services, when enabled.
 	if h.coreServices {
 		proto.RegisterTeamServer(grpcServer, newCoreServer(h.Server))
+		proto.RegisterEnrollmentServer(grpcServer, newEnrollServer(h.Server))
 	}
 
 	// Let applications register their own gRPC services on the server.
@@ -259,7 +276,7 @@ func (h *Handler) ServeOn(ln net.Listener) {
 			}
 		}()
 
-		if err := grpcServer.Serve(ln); err != nil {
+		if err := grpcServer.Serve(tracker); err != nil {
 			rpcLog.Error("gRPC server exited with error", "error", err)
 		} else {
 			panicked = false
@@ -0,0 +1,199 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+
+	"github.com/reeflective/team/server"
+)
+
+// clientVersionHeader is the request header in which teamclients send their
+// version. It must match the header sent by the client transport.
+const clientVersionHeader = "team-client-version"
+
+// sessionKey is the context key under which a connection's session is stored.
+type sessionKey struct{}
+
+// connSession tracks the teamserver session of a single client connection.
+// The session is registered on the first successfully authenticated call,
+// and unregistered when the connection is closed, for whatever reason.
+type connSession struct {
+	mutex *sync.Mutex
+	conn  net.Conn
+	addr  string
+	id    string
+}
+
+// registered returns true if the connection has already been registered as a session.
+func (c *connSession) registered() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.id != ""
+}
+
+// register adds the connection as a session of the given user, if not already done.
+func (c *connSession) register(serv *server.Server, handler, user, credential, version string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.id != "" || c.conn == nil {
+		return nil
+	}
+
+	id, err := serv.SessionAdd(server.Session{
+		User:          user,
+		Credential:    credential,
+		Handler:       handler,
+		RemoteAddr:    c.addr,
+		ClientVersion: version,
+	}, c.conn)
+	if err != nil {
+		return err
+	}
+
+	c.id = id
+
+	return nil
+}
+
+// unregister removes the connection session from the teamserver, if any.
+func (c *connSession) unregister(serv *server.Server) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.id == "" {
+		return
+	}
+
+	serv.SessionRemove(c.id)
+	c.id = ""
+}
+
+// sessionTracker is a net.Listener wrapper keeping a reference to each accepted
+// client connection (so that a kicked session can be closed), and a gRPC stats
+// handler binding these connections to their sessions, and removing them when
+// the gRPC server notices the connection ended.
+type sessionTracker struct {
+	net.Listener
+	handler *Handler
+	conns   *sync.Map
+}
+
+func newSessionTracker(h *Handler, ln net.Listener) *sessionTracker {
+	return &sessionTracker{
+		Listener: ln,
+		handler:  h,
+		conns:    &sync.Map{},
+	}
+}
+
+// Accept implements net.Listener.Accept(), keeping a reference to the connection.
+func (t *sessionTracker) Accept() (net.Conn, error) {
+	conn, err := t.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+
+	tracked := &trackedConn{Conn: conn, tracker: t}
+	t.conns.Store(conn.RemoteAddr().String(), tracked)
+
+	return tracked, nil
+}
+
+// TagConn implements stats.Handler.TagConn(), attaching a session to the connection context.
+func (t *sessionTracker) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	sess := &connSession{mutex: &sync.Mutex{}}
+
+	if info.RemoteAddr != nil {
+		sess.addr = info.RemoteAddr.String()
+
+		if conn, found := t.conns.Load(sess.addr); found {
+			sess.conn = conn.(net.Conn)
+		}
+	}
+
+	return context.WithValue(ctx, sessionKey{}, sess)
+}
+
+// HandleConn implements stats.Handler.HandleConn(), removing sessions of closed connections.
+func (t *sessionTracker) HandleConn(ctx context.Context, connStats stats.ConnStats) {
+	if _, ended := connStats.(*stats.ConnEnd); !ended {
+		return
+	}
+
+	if sess, ok := ctx.Value(sessionKey{}).(*connSession); ok {
+		sess.unregister(t.handler.Server)
+	}
+}
+
+// TagRPC implements stats.Handler.TagRPC(). It is a no-op.
+func (t *sessionTracker) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC implements stats.Handler.HandleRPC(). It is a no-op.
+func (t *sessionTracker) HandleRPC(context.Context, stats.RPCStats) {}
+
+// trackedConn removes itself from its tracker when closed.
+type trackedConn struct {
+	net.Conn
+	tracker *sessionTracker
+	once    sync.Once
+}
+
+// Close implements net.Conn.Close().
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.tracker.conns.Delete(c.Conn.RemoteAddr().String())
+	})
+
+	return c.Conn.Close()
+}
+
+// registerSession binds the connection of an authenticated call to a teamserver
+// session for the user, if the call was made over a connection tracked by one
+// of the handler listeners. Only the first authenticated call of a connection
+// registers a session: subsequent calls on the same connection are no-ops.
+func (h *Handler) registerSession(ctx context.Context, user, rawToken string) error {
+	sess, ok := ctx.Value(sessionKey{}).(*connSession)
+	if !ok || sess.registered() {
+		return nil
+	}
+
+	var credential, version string
+
+	if cred, err := h.TokenCredential(rawToken); err == nil {
+		credential = cred.Label
+	}
+
+	if md, found := metadata.FromIncomingContext(ctx); found {
+		if values := md.Get(clientVersionHeader); len(values) > 0 {
+			version = values[0]
+		}
+	}
+
+	return sess.register(h.Server, h.Name(), user, credential, version)
+}
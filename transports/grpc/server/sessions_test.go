@@ -0,0 +1,119 @@
+package server
+
+/*
+   team - Embedded teamserver for Go programs and CLI applications
+   Copyright (C) 2023 Reeflective
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/stats"
+
+	"github.com/reeflective/team/server"
+)
+
+// TestSessionTracker checks that the connection of the first authenticated call is registered
+// as a session (once), that kicking the session closes the connection, and that the session
+// is removed when the gRPC server notices the connection ended.
+func TestSessionTracker(t *testing.T) {
+	teamserver, err := server.New("grpctest", server.WithInMemory())
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+
+	config, err := teamserver.UserCreate("alice", "localhost", 31337)
+	if err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	handler := &Handler{Server: teamserver}
+	tracker := newSessionTracker(handler, listener)
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+
+	conn, err := tracker.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	ctx := tracker.TagConn(context.Background(), &stats.ConnTagInfo{RemoteAddr: conn.RemoteAddr()})
+
+	for range 2 {
+		if err := handler.registerSession(ctx, "alice", config.Token); err != nil {
+			t.Fatalf("registerSession: %v", err)
+		}
+	}
+
+	sessions := teamserver.Sessions()
+	if len(sessions) != 1 || sessions[0].User != "alice" || sessions[0].Credential != server.DefaultCredential {
+		t.Fatalf("expected one session of alice's default credential, got %+v", sessions)
+	}
+
+	if err := teamserver.KickSession(sessions[0].ID); err != nil {
+		t.Fatalf("KickSession: %v", err)
+	}
+
+	if _, tracked := tracker.conns.Load(conn.RemoteAddr().String()); tracked {
+		t.Fatal("kicked connection should be closed and untracked")
+	}
+
+	// A kicked session is removed right away: ending the connection is then a no-op.
+	tracker.HandleConn(ctx, &stats.ConnEnd{})
+
+	if sessions := teamserver.Sessions(); len(sessions) != 0 {
+		t.Fatalf("session should be removed once its connection ended, got %+v", sessions)
+	}
+
+	// Sessions of connections ending by themselves are removed as well.
+	other, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer other.Close()
+
+	conn, err = tracker.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	ctx = tracker.TagConn(context.Background(), &stats.ConnTagInfo{RemoteAddr: conn.RemoteAddr()})
+	if err := handler.registerSession(ctx, "alice", config.Token); err != nil {
+		t.Fatalf("registerSession: %v", err)
+	}
+
+	if len(teamserver.Sessions()) != 1 {
+		t.Fatal("authenticated connection should be registered as a session")
+	}
+
+	tracker.HandleConn(ctx, &stats.ConnEnd{})
+
+	if sessions := teamserver.Sessions(); len(sessions) != 0 {
+		t.Fatalf("session should be removed once its connection ended, got %+v", sessions)
+	}
+}